@@ -0,0 +1,480 @@
+// Команда admin — инструмент администратора для обслуживания редакционной системы из командной строки.
+// Работает с той же базой и через те же функции, что и сервер; запускать можно из любого каталога:
+//
+//	go run ./cmd/admin <команда> [флаги]
+//
+// Код возврата: 0 — успех, 1 — ошибка или найденные нарушения целостности, 2 — неверные аргументы.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"example.com/myproject/handlers"
+)
+
+// Команда утилиты
+type command struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"create-admin":           {"-login L [-password P] [-force] — создать администратора (по умолчанию только если его ещё нет)", createAdmin},
+	"reset-password":         {"-login L [-password P] — задать новый пароль и завершить сессии пользователя", resetPassword},
+	"set-role":               {"-login L -role R — сменить роль пользователя", setRole},
+	"migrate":                {"[-status] — применить миграции или показать версию схемы", migrate},
+	"list-publications":      {"[-status S] [-limit N] — список публикаций", listPublications},
+	"transition-publication": {"-id N -status S [-note T] — перевести публикацию в другой статус", transitionPublication},
+	"publish-scheduled":      {"— выложить запланированные публикации, время которых наступило", publishScheduled},
+	"export":                 {"[-format json|csv] [-passwords] [-o файл] — выгрузить базу", exportBundle},
+	"import":                 {"-file F [-strategy skip|overwrite|fail] [-dry-run] — загрузить выгрузку", importBundle},
+	"check":                  {"— проверить целостность данных", checkConsistency},
+}
+
+// errUsage означает неверные аргументы: справка уже выведена
+var errUsage = errors.New("неверные аргументы")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Неизвестная команда: %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.Run(os.Args[2:]); err != nil {
+		if err == errUsage || err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "Ошибка:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Использование: admin <команда> [флаги]")
+	fmt.Fprintln(os.Stderr, "\nКоманды:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-24s %s\n", name, commands[name].Usage)
+	}
+}
+
+// parseFlags разбирает флаги команды; лишние позиционные аргументы считаются ошибкой
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Лишние аргументы: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// required проверяет, что обязательные флаги заданы
+func required(fs *flag.FlagSet, values map[string]string) error {
+	for name, value := range values {
+		if value == "" {
+			fmt.Fprintf(os.Stderr, "Флаг -%s обязателен\n", name)
+			fs.Usage()
+			return errUsage
+		}
+	}
+	return nil
+}
+
+// connect подключается к базе, проверяет, что схема актуальна, и запускает шину событий,
+// чтобы изменения из командной строки видели открытые страницы сервера. Возвращает функцию завершения.
+func connect() (func(), error) {
+	if err := handlers.ConnectDatabase(); err != nil {
+		return nil, err
+	}
+	current, latest, err := handlers.SchemaVersion()
+	if err != nil {
+		handlers.Db.Close()
+		return nil, err
+	}
+	if current < latest {
+		handlers.Db.Close()
+		return nil, fmt.Errorf("схема базы устарела (версия %d из %d), сначала выполните: admin migrate", current, latest)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := handlers.StartEventBus(ctx, os.Getenv("EVENT_BUS") == "postgres"); err != nil {
+		cancel()
+		handlers.Db.Close()
+		return nil, err
+	}
+	return func() {
+		cancel()
+		handlers.Bus.Close()
+		handlers.Db.Close()
+	}, nil
+}
+
+// readPassword читает пароль из первой строки стандартного ввода, если он не передан флагом
+func readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Пароль: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func createAdmin(args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	login := fs.String("login", "", "логин администратора")
+	password := fs.String("password", "", "пароль (если не задан, читается из стандартного ввода)")
+	force := fs.Bool("force", false, "создать, даже если администратор уже есть")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := required(fs, map[string]string{"login": *login}); err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if !*force {
+		var exists bool
+		err := handlers.Db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE role = $1 AND deleted_at IS NULL)`, handlers.RoleAdmin).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.New("администратор уже есть; чтобы добавить ещё одного, укажите -force")
+		}
+	}
+	pass, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	userID, err := handlers.CreateUser(handlers.Db, *login, pass, handlers.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Создан администратор %s (id %d)\n", *login, userID)
+	return nil
+}
+
+func resetPassword(args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	login := fs.String("login", "", "логин пользователя")
+	password := fs.String("password", "", "новый пароль (если не задан, читается из стандартного ввода)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := required(fs, map[string]string{"login": *login}); err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	userID, err := handlers.GetUserIDByLogin(*login)
+	if err != nil {
+		return err
+	}
+	pass, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	if err := handlers.SetPassword(handlers.Db, userID, pass); err != nil {
+		return err
+	}
+	fmt.Printf("Пароль пользователя %s изменён, его сессии завершены\n", *login)
+	return nil
+}
+
+func setRole(args []string) error {
+	fs := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	login := fs.String("login", "", "логин пользователя")
+	role := fs.String("role", "", "новая роль: "+strings.Join(handlers.KnownRoles, ", "))
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := required(fs, map[string]string{"login": *login, "role": *role}); err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	userID, err := handlers.GetUserIDByLogin(*login)
+	if err != nil {
+		return err
+	}
+	if err := handlers.SetUserRole(handlers.Db, userID, *role); err != nil {
+		return err
+	}
+	fmt.Printf("Пользователю %s назначена роль %s\n", *login, *role)
+	return nil
+}
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := fs.Bool("status", false, "только показать версию схемы")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := handlers.ConnectDatabase(); err != nil {
+		return err
+	}
+	defer handlers.Db.Close()
+
+	if !*status {
+		if err := handlers.MigrateDatabase(); err != nil {
+			return err
+		}
+	}
+	current, latest, err := handlers.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Версия схемы: %d из %d\n", current, latest)
+	return nil
+}
+
+func listPublications(args []string) error {
+	fs := flag.NewFlagSet("list-publications", flag.ContinueOnError)
+	status := fs.String("status", "", "показать только публикации в этом статусе")
+	limit := fs.Int("limit", 50, "сколько публикаций показать")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	publications, err := handlers.ListPublications(*status, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tСТАТУС\tОТДЕЛ\tАВТОР\tИЗМЕНЕНА\tНАЗВАНИЕ")
+	for _, p := range publications {
+		updated := ""
+		if !p.UpdatedAt.IsZero() {
+			updated = p.UpdatedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Status, p.Department, p.AuthorName, updated, p.Title)
+	}
+	return w.Flush()
+}
+
+func transitionPublication(args []string) error {
+	fs := flag.NewFlagSet("transition-publication", flag.ContinueOnError)
+	id := fs.Int("id", 0, "идентификатор публикации")
+	status := fs.String("status", "", "новый статус: "+strings.Join(handlers.PublicationStatuses, ", "))
+	note := fs.String("note", "", "пояснение для истории версий")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		fmt.Fprintln(os.Stderr, "Флаг -id обязателен")
+		fs.Usage()
+		return errUsage
+	}
+	if err := required(fs, map[string]string{"status": *status}); err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if err := handlers.TransitionPublication(*id, *status, *note); err != nil {
+		return err
+	}
+	fmt.Printf("Публикация %d переведена в статус %s\n", *id, *status)
+	return nil
+}
+
+func publishScheduled(args []string) error {
+	fs := flag.NewFlagSet("publish-scheduled", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	published, err := handlers.PublishDuePublications()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Выложено публикаций: %d\n", len(published))
+	return nil
+}
+
+func exportBundle(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "json", "формат: json или csv (ZIP-архив)")
+	passwords := fs.Bool("passwords", false, "включить хеши паролей")
+	output := fs.String("o", "", "файл для записи (по умолчанию стандартный вывод)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "csv" {
+		fmt.Fprintln(os.Stderr, "Формат должен быть json или csv")
+		return errUsage
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	bundle, err := handlers.ExportBundle(*passwords)
+	if err != nil {
+		return err
+	}
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if *format == "json" {
+		err = handlers.WriteBundleJSON(out, bundle)
+	} else {
+		err = handlers.WriteBundleCSV(out, bundle)
+	}
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Выгрузка записана в %s: пользователей %d, публикаций %d\n", *output, len(bundle.Users), len(bundle.Publications))
+	}
+	return nil
+}
+
+func importBundle(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "файл выгрузки (.json или .zip)")
+	strategy := fs.String("strategy", handlers.ConflictSkip, "при совпадении с существующими данными: skip, overwrite или fail")
+	dryRun := fs.Bool("dry-run", false, "только проверить и показать, что изменится")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := required(fs, map[string]string{"file": *file}); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	bundle, err := handlers.ReadBundle(data)
+	if err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	report, err := handlers.ImportBundle(bundle, *strategy, *dryRun)
+	if err != nil {
+		return err
+	}
+	if report.DryRun {
+		fmt.Println("Пробный запуск: база не изменялась")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ОБЪЕКТЫ\tДОБАВЛЕНО\tОБНОВЛЕНО\tПРОПУЩЕНО")
+	for _, s := range report.Stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", s.Entity, s.Created, s.Updated, s.Skipped)
+	}
+	w.Flush()
+	for _, c := range report.Conflicts {
+		fmt.Println("Совпадение:", c)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Println("Предупреждение:", warning)
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintln(os.Stderr, "Ошибка:", e)
+	}
+	if !report.OK() {
+		return errors.New("импорт не выполнен")
+	}
+	return nil
+}
+
+func checkConsistency(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	done, err := connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	problems, err := handlers.CheckConsistency()
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("Нарушений не найдено")
+		return nil
+	}
+	for _, p := range problems {
+		ids := make([]string, len(p.IDs))
+		for i, id := range p.IDs {
+			ids[i] = fmt.Sprint(id)
+		}
+		fmt.Printf("%s (%d): %s\n", p.Check, len(p.IDs), strings.Join(ids, ", "))
+	}
+	return fmt.Errorf("найдено нарушений: %d", len(problems))
+}
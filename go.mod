@@ -1,13 +1,12 @@
 require (
+	github.com/go-pdf/fpdf v0.9.0
+	github.com/gorilla/sessions v1.4.0
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.26.0
 	golang.org/x/oauth2 v0.23.0
 )
 
-require (
-	github.com/gorilla/securecookie v1.1.2 // indirect
-	github.com/gorilla/sessions v1.4.0 // indirect
-)
+require github.com/gorilla/securecookie v1.1.2 // indirect
 
 module example.com/myproject
 
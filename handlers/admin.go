@@ -7,29 +7,27 @@ import (
 
 // Обработчик для отображения списка сотрудников
 func AdminEmployeesPage(w http.ResponseWriter, r *http.Request) {
-	// Получаем список всех пользователей
-	users, err := GetAllUsers()
-	if err != nil {
-		http.Error(w, "Ошибка получения списка сотрудников: "+err.Error(), http.StatusInternalServerError)
+	// Список сотрудников доступен только администратору из сессии
+	userID, ok := sessionAdmin(w, r)
+	if !ok {
 		return
 	}
 
-	// Предположим, что текущий пользователь — это администратор, информация о котором нам также нужна
-	userIDStr := r.URL.Query().Get("id")
-	userID, err := strconv.Atoi(userIDStr)
+	// Получаем страницу списка пользователей с учётом поиска
+	list, err := LoadUserList(r)
 	if err != nil {
-		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		http.Error(w, "Ошибка получения списка сотрудников: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Получаем информацию о текущем пользователе
-	userName, err := GetUserNameByIDFromDB(userID)
+	userName, err := GetUserNameByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка получения имени пользователя: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	role, err := GetUserRoleByIDFromDB(userID)
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка получения роли пользователя: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -41,11 +39,15 @@ func AdminEmployeesPage(w http.ResponseWriter, r *http.Request) {
 		UserName string
 		Role     string
 		Users    []User
+		List     UserList
+		Roles    []string
 	}{
 		UserID:   userID,
 		UserName: userName,
 		Role:     role,
-		Users:    users,
+		Users:    list.Users,
+		List:     list,
+		Roles:    KnownRoles,
 	}
 
 	// Выполняем рендеринг шаблона с обновленной структурой данных
@@ -57,8 +59,9 @@ func AdminEmployeesPage(w http.ResponseWriter, r *http.Request) {
 
 // Обработчик для добавления нового пользователя
 func AddUserHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Неправильный метод запроса", http.StatusMethodNotAllowed)
+	// Текущий администратор берётся из сессии
+	adminID, ok := adminForm(w, r)
+	if !ok {
 		return
 	}
 
@@ -67,32 +70,10 @@ func AddUserHandler(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	role := r.FormValue("role")
 
-	// Проверяем, что логин, пароль и роль не пустые
-	if login == "" || password == "" || role == "" {
-		http.Error(w, "Поля логин, пароль и роль обязательны", http.StatusBadRequest)
-		return
-	}
-
-	// Хешируем пароль
-	hashedPassword, err := HashPassword(password)
+	// Проверяем данные и добавляем пользователя
+	_, err := CreateUser(Db, login, password, role)
 	if err != nil {
-		http.Error(w, "Ошибка при хешировании пароля", http.StatusInternalServerError)
-		return
-	}
-
-	// Выполняем SQL-запрос для добавления пользователя
-	query := "INSERT INTO users (login, password, role) VALUES ($1, $2, $3)"
-	_, err = Db.Exec(query, login, hashedPassword, role)
-	if err != nil {
-		http.Error(w, "Ошибка добавления пользователя: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Получаем ID текущего администратора из формы
-	adminIDStr := r.FormValue("admin_id")
-	adminID, err := strconv.Atoi(adminIDStr)
-	if err != nil || adminID <= 0 {
-		http.Error(w, "Неверный идентификатор администратора", http.StatusBadRequest)
+		writeUserError(w, err)
 		return
 	}
 
@@ -102,35 +83,28 @@ func AddUserHandler(w http.ResponseWriter, r *http.Request) {
 
 // Обработчик для страницы администратора
 func AdminPage(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.URL.Query().Get("id")
-	if userIDStr == "" {
-		http.Error(w, "ID пользователя не передан", http.StatusBadRequest)
-		return
-	}
-
-	// Преобразуем строковый параметр id в целое число
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+	// Список сотрудников доступен только администратору из сессии
+	userID, ok := sessionAdmin(w, r)
+	if !ok {
 		return
 	}
 
 	// Получаем имя пользователя по его ID
-	userName, err := GetUserNameByIDFromDB(userID)
+	userName, err := GetUserNameByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении имени пользователя из базы данных", http.StatusInternalServerError)
 		return
 	}
 
 	// Получаем роль пользователя по его ID
-	role, err := GetUserRoleByIDFromDB(userID)
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении роли пользователя из базы данных", http.StatusInternalServerError)
 		return
 	}
 
-	// Получаем список сотрудников
-	users, err := GetAllUsers()
+	// Получаем страницу списка сотрудников с учётом поиска
+	list, err := LoadUserList(r)
 	if err != nil {
 		http.Error(w, "Ошибка получения списка сотрудников", http.StatusInternalServerError)
 		return
@@ -142,11 +116,15 @@ func AdminPage(w http.ResponseWriter, r *http.Request) {
 		UserName string
 		Role     string
 		Users    []User
+		List     UserList
+		Roles    []string
 	}{
 		UserID:   userID,
 		UserName: userName,
 		Role:     role,
-		Users:    users,
+		Users:    list.Users,
+		List:     list,
+		Roles:    KnownRoles,
 	}
 
 	// Выполняем рендеринг шаблона для страницы администратора
@@ -161,8 +139,9 @@ func AdminPage(w http.ResponseWriter, r *http.Request) {
 // Обработчик для удаления пользователя
 // Обработчик для удаления пользователя
 func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Неправильный метод запроса", http.StatusMethodNotAllowed)
+	// Текущий администратор берётся из сессии
+	adminID, ok := adminForm(w, r)
+	if !ok {
 		return
 	}
 
@@ -174,19 +153,33 @@ func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Удаляем пользователя из базы данных
-	query := "DELETE FROM users WHERE id = $1"
-	_, err = Db.Exec(query, userID)
-	if err != nil {
-		http.Error(w, "Ошибка при удалении пользователя: "+err.Error(), http.StatusInternalServerError)
+	if userID == adminID {
+		http.Error(w, "Нельзя удалить собственную учётную запись", http.StatusBadRequest)
 		return
 	}
 
-	// Получаем ID текущего администратора
-	adminIDStr := r.FormValue("admin_id")
-	adminID, err := strconv.Atoi(adminIDStr)
-	if err != nil || adminID <= 0 {
-		http.Error(w, "Неверный идентификатор администратора", http.StatusBadRequest)
+	// Преемник, которому передаётся незавершённая работа пользователя (необязательно)
+	successorID := 0
+	if successorIDStr := r.FormValue("successor_id"); successorIDStr != "" {
+		successorID, err = strconv.Atoi(successorIDStr)
+		if err != nil || successorID <= 0 || successorID == userID {
+			http.Error(w, "Неверный идентификатор преемника", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Перемещаем пользователя в корзину с передачей его работы
+	err = SoftDeleteUser(userID, successorID, adminID)
+	if err == ErrUserNotFound {
+		http.Error(w, "Пользователь не найден или уже удалён", http.StatusNotFound)
+		return
+	}
+	if err == ErrSuccessorNotFound {
+		http.Error(w, "Преемник не найден или удалён", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при удалении пользователя: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Публикация в ответах JSON API
+type publicationJSON struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Status    string    `json:"status"`
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newPublicationJSON(pub Publication) publicationJSON {
+	return publicationJSON{
+		ID:        pub.ID,
+		Title:     pub.Title,
+		Content:   pub.Content,
+		Status:    pub.Status,
+		Version:   pub.Version,
+		UpdatedAt: pub.UpdatedAt,
+	}
+}
+
+// Ответ JSON API с ошибкой; при конфликте версий содержит текущее состояние публикации
+type apiError struct {
+	Error   string           `json:"error"`
+	Current *publicationJSON `json:"current,omitempty"`
+}
+
+// writeJSON отправляет ответ в формате JSON
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		Logger(r.Context()).Error("Ошибка кодирования JSON", "error", err)
+	}
+}
+
+// writeJSONError отправляет сообщение об ошибке в формате JSON
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeJSON(w, r, status, apiError{Error: message})
+}
+
+// parseETagVersion извлекает номер версии из заголовка If-Match. Для "*" возвращается 0.
+func parseETagVersion(header string) (int, bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, true
+	}
+	header = strings.TrimPrefix(header, "W/")
+	if !strings.HasPrefix(header, `"v`) || !strings.HasSuffix(header, `"`) || len(header) < 4 {
+		return 0, false
+	}
+	version, err := strconv.Atoi(header[2 : len(header)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// apiPublicationAccess проверяет сессию и права пользователя на публикацию. При ошибке ответ уже отправлен.
+func apiPublicationAccess(w http.ResponseWriter, r *http.Request) (Publication, int, bool) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		writeJSONError(w, r, http.StatusUnauthorized, "Необходимо войти в систему")
+		return Publication{}, 0, false
+	}
+
+	publicationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "Неверный идентификатор публикации")
+		return Publication{}, 0, false
+	}
+
+	allowed, err := canManageContributors(r.Context(), publicationID, userID)
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, "Ошибка проверки прав")
+		return Publication{}, 0, false
+	}
+	if !allowed {
+		writeJSONError(w, r, http.StatusForbidden, "Недостаточно прав для доступа к публикации")
+		return Publication{}, 0, false
+	}
+
+	pub, err := GetPublicationForEdit(publicationID)
+	if err == sql.ErrNoRows {
+		writeJSONError(w, r, http.StatusNotFound, "Публикация не найдена")
+		return Publication{}, 0, false
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, "Ошибка при получении публикации")
+		return Publication{}, 0, false
+	}
+	return pub, userID, true
+}
+
+// JSON API публикации: GET возвращает публикацию с ETag, PUT сохраняет текст.
+// PUT требует версию: в заголовке If-Match (при расхождении — 412, при неверном заголовке — 400)
+// или в поле version тела запроса (при расхождении — 409). Без версии — 428.
+func PublicationAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		pub, _, ok := apiPublicationAccess(w, r)
+		if !ok {
+			return
+		}
+		etag := PublicationETag(pub.Version)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, newPublicationJSON(pub))
+
+	case http.MethodPut:
+		pub, userID, ok := apiPublicationAccess(w, r)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Title   string `json:"title"`
+			Content string `json:"content"`
+			Version int    `json:"version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, "Неверный формат JSON")
+			return
+		}
+		if body.Title == "" || body.Content == "" {
+			writeJSONError(w, r, http.StatusBadRequest, "Название и содержание обязательны")
+			return
+		}
+
+		conflictStatus := http.StatusConflict
+		version := body.Version
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			conflictStatus = http.StatusPreconditionFailed
+			var valid bool
+			version, valid = parseETagVersion(ifMatch)
+			if !valid {
+				// Непонятный заголовок — ошибка запроса, а не расхождение версий
+				writeJSONError(w, r, http.StatusBadRequest, "Неверный заголовок If-Match")
+				return
+			}
+			if version == 0 {
+				version = pub.Version
+			}
+		}
+		if version <= 0 {
+			writeJSONError(w, r, http.StatusPreconditionRequired, "Укажите версию публикации в заголовке If-Match или в поле version")
+			return
+		}
+
+		_, err := UpdatePublicationText(pub.ID, version, userID, body.Title, body.Content, "")
+		if err == ErrVersionConflict {
+			current, err := GetPublicationForEdit(pub.ID)
+			if err != nil {
+				writeJSONError(w, r, http.StatusInternalServerError, "Ошибка при получении публикации")
+				return
+			}
+			currentJSON := newPublicationJSON(current)
+			w.Header().Set("ETag", PublicationETag(current.Version))
+			writeJSON(w, r, conflictStatus, apiError{Error: ErrVersionConflict.Error(), Current: &currentJSON})
+			return
+		}
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "Публикация не найдена")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, r, http.StatusInternalServerError, "Ошибка при сохранении публикации")
+			return
+		}
+
+		saved, err := GetPublicationForEdit(pub.ID)
+		if err != nil {
+			writeJSONError(w, r, http.StatusInternalServerError, "Ошибка при получении публикации")
+			return
+		}
+		w.Header().Set("ETag", PublicationETag(saved.Version))
+		writeJSON(w, r, http.StatusOK, newPublicationJSON(saved))
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "Метод не поддерживается")
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -43,22 +44,115 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
 // Функция аутентификации
 func AuthenticateUser(ctx context.Context, login, password string) int {
-	query := "SELECT id, password, role FROM users WHERE login = $1"
+	query := "SELECT id, password, role, is_active FROM users WHERE login = $1 AND deleted_at IS NULL"
 	var currentUser User
 	var hashedPassword string
-	err := Db.QueryRow(query, login).Scan(&currentUser.IDuser, &hashedPassword, &currentUser.Role)
-	if err != nil {
-		log.Println("Ошибка при выполнении запроса:", err)
+	err := Db.QueryRowContext(ctx, query, login).Scan(&currentUser.IDuser, &hashedPassword, &currentUser.Role, &currentUser.IsActive)
+	if err == sql.ErrNoRows {
+		loginAttempts.Inc(loginUnknownUser)
 		return 0 // Если пользователя не найдено, возвращаем 0
 	}
+	if err != nil {
+		loginAttempts.Inc(loginError)
+		Logger(ctx).Error("Ошибка при выполнении запроса", "login", login, "error", err)
+		return 0
+	}
+	if !currentUser.IsActive {
+		loginAttempts.Inc(loginInactive)
+		Logger(ctx).Warn("Попытка входа деактивированного пользователя", "login", login)
+		return -1 // Деактивированный пользователь не может войти
+	}
 	if CheckPasswordHash(password, hashedPassword) {
+		loginAttempts.Inc(loginSuccess)
 		return currentUser.IDuser // Возвращаем ID пользователя при успешной аутентификации
 	} else {
-		log.Println("Неверный пароль для пользователя:", login)
+		loginAttempts.Inc(loginWrongPassword)
+		Logger(ctx).Warn("Неверный пароль", "login", login)
 		return -1 // Возвращаем -1 при неверном пароле
 	}
 }
 
+// Имя cookie сессии
+const sessionName = "session-name"
+
+// StartSession сохраняет пользователя в сессии вместе с текущей версией сессий пользователя.
+// Увеличение версии в базе данных завершает все ранее открытые сессии.
+func StartSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	var role string
+	var version int
+	err := Db.QueryRow("SELECT role, session_version FROM users WHERE id = $1", userID).Scan(&role, &version)
+	if err != nil {
+		return err
+	}
+
+	session, _ := store.Get(r, sessionName)
+	session.Values["user_id"] = userID
+	session.Values["session_version"] = version
+	if role == RoleAuthor {
+		session.Values["author_id"] = userID
+	}
+	return session.Save(r, w)
+}
+
+// CurrentUserID возвращает ID пользователя из действующей сессии
+func CurrentUserID(r *http.Request) (int, bool) {
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		return 0, false
+	}
+	userID, ok := session.Values["user_id"].(int)
+	if !ok || userID == 0 {
+		return 0, false
+	}
+	version, _ := session.Values["session_version"].(int)
+
+	var current int
+	var active bool
+	err = Db.QueryRow("SELECT session_version, is_active FROM users WHERE id = $1 AND deleted_at IS NULL", userID).Scan(&current, &active)
+	if err != nil || !active || current != version {
+		return 0, false
+	}
+	setRequestUser(r.Context(), userID)
+	return userID, true
+}
+
+// sessionRole возвращает пользователя сессии и его роль, если роль подходит под allowed.
+// При ошибке ответ уже отправлен: 401 без сессии, 403 при неподходящей роли с сообщением denied.
+func sessionRole(w http.ResponseWriter, r *http.Request, allowed func(role string) bool, denied string) (userID int, role string, ok bool) {
+	userID, ok = CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return 0, "", false
+	}
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении роли пользователя", http.StatusInternalServerError)
+		return 0, "", false
+	}
+	if !allowed(role) {
+		http.Error(w, denied, http.StatusForbidden)
+		return 0, "", false
+	}
+	return userID, role, true
+}
+
+// Выход пользователя из системы
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Снимаем блокировки редактирования, которые держал пользователь
+	if userID, ok := CurrentUserID(r); ok {
+		if err := ReleaseUserLocks(userID); err != nil {
+			Logger(r.Context()).Error("Ошибка снятия блокировок пользователя", "user_id", userID, "error", err)
+		}
+	}
+
+	session, _ := store.Get(r, sessionName)
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		Logger(r.Context()).Error("Ошибка при завершении сессии", "error", err)
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
 // Хеширование пароля
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -74,16 +168,30 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// Параметры подключения к базе данных; используются и пулом соединений, и слушателем LISTEN/NOTIFY
+const DatabaseDSN = "user=postgres password=1234 dbname=map sslmode=disable"
+
 // Функция для открытия базы данных
 func OpenDatabase() {
+	if err := ConnectDatabase(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Успешно подключено к базе данных")
+
+	if err := MigrateDatabase(); err != nil {
+		log.Fatal("Не удалось применить миграции базы данных:", err)
+	}
+}
+
+// ConnectDatabase подключается к базе данных без применения миграций
+func ConnectDatabase() error {
 	var err error
-	Db, err = sql.Open("postgres", "user=postgres password=1234 dbname=map sslmode=disable")
+	Db, err = sql.Open("postgres", DatabaseDSN)
 	if err != nil {
-		log.Fatal("Не удалось подключиться к базе данных:", err)
+		return fmt.Errorf("не удалось подключиться к базе данных: %w", err)
 	}
-	err = Db.Ping()
-	if err != nil {
-		log.Fatal("Не удалось выполнить ping базы данных:", err)
+	if err = Db.Ping(); err != nil {
+		return fmt.Errorf("не удалось выполнить ping базы данных: %w", err)
 	}
-	log.Println("Успешно подключено к базе данных")
+	return nil
 }
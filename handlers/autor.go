@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"database/sql"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,7 +12,7 @@ func GetAvailableTopicsForAuthor() ([]Topic, error) {
 	var topics []Topic
 
 	// Фильтруем темы по статусу 'assigned'
-	query := "SELECT id, topic, department FROM user_topics "
+	query := "SELECT id, topic, department FROM user_topics WHERE deleted_at IS NULL"
 
 	rows, err := Db.Query(query)
 	if err != nil {
@@ -57,9 +56,13 @@ func GetTopicNameByID(topicID int) (string, error) {
 	return topicName, nil
 }
 
+// GetAuthorPublications возвращает все публикации, в которых пользователь участвует как автор, соавтор или в другой роли
 func GetAuthorPublications(authorID int) ([]Publication, error) {
 	var publications []Publication
-	query := "SELECT id, title, status FROM publications WHERE author_id = $1"
+	query := `SELECT p.id, p.title, p.status, p.author_id, c.role
+              FROM publication_contributors c JOIN publications p ON p.id = c.publication_id
+              WHERE c.user_id = $1 AND p.deleted_at IS NULL
+              ORDER BY p.updated_at DESC`
 	rows, err := Db.Query(query, authorID)
 	if err != nil {
 		return nil, err
@@ -68,20 +71,61 @@ func GetAuthorPublications(authorID int) ([]Publication, error) {
 
 	for rows.Next() {
 		var publication Publication
-		if err := rows.Scan(&publication.ID, &publication.Title, &publication.Status); err != nil {
+		if err := rows.Scan(&publication.ID, &publication.Title, &publication.Status, &publication.AuthorID, &publication.ContributorRole); err != nil {
 			return nil, err
 		}
 		publications = append(publications, publication)
 	}
-	return publications, nil
+	return publications, rows.Err()
 }
 
-// GetTopicsByAuthorID получает список доступных тем для автора
+// createPublication создаёт черновик с рубрикой и тегами из формы, его изображениями и записывает создателя первым автором в подписи
+func createPublication(r *http.Request, title, content string, topicID, authorID int, images []PublicationImage) (int, error) {
+	tx, err := Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Срок сдачи и отдел берутся из назначения темы
+	query := `INSERT INTO publications (title, content, topic_id, author_id, status, created_at, updated_at, due_at, department)
+              VALUES ($1, $2, $3, $4, 'draft', $5, $5,
+                      (SELECT due_at FROM topic_assignments WHERE topic_id = $3 AND author_id = $4),
+                      (SELECT department FROM user_topics WHERE id = $3))
+              RETURNING id`
+	var pubID int
+	if err := tx.QueryRow(query, title, content, topicID, authorID, time.Now()).Scan(&pubID); err != nil {
+		return 0, err
+	}
+	if err := AddContributor(tx, pubID, authorID, ContributorAuthor); err != nil {
+		return 0, err
+	}
+	if err := SavePublicationImages(tx, pubID, images); err != nil {
+		return 0, err
+	}
+	if _, err := SaveRevision(tx, pubID, authorID, "Создание"); err != nil {
+		return 0, err
+	}
+	categoryID, _ := strconv.Atoi(r.FormValue("category_id"))
+	if err := SetPublicationTaxonomy(tx, pubID, categoryID, ParseTagNames(r.FormValue("tags"))); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	publishPublicationEvent(r.Context(), EventSubmitted, pubID, authorID, "Новая публикация «"+title+"»")
+	return pubID, nil
+}
+
+// GetTopicsByAuthorID получает список тем, назначенных автору (кроме отклонённых)
 func GetTopicsByAuthorID(authorID int) ([]Topic, error) {
 	var topics []Topic
 
 	// Запрос для получения списка тем, связанных с автором
-	query := "SELECT id, topic, department FROM user_topics "
+	query := `SELECT t.id, t.topic, t.department, a.id, a.status, a.due_at
+              FROM topic_assignments a JOIN user_topics t ON t.id = a.topic_id
+              WHERE a.author_id = $1 AND a.status IN ('assigned', 'accepted') AND t.deleted_at IS NULL
+              ORDER BY a.due_at NULLS LAST, a.assigned_at`
 	rows, err := Db.Query(query, authorID)
 	if err != nil {
 		return nil, err
@@ -90,7 +134,7 @@ func GetTopicsByAuthorID(authorID int) ([]Topic, error) {
 
 	for rows.Next() {
 		var topic Topic
-		if err := rows.Scan(&topic.ID, &topic.Topic, &topic.Department); err != nil {
+		if err := rows.Scan(&topic.ID, &topic.Topic, &topic.Department, &topic.AssignmentID, &topic.AssignmentStatus, &topic.DueAt); err != nil {
 			return nil, err
 		}
 		topics = append(topics, topic)
@@ -107,7 +151,7 @@ func GetTopicsByAuthorID(authorID int) ([]Topic, error) {
 // Функция для получения всех доступных тем
 func GetAllTopics() ([]Topic, error) {
 	var topics []Topic
-	query := "SELECT id, topic, department FROM user_topics"
+	query := "SELECT id, topic, department FROM user_topics WHERE deleted_at IS NULL"
 	rows, err := Db.Query(query)
 	if err != nil {
 		return nil, err
@@ -151,22 +195,28 @@ func FixCommentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	query := `UPDATE publications 
               SET corrections = $1, status = 'under_review' 
-              WHERE id = $2`
+              WHERE id = $2
+              RETURNING COALESCE(author_id, 0)`
 
-	_, err = Db.Exec(query, corrections, publicationID)
+	var authorID int
+	err = Db.QueryRow(query, corrections, publicationID).Scan(&authorID)
 	if err != nil {
 		http.Error(w, "Ошибка при обновлении публикации", http.StatusInternalServerError)
 		return
 	}
+	if err := AddPublicationComment(publicationID, authorID, "Ответ автора: "+corrections); err != nil {
+		Logger(r.Context()).Error("Ошибка сохранения ответа на замечания", "publication_id", publicationID, "error", err)
+	}
+	publishPublicationEvent(r.Context(), EventComment, publicationID, 0, "Автор ответил на замечания: "+corrections)
 
 	http.Redirect(w, r, "/author/publications", http.StatusSeeOther)
 }
 
 func AuthorCreatePublicationFormHandler(w http.ResponseWriter, r *http.Request) {
-	authorIDStr := r.URL.Query().Get("author_id")
-	authorID, err := strconv.Atoi(authorIDStr)
-	if err != nil {
-		http.Error(w, "Неверный идентификатор автора", http.StatusBadRequest)
+	// Автор публикации — пользователь сессии
+	authorID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
 		return
 	}
 
@@ -177,20 +227,38 @@ func AuthorCreatePublicationFormHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	assigned, err := CheckTopicAssignedToAuthor(topicID, authorID)
+	if err != nil {
+		http.Error(w, "Ошибка проверки назначения темы", http.StatusInternalServerError)
+		return
+	}
+	if !assigned {
+		http.Error(w, "Тема не назначена этому автору", http.StatusForbidden)
+		return
+	}
+
 	topicName, err := GetTopicNameByID(topicID)
 	if err != nil {
 		http.Error(w, "Ошибка получения названия темы: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	categories, err := GetCategoryTree()
+	if err != nil {
+		http.Error(w, "Ошибка получения рубрик: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	data := struct {
-		TopicID   int
-		TopicName string
-		AuthorID  int
+		TopicID    int
+		TopicName  string
+		AuthorID   int
+		Categories []Category
 	}{
-		TopicID:   topicID,
-		TopicName: topicName,
-		AuthorID:  authorID,
+		TopicID:    topicID,
+		TopicName:  topicName,
+		AuthorID:   authorID,
+		Categories: categories,
 	}
 
 	err = TmplCreatePublication.Execute(w, data)
@@ -208,7 +276,7 @@ func AuthorCreatePublicationHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Получение ID автора из сессии
-	session, _ := store.Get(r, "session-name")
+	session, _ := store.Get(r, sessionName)
 	authorID, ok := session.Values["author_id"].(int)
 	if !ok || authorID == 0 {
 		http.Error(w, "Автор не авторизован", http.StatusUnauthorized)
@@ -233,29 +301,14 @@ func AuthorCreatePublicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Текущее время для полей created_at и updated_at
-	currentTime := time.Now()
-
-	// SQL-запрос для вставки записи, включая created_at и updated_at
-	query := `INSERT INTO publications 
-                (title, content, topic_id, author_id, status, created_at, updated_at) 
-              VALUES ($1, $2, $3, $4, 'draft', $5, $5)`
-
-	// Выполнение запроса с параметрами
-	result, err := Db.Exec(query, title, content, topicID, authorID, currentTime)
+	// Создание публикации вместе с записью автора в подписи
+	pubID, err := createPublication(r, title, content, topicID, authorID, nil)
 	if err != nil {
-		log.Printf("Ошибка при выполнении запроса вставки: %v", err)
+		Logger(r.Context()).Error("Ошибка при создании публикации", "error", err)
 		http.Error(w, "Ошибка при создании публикации: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// Получение ID созданной публикации для проверки результата
-	pubID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Ошибка получения ID вставленной публикации: %v", err)
-	} else {
-		log.Printf("Публикация успешно создана с ID: %d", pubID)
-	}
+	Logger(r.Context()).Info("Публикация создана", "publication_id", pubID)
 
 	// Перенаправление на страницу автора
 	http.Redirect(w, r, "/author?id="+strconv.Itoa(authorID), http.StatusSeeOther)
@@ -266,11 +319,10 @@ func CreatePublicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получение ID автора из формы
-	authorIDStr := r.FormValue("author_id")
-	authorID, err := strconv.Atoi(authorIDStr)
-	if err != nil || authorID == 0 {
-		http.Error(w, "Неверный идентификатор автора", http.StatusBadRequest)
+	// Автор публикации — пользователь сессии
+	authorID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
 		return
 	}
 
@@ -291,22 +343,12 @@ func CreatePublicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Проверка существования `topic_id`
-	exists, err := CheckTopicExists(topicID)
-	if err != nil {
-		http.Error(w, "Ошибка проверки темы", http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		http.Error(w, "Указанная тема не существует", http.StatusBadRequest)
+	if !checkAuthorTopic(w, topicID, authorID) {
 		return
 	}
 
-	// Создание публикации
-	query := `INSERT INTO publications (title, content, topic_id, author_id, status, created_at, updated_at) 
-              VALUES ($1, $2, $3, $4, 'draft', $5, $5)`
-	currentTime := time.Now()
-	_, err = Db.Exec(query, title, content, topicID, authorID, currentTime)
+	// Создание публикации; срок сдачи и отдел берутся из назначения темы
+	_, err = createPublication(r, title, content, topicID, authorID, nil)
 	if err != nil {
 		http.Error(w, "Ошибка при создании публикации: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -317,10 +359,35 @@ func CreatePublicationHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 }
 
+// checkAuthorTopic проверяет, что тема существует и назначена автору; при ошибке сам отвечает клиенту
+func checkAuthorTopic(w http.ResponseWriter, topicID, authorID int) bool {
+	exists, err := CheckTopicExists(topicID)
+	if err != nil {
+		http.Error(w, "Ошибка проверки темы", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		http.Error(w, "Указанная тема не существует", http.StatusBadRequest)
+		return false
+	}
+
+	// Публикацию можно создать только по теме, принятой автором
+	assigned, err := CheckTopicAssignedToAuthor(topicID, authorID)
+	if err != nil {
+		http.Error(w, "Ошибка проверки назначения темы", http.StatusInternalServerError)
+		return false
+	}
+	if !assigned {
+		http.Error(w, "Тема не назначена этому автору", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 // CheckTopicExists проверяет, существует ли тема с данным ID в таблице user_topics.
 func CheckTopicExists(topicID int) (bool, error) {
 	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM user_topics WHERE id = $1)`
+	query := `SELECT EXISTS(SELECT 1 FROM user_topics WHERE id = $1 AND deleted_at IS NULL)`
 
 	// Выполняем запрос в базу данных, который возвращает true, если запись существует
 	err := Db.QueryRow(query, topicID).Scan(&exists)
@@ -338,12 +405,18 @@ func AuthorPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topics, err := GetAllTopics()
+	topics, err := GetTopicsByAuthorID(authorID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении тем", http.StatusInternalServerError)
 		return
 	}
 
+	openTopics, err := GetOpenTopics(authorID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении открытых тем", http.StatusInternalServerError)
+		return
+	}
+
 	// Получение публикаций автора
 	publications, err := GetAuthorPublications(authorID)
 	if err != nil {
@@ -354,10 +427,12 @@ func AuthorPage(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		AuthorID     int
 		Topics       []Topic
+		OpenTopics   []Topic
 		Publications []Publication
 	}{
 		AuthorID:     authorID,
 		Topics:       topics,
+		OpenTopics:   openTopics,
 		Publications: publications,
 	}
 
@@ -379,11 +454,18 @@ func EditPublicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Править текст могут все авторы публикации
+	userID, ok := publicationEditor(w, r, publicationID)
+	if !ok {
+		return
+	}
+
 	// Получаем информацию о публикации
 	var title, content, status, remarks string
-	var authorID int
-	query := `SELECT title, content, status, remarks, author_id FROM publications WHERE id = $1`
-	err = Db.QueryRow(query, publicationID).Scan(&title, &content, &status, &remarks, &authorID)
+	var authorID, version int
+	var categoryID sql.NullInt64
+	query := `SELECT title, content, status, remarks, author_id, category_id, version FROM publications WHERE id = $1 AND deleted_at IS NULL`
+	err = Db.QueryRow(query, publicationID).Scan(&title, &content, &status, &remarks, &authorID, &categoryID, &version)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Публикация не найдена", http.StatusNotFound)
@@ -399,14 +481,56 @@ func EditPublicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Берём блокировку редактирования на пользователя сессии; если публикацию уже правит другой пользователь,
+	// показываем, кто именно
+	lock, acquired, err := AcquireEditLock(publicationID, userID)
+	if err != nil {
+		http.Error(w, "Ошибка при блокировке публикации: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contributors, err := GetContributors(publicationID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении участников публикации: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	categories, err := GetCategoryTree()
+	if err != nil {
+		http.Error(w, "Ошибка при получении рубрик: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tags, err := GetPublicationTags(publicationID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении тегов: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	revisions, err := GetRevisions(publicationID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении версий: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Данные для шаблона
 	data := map[string]interface{}{
-		"ID":       publicationID,
-		"Title":    title,
-		"Content":  content,
-		"Status":   status,
-		"Remarks":  remarks,
-		"AuthorID": authorID,
+		"ID":            publicationID,
+		"Title":         title,
+		"Content":       content,
+		"Status":        status,
+		"Version":       version,
+		"Remarks":       remarks,
+		"AuthorID":      authorID,
+		"UserID":        userID,
+		"Contributors":  contributors,
+		"Roles":         ContributorRoles,
+		"RoleLabels":    ContributorRoleLabels,
+		"Categories":    categories,
+		"CategoryID":    int(categoryID.Int64),
+		"TagList":       Publication{Tags: tags}.TagList(),
+		"Revisions":     revisions,
+		"Lock":          lock,
+		"LockedByOther": !acquired,
+		"HeartbeatMs":   LockHeartbeat.Milliseconds(),
 	}
 
 	// Рендеринг шаблона
@@ -422,8 +546,6 @@ func UpdatePublicationHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Получаем данные из формы
 	publicationIDStr := r.FormValue("publication_id")
-	title := r.FormValue("title")
-	content := r.FormValue("content")
 
 	publicationID, err := strconv.Atoi(publicationIDStr)
 	if err != nil {
@@ -431,14 +553,49 @@ func UpdatePublicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Обновляем публикацию в базе данных
-	updateQuery := `UPDATE publications SET title = $1, content = $2, status = 'pending', updated_at = $3 WHERE id = $4`
-	_, err = Db.Exec(updateQuery, title, content, time.Now(), publicationID)
-	if err != nil {
-		http.Error(w, "Ошибка при обновлении публикации: "+err.Error(), http.StatusInternalServerError)
+	userID, ok := publicationEditor(w, r, publicationID)
+	if !ok {
+		return
+	}
+
+	// Обновляем публикацию в базе данных, если её не изменили с момента открытия формы
+	fields := map[string]string{
+		"publication_id": publicationIDStr,
+		"user_id":        strconv.Itoa(userID),
+		"category_id":    r.FormValue("category_id"),
+		"tags":           r.FormValue("tags"),
+	}
+	backURL := "/author/edit_publication?publication_id=" + publicationIDStr
+	if !savePublicationForm(w, r, publicationID, userID, "pending", fields, backURL) {
+		return
+	}
+
+	// Рубрика и теги
+	if err := updatePublicationTaxonomy(publicationID, r); err != nil {
+		writeUserError(w, err)
 		return
 	}
 
 	// Перенаправление обратно к списку публикаций автора
-	http.Redirect(w, r, "/author_page?id="+r.FormValue("author_id"), http.StatusSeeOther)
+	http.Redirect(w, r, "/author_page?id="+strconv.Itoa(userID), http.StatusSeeOther)
+}
+
+// publicationEditor проверяет, что пользователь сессии является автором или соавтором публикации.
+// При ошибке ответ уже отправлен.
+func publicationEditor(w http.ResponseWriter, r *http.Request, publicationID int) (int, bool) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return 0, false
+	}
+	allowed, err := CanEditPublication(publicationID, userID)
+	if err != nil {
+		http.Error(w, "Ошибка проверки прав: "+err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if !allowed {
+		http.Error(w, "Редактировать публикацию могут только её авторы", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
 }
@@ -0,0 +1,1292 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+var TmplDataTransfer = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "data_transfer.html"))
+
+// Формат выгрузки редакционной базы. Версия растёт при несовместимых изменениях структуры,
+// импорт принимает выгрузки своей и более ранних версий.
+const (
+	bundleFormat    = "myproject-bundle"
+	BundleVersion   = 1
+	maxBundleUpload = 200 << 20
+	maxBundleErrors = 100
+)
+
+// Стратегии разрешения конфликтов с уже существующими данными
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+)
+
+var ConflictStrategies = []string{ConflictSkip, ConflictOverwrite, ConflictFail}
+
+var ConflictStrategyLabels = map[string]string{
+	ConflictSkip:      "оставить существующие данные",
+	ConflictOverwrite: "перезаписать существующие данные",
+	ConflictFail:      "остановить импорт при любом совпадении",
+}
+
+// BundleError — ошибка в загруженном файле выгрузки, которую нужно показать администратору
+type BundleError struct {
+	Message string
+}
+
+func (e *BundleError) Error() string {
+	return e.Message
+}
+
+// Пользователь в выгрузке. Хеш пароля выгружается только по явному запросу.
+type BundleUser struct {
+	ID           int    `json:"id"`
+	Login        string `json:"login"`
+	Role         string `json:"role"`
+	IsActive     bool   `json:"is_active"`
+	FullName     string `json:"full_name"`
+	Email        string `json:"email"`
+	Bio          string `json:"bio"`
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// Отдел; отдельной таблицы нет, список собирается из тем и публикаций
+type BundleDepartment struct {
+	Name string `json:"name"`
+}
+
+type BundleTopic struct {
+	ID         int        `json:"id"`
+	EditorID   *int       `json:"editor_id"`
+	Topic      string     `json:"topic"`
+	Department string     `json:"department"`
+	IsOpen     bool       `json:"is_open"`
+	DueAt      *time.Time `json:"due_at"`
+	AssignedAt *time.Time `json:"assigned_at"`
+}
+
+type BundleAssignment struct {
+	TopicID    int        `json:"topic_id"`
+	AuthorID   int        `json:"author_id"`
+	Status     string     `json:"status"`
+	DueAt      *time.Time `json:"due_at"`
+	AssignedAt *time.Time `json:"assigned_at"`
+}
+
+type BundlePublication struct {
+	ID          int        `json:"id"`
+	TopicID     *int       `json:"topic_id"`
+	AuthorID    *int       `json:"author_id"`
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	Status      string     `json:"status"`
+	Department  string     `json:"department"`
+	Remarks     string     `json:"remarks"`
+	IsPublished bool       `json:"is_published"`
+	Version     int        `json:"version"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+	PublishedAt *time.Time `json:"published_at"`
+	DueAt       *time.Time `json:"due_at"`
+}
+
+type BundleContributor struct {
+	PublicationID int    `json:"publication_id"`
+	UserID        int    `json:"user_id"`
+	Role          string `json:"role"`
+	Position      int    `json:"position"`
+}
+
+type BundleRevision struct {
+	ID            int       `json:"id"`
+	PublicationID int       `json:"publication_id"`
+	Title         string    `json:"title"`
+	Content       string    `json:"content"`
+	Status        string    `json:"status"`
+	Version       *int      `json:"version"`
+	Note          string    `json:"note"`
+	CreatedBy     *int      `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type BundleComment struct {
+	ID            int       `json:"id"`
+	PublicationID int       `json:"publication_id"`
+	RevisionID    *int      `json:"revision_id"`
+	AuthorID      *int      `json:"author_id"`
+	Body          string    `json:"body"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Bundle — выгрузка редакционной базы. Идентификаторы в ней действуют только внутри файла,
+// при импорте объектам выдаются новые, а ссылки переписываются.
+type Bundle struct {
+	Format       string              `json:"format"`
+	Version      int                 `json:"version"`
+	ExportedAt   time.Time           `json:"exported_at"`
+	Users        []BundleUser        `json:"users"`
+	Departments  []BundleDepartment  `json:"departments"`
+	Topics       []BundleTopic       `json:"topics"`
+	Assignments  []BundleAssignment  `json:"assignments"`
+	Publications []BundlePublication `json:"publications"`
+	Contributors []BundleContributor `json:"contributors"`
+	Revisions    []BundleRevision    `json:"revisions"`
+	Comments     []BundleComment     `json:"comments"`
+}
+
+// bundleManifest — заголовок выгрузки в архиве CSV
+type bundleManifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// csvFiles перечисляет CSV-файлы архива и срезы, в которые они читаются
+func (b *Bundle) csvFiles() []struct {
+	Name string
+	Rows interface{}
+} {
+	return []struct {
+		Name string
+		Rows interface{}
+	}{
+		{"users.csv", &b.Users},
+		{"departments.csv", &b.Departments},
+		{"topics.csv", &b.Topics},
+		{"assignments.csv", &b.Assignments},
+		{"publications.csv", &b.Publications},
+		{"contributors.csv", &b.Contributors},
+		{"revisions.csv", &b.Revisions},
+		{"comments.csv", &b.Comments},
+	}
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+func nullTimePtr(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
+
+// timeOrNow подставляет текущее время вместо отсутствующей даты
+func timeOrNow(t *time.Time) time.Time {
+	if t == nil {
+		return time.Now()
+	}
+	return *t
+}
+
+// ---------- Выгрузка ----------
+
+// ExportBundle выгружает базу целиком из одного снимка. Удалённые в корзину объекты не попадают в выгрузку,
+// а ссылки на них обнуляются.
+func ExportBundle(includePasswords bool) (Bundle, error) {
+	b := Bundle{Format: bundleFormat, Version: BundleVersion, ExportedAt: time.Now().UTC()}
+	tx, err := Db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return b, err
+	}
+	defer tx.Rollback()
+
+	users := map[int]bool{}
+	rows, err := tx.Query(`SELECT u.id, u.login, u.role, u.is_active, u.password,
+                                  COALESCE(p.full_name, ''), COALESCE(p.email, ''), COALESCE(p.bio, '')
+                           FROM users u LEFT JOIN user_profiles p ON p.user_id = u.id
+                           WHERE u.deleted_at IS NULL ORDER BY u.id`)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var u BundleUser
+		var hash string
+		if err := rows.Scan(&u.ID, &u.Login, &u.Role, &u.IsActive, &hash, &u.FullName, &u.Email, &u.Bio); err != nil {
+			rows.Close()
+			return b, err
+		}
+		if includePasswords {
+			u.PasswordHash = hash
+		}
+		users[u.ID] = true
+		b.Users = append(b.Users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+	userRef := func(v sql.NullInt64) *int {
+		if !users[int(v.Int64)] {
+			return nil
+		}
+		return nullIntPtr(v)
+	}
+
+	departments := map[string]bool{}
+	topics := map[int]bool{}
+	rows, err = tx.Query(`SELECT id, editor_id, topic, COALESCE(department, ''), is_open, due_at, assigned_at
+                          FROM user_topics WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var t BundleTopic
+		var editorID sql.NullInt64
+		var dueAt, assignedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &editorID, &t.Topic, &t.Department, &t.IsOpen, &dueAt, &assignedAt); err != nil {
+			rows.Close()
+			return b, err
+		}
+		t.EditorID, t.DueAt, t.AssignedAt = userRef(editorID), nullTimePtr(dueAt), nullTimePtr(assignedAt)
+		topics[t.ID] = true
+		departments[t.Department] = true
+		b.Topics = append(b.Topics, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	rows, err = tx.Query(`SELECT a.topic_id, a.author_id, a.status, a.due_at, a.assigned_at
+                          FROM topic_assignments a ORDER BY a.id`)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var a BundleAssignment
+		var dueAt, assignedAt sql.NullTime
+		if err := rows.Scan(&a.TopicID, &a.AuthorID, &a.Status, &dueAt, &assignedAt); err != nil {
+			rows.Close()
+			return b, err
+		}
+		if !topics[a.TopicID] || !users[a.AuthorID] {
+			continue
+		}
+		a.DueAt, a.AssignedAt = nullTimePtr(dueAt), nullTimePtr(assignedAt)
+		b.Assignments = append(b.Assignments, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	publications := map[int]bool{}
+	rows, err = tx.Query(`SELECT id, topic_id, author_id, title, content, status, COALESCE(department, ''), COALESCE(remarks, ''),
+                                 COALESCE(is_published, FALSE), version, created_at, updated_at, published_at, due_at
+                          FROM publications WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var p BundlePublication
+		var topicID, authorID sql.NullInt64
+		var createdAt, updatedAt, publishedAt, dueAt sql.NullTime
+		if err := rows.Scan(&p.ID, &topicID, &authorID, &p.Title, &p.Content, &p.Status, &p.Department, &p.Remarks,
+			&p.IsPublished, &p.Version, &createdAt, &updatedAt, &publishedAt, &dueAt); err != nil {
+			rows.Close()
+			return b, err
+		}
+		if topics[int(topicID.Int64)] {
+			p.TopicID = nullIntPtr(topicID)
+		}
+		p.AuthorID = userRef(authorID)
+		p.CreatedAt, p.UpdatedAt, p.PublishedAt, p.DueAt = nullTimePtr(createdAt), nullTimePtr(updatedAt), nullTimePtr(publishedAt), nullTimePtr(dueAt)
+		publications[p.ID] = true
+		departments[p.Department] = true
+		b.Publications = append(b.Publications, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	rows, err = tx.Query(`SELECT publication_id, user_id, role, position FROM publication_contributors
+                          ORDER BY publication_id, position, user_id`)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var c BundleContributor
+		if err := rows.Scan(&c.PublicationID, &c.UserID, &c.Role, &c.Position); err != nil {
+			rows.Close()
+			return b, err
+		}
+		if publications[c.PublicationID] && users[c.UserID] {
+			b.Contributors = append(b.Contributors, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	revisions := map[int]bool{}
+	rows, err = tx.Query(`SELECT id, publication_id, title, content, status, version, note, created_by, created_at
+                          FROM publication_revisions ORDER BY id`)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var r BundleRevision
+		var version, createdBy sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.PublicationID, &r.Title, &r.Content, &r.Status, &version, &r.Note, &createdBy, &r.CreatedAt); err != nil {
+			rows.Close()
+			return b, err
+		}
+		if !publications[r.PublicationID] {
+			continue
+		}
+		r.Version, r.CreatedBy = nullIntPtr(version), userRef(createdBy)
+		revisions[r.ID] = true
+		b.Revisions = append(b.Revisions, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	rows, err = tx.Query(`SELECT id, publication_id, revision_id, author_id, body, created_at
+                          FROM publication_comments ORDER BY id`)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var c BundleComment
+		var revisionID, authorID sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.PublicationID, &revisionID, &authorID, &c.Body, &c.CreatedAt); err != nil {
+			rows.Close()
+			return b, err
+		}
+		if !publications[c.PublicationID] {
+			continue
+		}
+		if revisions[int(revisionID.Int64)] {
+			c.RevisionID = nullIntPtr(revisionID)
+		}
+		c.AuthorID = userRef(authorID)
+		b.Comments = append(b.Comments, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	var names []string
+	for name := range departments {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.Departments = append(b.Departments, BundleDepartment{Name: name})
+	}
+	return b, nil
+}
+
+// WriteBundleJSON записывает выгрузку одним JSON-файлом
+func WriteBundleJSON(out io.Writer, b Bundle) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// WriteBundleCSV записывает выгрузку ZIP-архивом: manifest.json и по CSV-файлу на каждый вид объектов
+func WriteBundleCSV(out io.Writer, b Bundle) error {
+	z := zip.NewWriter(out)
+	f, err := z.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(bundleManifest{Format: b.Format, Version: b.Version, ExportedAt: b.ExportedAt}); err != nil {
+		return err
+	}
+	for _, file := range b.csvFiles() {
+		f, err := z.Create(file.Name)
+		if err != nil {
+			return err
+		}
+		if err := writeCSVRows(f, file.Rows); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// ReadBundle разбирает загруженную выгрузку: JSON-файл или ZIP-архив с CSV
+func ReadBundle(data []byte) (Bundle, error) {
+	var b Bundle
+	if bytes.HasPrefix(data, []byte("PK")) {
+		return readBundleCSV(data)
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, &BundleError{Message: "Файл не является выгрузкой в формате JSON: " + err.Error()}
+	}
+	return b, nil
+}
+
+func readBundleCSV(data []byte) (Bundle, error) {
+	var b Bundle
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return b, &BundleError{Message: "Повреждённый ZIP-архив"}
+	}
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	manifest, err := readBundlePart(files, "manifest.json")
+	if err != nil {
+		return b, err
+	}
+	if manifest == nil {
+		return b, &BundleError{Message: "В архиве нет manifest.json"}
+	}
+	var m bundleManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return b, &BundleError{Message: "Некорректный manifest.json: " + err.Error()}
+	}
+	b.Format, b.Version, b.ExportedAt = m.Format, m.Version, m.ExportedAt
+
+	for _, file := range b.csvFiles() {
+		part, err := readBundlePart(files, file.Name)
+		if err != nil {
+			return b, err
+		}
+		if part == nil {
+			continue
+		}
+		if err := readCSVRows(bytes.NewReader(part), file.Rows); err != nil {
+			return b, &BundleError{Message: file.Name + ": " + err.Error()}
+		}
+	}
+	return b, nil
+}
+
+// readBundlePart читает файл архива с ограничением размера; отсутствующий файл возвращается как nil
+func readBundlePart(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, &BundleError{Message: "Не удалось открыть " + name}
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxBundleUpload+1))
+	if err != nil {
+		return nil, &BundleError{Message: "Не удалось прочитать " + name}
+	}
+	if len(data) > maxBundleUpload {
+		return nil, &BundleError{Message: "Файл " + name + " слишком большой"}
+	}
+	return data, nil
+}
+
+// ---------- Проверка ----------
+
+// ValidateBundle проверяет формат, обязательные поля и ссылки между объектами выгрузки.
+// Возвращает все найденные ошибки, чтобы их можно было исправить за один раз.
+func ValidateBundle(b Bundle) []string {
+	var errs []string
+	add := func(format string, args ...interface{}) {
+		if len(errs) < maxBundleErrors {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if b.Format != bundleFormat {
+		add("Неизвестный формат выгрузки %q", b.Format)
+		return errs
+	}
+	if b.Version < 1 || b.Version > BundleVersion {
+		add("Версия выгрузки %d не поддерживается (поддерживаются версии 1–%d)", b.Version, BundleVersion)
+		return errs
+	}
+
+	users := map[int]bool{}
+	logins := map[string]bool{}
+	for i, u := range b.Users {
+		if u.ID <= 0 || users[u.ID] {
+			add("Пользователь №%d: отсутствует или повторяется id %d", i+1, u.ID)
+		}
+		users[u.ID] = true
+		if u.Login == "" || strings.ContainsAny(u.Login, " \t\r\n") {
+			add("Пользователь %d: некорректный логин %q", u.ID, u.Login)
+		}
+		if logins[u.Login] {
+			add("Пользователь %d: логин %s повторяется", u.ID, u.Login)
+		}
+		logins[u.Login] = true
+		if err := ValidateRole(u.Role); err != nil {
+			add("Пользователь %d: %v", u.ID, err)
+		}
+		if u.PasswordHash != "" && !strings.HasPrefix(u.PasswordHash, "$2") {
+			add("Пользователь %d: хеш пароля не похож на bcrypt", u.ID)
+		}
+	}
+	optionalUser := func(what string, id int, ref *int) {
+		if ref != nil && !users[*ref] {
+			add("%s %d ссылается на пользователя %d, которого нет в выгрузке", what, id, *ref)
+		}
+	}
+
+	seenDepartments := map[string]bool{}
+	for _, d := range b.Departments {
+		if strings.TrimSpace(d.Name) == "" {
+			add("Пустое название отдела")
+		}
+		if seenDepartments[d.Name] {
+			add("Отдел %q повторяется", d.Name)
+		}
+		seenDepartments[d.Name] = true
+	}
+
+	topics := map[int]bool{}
+	for i, t := range b.Topics {
+		if t.ID <= 0 || topics[t.ID] {
+			add("Тема №%d: отсутствует или повторяется id %d", i+1, t.ID)
+		}
+		topics[t.ID] = true
+		if strings.TrimSpace(t.Topic) == "" {
+			add("Тема %d: пустое название", t.ID)
+		}
+		optionalUser("Тема", t.ID, t.EditorID)
+	}
+	for i, a := range b.Assignments {
+		if !topics[a.TopicID] {
+			add("Назначение №%d: нет темы %d", i+1, a.TopicID)
+		}
+		if !users[a.AuthorID] {
+			add("Назначение №%d: нет автора %d", i+1, a.AuthorID)
+		}
+		if a.Status == "" {
+			add("Назначение №%d: пустой статус", i+1)
+		}
+	}
+
+	publications := map[int]bool{}
+	for i, p := range b.Publications {
+		if p.ID <= 0 || publications[p.ID] {
+			add("Публикация №%d: отсутствует или повторяется id %d", i+1, p.ID)
+		}
+		publications[p.ID] = true
+		if strings.TrimSpace(p.Title) == "" {
+			add("Публикация %d: пустое название", p.ID)
+		}
+		if p.Status == "" {
+			add("Публикация %d: пустой статус", p.ID)
+		}
+		if p.TopicID != nil && !topics[*p.TopicID] {
+			add("Публикация %d ссылается на тему %d, которой нет в выгрузке", p.ID, *p.TopicID)
+		}
+		optionalUser("Публикация", p.ID, p.AuthorID)
+	}
+	for i, c := range b.Contributors {
+		if !publications[c.PublicationID] || !users[c.UserID] {
+			add("Участник №%d: нет публикации %d или пользователя %d", i+1, c.PublicationID, c.UserID)
+		}
+		if _, ok := ContributorRoleLabels[c.Role]; !ok {
+			add("Участник №%d: неизвестная роль %q", i+1, c.Role)
+		}
+	}
+
+	revisions := map[int]int{} // версия → публикация
+	for i, r := range b.Revisions {
+		if _, dup := revisions[r.ID]; r.ID <= 0 || dup {
+			add("Версия №%d: отсутствует или повторяется id %d", i+1, r.ID)
+		}
+		revisions[r.ID] = r.PublicationID
+		if !publications[r.PublicationID] {
+			add("Версия %d ссылается на публикацию %d, которой нет в выгрузке", r.ID, r.PublicationID)
+		}
+		optionalUser("Версия", r.ID, r.CreatedBy)
+	}
+	for i, c := range b.Comments {
+		if !publications[c.PublicationID] {
+			add("Замечание №%d ссылается на публикацию %d, которой нет в выгрузке", i+1, c.PublicationID)
+		}
+		if c.RevisionID != nil && revisions[*c.RevisionID] != c.PublicationID {
+			add("Замечание №%d ссылается на версию %d другой публикации", i+1, *c.RevisionID)
+		}
+		if strings.TrimSpace(c.Body) == "" {
+			add("Замечание №%d: пустой текст", i+1)
+		}
+		optionalUser("Замечание", c.ID, c.AuthorID)
+	}
+	return errs
+}
+
+// ---------- Импорт ----------
+
+// Итог импорта по одному виду объектов
+type ImportStat struct {
+	Entity  string
+	Created int
+	Updated int
+	Skipped int
+}
+
+// Отчёт об импорте. При пробном запуске все изменения откатываются, но отчёт тот же.
+type ImportReport struct {
+	DryRun    bool
+	Strategy  string
+	Stats     []*ImportStat
+	Conflicts []string
+	Warnings  []string
+	Errors    []string
+}
+
+// OK сообщает, что импорт прошёл (или прошёл бы) без ошибок
+func (r *ImportReport) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// StrategyLabel нужна шаблону для подписи стратегии
+func (r *ImportReport) StrategyLabel() string {
+	return ConflictStrategyLabels[r.Strategy]
+}
+
+func (r *ImportReport) stat(entity string) *ImportStat {
+	s := &ImportStat{Entity: entity}
+	r.Stats = append(r.Stats, s)
+	return s
+}
+
+// bundleImporter переносит выгрузку в базу и запоминает, какие новые идентификаторы получили объекты
+type bundleImporter struct {
+	tx       *sql.Tx
+	strategy string
+	report   *ImportReport
+
+	users        map[int]int
+	topics       map[int]int
+	publications map[int]int
+	revisions    map[int]int
+}
+
+// conflict отмечает совпадение с существующим объектом и решает, перезаписывать ли его
+func (im *bundleImporter) conflict(stat *ImportStat, format string, args ...interface{}) bool {
+	if len(im.report.Conflicts) < maxBundleErrors {
+		im.report.Conflicts = append(im.report.Conflicts, fmt.Sprintf(format, args...))
+	}
+	if im.strategy == ConflictOverwrite {
+		stat.Updated++
+		return true
+	}
+	stat.Skipped++
+	return false
+}
+
+// ref переводит ссылку из выгрузки в идентификатор в базе; nil остаётся NULL
+func ref(ids map[int]int, id *int) interface{} {
+	if id == nil {
+		return nil
+	}
+	return ids[*id]
+}
+
+// ImportBundle загружает выгрузку в одной транзакции. Объекты получают новые идентификаторы,
+// совпадения с существующими (по логину, названию темы, названию и автору публикации) решаются стратегией.
+// При пробном запуске или ошибке транзакция откатывается.
+func ImportBundle(b Bundle, strategy string, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun, Strategy: strategy}
+	if _, ok := ConflictStrategyLabels[strategy]; !ok {
+		return report, &BundleError{Message: "Неизвестная стратегия разрешения конфликтов: " + strategy}
+	}
+	if report.Errors = ValidateBundle(b); len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	im := &bundleImporter{
+		tx: tx, strategy: strategy, report: report,
+		users: map[int]int{}, topics: map[int]int{}, publications: map[int]int{}, revisions: map[int]int{},
+	}
+	steps := []func(Bundle) error{
+		im.importUsers, im.importDepartments, im.importTopics, im.importAssignments,
+		im.importPublications, im.importContributors, im.importRevisions, im.importComments,
+	}
+	for _, step := range steps {
+		if err := step(b); err != nil {
+			return report, err
+		}
+	}
+
+	if strategy == ConflictFail && len(report.Conflicts) > 0 {
+		report.Errors = append(report.Errors, "Импорт остановлен: найдены совпадения с существующими данными")
+		return report, nil
+	}
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+func (im *bundleImporter) importUsers(b Bundle) error {
+	stat := im.report.stat("Пользователи")
+	withoutPassword := 0
+	for _, u := range b.Users {
+		var id int
+		err := im.tx.QueryRow(`SELECT id FROM users WHERE login = $1`, u.Login).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if err == sql.ErrNoRows {
+			hash := u.PasswordHash
+			if hash == "" {
+				// Пароль не переносится: выдаём случайный, администратор потом сбросит его
+				withoutPassword++
+				secret := make([]byte, 16)
+				if _, err := rand.Read(secret); err != nil {
+					return err
+				}
+				if hash, err = HashPassword(hex.EncodeToString(secret)); err != nil {
+					return err
+				}
+			}
+			err := im.tx.QueryRow(`INSERT INTO users (login, password, role, is_active) VALUES ($1, $2, $3, $4) RETURNING id`,
+				u.Login, hash, u.Role, u.IsActive).Scan(&id)
+			if err != nil {
+				return err
+			}
+			stat.Created++
+		} else if im.conflict(stat, "Пользователь %s уже есть (id %d)", u.Login, id) {
+			_, err := im.tx.Exec(`UPDATE users SET role = $1, is_active = $2, password = COALESCE(NULLIF($3, ''), password),
+                                  deleted_at = NULL, deleted_by = NULL
+                                  WHERE id = $4`, u.Role, u.IsActive, u.PasswordHash, id)
+			if err != nil {
+				return err
+			}
+		} else {
+			im.users[u.ID] = id
+			continue
+		}
+
+		if u.FullName != "" || u.Email != "" || u.Bio != "" {
+			_, err := im.tx.Exec(`INSERT INTO user_profiles (user_id, full_name, email, bio) VALUES ($1, $2, $3, $4)
+                                  ON CONFLICT (user_id) DO UPDATE
+                                  SET full_name = EXCLUDED.full_name, email = EXCLUDED.email, bio = EXCLUDED.bio, updated_at = NOW()`,
+				id, u.FullName, u.Email, u.Bio)
+			if err != nil {
+				return err
+			}
+		}
+		im.users[u.ID] = id
+	}
+	if withoutPassword > 0 {
+		im.report.Warnings = append(im.report.Warnings,
+			fmt.Sprintf("Пользователей без пароля в выгрузке: %d. Им выдан случайный пароль — сбросьте его на странице сотрудников.", withoutPassword))
+	}
+	return nil
+}
+
+// importDepartments только сверяет отделы: они хранятся в темах и публикациях и появятся вместе с ними
+func (im *bundleImporter) importDepartments(b Bundle) error {
+	stat := im.report.stat("Отделы")
+	rows, err := im.tx.Query(`SELECT department FROM user_topics WHERE COALESCE(department, '') <> ''
+                              UNION
+                              SELECT department FROM publications WHERE COALESCE(department, '') <> ''`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, d := range b.Departments {
+		if existing[d.Name] {
+			stat.Skipped++
+		} else {
+			stat.Created++
+		}
+	}
+	return nil
+}
+
+func (im *bundleImporter) importTopics(b Bundle) error {
+	stat := im.report.stat("Темы")
+	for _, t := range b.Topics {
+		var id int
+		err := im.tx.QueryRow(`SELECT id FROM user_topics WHERE topic = $1 AND COALESCE(department, '') = $2 AND deleted_at IS NULL
+                               ORDER BY id LIMIT 1`, t.Topic, t.Department).Scan(&id)
+		switch {
+		case err == sql.ErrNoRows:
+			err := im.tx.QueryRow(`INSERT INTO user_topics (editor_id, topic, department, assigned_at, is_open, due_at)
+                                   VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+				ref(im.users, t.EditorID), t.Topic, t.Department, timeOrNow(t.AssignedAt), t.IsOpen, t.DueAt).Scan(&id)
+			if err != nil {
+				return err
+			}
+			stat.Created++
+		case err != nil:
+			return err
+		default:
+			if im.conflict(stat, "Тема «%s» (%s) уже есть (id %d)", t.Topic, t.Department, id) {
+				_, err := im.tx.Exec(`UPDATE user_topics SET editor_id = $1, is_open = $2, due_at = $3 WHERE id = $4`,
+					ref(im.users, t.EditorID), t.IsOpen, t.DueAt, id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		im.topics[t.ID] = id
+	}
+	return nil
+}
+
+// upsertCounted выполняет INSERT ... RETURNING (xmax = 0) и учитывает, была ли строка добавлена,
+// обновлена или пропущена из-за ON CONFLICT DO NOTHING
+func upsertCounted(tx *sql.Tx, stat *ImportStat, query string, args ...interface{}) error {
+	var inserted bool
+	err := tx.QueryRow(query, args...).Scan(&inserted)
+	switch {
+	case err == sql.ErrNoRows:
+		stat.Skipped++
+	case err != nil:
+		return err
+	case inserted:
+		stat.Created++
+	default:
+		stat.Updated++
+	}
+	return nil
+}
+
+// onConflict возвращает окончание запроса для таблиц со связями: при перезаписи обновляет поля, иначе пропускает строку
+func (im *bundleImporter) onConflict(update string) string {
+	if im.strategy == ConflictOverwrite {
+		return "DO UPDATE SET " + update
+	}
+	return "DO NOTHING"
+}
+
+func (im *bundleImporter) importAssignments(b Bundle) error {
+	stat := im.report.stat("Назначения тем")
+	query := `INSERT INTO topic_assignments (topic_id, author_id, status, due_at, assigned_at)
+              VALUES ($1, $2, $3, $4, $5)
+              ON CONFLICT (topic_id, author_id) ` + im.onConflict("status = EXCLUDED.status, due_at = EXCLUDED.due_at") + `
+              RETURNING (xmax = 0)`
+	for _, a := range b.Assignments {
+		if err := upsertCounted(im.tx, stat, query, im.topics[a.TopicID], im.users[a.AuthorID], a.Status, a.DueAt, timeOrNow(a.AssignedAt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *bundleImporter) importPublications(b Bundle) error {
+	stat := im.report.stat("Публикации")
+	for _, p := range b.Publications {
+		authorID := ref(im.users, p.AuthorID)
+		version := p.Version
+		if version < 1 {
+			version = 1
+		}
+		createdAt := timeOrNow(p.CreatedAt)
+		updatedAt := createdAt
+		if p.UpdatedAt != nil {
+			updatedAt = *p.UpdatedAt
+		}
+
+		var id int
+		err := im.tx.QueryRow(`SELECT id FROM publications
+                               WHERE title = $1 AND author_id IS NOT DISTINCT FROM $2 AND created_at = $3 AND deleted_at IS NULL
+                               ORDER BY id LIMIT 1`, p.Title, authorID, createdAt).Scan(&id)
+		switch {
+		case err == sql.ErrNoRows:
+			err := im.tx.QueryRow(`INSERT INTO publications (title, content, topic_id, author_id, status, department, remarks, is_published,
+                                                             version, created_at, updated_at, published_at, due_at)
+                                   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+                                   RETURNING id`,
+				p.Title, p.Content, ref(im.topics, p.TopicID), authorID, p.Status, p.Department, p.Remarks, p.IsPublished,
+				version, createdAt, updatedAt, p.PublishedAt, p.DueAt).Scan(&id)
+			if err != nil {
+				return err
+			}
+			stat.Created++
+		case err != nil:
+			return err
+		default:
+			if im.conflict(stat, "Публикация «%s» уже есть (id %d)", p.Title, id) {
+				// Версия растёт от текущей: номер из выгрузки может оказаться меньше, и тогда открытые
+				// в редакторе копии сохранились бы поверх импортированного текста
+				_, err := im.tx.Exec(`UPDATE publications
+                                      SET content = $1, topic_id = $2, status = $3, department = $4, remarks = $5, is_published = $6,
+                                          version = version + 1, updated_at = $7, published_at = $8, due_at = $9
+                                      WHERE id = $10`,
+					p.Content, ref(im.topics, p.TopicID), p.Status, p.Department, p.Remarks, p.IsPublished,
+					updatedAt, p.PublishedAt, p.DueAt, id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		im.publications[p.ID] = id
+	}
+	return nil
+}
+
+func (im *bundleImporter) importContributors(b Bundle) error {
+	stat := im.report.stat("Участники публикаций")
+	query := `INSERT INTO publication_contributors (publication_id, user_id, role, position)
+              VALUES ($1, $2, $3, $4)
+              ON CONFLICT (publication_id, user_id) ` + im.onConflict("role = EXCLUDED.role, position = EXCLUDED.position") + `
+              RETURNING (xmax = 0)`
+	for _, c := range b.Contributors {
+		if err := upsertCounted(im.tx, stat, query, im.publications[c.PublicationID], im.users[c.UserID], c.Role, c.Position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importRevisions добавляет историю версий. Версия, уже сохранённая у публикации в то же время
+// с тем же названием, считается той же самой и не дублируется.
+func (im *bundleImporter) importRevisions(b Bundle) error {
+	stat := im.report.stat("Версии")
+	for _, r := range b.Revisions {
+		pubID := im.publications[r.PublicationID]
+		var id int
+		err := im.tx.QueryRow(`SELECT id FROM publication_revisions WHERE publication_id = $1 AND created_at = $2 AND title = $3
+                               ORDER BY id LIMIT 1`, pubID, r.CreatedAt, r.Title).Scan(&id)
+		switch {
+		case err == sql.ErrNoRows:
+			err := im.tx.QueryRow(`INSERT INTO publication_revisions (publication_id, title, content, status, version, note, created_by, created_at)
+                                   VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+				pubID, r.Title, r.Content, r.Status, r.Version, r.Note, ref(im.users, r.CreatedBy), r.CreatedAt).Scan(&id)
+			if err != nil {
+				return err
+			}
+			stat.Created++
+		case err != nil:
+			return err
+		default:
+			stat.Skipped++
+		}
+		im.revisions[r.ID] = id
+	}
+	return nil
+}
+
+// importComments добавляет замечания, пропуская уже имеющиеся с тем же текстом и временем
+func (im *bundleImporter) importComments(b Bundle) error {
+	stat := im.report.stat("Замечания")
+	for _, c := range b.Comments {
+		pubID := im.publications[c.PublicationID]
+		var exists bool
+		err := im.tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM publication_comments WHERE publication_id = $1 AND created_at = $2 AND body = $3)`,
+			pubID, c.CreatedAt, c.Body).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			stat.Skipped++
+			continue
+		}
+		_, err = im.tx.Exec(`INSERT INTO publication_comments (publication_id, revision_id, author_id, body, created_at)
+                             VALUES ($1, $2, $3, $4, $5)`,
+			pubID, ref(im.revisions, c.RevisionID), ref(im.users, c.AuthorID), c.Body, c.CreatedAt)
+		if err != nil {
+			return err
+		}
+		stat.Created++
+	}
+	return nil
+}
+
+// ---------- Обработчики ----------
+
+// dataTransferPage показывает страницу переноса данных, при наличии — с отчётом об импорте
+func dataTransferPage(w http.ResponseWriter, adminID int, report *ImportReport) {
+	data := struct {
+		AdminID    int
+		Strategies []string
+		Labels     map[string]string
+		Version    int
+		Report     *ImportReport
+	}{
+		AdminID:    adminID,
+		Strategies: ConflictStrategies,
+		Labels:     ConflictStrategyLabels,
+		Version:    BundleVersion,
+		Report:     report,
+	}
+	if report != nil && !report.OK() {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := TmplDataTransfer.Execute(w, data); err != nil {
+		http.Error(w, "Ошибка выполнения шаблона: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Страница выгрузки и загрузки редакционной базы
+func DataTransferPage(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := sessionAdmin(w, r)
+	if !ok {
+		return
+	}
+	dataTransferPage(w, adminID, nil)
+}
+
+// Выгрузка базы в JSON или в ZIP-архив с CSV
+func ExportBundleHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := sessionAdmin(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "json" && format != "csv" {
+		http.Error(w, "Формат выгрузки должен быть json или csv", http.StatusBadRequest)
+		return
+	}
+	includePasswords := r.URL.Query().Get("passwords") == "1"
+
+	bundle, err := ExportBundle(includePasswords)
+	if err != nil {
+		http.Error(w, "Ошибка при выгрузке базы: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	name := "bundle-" + bundle.ExportedAt.Format("20060102-150405")
+	if format == "json" {
+		err = WriteBundleJSON(&buf, bundle)
+		name += ".json"
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		err = WriteBundleCSV(&buf, bundle)
+		name += ".zip"
+		w.Header().Set("Content-Type", "application/zip")
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при формировании выгрузки: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	Logger(r.Context()).Info("Администратор выгрузил базу", "admin_id", adminID, "format", format, "passwords", includePasswords)
+
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	buf.WriteTo(w)
+}
+
+// Загрузка выгрузки: пробный запуск показывает, что изменится, не меняя базу
+func ImportBundleHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBundleUpload+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Файл слишком большой или форма повреждена", http.StatusBadRequest)
+		return
+	}
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+	strategy := r.FormValue("strategy")
+	dryRun := r.FormValue("dry_run") != ""
+
+	file, _, err := r.FormFile("bundle")
+	if err != nil {
+		http.Error(w, "Файл выгрузки не передан", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Ошибка чтения файла", http.StatusBadRequest)
+		return
+	}
+
+	report := &ImportReport{DryRun: dryRun, Strategy: strategy}
+	bundle, err := ReadBundle(data)
+	if err == nil {
+		report, err = ImportBundle(bundle, strategy, dryRun)
+	}
+	if be, ok := err.(*BundleError); ok {
+		report.Errors = append(report.Errors, be.Message)
+		err = nil
+	}
+	if err != nil {
+		Logger(r.Context()).Error("Ошибка импорта выгрузки", "error", err)
+		http.Error(w, "Ошибка при импорте: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if report.OK() && !dryRun {
+		Logger(r.Context()).Info("Администратор загрузил выгрузку", "admin_id", adminID, "strategy", strategy)
+	}
+	dataTransferPage(w, adminID, report)
+}
+
+// ---------- CSV ----------
+
+// csvColumns возвращает названия столбцов по json-тегам полей структуры
+func csvColumns(t reflect.Type) []string {
+	columns := make([]string, t.NumField())
+	for i := range columns {
+		columns[i] = strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+	}
+	return columns
+}
+
+// csvFormat записывает значение поля строкой; пустая строка означает NULL
+func csvFormat(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// csvParse заполняет поле из строки CSV
+func csvParse(field reflect.Value, s string) error {
+	if field.Kind() == reflect.Ptr {
+		if s == "" {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(s)
+	case int:
+		if s == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("ожидалось число, получено %q", s)
+		}
+		field.SetInt(int64(n))
+	case bool:
+		if s == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("ожидалось true или false, получено %q", s)
+		}
+		field.SetBool(b)
+	case time.Time:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("ожидалась дата в формате RFC 3339, получено %q", s)
+		}
+		field.Set(reflect.ValueOf(t))
+	}
+	return nil
+}
+
+// writeCSVRows записывает срез структур (по указателю) как CSV с заголовком
+func writeCSVRows(out io.Writer, rows interface{}) error {
+	slice := reflect.ValueOf(rows).Elem()
+	w := csv.NewWriter(out)
+	if err := w.Write(csvColumns(slice.Type().Elem())); err != nil {
+		return err
+	}
+	for i := 0; i < slice.Len(); i++ {
+		row := slice.Index(i)
+		record := make([]string, row.NumField())
+		for j := range record {
+			record[j] = csvFormat(row.Field(j))
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// readCSVRows читает CSV с заголовком в срез структур. Неизвестные столбцы пропускаются,
+// отсутствующие остаются пустыми.
+func readCSVRows(in io.Reader, rows interface{}) error {
+	slice := reflect.ValueOf(rows).Elem()
+	rowType := slice.Type().Elem()
+	r := csv.NewReader(in)
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fields := map[string]int{}
+	for i, name := range csvColumns(rowType) {
+		fields[name] = i
+	}
+
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := reflect.New(rowType).Elem()
+		for i, name := range header {
+			// Excel добавляет в начало файла метку порядка байтов
+			j, ok := fields[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))]
+			if !ok {
+				continue
+			}
+			if err := csvParse(row.Field(j), record[i]); err != nil {
+				return fmt.Errorf("строка %d, столбец %s: %v", line, name, err)
+			}
+		}
+		slice.Set(reflect.Append(slice, row))
+	}
+}
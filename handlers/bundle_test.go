@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func intPtr(v int) *int { return &v }
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+// testBundle собирает выгрузку, в которой заполнены все виды объектов и все ссылки между ними
+func testBundle() Bundle {
+	at := time.Date(2024, 3, 1, 10, 15, 30, 123456789, time.UTC)
+	return Bundle{
+		Format:     bundleFormat,
+		Version:    BundleVersion,
+		ExportedAt: at,
+		Users: []BundleUser{
+			{ID: 1, Login: "editor", Role: RoleChiefEditor, IsActive: true, FullName: "Главный, редактор", Email: "editor@example.com",
+				Bio: "Пишет \"колонки\"\nи правит", PasswordHash: "$2a$10$abcdefghijklmnopqrstuv"},
+			{ID: 2, Login: "author", Role: RoleAuthor, PasswordHash: "$2a$10$vutsrqponmlkjihgfedcba"},
+		},
+		Departments: []BundleDepartment{{Name: "Политика"}},
+		Topics: []BundleTopic{
+			{ID: 5, EditorID: intPtr(1), Topic: "Выборы", Department: "Политика", IsOpen: true, DueAt: timePtr(at.Add(48 * time.Hour)), AssignedAt: timePtr(at)},
+		},
+		Assignments: []BundleAssignment{
+			{TopicID: 5, AuthorID: 2, Status: "in_progress", DueAt: timePtr(at.Add(24 * time.Hour)), AssignedAt: timePtr(at)},
+		},
+		Publications: []BundlePublication{
+			{ID: 7, TopicID: intPtr(5), AuthorID: intPtr(2), Title: "Итоги", Content: "Первая строка\nвторая, с запятой", Status: "draft",
+				Department: "Политика", Remarks: "", Version: 42, CreatedAt: timePtr(at), UpdatedAt: timePtr(at.Add(time.Hour))},
+		},
+		Contributors: []BundleContributor{{PublicationID: 7, UserID: 1, Role: ContributorFactChecker, Position: 1}},
+		Revisions: []BundleRevision{
+			{ID: 9, PublicationID: 7, Title: "Итоги", Content: "Первая строка", Status: "draft", Version: intPtr(41), Note: "черновик",
+				CreatedBy: intPtr(1), CreatedAt: at.Add(30 * time.Minute)},
+		},
+		Comments: []BundleComment{
+			{ID: 11, PublicationID: 7, RevisionID: intPtr(9), AuthorID: intPtr(2), Body: "Проверьте цифры", CreatedAt: at.Add(40 * time.Minute)},
+		},
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	b := testBundle()
+	if errs := ValidateBundle(b); len(errs) > 0 {
+		t.Fatalf("тестовая выгрузка не проходит проверку: %v", errs)
+	}
+
+	writers := map[string]func(io.Writer, Bundle) error{"json": WriteBundleJSON, "csv": WriteBundleCSV}
+	for name, write := range writers {
+		var buf bytes.Buffer
+		if err := write(&buf, b); err != nil {
+			t.Fatalf("%s: запись: %v", name, err)
+		}
+		got, err := ReadBundle(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: чтение: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, b) {
+			t.Errorf("%s: выгрузка изменилась при чтении\nбыло:  %+v\nстало: %+v", name, b, got)
+		}
+	}
+}
+
+func TestImportBundleRemapsIDs(t *testing.T) {
+	s := &fakeStore{}
+	useFakeDb(t, s)
+
+	report, err := ImportBundle(testBundle(), ConflictSkip, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("импорт не прошёл: %v %v", report.Errors, report.Conflicts)
+	}
+
+	// Новые идентификаторы выдаются по порядку: пользователи 1 → 1000 и 2 → 1001, тема 5 → 1002,
+	// публикация 7 → 1003, версия 9 → 1004
+	assertArgs(t, s.find(t, "INSERT INTO user_profiles"), map[int]int64{0: 1000})
+	assertArgs(t, s.find(t, "INSERT INTO user_topics"), map[int]int64{0: 1000})
+	assertArgs(t, s.find(t, "INSERT INTO topic_assignments"), map[int]int64{0: 1002, 1: 1001})
+	assertArgs(t, s.find(t, "INSERT INTO publications"), map[int]int64{2: 1002, 3: 1001, 8: 42})
+	assertArgs(t, s.find(t, "INSERT INTO publication_contributors"), map[int]int64{0: 1003, 1: 1000})
+	assertArgs(t, s.find(t, "INSERT INTO publication_revisions"), map[int]int64{0: 1003, 6: 1000})
+	assertArgs(t, s.find(t, "INSERT INTO publication_comments"), map[int]int64{0: 1003, 1: 1004, 2: 1001})
+
+	for _, stat := range report.Stats {
+		if stat.Created != 1 && !(stat.Entity == "Пользователи" && stat.Created == 2) {
+			t.Errorf("%s: создано %d", stat.Entity, stat.Created)
+		}
+	}
+}
+
+func TestImportBundleOverwriteBumpsVersion(t *testing.T) {
+	s := &fakeStore{existing: func(query string) (int64, bool) {
+		return 500, strings.HasPrefix(query, "SELECT id FROM publications")
+	}}
+	useFakeDb(t, s)
+
+	report, err := ImportBundle(testBundle(), ConflictOverwrite, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("импорт не прошёл: %v", report.Errors)
+	}
+
+	update := s.find(t, "UPDATE publications")
+	if !strings.Contains(update.query, "version = version + 1") {
+		t.Errorf("перезапись не увеличивает версию: %s", update.query)
+	}
+	for _, arg := range update.args {
+		if arg == int64(42) {
+			t.Errorf("перезапись передаёт версию из выгрузки: %v", update.args)
+		}
+	}
+	assertArgs(t, update, map[int]int64{len(update.args) - 1: 500})
+	assertArgs(t, s.find(t, "INSERT INTO publication_contributors"), map[int]int64{0: 500})
+	assertArgs(t, s.find(t, "INSERT INTO publication_revisions"), map[int]int64{0: 500})
+}
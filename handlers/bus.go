@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Шина событий между частями приложения и экземплярами сервера.
+// Доставка «хотя бы один раз»: обработчик может получить сообщение повторно
+// (после ошибки или переподключения), поэтому обработчики должны быть идемпотентными.
+
+// Темы шины событий
+const (
+	TopicPublication = "publication"
+	TopicUser        = "user"
+	TopicTopic       = "topic"
+)
+
+// Действия над пользователем
+const (
+	UserLoggedOut   = "logged_out"
+	UserDeactivated = "deactivated"
+)
+
+// PublicationChanged — изменение публикации
+type PublicationChanged struct {
+	PublicationID int    `json:"publication_id"`
+	Kind          string `json:"kind"` // тип события для рабочих страниц: EventStatusChanged и т.д.
+	Title         string `json:"title"`
+	Status        string `json:"status"`
+	Message       string `json:"message"`
+	ActorID       int    `json:"actor_id,omitempty"`
+	UserIDs       []int  `json:"user_ids,omitempty"` // участники публикации
+}
+
+// UserChanged — изменение учётной записи, после которого сессии пользователя недействительны
+type UserChanged struct {
+	UserID int    `json:"user_id"`
+	Action string `json:"action"`
+}
+
+// TopicChanged — назначение или изменение темы
+type TopicChanged struct {
+	Kind      string `json:"kind"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	ActorID   int    `json:"actor_id,omitempty"`
+	AuthorIDs []int  `json:"author_ids,omitempty"`
+}
+
+// BusMessage — сообщение шины с полезной нагрузкой в JSON
+type BusMessage struct {
+	ID        int64
+	Topic     string
+	Payload   json.RawMessage
+	Origin    string // экземпляр сервера, отправивший сообщение
+	CreatedAt time.Time
+}
+
+// Decode разбирает полезную нагрузку в типизированное событие
+func (m BusMessage) Decode(v interface{}) error {
+	return json.Unmarshal(m.Payload, v)
+}
+
+// BusHandler обрабатывает сообщение; ошибка приводит к повторной доставке
+type BusHandler func(BusMessage) error
+
+// EventBus — шина событий
+type EventBus interface {
+	Publish(topic string, payload interface{}) error
+	Subscribe(topic string, handler BusHandler)
+	Close() error
+}
+
+// Параметры повторной доставки
+const (
+	busMaxAttempts  = 5
+	busRetryBackoff = 200 * time.Millisecond
+)
+
+// Bus — шина событий приложения. По умолчанию работает в памяти процесса;
+// для нескольких экземпляров сервера StartEventBus подключает PostgreSQL.
+var Bus EventBus = NewMemoryBus()
+
+// Идентификатор этого экземпляра сервера
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "local"
+	}
+	return hex.EncodeToString(b)
+}
+
+// busSubscribers — подписчики по темам, общие для всех реализаций шины
+type busSubscribers struct {
+	mu       sync.RWMutex
+	handlers map[string][]BusHandler
+}
+
+func (s *busSubscribers) Subscribe(topic string, handler BusHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = map[string][]BusHandler{}
+	}
+	s.handlers[topic] = append(s.handlers[topic], handler)
+}
+
+// dispatch передаёт сообщение всем подписчикам темы, повторяя доставку при ошибке
+func (s *busSubscribers) dispatch(m BusMessage) {
+	s.mu.RLock()
+	handlers := append([]BusHandler{}, s.handlers[m.Topic]...)
+	s.mu.RUnlock()
+
+	for _, handler := range handlers {
+		backoff := busRetryBackoff
+		for attempt := 1; ; attempt++ {
+			err := handler(m)
+			if err == nil {
+				break
+			}
+			if attempt == busMaxAttempts {
+				log.Printf("Сообщение %d темы %q не обработано после %d попыток: %v", m.ID, m.Topic, attempt, err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// MemoryBus — шина внутри одного процесса. Сообщения доставляются по порядку в отдельной горутине.
+type MemoryBus struct {
+	busSubscribers
+	mu     sync.Mutex
+	nextID int64
+	queue  chan BusMessage
+	done   chan struct{}
+	closed bool
+}
+
+func NewMemoryBus() *MemoryBus {
+	b := &MemoryBus{queue: make(chan BusMessage, 1024), done: make(chan struct{})}
+	go func() {
+		defer close(b.done)
+		for m := range b.queue {
+			b.dispatch(m)
+		}
+	}()
+	return b
+}
+
+func (b *MemoryBus) Publish(topic string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBusClosed
+	}
+	b.nextID++
+	b.queue <- BusMessage{ID: b.nextID, Topic: topic, Payload: data, Origin: instanceID, CreatedAt: time.Now()}
+	return nil
+}
+
+// Close дожидается доставки уже отправленных сообщений
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	<-b.done
+	return nil
+}
+
+var errBusClosed = errors.New("шина событий закрыта")
+
+// Канал уведомлений PostgreSQL и параметры чтения журнала
+const (
+	busChannel      = "event_bus"
+	busPollInterval = 30 * time.Second
+	// Сообщения читаются с перекрытием: строка с меньшим id может зафиксироваться позже строки с большим
+	busLookback  = 10 * time.Second
+	busRetention = 24 * time.Hour
+)
+
+// PostgresBus — шина между экземплярами сервера. Сообщения записываются в таблицу bus_events,
+// а NOTIFY лишь будит слушателей. Уведомления, потерянные при обрыве соединения,
+// восстанавливаются чтением журнала после переподключения и при периодическом опросе.
+type PostgresBus struct {
+	busSubscribers
+	db       *sql.DB
+	listener *pq.Listener
+	since    time.Time           // время последнего прочитанного сообщения по часам базы
+	seen     map[int64]time.Time // уже доставленные сообщения внутри окна перекрытия
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewPostgresBus(db *sql.DB, dsn string) (*PostgresBus, error) {
+	b := &PostgresBus{db: db, seen: map[int64]time.Time{}, stop: make(chan struct{}), done: make(chan struct{})}
+
+	// Сообщения, отправленные до запуска экземпляра, не доставляются
+	if err := db.QueryRow(`SELECT clock_timestamp()`).Scan(&b.since); err != nil {
+		return nil, err
+	}
+
+	b.listener = pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			log.Printf("Шина событий: соединение с базой потеряно: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Println("Шина событий: соединение с базой восстановлено")
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("Шина событий: не удалось подключиться к базе: %v", err)
+		}
+	})
+	if err := b.listener.Listen(busChannel); err != nil {
+		b.listener.Close()
+		return nil, err
+	}
+
+	go b.run()
+	return b, nil
+}
+
+func (b *PostgresBus) Publish(topic string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(`WITH e AS (
+                            INSERT INTO bus_events (topic, payload, origin) VALUES ($1, $2, $3) RETURNING id
+                        )
+                        SELECT pg_notify($4, id::text) FROM e`,
+		topic, string(data), instanceID, busChannel)
+	return err
+}
+
+func (b *PostgresBus) Close() error {
+	b.once.Do(func() { close(b.stop) })
+	<-b.done
+	return b.listener.Close()
+}
+
+// run ждёт уведомлений и читает журнал. Пустое уведомление означает переподключение слушателя.
+func (b *PostgresBus) run() {
+	defer close(b.done)
+	poll := time.NewTicker(busPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.listener.Notify:
+		case <-poll.C:
+			go b.listener.Ping()
+		}
+		if err := b.catchUp(); err != nil {
+			log.Printf("Шина событий: ошибка чтения журнала: %v", err)
+		}
+	}
+}
+
+// catchUp доставляет подписчикам все ещё не доставленные сообщения журнала
+func (b *PostgresBus) catchUp() error {
+	rows, err := b.db.Query(`SELECT id, topic, payload, origin, created_at FROM bus_events
+                             WHERE created_at >= $1 ORDER BY id`, b.since.Add(-busLookback))
+	if err != nil {
+		return err
+	}
+	var messages []BusMessage
+	for rows.Next() {
+		var m BusMessage
+		var payload []byte
+		if err := rows.Scan(&m.ID, &m.Topic, &payload, &m.Origin, &m.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		m.Payload = payload
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		if _, ok := b.seen[m.ID]; ok {
+			continue
+		}
+		b.dispatch(m)
+		b.seen[m.ID] = m.CreatedAt
+		if m.CreatedAt.After(b.since) {
+			b.since = m.CreatedAt
+		}
+	}
+	for id, createdAt := range b.seen {
+		if createdAt.Before(b.since.Add(-busLookback)) {
+			delete(b.seen, id)
+		}
+	}
+	return nil
+}
+
+// PurgeBusEvents удаляет из журнала сообщения старше срока хранения
+func PurgeBusEvents() error {
+	_, err := Db.Exec(`DELETE FROM bus_events WHERE created_at < NOW() - make_interval(secs => $1)`, busRetention.Seconds())
+	return err
+}
+
+// StartEventBus подключает подписчиков приложения к шине. При distributed = true
+// события передаются через PostgreSQL и доходят до всех экземпляров сервера.
+func StartEventBus(ctx context.Context, distributed bool) error {
+	if distributed {
+		bus, err := NewPostgresBus(Db, DatabaseDSN)
+		if err != nil {
+			return err
+		}
+		Bus.Close()
+		Bus = bus
+		startPeriodicWorker(ctx, time.Hour, "очистка журнала шины событий", PurgeBusEvents)
+	}
+
+	Bus.Subscribe(TopicPublication, func(m BusMessage) error {
+		var e PublicationChanged
+		if err := m.Decode(&e); err != nil {
+			log.Printf("Шина событий: неверное сообщение %d: %v", m.ID, err)
+			return nil
+		}
+		Events.Publish(context.Background(), Event{
+			ID:            m.ID,
+			Type:          e.Kind,
+			PublicationID: e.PublicationID,
+			Title:         e.Title,
+			Status:        e.Status,
+			Message:       e.Message,
+			ActorID:       e.ActorID,
+			Time:          m.CreatedAt,
+			UserIDs:       e.UserIDs,
+			Editors:       true,
+		})
+		return nil
+	})
+	Bus.Subscribe(TopicTopic, func(m BusMessage) error {
+		var e TopicChanged
+		if err := m.Decode(&e); err != nil {
+			log.Printf("Шина событий: неверное сообщение %d: %v", m.ID, err)
+			return nil
+		}
+		Events.Publish(context.Background(), Event{
+			ID:      m.ID,
+			Type:    e.Kind,
+			Title:   e.Title,
+			Message: e.Message,
+			ActorID: e.ActorID,
+			Time:    m.CreatedAt,
+			UserIDs: e.AuthorIDs,
+			Editors: true,
+		})
+		return nil
+	})
+	// Сессии пользователя завершены: закрываем его живые подключения на этом экземпляре
+	Bus.Subscribe(TopicUser, func(m BusMessage) error {
+		var e UserChanged
+		if err := m.Decode(&e); err != nil {
+			log.Printf("Шина событий: неверное сообщение %d: %v", m.ID, err)
+			return nil
+		}
+		Events.DisconnectUser(e.UserID)
+		disconnectCollabUser(e.UserID)
+		return nil
+	})
+	subscribeNotifications(Bus)
+	return nil
+}
+
+// publishBusEvent отправляет событие в шину; ошибка не мешает основному действию
+func publishBusEvent(topic string, payload interface{}) {
+	if err := Bus.Publish(topic, payload); err != nil {
+		log.Printf("Ошибка отправки события %q в шину: %v", topic, err)
+	}
+}
@@ -0,0 +1,526 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+var TmplCalendar = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "calendar.html"))
+var TmplCalendarSubscribe = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "calendar_subscribe.html"))
+
+// Виды записей редакционного календаря
+const (
+	CalendarTopicDeadline  = "topic_deadline"  // срок сдачи темы
+	CalendarPublicationDue = "publication_due" // срок сдачи черновика
+	CalendarScheduled      = "scheduled"       // запланированная выкладка
+	CalendarEmbargo        = "embargo"         // снятие эмбарго
+)
+
+var CalendarKindLabels = map[string]string{
+	CalendarTopicDeadline:  "Срок сдачи темы",
+	CalendarPublicationDue: "Срок сдачи черновика",
+	CalendarScheduled:      "Выкладка",
+	CalendarEmbargo:        "Снятие эмбарго",
+}
+
+// Период, который попадает в подписку на календарь
+const (
+	calendarFeedPast   = 30 * 24 * time.Hour
+	calendarFeedFuture = 365 * 24 * time.Hour
+)
+
+// CalendarEntry — запись календаря: срок или дата, привязанная к теме или публикации
+type CalendarEntry struct {
+	Kind       string
+	ID         int // идентификатор темы или публикации
+	Title      string
+	Department string
+	At         time.Time
+}
+
+func (e CalendarEntry) KindLabel() string {
+	return CalendarKindLabels[e.Kind]
+}
+
+// Day возвращает дату записи в формате поля даты, куда её можно перетащить
+func (e CalendarEntry) Day() string {
+	return e.At.In(calendarLocation()).Format("2006-01-02")
+}
+
+// Time возвращает время записи в часовом поясе редакции
+func (e CalendarEntry) Time() string {
+	return e.At.In(calendarLocation()).Format("15:04")
+}
+
+// День в сетке месяца
+type CalendarDay struct {
+	Date    time.Time
+	InMonth bool
+	Today   bool
+	Entries []CalendarEntry
+}
+
+// ISO возвращает дату дня в формате 2006-01-02
+func (d CalendarDay) ISO() string {
+	return d.Date.Format("2006-01-02")
+}
+
+// calendarLocation — часовой пояс, в котором календарь делится на дни
+func calendarLocation() *time.Location {
+	loc, err := time.LoadLocation(DefaultScheduleTZ)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// GetCalendarEntries возвращает записи календаря в интервале [from, to).
+// Пустой department означает все отделы; userID > 0 оставляет только записи,
+// касающиеся пользователя: его темы и публикации, в которых он участвует.
+func GetCalendarEntries(from, to time.Time, department string, userID int) ([]CalendarEntry, error) {
+	query := `SELECT 'topic_deadline', t.id, t.topic, COALESCE(t.department, ''), t.due_at::timestamptz
+              FROM user_topics t
+              WHERE t.deleted_at IS NULL AND t.due_at >= $1 AND t.due_at < $2
+                AND ($3 = '' OR t.department = $3)
+                AND ($4 = 0 OR t.editor_id = $4 OR EXISTS (
+                    SELECT 1 FROM topic_assignments a
+                    WHERE a.topic_id = t.id AND a.author_id = $4 AND a.status NOT IN ('declined', 'cancelled')))
+              UNION ALL
+              SELECT 'publication_due', p.id, p.title, COALESCE(p.department, ''), p.due_at::timestamptz
+              FROM publications p
+              WHERE p.deleted_at IS NULL AND p.status = 'draft' AND p.due_at >= $1 AND p.due_at < $2
+                AND ($3 = '' OR p.department = $3)
+                AND ($4 = 0 OR EXISTS (SELECT 1 FROM publication_contributors c WHERE c.publication_id = p.id AND c.user_id = $4))
+              UNION ALL
+              SELECT 'scheduled', p.id, p.title, COALESCE(p.department, ''), p.scheduled_at
+              FROM publications p
+              WHERE p.deleted_at IS NULL AND p.status = 'scheduled' AND p.scheduled_at >= $1 AND p.scheduled_at < $2
+                AND ($3 = '' OR p.department = $3)
+                AND ($4 = 0 OR EXISTS (SELECT 1 FROM publication_contributors c WHERE c.publication_id = p.id AND c.user_id = $4))
+              UNION ALL
+              SELECT 'embargo', p.id, p.title, COALESCE(p.department, ''), p.embargo_until
+              FROM publications p
+              WHERE p.deleted_at IS NULL AND p.status IN ('approved', 'scheduled')
+                AND p.embargo_until >= $1 AND p.embargo_until < $2
+                AND ($3 = '' OR p.department = $3)
+                AND ($4 = 0 OR EXISTS (SELECT 1 FROM publication_contributors c WHERE c.publication_id = p.id AND c.user_id = $4))
+              ORDER BY 5, 1, 2`
+	rows, err := Db.Query(query, from, to, department, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []CalendarEntry
+	for rows.Next() {
+		var e CalendarEntry
+		if err := rows.Scan(&e.Kind, &e.ID, &e.Title, &e.Department, &e.At); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetDepartments возвращает отделы, встречающиеся в темах и публикациях
+func GetDepartments() ([]string, error) {
+	rows, err := Db.Query(`SELECT department FROM user_topics WHERE COALESCE(department, '') <> ''
+                           UNION
+                           SELECT department FROM publications WHERE COALESCE(department, '') <> ''
+                           ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var departments []string
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		departments = append(departments, d)
+	}
+	return departments, rows.Err()
+}
+
+// calendarGrid раскладывает записи по неделям месяца; неделя начинается с понедельника
+func calendarGrid(month time.Time, entries []CalendarEntry) [][]CalendarDay {
+	loc := month.Location()
+	byDay := map[string][]CalendarEntry{}
+	for _, e := range entries {
+		byDay[e.Day()] = append(byDay[e.Day()], e)
+	}
+
+	today := time.Now().In(loc).Format("2006-01-02")
+	offset := (int(month.Weekday()) + 6) % 7
+	day := month.AddDate(0, 0, -offset)
+	var weeks [][]CalendarDay
+	for len(weeks) == 0 || day.Month() == month.Month() {
+		week := make([]CalendarDay, 7)
+		for i := range week {
+			iso := day.Format("2006-01-02")
+			week[i] = CalendarDay{Date: day, InMonth: day.Month() == month.Month(), Today: iso == today, Entries: byDay[iso]}
+			day = day.AddDate(0, 0, 1)
+		}
+		weeks = append(weeks, week)
+	}
+	return weeks
+}
+
+// calendarEditor проверяет, что пользователь сессии — редактор. При ошибке ответ уже отправлен.
+func calendarEditor(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	return sessionRole(w, r, IsEditorRole, "Календарь доступен только редакторам")
+}
+
+// Редакционный календарь на месяц
+func CalendarPage(w http.ResponseWriter, r *http.Request) {
+	editorID, role, ok := calendarEditor(w, r)
+	if !ok {
+		return
+	}
+
+	loc := calendarLocation()
+	month, err := time.ParseInLocation("2006-01", r.URL.Query().Get("month"), loc)
+	if err != nil {
+		now := time.Now().In(loc)
+		month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	department := r.URL.Query().Get("department")
+
+	// В сетку попадают и дни соседних месяцев, поэтому берём запас в неделю
+	entries, err := GetCalendarEntries(month.AddDate(0, 0, -7), month.AddDate(0, 1, 7), department, 0)
+	if err != nil {
+		http.Error(w, "Ошибка при получении календаря: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	departments, err := GetDepartments()
+	if err != nil {
+		http.Error(w, "Ошибка при получении отделов", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		EditorID    int
+		Month       time.Time
+		Prev        string
+		Next        string
+		Weeks       [][]CalendarDay
+		Department  string
+		Departments []string
+		KindLabels  map[string]string
+		BackURL     string
+	}{
+		EditorID:    editorID,
+		Month:       month,
+		Prev:        month.AddDate(0, -1, 0).Format("2006-01"),
+		Next:        month.AddDate(0, 1, 0).Format("2006-01"),
+		Weeks:       calendarGrid(month, entries),
+		Department:  department,
+		Departments: departments,
+		KindLabels:  CalendarKindLabels,
+		BackURL:     rolePageURL(role, editorID),
+	}
+	if err := TmplCalendar.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона", "error", err)
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// moveTime переносит момент на другой день, сохраняя время суток в часовом поясе редакции
+func moveTime(t time.Time, day time.Time) time.Time {
+	loc := calendarLocation()
+	t = t.In(loc)
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+}
+
+// MoveCalendarEntry переносит срок или дату записи календаря на другой день
+func MoveCalendarEntry(kind string, id int, day time.Time) (time.Time, error) {
+	tx, err := Db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var current time.Time
+	var lockQuery string
+	switch kind {
+	case CalendarTopicDeadline:
+		lockQuery = `SELECT due_at::timestamptz FROM user_topics WHERE id = $1 AND deleted_at IS NULL AND due_at IS NOT NULL FOR UPDATE`
+	case CalendarPublicationDue:
+		lockQuery = `SELECT due_at::timestamptz FROM publications WHERE id = $1 AND deleted_at IS NULL AND status = 'draft' AND due_at IS NOT NULL FOR UPDATE`
+	case CalendarScheduled:
+		lockQuery = `SELECT scheduled_at FROM publications WHERE id = $1 AND deleted_at IS NULL AND status = 'scheduled' FOR UPDATE`
+	case CalendarEmbargo:
+		lockQuery = `SELECT embargo_until FROM publications WHERE id = $1 AND deleted_at IS NULL AND status IN ('approved', 'scheduled') AND embargo_until IS NOT NULL FOR UPDATE`
+	default:
+		return time.Time{}, &UserValidationError{Message: "Неизвестный вид записи календаря"}
+	}
+	if err := tx.QueryRow(lockQuery, id).Scan(&current); err != nil {
+		return time.Time{}, err
+	}
+	moved := moveTime(current, day)
+	// Сроки хранятся в TIMESTAMP без пояса, в местном времени сервера, как их записывает ParseDueDate
+	movedLocal := moved.In(time.Local)
+
+	switch kind {
+	case CalendarTopicDeadline:
+		// Сроки авторов, совпадавшие со сроком темы, переносятся вместе с ним
+		if _, err := tx.Exec(`UPDATE topic_assignments SET due_at = $1 WHERE topic_id = $2 AND due_at::timestamptz = $3`, movedLocal, id, current); err != nil {
+			return time.Time{}, err
+		}
+		_, err = tx.Exec(`UPDATE user_topics SET due_at = $1 WHERE id = $2`, movedLocal, id)
+	case CalendarPublicationDue:
+		_, err = tx.Exec(`UPDATE publications SET due_at = $1 WHERE id = $2`, movedLocal, id)
+	case CalendarScheduled:
+		if moved.Before(time.Now()) {
+			return time.Time{}, &UserValidationError{Message: "Нельзя перенести выкладку в прошлое"}
+		}
+		var result sql.Result
+		result, err = tx.Exec(`UPDATE publications SET scheduled_at = $1, updated_at = NOW()
+                               WHERE id = $2 AND (embargo_until IS NULL OR embargo_until <= $1)`, moved, id)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n == 0 {
+				return time.Time{}, &UserValidationError{Message: "Выкладка не может быть раньше снятия эмбарго"}
+			}
+		}
+	case CalendarEmbargo:
+		var result sql.Result
+		result, err = tx.Exec(`UPDATE publications SET embargo_until = $1, updated_at = NOW()
+                               WHERE id = $2 AND (scheduled_at IS NULL OR status <> 'scheduled' OR scheduled_at >= $1)`, moved, id)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n == 0 {
+				return time.Time{}, &UserValidationError{Message: "Эмбарго должно сниматься не позже времени выкладки"}
+			}
+		}
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return moved, tx.Commit()
+}
+
+// Перенос записи календаря перетаскиванием. Отвечает 204 или текстом ошибки.
+func MoveCalendarEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	editorID, _, ok := calendarEditor(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("entry_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор записи", http.StatusBadRequest)
+		return
+	}
+	day, err := time.ParseInLocation("2006-01-02", r.FormValue("date"), calendarLocation())
+	if err != nil {
+		http.Error(w, "Неверная дата", http.StatusBadRequest)
+		return
+	}
+
+	kind := r.FormValue("kind")
+	moved, err := MoveCalendarEntry(kind, id, day)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Запись не найдена или её уже нельзя перенести", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if kind != CalendarTopicDeadline {
+		publishPublicationEvent(r.Context(), EventStatusChanged, id, editorID,
+			CalendarKindLabels[kind]+": перенесено на "+moved.Format("02.01.2006 15:04"))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// calendarToken возвращает ключ подписки на календарь, создавая его при необходимости.
+// При reset = true выдаётся новый ключ, а старые ссылки перестают работать.
+func calendarToken(userID int, reset bool) (string, error) {
+	var token sql.NullString
+	if !reset {
+		if err := Db.QueryRow(`SELECT calendar_token FROM users WHERE id = $1`, userID).Scan(&token); err != nil {
+			return "", err
+		}
+		if token.Valid {
+			return token.String, nil
+		}
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	newToken := hex.EncodeToString(b)
+	if _, err := Db.Exec(`UPDATE users SET calendar_token = $1 WHERE id = $2`, newToken, userID); err != nil {
+		return "", err
+	}
+	return newToken, nil
+}
+
+// Страница с адресами подписки на календарь
+func CalendarSubscribePage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return
+	}
+	reset := false
+	if r.Method == http.MethodPost {
+		reset = r.FormValue("reset") != ""
+	}
+	token, err := calendarToken(userID, reset)
+	if err != nil {
+		http.Error(w, "Ошибка при получении ключа календаря", http.StatusInternalServerError)
+		return
+	}
+	if reset {
+		http.Redirect(w, r, "/calendar/subscribe", http.StatusSeeOther)
+		return
+	}
+
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении роли пользователя", http.StatusInternalServerError)
+		return
+	}
+	// Календари отделов предлагаются только редакторам
+	var departments []string
+	if IsEditorRole(role) {
+		if departments, err = GetDepartments(); err != nil {
+			http.Error(w, "Ошибка при получении отделов", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host + "/calendar.ics?token=" + token
+	type feed struct{ Name, URL string }
+	feeds := []feed{{Name: "Мой календарь", URL: base}}
+	for _, d := range departments {
+		feeds = append(feeds, feed{Name: "Отдел " + d, URL: base + "&department=" + url.QueryEscape(d)})
+	}
+
+	data := struct {
+		Feeds   []feed
+		BackURL string
+	}{
+		Feeds:   feeds,
+		BackURL: rolePageURL(role, userID),
+	}
+	if err := TmplCalendarSubscribe.Execute(w, data); err != nil {
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// icsEscape экранирует текст по RFC 5545
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsLine записывает строку календаря, перенося её на 75 байт без разрыва символов UTF-8
+// (продолжение начинается с пробела, который тоже входит в 75 байт)
+func icsLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// WriteICS формирует календарь в формате iCalendar
+func WriteICS(name string, entries []CalendarEntry, host string) string {
+	const stamp = "20060102T150405Z"
+	now := time.Now().UTC().Format(stamp)
+
+	var b strings.Builder
+	icsLine(&b, "BEGIN:VCALENDAR")
+	icsLine(&b, "VERSION:2.0")
+	icsLine(&b, "PRODID:-//myproject//Редакционный календарь//RU")
+	icsLine(&b, "CALSCALE:GREGORIAN")
+	icsLine(&b, "X-WR-CALNAME:"+icsEscape(name))
+	for _, e := range entries {
+		icsLine(&b, "BEGIN:VEVENT")
+		icsLine(&b, fmt.Sprintf("UID:%s-%d@%s", e.Kind, e.ID, host))
+		icsLine(&b, "DTSTAMP:"+now)
+		icsLine(&b, "DTSTART:"+e.At.UTC().Format(stamp))
+		icsLine(&b, "DTEND:"+e.At.Add(30*time.Minute).UTC().Format(stamp))
+		icsLine(&b, "SUMMARY:"+icsEscape(e.KindLabel()+": "+e.Title))
+		if e.Department != "" {
+			icsLine(&b, "CATEGORIES:"+icsEscape(e.Department))
+			icsLine(&b, "DESCRIPTION:"+icsEscape("Отдел: "+e.Department))
+		}
+		icsLine(&b, "END:VEVENT")
+	}
+	icsLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// Подписка на календарь пользователя или отдела (?department=) по секретному ключу
+func CalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Не передан ключ календаря", http.StatusUnauthorized)
+		return
+	}
+	var userID int
+	var role string
+	err := Db.QueryRow(`SELECT id, role FROM users WHERE calendar_token = $1 AND is_active AND deleted_at IS NULL`, token).
+		Scan(&userID, &role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Неверный ключ календаря", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при проверке ключа календаря", http.StatusInternalServerError)
+		return
+	}
+
+	// Редакторы видят весь план редакции и календари отделов, остальные — только свои темы и публикации
+	department := r.URL.Query().Get("department")
+	filterUser := userID
+	name := "Редакционный календарь"
+	if IsEditorRole(role) {
+		filterUser = 0
+	} else if department != "" {
+		http.Error(w, "Календарь отдела доступен только редакторам", http.StatusForbidden)
+		return
+	}
+	if department != "" {
+		name += ": " + department
+	}
+
+	now := time.Now()
+	entries, err := GetCalendarEntries(now.Add(-calendarFeedPast), now.Add(calendarFeedFuture), department, filterUser)
+	if err != nil {
+		http.Error(w, "Ошибка при получении календаря", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="calendar.ics"`)
+	fmt.Fprint(w, WriteICS(name, entries, r.Host))
+}
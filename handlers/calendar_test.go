@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// calendarFeed запрашивает календарь по ключу пользователя с заданной ролью
+func calendarFeed(t *testing.T, role, query string) (*httptest.ResponseRecorder, *fakeStore) {
+	t.Helper()
+	s := &fakeStore{row: func(q string, _ []driver.Value) []driver.Value {
+		if strings.Contains(q, "calendar_token") {
+			return []driver.Value{int64(5), role}
+		}
+		return nil
+	}}
+	useFakeDb(t, s)
+	rec := httptest.NewRecorder()
+	CalendarFeedHandler(rec, httptest.NewRequest(http.MethodGet, "/calendar.ics?token=secret"+query, nil))
+	return rec, s
+}
+
+func TestCalendarDepartmentFeedOnlyForEditors(t *testing.T) {
+	rec, s := calendarFeed(t, RoleAuthor, "&department=Политика")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("автор, календарь отдела: статус %d, ожидался 403", rec.Code)
+	}
+	if len(s.calls) != 1 {
+		t.Errorf("после отказа выполнялись запросы: %d", len(s.calls)-1)
+	}
+
+	for _, role := range []string{RoleSectionEditor, RoleChiefEditor} {
+		if rec, _ := calendarFeed(t, role, "&department=Политика"); rec.Code != http.StatusOK {
+			t.Errorf("%s, календарь отдела: статус %d", role, rec.Code)
+		}
+	}
+
+	// Свой календарь автора фильтруется по нему самому
+	rec, s = calendarFeed(t, RoleAuthor, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("автор, свой календарь: статус %d", rec.Code)
+	}
+	entries := s.find(t, "FROM user_topics")
+	if len(entries.args) != 4 || entries.args[3] != int64(5) {
+		t.Errorf("календарь автора не отфильтрован по пользователю: %v", entries.args)
+	}
+}
@@ -0,0 +1,581 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	htmltemplate "html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"example.com/myproject/templates"
+)
+
+var TmplCollab = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "collab.html"))
+
+const (
+	collabPingInterval = 30 * time.Second
+	collabSendBuffer   = 256
+	collabHistoryLimit = 1000 // сколько последних операций хранится для преобразования запоздавших правок
+)
+
+// Сообщение протокола совместного редактирования
+type collabMessage struct {
+	Type         string              `json:"type"`
+	Revision     int                 `json:"revision,omitempty"`
+	Operation    *TextOperation      `json:"operation,omitempty"`
+	Position     int                 `json:"position"`
+	Content      string              `json:"content,omitempty"`
+	UserID       int                 `json:"user_id,omitempty"`
+	Name         string              `json:"name,omitempty"`
+	Participants []collabParticipant `json:"participants,omitempty"`
+	Version      int                 `json:"version,omitempty"`
+	Error        string              `json:"error,omitempty"`
+}
+
+// Участник сессии и позиция его курсора
+type collabParticipant struct {
+	UserID   int    `json:"user_id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+// Подключение участника к сессии
+type collabClient struct {
+	conn     *wsConn
+	send     chan []byte
+	userID   int
+	name     string
+	position int
+	log      *slog.Logger // журнал запроса, открывшего соединение
+}
+
+// CollabSession — общий документ публикации, который правят несколько участников.
+// Сервер упорядочивает операции: каждая получает номер ревизии, а запоздавшие
+// правки преобразуются относительно уже применённых.
+type CollabSession struct {
+	mu            sync.Mutex
+	persistMu     sync.Mutex // сохранения идут по очереди, иначе второе упрётся в устаревшую версию
+	publicationID int
+	title         string
+	version       int // версия публикации в базе, на которую опирается сессия
+	doc           []uint16
+	revision      int
+	history       []*TextOperation // операции с ревизиями historyBase+1 … revision
+	historyBase   int
+	clients       map[*collabClient]bool
+	dirty         bool
+	lastEditor    int
+	saveFailed    bool
+	lockHolder    int // от имени этого участника сессия держит блокировку редактирования
+	log           *slog.Logger
+}
+
+// Активные сессии по идентификатору публикации
+var collabSessions = struct {
+	sync.Mutex
+	m map[int]*CollabSession
+}{m: map[int]*CollabSession{}}
+
+// collabLockedError — публикацию вне совместного редактирования правит другой пользователь
+type collabLockedError struct {
+	lock EditLock
+}
+
+func (e *collabLockedError) Error() string {
+	return "публикацию редактирует " + e.lock.UserName
+}
+
+// openCollabSession возвращает сессию публикации, открывая её при первом подключении.
+// Открытая сессия держит блокировку редактирования от имени первого участника, поэтому
+// сессия не открывается, пока публикацию правит кто-то другой. Вызывается под collabSessions.
+func openCollabSession(publicationID, userID int) (*CollabSession, error) {
+	if s, ok := collabSessions.m[publicationID]; ok {
+		return s, nil
+	}
+	pub, err := GetPublicationForEdit(publicationID)
+	if err != nil {
+		return nil, err
+	}
+	lock, acquired, err := AcquireEditLock(publicationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, &collabLockedError{lock: lock}
+	}
+	s := &CollabSession{
+		publicationID: publicationID,
+		title:         pub.Title,
+		version:       pub.Version,
+		doc:           utf16.Encode([]rune(pub.Content)),
+		clients:       map[*collabClient]bool{},
+		lockHolder:    userID,
+		// Сессия переживает запросы участников, поэтому пишет в общий журнал
+		log: slog.Default().With("publication_id", publicationID),
+	}
+	collabSessions.m[publicationID] = s
+	return s, nil
+}
+
+// getCollabSession возвращает сессию публикации, открывая её при первом подключении
+func getCollabSession(publicationID, userID int) (*CollabSession, error) {
+	collabSessions.Lock()
+	defer collabSessions.Unlock()
+	return openCollabSession(publicationID, userID)
+}
+
+// joinCollabSession подключает участника к сессии публикации. Поиск сессии и подключение
+// выполняются под одной блокировкой списка сессий: иначе последний участник мог бы
+// закрыть сессию между ними, и новый участник остался бы в уже закрытой сессии.
+func joinCollabSession(publicationID int, c *collabClient) (*CollabSession, error) {
+	collabSessions.Lock()
+	defer collabSessions.Unlock()
+	s, err := openCollabSession(publicationID, c.userID)
+	if err != nil {
+		return nil, err
+	}
+	s.join(c)
+	return s, nil
+}
+
+// deliver ставит сообщение в очередь отправки. Клиент, который не успевает читать,
+// отключается: пропуск операции нарушил бы согласованность его документа.
+// Вызывается под s.mu.
+func (s *CollabSession) deliver(c *collabClient, msg collabMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.log.Error("Ошибка кодирования сообщения совместного редактирования", "publication_id", s.publicationID, "error", err)
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		c.log.Warn("Участник не успевает получать правки, соединение закрыто", "publication_id", s.publicationID, "user_id", c.userID)
+		c.conn.conn.Close()
+	}
+}
+
+// broadcast рассылает сообщение всем участникам, кроме except. Вызывается под s.mu.
+func (s *CollabSession) broadcast(msg collabMessage, except *collabClient) {
+	for c := range s.clients {
+		if c != except {
+			s.deliver(c, msg)
+		}
+	}
+}
+
+// participants возвращает список участников. Вызывается под s.mu.
+func (s *CollabSession) participants() []collabParticipant {
+	list := make([]collabParticipant, 0, len(s.clients))
+	for c := range s.clients {
+		list = append(list, collabParticipant{UserID: c.userID, Name: c.name, Position: c.position})
+	}
+	return list
+}
+
+// join подключает участника и отправляет ему текущее состояние документа.
+// Вызывается под collabSessions, см. joinCollabSession.
+func (s *CollabSession) join(c *collabClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[c] = true
+	s.deliver(c, collabMessage{
+		Type:         "init",
+		Revision:     s.revision,
+		Content:      string(utf16.Decode(s.doc)),
+		UserID:       c.userID,
+		Participants: s.participants(),
+		Version:      s.version,
+	})
+	s.broadcast(collabMessage{Type: "join", UserID: c.userID, Name: c.name}, c)
+}
+
+// leave отключает участника. Когда уходит последний, документ сохраняется и сессия закрывается.
+func (s *CollabSession) leave(c *collabClient) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	close(c.send)
+	s.broadcast(collabMessage{Type: "leave", UserID: c.userID}, nil)
+	empty := len(s.clients) == 0
+	s.mu.Unlock()
+
+	if empty {
+		s.closeIfIdle()
+	}
+}
+
+// closeIfIdle сохраняет документ и закрывает сессию без участников, снимая её блокировку редактирования
+func (s *CollabSession) closeIfIdle() {
+	s.persist()
+
+	collabSessions.Lock()
+	defer collabSessions.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) != 0 || collabSessions.m[s.publicationID] != s {
+		return
+	}
+	delete(collabSessions.m, s.publicationID)
+	if err := ReleaseEditLock(s.publicationID, s.lockHolder); err != nil {
+		s.log.Error("Ошибка снятия блокировки после совместного редактирования", "error", err)
+	}
+}
+
+// keepLock продлевает блокировку редактирования сессии. Если блокировку забрал главный редактор,
+// автосохранение останавливается, чтобы не перезаписать его правки.
+func (s *CollabSession) keepLock() {
+	s.mu.Lock()
+	if s.saveFailed {
+		s.mu.Unlock()
+		return
+	}
+	holder := s.lockHolder
+	s.mu.Unlock()
+
+	err := HeartbeatEditLock(s.publicationID, holder)
+	if err == nil {
+		return
+	}
+	if err != ErrLockLost {
+		s.log.Error("Ошибка продления блокировки совместного редактирования", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.saveFailed {
+		s.saveFailed = true
+		s.log.Warn("Совместное редактирование: блокировка потеряна, сохранение остановлено")
+		s.broadcast(collabMessage{Type: "error", Error: "Блокировку публикации забрал другой редактор, автосохранение остановлено. Скопируйте текст и откройте сессию заново."}, nil)
+	}
+}
+
+// receiveOperation применяет правку участника, сделанную относительно ревизии revision
+func (s *CollabSession) receiveOperation(c *collabClient, revision int, op *TextOperation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if revision < s.historyBase || revision > s.revision {
+		s.deliver(c, collabMessage{Type: "error", Error: "Ваша копия документа устарела, требуется перезагрузка"})
+		return
+	}
+
+	// Преобразуем правку относительно операций, которых участник ещё не видел
+	var err error
+	for _, applied := range s.history[revision-s.historyBase:] {
+		if op, _, err = TransformOperations(op, applied); err != nil {
+			break
+		}
+	}
+	var doc []uint16
+	if err == nil {
+		doc, err = op.Apply(s.doc)
+	}
+	if err != nil {
+		s.deliver(c, collabMessage{Type: "error", Error: "Правка не соответствует документу, требуется перезагрузка"})
+		return
+	}
+
+	s.doc = doc
+	s.revision++
+	s.history = append(s.history, op)
+	if len(s.history) > collabHistoryLimit {
+		drop := len(s.history) - collabHistoryLimit
+		s.history = append([]*TextOperation{}, s.history[drop:]...)
+		s.historyBase += drop
+	}
+	s.dirty = true
+	s.lastEditor = c.userID
+
+	for other := range s.clients {
+		other.position = op.TransformIndex(other.position)
+	}
+	s.deliver(c, collabMessage{Type: "ack", Revision: s.revision})
+	s.broadcast(collabMessage{Type: "operation", Revision: s.revision, Operation: op, UserID: c.userID}, c)
+}
+
+// moveCursor сообщает остальным участникам новую позицию курсора
+func (s *CollabSession) moveCursor(c *collabClient, position int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.position = max(0, min(position, len(s.doc)))
+	s.broadcast(collabMessage{Type: "cursor", UserID: c.userID, Name: c.name, Position: c.position}, c)
+}
+
+// persist сохраняет документ в публикацию и историю версий, если с прошлого сохранения были правки
+func (s *CollabSession) persist() {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty || s.saveFailed {
+		s.mu.Unlock()
+		return
+	}
+	content := string(utf16.Decode(s.doc))
+	revision, version, title, editor := s.revision, s.version, s.title, s.lastEditor
+	s.mu.Unlock()
+
+	newVersion, err := UpdatePublicationText(s.publicationID, version, editor, title, content, "")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == ErrVersionConflict || err == sql.ErrNoRows {
+		// Публикацию сохранили или удалили в обход сессии: перезаписывать чужие правки нельзя
+		if !s.saveFailed {
+			s.saveFailed = true
+			s.log.Warn("Совместное редактирование: сохранение остановлено, публикация изменена вне сессии")
+			s.broadcast(collabMessage{Type: "error", Error: "Публикация изменена вне совместного редактирования, автосохранение остановлено. Скопируйте текст и откройте сессию заново."}, nil)
+		}
+		return
+	}
+	if err != nil {
+		s.log.Error("Ошибка сохранения совместного редактирования", "error", err)
+		return
+	}
+
+	s.version = newVersion
+	s.dirty = s.revision != revision
+	s.broadcast(collabMessage{Type: "saved", Revision: revision, Version: newVersion}, nil)
+}
+
+// PersistCollabSessions продлевает блокировки активных сессий и сохраняет несохранённые правки
+func PersistCollabSessions() error {
+	collabSessions.Lock()
+	sessions := make([]*CollabSession, 0, len(collabSessions.m))
+	for _, s := range collabSessions.m {
+		sessions = append(sessions, s)
+	}
+	collabSessions.Unlock()
+
+	for _, s := range sessions {
+		s.keepLock()
+		s.persist()
+	}
+	return nil
+}
+
+// CloseCollabSessions сохраняет все сессии и закрывает соединения участников при остановке сервера.
+// Соединения WebSocket не отслеживаются http.Server и сами по себе не завершатся.
+func CloseCollabSessions() {
+	PersistCollabSessions()
+
+	collabSessions.Lock()
+	var clients []*collabClient
+	for _, s := range collabSessions.m {
+		s.mu.Lock()
+		for c := range s.clients {
+			clients = append(clients, c)
+		}
+		s.mu.Unlock()
+	}
+	collabSessions.Unlock()
+
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+// StartCollabPersister периодически сохраняет документы совместного редактирования
+func StartCollabPersister(ctx context.Context, interval time.Duration) {
+	startPeriodicWorker(ctx, interval, "сохранение совместного редактирования", PersistCollabSessions)
+}
+
+// collabAccess проверяет сессию пользователя и его право править публикацию. При ошибке ответ уже отправлен.
+func collabAccess(w http.ResponseWriter, r *http.Request) (publicationID, userID int, ok bool) {
+	userID, ok = CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	publicationID, err := strconv.Atoi(r.URL.Query().Get("publication_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор публикации", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	allowed, err := canManageContributors(r.Context(), publicationID, userID)
+	if err != nil {
+		http.Error(w, "Ошибка проверки прав: "+err.Error(), http.StatusInternalServerError)
+		return 0, 0, false
+	}
+	if !allowed {
+		http.Error(w, "Недостаточно прав для редактирования публикации", http.StatusForbidden)
+		return 0, 0, false
+	}
+	return publicationID, userID, true
+}
+
+// collabLockedBy возвращает блокировку другого пользователя, из-за которой сессию нельзя открыть.
+// К уже открытой сессии можно подключиться: блокировку держит она сама.
+func collabLockedBy(publicationID, userID int) (*EditLock, error) {
+	collabSessions.Lock()
+	_, active := collabSessions.m[publicationID]
+	collabSessions.Unlock()
+	if active {
+		return nil, nil
+	}
+
+	var lock EditLock
+	err := Db.QueryRow(lockSelect+` AND l.publication_id = $1`, publicationID).
+		Scan(&lock.PublicationID, &lock.UserID, &lock.UserName, &lock.AcquiredAt, &lock.ExpiresAt)
+	if err == sql.ErrNoRows || (err == nil && lock.UserID == userID) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// Страница совместного редактирования
+func CollabPage(w http.ResponseWriter, r *http.Request) {
+	publicationID, userID, ok := collabAccess(w, r)
+	if !ok {
+		return
+	}
+	pub, err := GetPublicationForEdit(publicationID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Публикация не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении публикации: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	lock, err := collabLockedBy(publicationID, userID)
+	if err != nil {
+		http.Error(w, "Ошибка при проверке блокировки: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if lock != nil {
+		http.Error(w, "Публикацию сейчас редактирует "+lock.UserName+", совместное редактирование недоступно", http.StatusConflict)
+		return
+	}
+
+	data := struct {
+		PublicationID int
+		Title         string
+		UserID        int
+	}{
+		PublicationID: publicationID,
+		Title:         pub.Title,
+		UserID:        userID,
+	}
+	if err := TmplCollab.Execute(w, data); err != nil {
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// Соединение WebSocket для совместного редактирования
+func CollabSocketHandler(w http.ResponseWriter, r *http.Request) {
+	publicationID, userID, ok := collabAccess(w, r)
+	if !ok {
+		return
+	}
+	name := strconv.Itoa(userID)
+	if profile, err := GetProfile(userID); err == nil {
+		name = profile.DisplayName()
+	}
+
+	// Ошибки открытия сессии сообщаем ответом HTTP, пока соединение ещё не переключено
+	opened, err := getCollabSession(publicationID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Публикация не найдена", http.StatusNotFound)
+		return
+	}
+	if lockedErr, ok := err.(*collabLockedError); ok {
+		http.Error(w, "Публикацию сейчас редактирует "+lockedErr.lock.UserName, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка открытия сессии: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		opened.closeIfIdle()
+		return
+	}
+	client := &collabClient{conn: conn, send: make(chan []byte, collabSendBuffer), userID: userID, name: name, log: Logger(r.Context())}
+
+	// Отправка сообщений и проверка связи в отдельной горутине
+	go func() {
+		ticker := time.NewTicker(collabPingInterval)
+		defer ticker.Stop()
+		defer conn.Close()
+		for {
+			select {
+			case data, ok := <-client.send:
+				if !ok {
+					return
+				}
+				if err := conn.WriteText(data); err != nil {
+					conn.conn.Close()
+					return
+				}
+			case <-ticker.C:
+				if err := conn.Ping(); err != nil {
+					conn.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	// Пока шло переключение, сессия могла закрыться; тогда откроется новая
+	session, err := joinCollabSession(publicationID, client)
+	if err != nil {
+		if data, err := json.Marshal(collabMessage{Type: "error", Error: "Не удалось подключиться к сессии: " + err.Error()}); err == nil {
+			client.send <- data
+		}
+		close(client.send)
+		return
+	}
+	defer session.leave(client)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * collabPingInterval))
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg collabMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			session.mu.Lock()
+			session.deliver(client, collabMessage{Type: "error", Error: "Неверный формат сообщения"})
+			session.mu.Unlock()
+			continue
+		}
+		switch msg.Type {
+		case "operation":
+			if msg.Operation != nil {
+				session.receiveOperation(client, msg.Revision, msg.Operation)
+			}
+		case "cursor":
+			session.moveCursor(client, msg.Position)
+		}
+	}
+}
+
+// disconnectCollabUser закрывает подключения пользователя к сессиям совместного редактирования
+func disconnectCollabUser(userID int) {
+	collabSessions.Lock()
+	defer collabSessions.Unlock()
+	for _, s := range collabSessions.m {
+		s.mu.Lock()
+		for c := range s.clients {
+			if c.userID == userID {
+				c.conn.conn.Close()
+			}
+		}
+		s.mu.Unlock()
+	}
+}
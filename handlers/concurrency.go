@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+var TmplConflict = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "conflict.html"))
+
+// ErrVersionConflict — публикацию успели изменить после того, как пользователь открыл её на редактирование
+var ErrVersionConflict = errors.New("публикация была изменена другим пользователем")
+
+// ParseVersion разбирает номер версии из формы или запроса
+func ParseVersion(value string) (int, error) {
+	version, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || version <= 0 {
+		return 0, &UserValidationError{Message: "Не указана версия публикации"}
+	}
+	return version, nil
+}
+
+// PublicationETag возвращает ETag для версии публикации
+func PublicationETag(version int) string {
+	return `"v` + strconv.Itoa(version) + `"`
+}
+
+// GetPublicationForEdit возвращает текст, статус и версию публикации
+func GetPublicationForEdit(publicationID int) (Publication, error) {
+	var pub Publication
+	query := `SELECT id, title, content, status, author_id, version, updated_at
+              FROM publications WHERE id = $1 AND deleted_at IS NULL`
+	err := Db.QueryRow(query, publicationID).Scan(&pub.ID, &pub.Title, &pub.Content, &pub.Status, &pub.AuthorID, &pub.Version, &pub.UpdatedAt)
+	return pub, err
+}
+
+// UpdatePublicationText сохраняет заголовок и текст, если публикация всё ещё имеет версию version.
+// Пустой status оставляет статус без изменений. Возвращает новую версию или ErrVersionConflict.
+func UpdatePublicationText(publicationID, version, userID int, title, content, status string) (int, error) {
+	tx, err := Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newVersion int
+	query := `UPDATE publications SET title = $1, content = $2, status = COALESCE(NULLIF($3, ''), status),
+                  updated_at = $4, version = version + 1
+              WHERE id = $5 AND version = $6 AND deleted_at IS NULL
+              RETURNING version`
+	err = tx.QueryRow(query, title, content, status, time.Now(), publicationID, version).Scan(&newVersion)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM publications WHERE id = $1 AND deleted_at IS NULL)`, publicationID).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, ErrVersionConflict
+		}
+		return 0, sql.ErrNoRows
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Каждая сохранённая версия попадает в историю: она служит общей основой при слиянии
+	if _, err := SaveRevision(tx, publicationID, userID, "Редактирование"); err != nil {
+		return 0, err
+	}
+	return newVersion, tx.Commit()
+}
+
+// getRevisionByVersion возвращает текст публикации в указанной версии
+func getRevisionByVersion(publicationID, version int) (Revision, error) {
+	var rev Revision
+	query := `SELECT id, title, content FROM publication_revisions
+              WHERE publication_id = $1 AND version = $2 ORDER BY id DESC LIMIT 1`
+	err := Db.QueryRow(query, publicationID, version).Scan(&rev.ID, &rev.Title, &rev.Content)
+	return rev, err
+}
+
+// Конфликт правок: сохранённая версия, версия пользователя и вариант слияния
+type Conflict struct {
+	Current       Publication
+	YourTitle     string
+	YourContent   string
+	Diff          []DiffLine
+	MergedTitle   string
+	MergedContent string
+	Conflicts     int
+	HasBase       bool
+	Action        string            // куда отправить объединённую версию
+	Fields        map[string]string // скрытые поля исходной формы
+	BackURL       string
+}
+
+// NewConflict готовит данные для страницы конфликта. Если известна исходная версия,
+// непересекающиеся правки объединяются автоматически.
+func NewConflict(ctx context.Context, current Publication, baseVersion int, yourTitle, yourContent string) Conflict {
+	c := Conflict{
+		Current:       current,
+		YourTitle:     yourTitle,
+		YourContent:   yourContent,
+		Diff:          DiffLines(current.Content, yourContent),
+		MergedTitle:   yourTitle,
+		MergedContent: yourContent,
+	}
+
+	base, err := getRevisionByVersion(current.ID, baseVersion)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			Logger(ctx).Error("Ошибка получения исходной версии публикации", "publication_id", current.ID, "error", err)
+		}
+		return c
+	}
+	c.HasBase = true
+	c.MergedContent, c.Conflicts = MergeTexts(base.Content, yourContent, current.Content)
+	if yourTitle == base.Title {
+		c.MergedTitle = current.Title
+	} else if current.Title != base.Title && current.Title != yourTitle {
+		c.Conflicts++
+	}
+	return c
+}
+
+// renderConflict отвечает страницей конфликта со статусом 409
+func renderConflict(w http.ResponseWriter, r *http.Request, c Conflict) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusConflict)
+	if err := TmplConflict.Execute(w, c); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона конфликта", "error", err)
+	}
+}
+
+// savePublicationForm сохраняет текст из формы редактирования с проверкой версии.
+// При конфликте показывает страницу слияния. Возвращает true, если изменения сохранены.
+func savePublicationForm(w http.ResponseWriter, r *http.Request, publicationID, userID int, status string, fields map[string]string, backURL string) bool {
+	title := r.FormValue("title")
+	content := r.FormValue("content")
+	version, err := ParseVersion(r.FormValue("version"))
+	if err != nil {
+		writeUserError(w, err)
+		return false
+	}
+	if strings.Contains(content, mergeMarkerOurs) || strings.Contains(content, mergeMarkerTheirs) {
+		http.Error(w, "Текст содержит маркеры конфликта: выберите нужный вариант и удалите маркеры", http.StatusBadRequest)
+		return false
+	}
+
+	_, err = UpdatePublicationText(publicationID, version, userID, title, content, status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Публикация не найдена", http.StatusNotFound)
+		return false
+	}
+	if err == ErrVersionConflict {
+		current, err := GetPublicationForEdit(publicationID)
+		if err != nil {
+			http.Error(w, "Ошибка при получении публикации: "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		conflict := NewConflict(r.Context(), current, version, title, content)
+		conflict.Action = r.URL.Path
+		conflict.Fields = fields
+		conflict.BackURL = backURL
+		renderConflict(w, r, conflict)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при обновлении публикации: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	releaseLockAfterSave(r.Context(), publicationID, userID)
+	if status == "pending" {
+		publishPublicationEvent(r.Context(), EventStatusChanged, publicationID, userID, "Публикация отправлена на проверку")
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionedStore — база, в которой публикация 7 автора 2 сохранена в версии version.
+// Сохранение проходит, только если правка сделана относительно этой версии.
+func versionedStore(version int64) *fakeStore {
+	return &fakeStore{row: func(query string, args []driver.Value) []driver.Value {
+		switch {
+		case strings.HasPrefix(query, "SELECT id, title, content, status, author_id, version, updated_at FROM publications"):
+			return []driver.Value{int64(7), "Итоги", "Сохранённый текст", "draft", int64(2), version, time.Now()}
+		case strings.HasPrefix(query, "UPDATE publications SET title"):
+			if args[5] != version {
+				return []driver.Value{}
+			}
+			return []driver.Value{version + 1}
+		case strings.HasPrefix(query, "SELECT EXISTS(SELECT 1 FROM publications WHERE id = $1 AND deleted_at IS NULL)"):
+			return []driver.Value{true}
+		case strings.HasPrefix(query, "SELECT EXISTS( SELECT 1 FROM publications WHERE id = $1 AND author_id = $2"):
+			return []driver.Value{args[1] == int64(2)}
+		}
+		return nil
+	}}
+}
+
+// savedRevision сообщает, попала ли правка в историю версий
+func savedRevision(s *fakeStore) bool {
+	for _, c := range s.calls {
+		if strings.HasPrefix(c.query, "INSERT INTO publication_revisions") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEditPublicationStaleVersion(t *testing.T) {
+	form := url.Values{"id": {"7"}, "title": {"Итоги"}, "content": {"Моя правка"}}
+
+	s := versionedStore(5)
+	useFakeDb(t, s)
+	form.Set("version", "4")
+	rec := httptest.NewRecorder()
+	EditPublication(rec, withSession(t, s, postForm("/edit_publication", form), 3, RoleSectionEditor))
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("устаревшая версия: статус %d, ожидался 409", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Сохранённый текст") || !strings.Contains(body, "Моя правка") {
+		t.Errorf("на странице конфликта нет обоих вариантов текста:\n%s", body)
+	}
+	if savedRevision(s) {
+		t.Error("правка по устаревшей версии попала в историю")
+	}
+
+	s = versionedStore(5)
+	useFakeDb(t, s)
+	form.Set("version", "5")
+	rec = httptest.NewRecorder()
+	EditPublication(rec, withSession(t, s, postForm("/edit_publication", form), 3, RoleSectionEditor))
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/section_editor_page?id=3" {
+		t.Errorf("текущая версия: статус %d, переход %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if !savedRevision(s) {
+		t.Error("сохранённая правка не попала в историю")
+	}
+}
+
+func TestPublicationAPIIfMatch(t *testing.T) {
+	put := func(s *fakeStore, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/publication?id=7", strings.NewReader(`{"title":"Итоги","content":"Моя правка"}`))
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		PublicationAPIHandler(rec, withSession(t, s, req, 2, RoleAuthor))
+		return rec
+	}
+
+	s := versionedStore(5)
+	useFakeDb(t, s)
+	rec := put(s, PublicationETag(4))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("устаревший If-Match: статус %d, ожидался 412", rec.Code)
+	}
+	if etag := rec.Header().Get("ETag"); etag != PublicationETag(5) {
+		t.Errorf("ETag = %s, ожидался ETag текущей версии", etag)
+	}
+	if savedRevision(s) {
+		t.Error("правка по устаревшей версии попала в историю")
+	}
+
+	if rec := put(s, `v5`); rec.Code != http.StatusBadRequest {
+		t.Errorf("If-Match без кавычек: статус %d, ожидался 400", rec.Code)
+	}
+
+	s = versionedStore(5)
+	useFakeDb(t, s)
+	if rec := put(s, PublicationETag(5)); rec.Code != http.StatusOK || !savedRevision(s) {
+		t.Errorf("текущий If-Match: статус %d, ответ %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Роли участников публикации
+const (
+	ContributorAuthor       = "author"
+	ContributorTranslator   = "translator"
+	ContributorPhotographer = "photographer"
+	ContributorFactChecker  = "fact_checker"
+)
+
+var ContributorRoles = []string{ContributorAuthor, ContributorTranslator, ContributorPhotographer, ContributorFactChecker}
+
+// Названия ролей участников для подписи и форм
+var ContributorRoleLabels = map[string]string{
+	ContributorAuthor:       "автор",
+	ContributorTranslator:   "переводчик",
+	ContributorPhotographer: "фотограф",
+	ContributorFactChecker:  "фактчекер",
+}
+
+var ErrLastAuthor = errors.New("у публикации должен остаться хотя бы один автор")
+
+// Участник публикации
+type Contributor struct {
+	PublicationID int
+	UserID        int
+	Name          string
+	Role          string
+	Position      int
+}
+
+// GetContributors возвращает участников публикации в порядке подписи
+func GetContributors(publicationID int) ([]Contributor, error) {
+	query := `SELECT c.publication_id, c.user_id, COALESCE(NULLIF(p.full_name, ''), u.login), c.role, c.position
+              FROM publication_contributors c
+              JOIN users u ON u.id = c.user_id
+              LEFT JOIN user_profiles p ON p.user_id = c.user_id
+              WHERE c.publication_id = $1
+              ORDER BY c.position, c.added_at`
+	rows, err := Db.Query(query, publicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contributors []Contributor
+	for rows.Next() {
+		var c Contributor
+		if err := rows.Scan(&c.PublicationID, &c.UserID, &c.Name, &c.Role, &c.Position); err != nil {
+			return nil, err
+		}
+		contributors = append(contributors, c)
+	}
+	return contributors, rows.Err()
+}
+
+// RoleLabel возвращает название роли участника для подписи
+func (c Contributor) RoleLabel() string {
+	return ContributorRoleLabels[c.Role]
+}
+
+// ContributorRoleLabel возвращает название роли текущего пользователя в публикации
+func (p Publication) ContributorRoleLabel() string {
+	return ContributorRoleLabels[p.ContributorRole]
+}
+
+// validateContributorRole проверяет роль участника
+func validateContributorRole(role string) error {
+	for _, known := range ContributorRoles {
+		if role == known {
+			return nil
+		}
+	}
+	return &UserValidationError{Message: "Неизвестная роль участника: " + role}
+}
+
+// AddContributor добавляет участника в конец подписи или меняет роль уже добавленного.
+// Роль последнего автора сменить нельзя, как нельзя и убрать его.
+func AddContributor(exec dbExecutor, publicationID, userID int, role string) error {
+	if err := validateContributorRole(role); err != nil {
+		return err
+	}
+	query := `INSERT INTO publication_contributors (publication_id, user_id, role, position)
+              VALUES ($1, $2, $3, (SELECT COALESCE(MAX(position) + 1, 0) FROM publication_contributors WHERE publication_id = $1))
+              ON CONFLICT (publication_id, user_id) DO UPDATE SET role = EXCLUDED.role
+              WHERE EXCLUDED.role = $4 OR publication_contributors.role <> $4
+                 OR (SELECT COUNT(*) FROM publication_contributors WHERE publication_id = $1 AND role = $4) > 1`
+	result, err := exec.Exec(query, publicationID, userID, role, ContributorAuthor)
+	if err != nil {
+		return err
+	}
+	// Строка не добавлена и не обновлена только тогда, когда условие не пустило снять роль с последнего автора
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrLastAuthor
+	}
+	return nil
+}
+
+// RemoveContributor убирает участника. Последнего автора убрать нельзя.
+func RemoveContributor(publicationID, userID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow(`SELECT role FROM publication_contributors WHERE publication_id = $1 AND user_id = $2 FOR UPDATE`,
+		publicationID, userID).Scan(&role)
+	if err != nil {
+		return err
+	}
+
+	if role == ContributorAuthor {
+		var authors int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM publication_contributors WHERE publication_id = $1 AND role = $2`,
+			publicationID, ContributorAuthor).Scan(&authors)
+		if err != nil {
+			return err
+		}
+		if authors <= 1 {
+			return ErrLastAuthor
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM publication_contributors WHERE publication_id = $1 AND user_id = $2`, publicationID, userID); err != nil {
+		return err
+	}
+
+	// Ответственным автором публикации остаётся первый автор в подписи
+	_, err = tx.Exec(`UPDATE publications SET author_id = (
+                          SELECT user_id FROM publication_contributors
+                          WHERE publication_id = $1 AND role = $2 ORDER BY position, added_at LIMIT 1)
+                      WHERE id = $1 AND author_id = $3`, publicationID, ContributorAuthor, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveContributor сдвигает участника в подписи на одну позицию вверх (delta < 0) или вниз (delta > 0)
+func MoveContributor(publicationID, userID, delta int) error {
+	contributors, err := GetContributors(publicationID)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i, c := range contributors {
+		if c.UserID == userID {
+			index = i
+		}
+	}
+	if index < 0 {
+		return sql.ErrNoRows
+	}
+	target := index + delta
+	if target < 0 || target >= len(contributors) {
+		return nil
+	}
+	contributors[index], contributors[target] = contributors[target], contributors[index]
+
+	// Перенумеровываем позиции, чтобы порядок был однозначным
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for position, c := range contributors {
+		_, err := tx.Exec(`UPDATE publication_contributors SET position = $1 WHERE publication_id = $2 AND user_id = $3`,
+			position, publicationID, c.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CanEditPublication сообщает, может ли пользователь править текст публикации:
+// это разрешено всем её авторам и соавторам
+func CanEditPublication(publicationID, userID int) (bool, error) {
+	var allowed bool
+	query := `SELECT EXISTS(
+                  SELECT 1 FROM publications WHERE id = $1 AND author_id = $2
+                  UNION ALL
+                  SELECT 1 FROM publication_contributors WHERE publication_id = $1 AND user_id = $2 AND role = $3)`
+	err := Db.QueryRow(query, publicationID, userID, ContributorAuthor).Scan(&allowed)
+	return allowed, err
+}
+
+// canManageContributors — участников меняют авторы публикации и редакторы
+func canManageContributors(ctx context.Context, publicationID, userID int) (bool, error) {
+	allowed, err := CanEditPublication(publicationID, userID)
+	if err != nil || allowed {
+		return allowed, err
+	}
+	return CheckEditor(ctx, userID)
+}
+
+// Управление участниками публикации: добавление, удаление и изменение порядка
+func ContributorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	publicationIDStr := r.FormValue("publication_id")
+	publicationID, err := strconv.Atoi(publicationIDStr)
+	if err != nil {
+		http.Error(w, "Неверный идентификатор публикации", http.StatusBadRequest)
+		return
+	}
+	// Права проверяются у пользователя сессии, а не у переданного в форме
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return
+	}
+	contributorID, err := strconv.Atoi(r.FormValue("contributor_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор участника", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := canManageContributors(r.Context(), publicationID, userID)
+	if err != nil {
+		http.Error(w, "Ошибка проверки прав: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Недостаточно прав для изменения участников", http.StatusForbidden)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "add":
+		err = AddContributor(Db, publicationID, contributorID, r.FormValue("role"))
+	case "remove":
+		err = RemoveContributor(publicationID, contributorID)
+	case "up":
+		err = MoveContributor(publicationID, contributorID, -1)
+	case "down":
+		err = MoveContributor(publicationID, contributorID, 1)
+	default:
+		http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+		return
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Участник не найден", http.StatusNotFound)
+		return
+	}
+	if err == ErrLastAuthor {
+		http.Error(w, "У публикации должен остаться хотя бы один автор", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if r.FormValue("action") == "add" {
+		publishPublicationEvent(r.Context(), EventAssigned, publicationID, userID, "Назначен участник публикации: "+ContributorRoleLabels[r.FormValue("role")])
+	}
+
+	http.Redirect(w, r, "/author/edit_publication?publication_id="+publicationIDStr, http.StatusSeeOther)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAddContributorKeepsLastAuthor(t *testing.T) {
+	s := &fakeStore{affected: func(string, []driver.Value) int64 { return 0 }}
+	useFakeDb(t, s)
+
+	// Условие ON CONFLICT не пустило обновление: это последний автор
+	if err := AddContributor(Db, 7, 3, ContributorFactChecker); err != ErrLastAuthor {
+		t.Fatalf("ожидалась ErrLastAuthor, получено %v", err)
+	}
+	call := s.find(t, "INSERT INTO publication_contributors")
+	if !strings.Contains(call.query, "DO UPDATE SET role = EXCLUDED.role WHERE") {
+		t.Errorf("смена роли не ограничена условием: %s", call.query)
+	}
+
+	s.affected = nil
+	if err := AddContributor(Db, 7, 3, ContributorFactChecker); err != nil {
+		t.Fatalf("смена роли при других авторах: %v", err)
+	}
+}
+
+func TestAssignPublicationsRequiresSession(t *testing.T) {
+	s := &fakeStore{}
+	useFakeDb(t, s)
+
+	form := url.Values{"user_id": {"3"}, "publication_id": {"7"}, "role": {ContributorAuthor}}
+	req := httptest.NewRequest(http.MethodPost, "/section_editor/assign_publications", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	AssignPublications(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("без сессии: статус %d, ожидался 401", rec.Code)
+	}
+	for _, c := range s.calls {
+		if strings.Contains(c.query, "publication_contributors") {
+			t.Errorf("участник назначен без сессии: %s", c.query)
+		}
+	}
+}
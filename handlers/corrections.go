@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Опубликованное исправление к статье
+type Correction struct {
+	ID            int
+	PublicationID int
+	Notice        string
+	RevisionID    int
+	CreatedAt     time.Time
+}
+
+// GetCorrections возвращает исправления публикации в хронологическом порядке
+func GetCorrections(publicationID int) ([]Correction, error) {
+	query := `SELECT id, publication_id, notice, COALESCE(revision_id, 0), created_at
+              FROM publication_corrections WHERE publication_id = $1 ORDER BY created_at`
+	rows, err := Db.Query(query, publicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var corrections []Correction
+	for rows.Next() {
+		var c Correction
+		if err := rows.Scan(&c.ID, &c.PublicationID, &c.Notice, &c.RevisionID, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		corrections = append(corrections, c)
+	}
+	return corrections, rows.Err()
+}
+
+// editorAction разбирает общие поля формы действий редактора над опубликованной статьёй.
+// Редактор берётся из сессии и должен иметь роль редактора. При ошибке ответ уже отправлен и ok == false.
+func editorAction(w http.ResponseWriter, r *http.Request) (articleID, editorID int, role string, ok bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return 0, 0, "", false
+	}
+
+	editorID, role, ok = sessionRole(w, r, IsEditorRole, "Недопустимая роль пользователя")
+	if !ok {
+		return 0, 0, "", false
+	}
+
+	articleID, err := strconv.Atoi(r.FormValue("article_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+		return 0, 0, "", false
+	}
+
+	return articleID, editorID, role, true
+}
+
+// redirectToEditorPage возвращает редактора на страницу его роли
+func redirectToEditorPage(w http.ResponseWriter, r *http.Request, role string, editorID int) {
+	http.Redirect(w, r, editorPageURL(role, editorID), http.StatusSeeOther)
+}
+
+// editorPageURL — адрес страницы редактора для его роли
+func editorPageURL(role string, editorID int) string {
+	if role == RoleSectionEditor {
+		return "/section_editor_page?id=" + strconv.Itoa(editorID)
+	}
+	return "/chief_editor_page?id=" + strconv.Itoa(editorID)
+}
+
+// Снятие статьи с публикации с указанием причины
+func UnpublishPublicationHandler(w http.ResponseWriter, r *http.Request) {
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		http.Error(w, "Укажите причину снятия с публикации", http.StatusBadRequest)
+		return
+	}
+
+	query := `UPDATE publications
+              SET status = 'unpublished', is_published = FALSE, unpublish_reason = $1, unpublished_at = $2, updated_at = $2
+              WHERE id = $3 AND is_published = TRUE`
+	result, err := Db.Exec(query, reason, time.Now(), articleID)
+	if err != nil {
+		http.Error(w, "Ошибка при снятии с публикации: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		http.Error(w, "Публикация не найдена или не опубликована", http.StatusConflict)
+		return
+	}
+	publishPublicationEvent(r.Context(), EventStatusChanged, articleID, editorID, "Публикация снята: "+reason)
+
+	redirectToEditorPage(w, r, role, editorID)
+}
+
+// Отзыв статьи: она остаётся доступной, но помечается баннером об отзыве
+func RetractPublicationHandler(w http.ResponseWriter, r *http.Request) {
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		http.Error(w, "Укажите причину отзыва", http.StatusBadRequest)
+		return
+	}
+
+	query := `UPDATE publications
+              SET is_retracted = TRUE, retraction_reason = $1, retracted_at = $2, updated_at = $2
+              WHERE id = $3 AND is_published = TRUE AND is_retracted = FALSE`
+	result, err := Db.Exec(query, reason, time.Now(), articleID)
+	if err != nil {
+		http.Error(w, "Ошибка при отзыве публикации: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		http.Error(w, "Публикация не опубликована или уже отозвана", http.StatusConflict)
+		return
+	}
+
+	redirectToEditorPage(w, r, role, editorID)
+}
+
+// Публикация исправления: обновлённый текст сохраняется как новая версия,
+// к статье добавляется датированное уведомление об исправлении
+func CorrectPublicationHandler(w http.ResponseWriter, r *http.Request) {
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
+		return
+	}
+
+	notice := strings.TrimSpace(r.FormValue("notice"))
+	content := r.FormValue("content")
+	if notice == "" || content == "" {
+		http.Error(w, "Текст исправления и содержание статьи обязательны", http.StatusBadRequest)
+		return
+	}
+	version, err := ParseVersion(r.FormValue("version"))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Если история версий пуста, сохраняем исходную опубликованную версию
+	var hasRevisions bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM publication_revisions WHERE publication_id = $1)`, articleID).Scan(&hasRevisions)
+	if err != nil {
+		http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !hasRevisions {
+		if _, err := SaveRevision(tx, articleID, 0, "Опубликованная версия"); err != nil {
+			http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var isPublished bool
+	var currentVersion int
+	err = tx.QueryRow(`SELECT COALESCE(is_published, FALSE), version FROM publications WHERE id = $1 FOR UPDATE`, articleID).
+		Scan(&isPublished, &currentVersion)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Статья не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !isPublished {
+		http.Error(w, "Исправления публикуются только для опубликованных статей", http.StatusConflict)
+		return
+	}
+	if currentVersion != version {
+		http.Error(w, "Статья была изменена другим пользователем. Обновите страницу и внесите исправление повторно.", http.StatusConflict)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE publications SET content = $1, updated_at = $2, version = version + 1 WHERE id = $3`,
+		content, time.Now(), articleID)
+	if err != nil {
+		http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	revisionID, err := SaveRevision(tx, articleID, editorID, "Исправление: "+notice)
+	if err != nil {
+		http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`INSERT INTO publication_corrections (publication_id, notice, revision_id, created_by) VALUES ($1, $2, $3, $4)`,
+		articleID, notice, revisionID, nullableUserID(editorID))
+	if err != nil {
+		http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка при сохранении исправления: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	redirectToEditorPage(w, r, role, editorID)
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+var TmplOverdueReport = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "overdue_report.html"))
+
+// Виды просрочек
+const (
+	EscalationDraftDeadline = "draft_deadline" // черновик не сдан к сроку
+	EscalationSLA           = "sla"            // публикация слишком долго находится в одном статусе
+)
+
+// Escalation — просрочка, переданная главному редактору
+type Escalation struct {
+	ID               int
+	PublicationID    int
+	PublicationTitle string
+	Department       string
+	Kind             string
+	Status           string
+	DetectedAt       time.Time
+	StatusChangedAt  time.Time
+}
+
+// Строка отчёта по просрочкам для одного отдела
+type DepartmentOverdue struct {
+	Department    string
+	DraftDeadline int
+	SLA           int
+	Total         int
+}
+
+// StartOverdueScheduler периодически ищет просроченные публикации до отмены контекста
+func StartOverdueScheduler(ctx context.Context, interval time.Duration) {
+	startPeriodicWorker(ctx, interval, "проверка просрочек", CheckOverdue)
+}
+
+// CheckOverdue фиксирует новые просрочки, эскалируя их главному редактору темы,
+// и закрывает эскалации, условия которых больше не выполняются
+func CheckOverdue() error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Закрываем эскалации: статус публикации сменился или срок сдачи перенесён
+	_, err = tx.Exec(`UPDATE escalations e SET resolved_at = NOW()
+                      FROM publications p
+                      WHERE e.publication_id = p.id AND e.resolved_at IS NULL
+                        AND (p.status_changed_at <> e.status_changed_at OR p.deleted_at IS NOT NULL
+                             OR (e.kind = $1 AND (p.due_at IS NULL OR p.due_at >= NOW())))`,
+		EscalationDraftDeadline)
+	if err != nil {
+		return err
+	}
+
+	// Черновики и доработки, не сданные к сроку
+	draftResult, err := tx.Exec(`INSERT INTO escalations (publication_id, kind, status, escalated_to, status_changed_at)
+                                 SELECT p.id, $1, p.status, t.editor_id, p.status_changed_at
+                                 FROM publications p LEFT JOIN user_topics t ON t.id = p.topic_id
+                                 WHERE p.status IN ('draft', 'revision') AND p.due_at < NOW() AND p.deleted_at IS NULL
+                                 ON CONFLICT (publication_id, kind) WHERE resolved_at IS NULL DO NOTHING`,
+		EscalationDraftDeadline)
+	if err != nil {
+		return err
+	}
+
+	// Публикации, превысившие SLA своего статуса
+	slaResult, err := tx.Exec(`INSERT INTO escalations (publication_id, kind, status, escalated_to, status_changed_at)
+                               SELECT p.id, $1, p.status, t.editor_id, p.status_changed_at
+                               FROM publications p
+                               JOIN status_slas s ON s.status = p.status
+                               LEFT JOIN user_topics t ON t.id = p.topic_id
+                               WHERE p.status_changed_at + s.max_hours * INTERVAL '1 hour' < NOW() AND p.deleted_at IS NULL
+                               ON CONFLICT (publication_id, kind) WHERE resolved_at IS NULL DO NOTHING`,
+		EscalationSLA)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	drafts, _ := draftResult.RowsAffected()
+	slas, _ := slaResult.RowsAffected()
+	if drafts > 0 || slas > 0 {
+		log.Printf("Эскалировано главному редактору: просроченных черновиков %d, нарушений SLA %d", drafts, slas)
+	}
+	return nil
+}
+
+// GetOpenEscalations возвращает незакрытые эскалации главного редактора.
+// Эскалации публикаций без темы видны всем главным редакторам.
+func GetOpenEscalations(editorID int) ([]Escalation, error) {
+	query := `SELECT e.id, e.publication_id, p.title, COALESCE(p.department, ''), e.kind, e.status, e.detected_at, e.status_changed_at
+              FROM escalations e JOIN publications p ON p.id = e.publication_id
+              WHERE e.resolved_at IS NULL AND (e.escalated_to = $1 OR e.escalated_to IS NULL)
+              ORDER BY e.detected_at`
+	rows, err := Db.Query(query, editorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var escalations []Escalation
+	for rows.Next() {
+		var e Escalation
+		if err := rows.Scan(&e.ID, &e.PublicationID, &e.PublicationTitle, &e.Department, &e.Kind, &e.Status, &e.DetectedAt, &e.StatusChangedAt); err != nil {
+			return nil, err
+		}
+		escalations = append(escalations, e)
+	}
+	return escalations, rows.Err()
+}
+
+// GetOverdueReport считает незакрытые просрочки по отделам
+func GetOverdueReport() ([]DepartmentOverdue, error) {
+	query := `SELECT COALESCE(p.department, ''),
+                     COUNT(*) FILTER (WHERE e.kind = $1),
+                     COUNT(*) FILTER (WHERE e.kind = $2),
+                     COUNT(*)
+              FROM escalations e JOIN publications p ON p.id = e.publication_id
+              WHERE e.resolved_at IS NULL
+              GROUP BY 1 ORDER BY 4 DESC, 1`
+	rows, err := Db.Query(query, EscalationDraftDeadline, EscalationSLA)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []DepartmentOverdue
+	for rows.Next() {
+		var d DepartmentOverdue
+		if err := rows.Scan(&d.Department, &d.DraftDeadline, &d.SLA, &d.Total); err != nil {
+			return nil, err
+		}
+		report = append(report, d)
+	}
+	return report, rows.Err()
+}
+
+// Отчёт о просрочках по отделам для главного редактора
+func OverdueReportHandler(w http.ResponseWriter, r *http.Request) {
+	editorID, _, ok := sessionRole(w, r, IsEditorRole, "Отчёт о просрочках доступен только редакторам")
+	if !ok {
+		return
+	}
+
+	report, err := GetOverdueReport()
+	if err != nil {
+		http.Error(w, "Ошибка при формировании отчёта: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	escalations, err := GetOpenEscalations(editorID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении просрочек: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		EditorID    int
+		Report      []DepartmentOverdue
+		Escalations []Escalation
+	}{
+		EditorID:    editorID,
+		Report:      report,
+		Escalations: escalations,
+	}
+
+	if err := TmplOverdueReport.Execute(w, data); err != nil {
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// Установка срока сдачи черновика главным редактором
+func SetPublicationDueDateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	editorID, _, ok := sessionRole(w, r, IsEditorRole, "Срок сдачи может устанавливать только редактор")
+	if !ok {
+		return
+	}
+
+	articleID, err := strconv.Atoi(r.FormValue("article_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+		return
+	}
+
+	dueAt, err := ParseDueDate(r.FormValue("due_at"))
+	if err != nil {
+		http.Error(w, "Неверный формат срока сдачи", http.StatusBadRequest)
+		return
+	}
+
+	result, err := Db.Exec(`UPDATE publications SET due_at = $1 WHERE id = $2 AND deleted_at IS NULL`, dueAt, articleID)
+	if err != nil {
+		http.Error(w, "Ошибка при установке срока сдачи: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		http.Error(w, "Публикация не найдена", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/chief_editor_page?id="+strconv.Itoa(editorID), http.StatusSeeOther)
+}
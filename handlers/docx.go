@@ -0,0 +1,1023 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ограничения на загружаемые документы Word
+const (
+	maxDocxUpload   = 20 << 20  // размер файла
+	maxDocxPartSize = 50 << 20  // размер одной распакованной части архива
+	maxDocxUnpacked = 100 << 20 // общий размер всех распакованных частей
+	maxDocxImages   = 100       // число изображений в документе
+	docxMaxWidthEMU = 5486400   // 6 дюймов — ширина текста на странице A4 с полями
+	docxEMUPerPixel = 9525
+)
+
+// Пространства имён OOXML
+const (
+	docxNSWord    = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	docxNSRels    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	docxNSDrawing = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	docxNSWP      = "http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"
+	docxNSCompat  = "http://schemas.openxmlformats.org/markup-compatibility/2006"
+)
+
+var (
+	ErrNotDocx        = errors.New("файл не является документом Word (.docx)")
+	ErrDocxTooLarge   = errors.New("документ слишком велик после распаковки")
+	ErrDocxManyImages = fmt.Errorf("в документе больше %d изображений", maxDocxImages)
+
+	docxImageNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	docxImageExts   = map[string]string{"image/png": "png", "image/jpeg": "jpeg", "image/gif": "gif"}
+)
+
+// Изображение публикации; в тексте на него ссылаются строкой ![подпись](image:имя)
+type PublicationImage struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// SavePublicationImages сохраняет изображения публикации, заменяя одноимённые
+func SavePublicationImages(tx *sql.Tx, publicationID int, images []PublicationImage) error {
+	for _, img := range images {
+		_, err := tx.Exec(`INSERT INTO publication_images (publication_id, name, content_type, data)
+                           VALUES ($1, $2, $3, $4)
+                           ON CONFLICT (publication_id, name) DO UPDATE SET content_type = EXCLUDED.content_type, data = EXCLUDED.data`,
+			publicationID, img.Name, img.ContentType, img.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPublicationImages возвращает изображения публикации по именам
+func GetPublicationImages(publicationID int) (map[string]PublicationImage, error) {
+	rows, err := Db.Query(`SELECT name, content_type, data FROM publication_images WHERE publication_id = $1`, publicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := map[string]PublicationImage{}
+	for rows.Next() {
+		var img PublicationImage
+		if err := rows.Scan(&img.Name, &img.ContentType, &img.Data); err != nil {
+			return nil, err
+		}
+		images[img.Name] = img
+	}
+	return images, rows.Err()
+}
+
+// ---------- Импорт ----------
+
+// Результат разбора документа Word
+type DocxImport struct {
+	Title   string
+	Content string
+	Images  []PublicationImage
+}
+
+// docxParagraph — абзац документа до перевода в разметку
+type docxParagraph struct {
+	Style  string
+	NumID  string
+	Level  string
+	Runs   []markupRun
+	Images []docxParagraphImage
+}
+
+// docxParagraphImage — картинка в абзаце: идентификатор связи и подпись из свойств рисунка
+type docxParagraphImage struct {
+	RelID string
+	Alt   string
+}
+
+// docxArchive — части архива документа и остаток общего бюджета на распаковку.
+// Бюджет общий для всех частей: сжатый архив из множества мелких частей
+// иначе мог бы распаковаться в гигабайты.
+type docxArchive struct {
+	files     map[string]*zip.File
+	remaining int64
+}
+
+// readPart читает часть архива; отсутствующая часть возвращается как nil без ошибки
+func (a *docxArchive) readPart(name string) ([]byte, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	limit := min(int64(maxDocxPartSize), a.remaining)
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		if limit < maxDocxPartSize {
+			return nil, ErrDocxTooLarge
+		}
+		return nil, fmt.Errorf("часть документа %s слишком велика", name)
+	}
+	a.remaining -= int64(len(data))
+	return data, nil
+}
+
+// docxAttr возвращает значение атрибута по локальному имени
+func docxAttr(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// docxToggle читает признак вида <w:b/>: без значения он включён, "0", "false" и "none" выключают его
+func docxToggle(se xml.StartElement) bool {
+	switch docxAttr(se, "val") {
+	case "0", "false", "off", "none":
+		return false
+	}
+	return true
+}
+
+// docxStyleNames сопоставляет идентификаторы стилей с их именами в нижнем регистре ("heading 1", "title")
+func docxStyleNames(data []byte) map[string]string {
+	names := map[string]string{}
+	var doc struct {
+		Styles []struct {
+			ID   string `xml:"styleId,attr"`
+			Name struct {
+				Val string `xml:"val,attr"`
+			} `xml:"name"`
+		} `xml:"style"`
+	}
+	if xml.Unmarshal(data, &doc) == nil {
+		for _, s := range doc.Styles {
+			names[s.ID] = strings.ToLower(s.Name.Val)
+		}
+	}
+	return names
+}
+
+// docxBulletLists определяет, какие уровни списков маркированные: numId → ilvl → true
+func docxBulletLists(data []byte) map[string]map[string]bool {
+	var doc struct {
+		Abstract []struct {
+			ID     string `xml:"abstractNumId,attr"`
+			Levels []struct {
+				Level  string `xml:"ilvl,attr"`
+				Format struct {
+					Val string `xml:"val,attr"`
+				} `xml:"numFmt"`
+			} `xml:"lvl"`
+		} `xml:"abstractNum"`
+		Nums []struct {
+			ID       string `xml:"numId,attr"`
+			Abstract struct {
+				Val string `xml:"val,attr"`
+			} `xml:"abstractNumId"`
+		} `xml:"num"`
+	}
+	bullets := map[string]map[string]bool{}
+	if xml.Unmarshal(data, &doc) != nil {
+		return bullets
+	}
+	abstract := map[string]map[string]bool{}
+	for _, a := range doc.Abstract {
+		levels := map[string]bool{}
+		for _, l := range a.Levels {
+			levels[l.Level] = l.Format.Val == "bullet"
+		}
+		abstract[a.ID] = levels
+	}
+	for _, n := range doc.Nums {
+		bullets[n.ID] = abstract[n.Abstract.Val]
+	}
+	return bullets
+}
+
+// docxRelationships возвращает цели связей документа: идентификатор → путь внутри архива
+func docxRelationships(data []byte) map[string]string {
+	targets := map[string]string{}
+	var doc struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+			Mode   string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if xml.Unmarshal(data, &doc) == nil {
+		for _, rel := range doc.Rels {
+			if rel.Mode == "External" {
+				continue
+			}
+			target := rel.Target
+			if strings.HasPrefix(target, "/") {
+				target = strings.TrimPrefix(target, "/")
+			} else {
+				target = path.Join("word", target)
+			}
+			targets[rel.ID] = target
+		}
+	}
+	return targets
+}
+
+// docxParagraphs проходит по word/document.xml и собирает абзацы с фрагментами текста и картинками.
+// Содержимое надписей и запасные варианты из mc:Fallback пропускаются, иначе текст задвоится.
+func docxParagraphs(data []byte) ([]docxParagraph, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var paragraphs []docxParagraph
+	var para *docxParagraph
+	var inPPr, inRun bool
+	var run markupRun
+	var alt string
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrNotDocx
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == docxNSCompat && t.Name.Local == "Fallback" {
+				if err := d.Skip(); err != nil {
+					return nil, ErrNotDocx
+				}
+				continue
+			}
+			if t.Name.Space == docxNSWP && t.Name.Local == "docPr" {
+				alt = docxAttr(t, "descr")
+				continue
+			}
+			if t.Name.Space == docxNSDrawing && t.Name.Local == "blip" && para != nil {
+				for _, a := range t.Attr {
+					if a.Name.Space == docxNSRels && a.Name.Local == "embed" {
+						para.Images = append(para.Images, docxParagraphImage{RelID: a.Value, Alt: alt})
+					}
+				}
+				alt = ""
+				continue
+			}
+			if t.Name.Space != docxNSWord {
+				continue
+			}
+			switch t.Name.Local {
+			case "txbxContent", "delText", "instrText":
+				if err := d.Skip(); err != nil {
+					return nil, ErrNotDocx
+				}
+			case "p":
+				para = &docxParagraph{}
+			case "pPr":
+				inPPr = true
+			case "pStyle":
+				if inPPr && para != nil {
+					para.Style = docxAttr(t, "val")
+				}
+			case "ilvl":
+				if inPPr && para != nil {
+					para.Level = docxAttr(t, "val")
+				}
+			case "numId":
+				if inPPr && para != nil {
+					para.NumID = docxAttr(t, "val")
+				}
+			case "r":
+				inRun = true
+				run = markupRun{}
+			case "b":
+				if inRun && !inPPr {
+					run.Bold = docxToggle(t)
+				}
+			case "i":
+				if inRun && !inPPr {
+					run.Italic = docxToggle(t)
+				}
+			case "t":
+				var text string
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return nil, ErrNotDocx
+				}
+				if para != nil {
+					para.Runs = append(para.Runs, markupRun{Text: text, Bold: run.Bold, Italic: run.Italic})
+				}
+			case "tab":
+				if inRun && para != nil {
+					para.Runs = append(para.Runs, markupRun{Text: "\t", Bold: run.Bold, Italic: run.Italic})
+				}
+			case "br", "cr":
+				if inRun && para != nil {
+					para.Runs = append(para.Runs, markupRun{Text: " ", Bold: run.Bold, Italic: run.Italic})
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Space != docxNSWord {
+				continue
+			}
+			switch t.Name.Local {
+			case "pPr":
+				inPPr = false
+			case "r":
+				inRun = false
+			case "p":
+				if para != nil {
+					paragraphs = append(paragraphs, *para)
+					para = nil
+				}
+			}
+		}
+	}
+	return paragraphs, nil
+}
+
+// docxHeadingLevel возвращает уровень заголовка по имени стиля или 0 для обычного абзаца
+func docxHeadingLevel(style string) int {
+	if !strings.HasPrefix(style, "heading ") {
+		return 0
+	}
+	level, err := strconv.Atoi(strings.TrimPrefix(style, "heading "))
+	if err != nil || level < 1 {
+		return 0
+	}
+	if level > 3 {
+		level = 3
+	}
+	return level
+}
+
+// ParseDOCX переводит документ Word в текст публикации с разметкой и список изображений.
+// Название берётся из абзаца со стилем «Название» или из свойств документа.
+func ParseDOCX(data []byte) (DocxImport, error) {
+	var result DocxImport
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return result, ErrNotDocx
+	}
+	archive := &docxArchive{files: map[string]*zip.File{}, remaining: maxDocxUnpacked}
+	for _, f := range z.File {
+		archive.files[f.Name] = f
+	}
+
+	document, err := archive.readPart("word/document.xml")
+	if err != nil {
+		return result, err
+	}
+	if document == nil {
+		return result, ErrNotDocx
+	}
+	parts := map[string][]byte{}
+	for _, name := range []string{"word/styles.xml", "word/numbering.xml", "word/_rels/document.xml.rels", "docProps/core.xml"} {
+		if parts[name], err = archive.readPart(name); err != nil {
+			return result, err
+		}
+	}
+	styles := docxStyleNames(parts["word/styles.xml"])
+	bullets := docxBulletLists(parts["word/numbering.xml"])
+	rels := docxRelationships(parts["word/_rels/document.xml.rels"])
+
+	paragraphs, err := docxParagraphs(document)
+	if err != nil {
+		return result, err
+	}
+
+	var lines []string
+	var prevList bool
+	prevNumID := ""
+	number := 0
+	imageNames := map[string]string{} // путь в архиве → имя изображения публикации
+	for _, p := range paragraphs {
+		style := styles[p.Style]
+		if style == "" {
+			style = strings.ToLower(p.Style)
+		}
+		block := markupBlock{Kind: markupParagraph, Runs: p.Runs}
+		if style == "title" {
+			if result.Title == "" {
+				for _, run := range p.Runs {
+					result.Title += run.Text
+				}
+				result.Title = strings.Join(strings.Fields(result.Title), " ")
+				continue
+			}
+			block.Kind, block.Level = markupHeading, 1
+		} else if level := docxHeadingLevel(style); level > 0 {
+			block.Kind, block.Level = markupHeading, level
+		} else if p.NumID != "" && p.NumID != "0" {
+			block.Kind = markupNumber
+			if bullets[p.NumID][p.Level] {
+				block.Kind = markupBullet
+			}
+		}
+
+		var blockLines []string
+		if text := formatInlineMarkup(p.Runs); text != "" {
+			if block.Kind == markupNumber {
+				if !prevList || p.NumID != prevNumID {
+					number = 0
+				}
+				number++
+			}
+			blockLines = append(blockLines, formatMarkupBlock(block, number))
+		}
+		for _, pi := range p.Images {
+			target, ok := rels[pi.RelID]
+			if !ok {
+				continue
+			}
+			name, ok := imageNames[target]
+			if !ok {
+				if len(result.Images) >= maxDocxImages {
+					return result, ErrDocxManyImages
+				}
+				img, err := docxImage(archive, target, len(result.Images)+1)
+				if err != nil {
+					return result, err
+				}
+				if img == nil {
+					continue
+				}
+				name = img.Name
+				imageNames[target] = name
+				result.Images = append(result.Images, *img)
+			}
+			blockLines = append(blockLines, formatMarkupBlock(markupBlock{Kind: markupImage, Image: name, Alt: pi.Alt}, 0))
+		}
+		if len(blockLines) == 0 {
+			continue
+		}
+
+		// Пункты одного списка идут подряд, остальные блоки разделяются пустой строкой
+		isList := block.Kind == markupBullet || block.Kind == markupNumber
+		if len(lines) > 0 && !(isList && prevList && p.NumID == prevNumID) {
+			lines = append(lines, "")
+		}
+		lines = append(lines, blockLines...)
+		prevList = isList && len(p.Images) == 0
+		prevNumID = p.NumID
+	}
+	result.Content = strings.Join(lines, "\n")
+
+	if result.Title == "" && parts["docProps/core.xml"] != nil {
+		var core struct {
+			Title string `xml:"title"`
+		}
+		if xml.Unmarshal(parts["docProps/core.xml"], &core) == nil {
+			result.Title = strings.TrimSpace(core.Title)
+		}
+	}
+	return result, nil
+}
+
+// docxImage извлекает картинку из архива; форматы, которые не показывают браузеры, пропускаются
+func docxImage(archive *docxArchive, target string, n int) (*PublicationImage, error) {
+	data, err := archive.readPart(target)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := docxImageExts[contentType]
+	if !ok {
+		return nil, nil
+	}
+	// Имя из архива оставляем, только если оно безопасно и расширение совпадает с содержимым
+	name := path.Base(target)
+	nameExt := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if nameExt == "jpg" {
+		nameExt = "jpeg"
+	}
+	if !docxImageNameRe.MatchString(name) || nameExt != ext {
+		name = fmt.Sprintf("image%d.%s", n, ext)
+	}
+	return &PublicationImage{Name: name, ContentType: contentType, Data: data}, nil
+}
+
+// ---------- Экспорт ----------
+
+// Замечание редакции в выгружаемом документе
+type DocxComment struct {
+	Author string
+	Date   time.Time
+	Text   string
+}
+
+// Документ для выгрузки в Word
+type DocxDocument struct {
+	Title    string
+	Author   string
+	Created  time.Time
+	Modified time.Time
+	Content  string
+	Images   map[string]PublicationImage
+	Comments []DocxComment
+}
+
+// docxText экранирует текст для XML
+func docxText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// docxRuns записывает фрагменты текста как w:r с полужирным и курсивом
+func docxRuns(b *strings.Builder, runs []markupRun) {
+	for _, run := range runs {
+		b.WriteString("<w:r>")
+		if run.Bold || run.Italic {
+			b.WriteString("<w:rPr>")
+			if run.Bold {
+				b.WriteString("<w:b/>")
+			}
+			if run.Italic {
+				b.WriteString("<w:i/>")
+			}
+			b.WriteString("</w:rPr>")
+		}
+		for i, part := range strings.Split(run.Text, "\t") {
+			if i > 0 {
+				b.WriteString("<w:tab/>")
+			}
+			if part != "" {
+				b.WriteString(`<w:t xml:space="preserve">` + docxText(part) + "</w:t>")
+			}
+		}
+		b.WriteString("</w:r>")
+	}
+}
+
+// docxImageRef — картинка, вложенная в архив
+type docxImageRef struct {
+	RelID string
+	Path  string
+	Image PublicationImage
+}
+
+// docxDrawing записывает картинку, вписанную в ширину страницы
+func docxDrawing(b *strings.Builder, id int, ref docxImageRef, alt string) {
+	cx, cy := int64(docxMaxWidthEMU), int64(docxMaxWidthEMU*3/4)
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(ref.Image.Data)); err == nil && cfg.Width > 0 && cfg.Height > 0 {
+		cx, cy = int64(cfg.Width)*docxEMUPerPixel, int64(cfg.Height)*docxEMUPerPixel
+		if cx > docxMaxWidthEMU {
+			cy = cy * docxMaxWidthEMU / cx
+			cx = docxMaxWidthEMU
+		}
+	}
+	name := docxText(ref.Image.Name)
+	fmt.Fprintf(b, `<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0">`+
+		`<wp:extent cx="%d" cy="%d"/><wp:docPr id="%d" name="%s" descr="%s"/>`+
+		`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:pic><pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`,
+		cx, cy, id, name, docxText(alt), id, name, ref.RelID, cx, cy)
+}
+
+// docxCommentRef ставит ссылку на замечание в конце его диапазона
+func docxCommentRef(b *strings.Builder, id int) {
+	fmt.Fprintf(b, `<w:commentRangeEnd w:id="%d"/><w:r><w:rPr><w:rStyle w:val="CommentReference"/></w:rPr><w:commentReference w:id="%d"/></w:r>`, id, id)
+}
+
+const docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Times New Roman" w:hAnsi="Times New Roman" w:eastAsia="Times New Roman" w:cs="Times New Roman"/><w:sz w:val="24"/><w:szCs w:val="24"/><w:lang w:val="ru-RU"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>
+<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:spacing w:after="240"/></w:pPr><w:rPr><w:b/><w:sz w:val="40"/><w:szCs w:val="40"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="32"/><w:szCs w:val="32"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:keepNext/><w:spacing w:before="200"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="28"/><w:szCs w:val="28"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:keepNext/><w:spacing w:before="160"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:i/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:qFormat/><w:pPr><w:spacing w:after="60"/><w:ind w:left="720"/><w:contextualSpacing/></w:pPr></w:style>
+<w:style w:type="paragraph" w:styleId="CommentText"><w:name w:val="annotation text"/><w:basedOn w:val="Normal"/><w:rPr><w:sz w:val="20"/><w:szCs w:val="20"/></w:rPr></w:style>
+<w:style w:type="character" w:styleId="CommentReference"><w:name w:val="annotation reference"/><w:rPr><w:sz w:val="16"/><w:szCs w:val="16"/></w:rPr></w:style>
+</w:styles>`
+
+// docxNumbering описывает маркированный список (numId 1) и нумерованные списки,
+// каждый из которых начинается с единицы (numId 2 и далее)
+func docxNumbering(numberedLists int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:abstractNum w:abstractNumId="0"><w:multiLevelType w:val="singleLevel"/><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="•"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="720" w:hanging="360"/></w:pPr></w:lvl></w:abstractNum>
+<w:abstractNum w:abstractNumId="1"><w:multiLevelType w:val="singleLevel"/><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="decimal"/><w:lvlText w:val="%1."/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="720" w:hanging="360"/></w:pPr></w:lvl></w:abstractNum>
+<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>
+`)
+	for i := 0; i < numberedLists; i++ {
+		fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="1"/><w:lvlOverride w:ilvl="0"><w:startOverride w:val="1"/></w:lvlOverride></w:num>`+"\n", i+2)
+	}
+	b.WriteString("</w:numbering>")
+	return b.String()
+}
+
+// docxInitials возвращает инициалы для подписи замечания
+func docxInitials(name string) string {
+	var initials []rune
+	for _, word := range strings.Fields(name) {
+		initials = append(initials, []rune(word)[0])
+	}
+	return string(initials)
+}
+
+// WriteDOCX записывает публикацию как документ Word. Замечания редакции прикрепляются
+// к тексту целиком: от названия до последнего абзаца.
+func WriteDOCX(out io.Writer, doc DocxDocument) error {
+	var body strings.Builder
+	var refs []docxImageRef
+	refByName := map[string]int{}
+	drawingID := 0
+	numberedLists := 0
+	prevKind := ""
+
+	body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr>`)
+	for i := range doc.Comments {
+		fmt.Fprintf(&body, `<w:commentRangeStart w:id="%d"/>`, i)
+	}
+	docxRuns(&body, []markupRun{{Text: doc.Title}})
+	body.WriteString("</w:p>")
+
+	for _, block := range parseMarkup(doc.Content) {
+		switch block.Kind {
+		case markupImage:
+			img, ok := doc.Images[block.Image]
+			if !ok {
+				body.WriteString("<w:p>")
+				docxRuns(&body, []markupRun{{Text: "[изображение " + block.Image + " не найдено]", Italic: true}})
+				body.WriteString("</w:p>")
+				break
+			}
+			i, ok := refByName[block.Image]
+			if !ok {
+				ext := docxImageExts[img.ContentType]
+				if ext == "" {
+					ext = "png"
+				}
+				i = len(refs)
+				refByName[block.Image] = i
+				refs = append(refs, docxImageRef{
+					RelID: fmt.Sprintf("rIdImage%d", i+1),
+					Path:  fmt.Sprintf("media/image%d.%s", i+1, ext),
+					Image: img,
+				})
+			}
+			drawingID++
+			body.WriteString(`<w:p><w:pPr><w:jc w:val="center"/></w:pPr>`)
+			docxDrawing(&body, drawingID, refs[i], block.Alt)
+			body.WriteString("</w:p>")
+		case markupHeading:
+			fmt.Fprintf(&body, `<w:p><w:pPr><w:pStyle w:val="Heading%d"/></w:pPr>`, block.Level)
+			docxRuns(&body, block.Runs)
+			body.WriteString("</w:p>")
+		case markupBullet, markupNumber:
+			numID := 1
+			if block.Kind == markupNumber {
+				if prevKind != markupNumber {
+					numberedLists++
+				}
+				numID = numberedLists + 1
+			}
+			fmt.Fprintf(&body, `<w:p><w:pPr><w:pStyle w:val="ListParagraph"/><w:numPr><w:ilvl w:val="0"/><w:numId w:val="%d"/></w:numPr></w:pPr>`, numID)
+			docxRuns(&body, block.Runs)
+			body.WriteString("</w:p>")
+		default:
+			body.WriteString("<w:p>")
+			docxRuns(&body, block.Runs)
+			body.WriteString("</w:p>")
+		}
+		prevKind = block.Kind
+	}
+
+	if len(doc.Comments) > 0 {
+		body.WriteString("<w:p>")
+		for i := range doc.Comments {
+			docxCommentRef(&body, i)
+		}
+		body.WriteString("</w:p>")
+	}
+
+	var comments strings.Builder
+	comments.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<w:comments xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`)
+	for i, c := range doc.Comments {
+		fmt.Fprintf(&comments, `<w:comment w:id="%d" w:author="%s" w:date="%s" w:initials="%s">`,
+			i, docxText(c.Author), c.Date.UTC().Format("2006-01-02T15:04:05Z"), docxText(docxInitials(c.Author)))
+		for _, line := range strings.Split(strings.ReplaceAll(c.Text, "\r\n", "\n"), "\n") {
+			comments.WriteString(`<w:p><w:pPr><w:pStyle w:val="CommentText"/></w:pPr>`)
+			docxRuns(&comments, []markupRun{{Text: line}})
+			comments.WriteString("</w:p>")
+		}
+		comments.WriteString("</w:comment>")
+	}
+	comments.WriteString("</w:comments>")
+
+	var rels strings.Builder
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+<Relationship Id="rIdNumbering" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>
+<Relationship Id="rIdComments" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/comments" Target="comments.xml"/>
+`)
+	for _, ref := range refs {
+		fmt.Fprintf(&rels, `<Relationship Id="%s" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="%s"/>`+"\n", ref.RelID, ref.Path)
+	}
+	rels.WriteString("</Relationships>")
+
+	document := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture">
+<w:body>` + body.String() + `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1134" w:right="850" w:bottom="1134" w:left="1701" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr></w:body>
+</w:document>`
+
+	core := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<dc:title>%s</dc:title><dc:creator>%s</dc:creator><dc:language>ru-RU</dc:language>
+<dcterms:created xsi:type="dcterms:W3CDTF">%s</dcterms:created><dcterms:modified xsi:type="dcterms:W3CDTF">%s</dcterms:modified>
+</cp:coreProperties>`, docxText(doc.Title), docxText(doc.Author),
+		doc.Created.UTC().Format("2006-01-02T15:04:05Z"), doc.Modified.UTC().Format("2006-01-02T15:04:05Z"))
+
+	z := zip.NewWriter(out)
+	write := func(name, content string) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, content)
+		return err
+	}
+	entries := []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Default Extension="png" ContentType="image/png"/>
+<Default Extension="jpeg" ContentType="image/jpeg"/>
+<Default Extension="gif" ContentType="image/gif"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>
+<Override PartName="/word/comments.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.comments+xml"/>
+<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>
+</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>
+</Relationships>`},
+		{"docProps/core.xml", core},
+		{"word/document.xml", document},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", docxNumbering(numberedLists)},
+		{"word/comments.xml", comments.String()},
+		{"word/_rels/document.xml.rels", rels.String()},
+	}
+	for _, e := range entries {
+		if err := write(e.name, e.content); err != nil {
+			return err
+		}
+	}
+	for _, ref := range refs {
+		f, err := z.Create("word/" + ref.Path)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(ref.Image.Data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// loadDocxDocument собирает текущую версию публикации или одну из сохранённых версий
+// вместе с замечаниями, сделанными к этому моменту
+func loadDocxDocument(publicationID, revisionID int) (DocxDocument, error) {
+	var doc DocxDocument
+	var updatedAt sql.NullTime
+	err := Db.QueryRow(`SELECT p.title, p.content, p.created_at, p.updated_at, COALESCE(NULLIF(pr.full_name, ''), u.login, '')
+                        FROM publications p
+                        LEFT JOIN users u ON u.id = p.author_id
+                        LEFT JOIN user_profiles pr ON pr.user_id = p.author_id
+                        WHERE p.id = $1 AND p.deleted_at IS NULL`, publicationID).
+		Scan(&doc.Title, &doc.Content, &doc.Created, &updatedAt, &doc.Author)
+	if err != nil {
+		return doc, err
+	}
+	doc.Modified = doc.Created
+	if updatedAt.Valid {
+		doc.Modified = updatedAt.Time
+	}
+
+	if revisionID > 0 {
+		err := Db.QueryRow(`SELECT title, content, created_at FROM publication_revisions WHERE id = $1 AND publication_id = $2`,
+			revisionID, publicationID).Scan(&doc.Title, &doc.Content, &doc.Modified)
+		if err != nil {
+			return doc, err
+		}
+	}
+
+	comments, err := GetPublicationComments(publicationID, revisionID)
+	if err != nil {
+		return doc, err
+	}
+	for _, c := range comments {
+		doc.Comments = append(doc.Comments, DocxComment{Author: c.AuthorName, Date: c.CreatedAt, Text: c.Body})
+	}
+	doc.Images, err = GetPublicationImages(publicationID)
+	return doc, err
+}
+
+// Создание черновика из документа Word по назначенной теме
+func ImportDocxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxDocxUpload+1<<20)
+	if err := r.ParseMultipartForm(maxDocxUpload); err != nil {
+		http.Error(w, "Файл слишком большой или форма повреждена", http.StatusBadRequest)
+		return
+	}
+
+	// Автор черновика — пользователь сессии
+	authorID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return
+	}
+	topicID, err := strconv.Atoi(r.FormValue("topic_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор темы", http.StatusBadRequest)
+		return
+	}
+	if !checkAuthorTopic(w, topicID, authorID) {
+		return
+	}
+
+	file, header, err := r.FormFile("docx")
+	if err != nil {
+		http.Error(w, "Выберите файл .docx", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxDocxUpload+1))
+	if err != nil {
+		http.Error(w, "Ошибка чтения файла", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxDocxUpload {
+		http.Error(w, "Файл слишком большой", http.StatusBadRequest)
+		return
+	}
+
+	imported, err := ParseDOCX(data)
+	if err != nil {
+		http.Error(w, "Не удалось прочитать документ: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(imported.Content) == "" {
+		http.Error(w, "Документ не содержит текста", http.StatusBadRequest)
+		return
+	}
+
+	// Название из формы важнее названия в документе; в крайнем случае берём имя файла
+	title := strings.TrimSpace(r.FormValue("title"))
+	if title == "" {
+		title = imported.Title
+	}
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(header.Filename), path.Ext(header.Filename))
+	}
+
+	pubID, err := createPublication(r, title, imported.Content, topicID, authorID, imported.Images)
+	if err != nil {
+		http.Error(w, "Ошибка при создании публикации: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	Logger(r.Context()).Info("Публикация импортирована из DOCX", "publication_id", pubID, "file", header.Filename, "images", len(imported.Images))
+
+	http.Redirect(w, r, "/author_page?id="+strconv.Itoa(authorID), http.StatusSeeOther)
+}
+
+// Выгрузка публикации или её версии в Word вместе с замечаниями редакции
+func PublicationDocxHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return
+	}
+	publicationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор публикации", http.StatusBadRequest)
+		return
+	}
+	revisionID := 0
+	if s := r.URL.Query().Get("revision_id"); s != "" {
+		if revisionID, err = strconv.Atoi(s); err != nil || revisionID <= 0 {
+			http.Error(w, "Неверный идентификатор версии", http.StatusBadRequest)
+			return
+		}
+	}
+	allowed, err := canManageContributors(r.Context(), publicationID, userID)
+	if err != nil {
+		http.Error(w, "Ошибка проверки прав", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Недостаточно прав для доступа к публикации", http.StatusForbidden)
+		return
+	}
+
+	doc, err := loadDocxDocument(publicationID, revisionID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Публикация или версия не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении публикации: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDOCX(&buf, doc); err != nil {
+		Logger(r.Context()).Error("Ошибка формирования DOCX", "publication_id", publicationID, "error", err)
+		http.Error(w, "Ошибка при формировании документа", http.StatusInternalServerError)
+		return
+	}
+	filename := fmt.Sprintf("publication-%d.docx", publicationID)
+	if revisionID > 0 {
+		filename = fmt.Sprintf("publication-%d-rev-%d.docx", publicationID, revisionID)
+	}
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.wordprocessingml.document")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	buf.WriteTo(w)
+}
+
+// Изображение публикации: опубликованные доступны всем, черновики — участникам и редакторам
+func PublicationImageHandler(w http.ResponseWriter, r *http.Request) {
+	publicationID, err := strconv.Atoi(r.URL.Query().Get("publication_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор публикации", http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Query().Get("name")
+
+	var img PublicationImage
+	var published bool
+	err = Db.QueryRow(`SELECT i.content_type, i.data, p.is_published
+                       FROM publication_images i JOIN publications p ON p.id = i.publication_id
+                       WHERE i.publication_id = $1 AND i.name = $2 AND p.deleted_at IS NULL`, publicationID, name).
+		Scan(&img.ContentType, &img.Data, &published)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении изображения", http.StatusInternalServerError)
+		return
+	}
+
+	if !published {
+		userID, ok := CurrentUserID(r)
+		if !ok {
+			http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+			return
+		}
+		allowed, err := canManageContributors(r.Context(), publicationID, userID)
+		if err != nil {
+			http.Error(w, "Ошибка проверки прав", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Недостаточно прав для доступа к публикации", http.StatusForbidden)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(img.Data)
+}
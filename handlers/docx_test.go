@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testDocx собирает документ Word из абзацев с картинками: по одной картинке на абзац
+func testDocx(t *testing.T, images int, imageSize int) []byte {
+	t.Helper()
+	var body, rels strings.Builder
+	for i := 1; i <= images; i++ {
+		fmt.Fprintf(&body, `<w:p><w:r><w:t>Абзац %d</w:t></w:r><w:r><w:drawing><a:blip r:embed="rId%d"/></w:drawing></w:r></w:p>`, i, i)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Target="media/image%d.png"/>`, i, i)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("word/document.xml", []byte(`<w:document xmlns:w="`+docxNSWord+`" xmlns:a="`+docxNSDrawing+`" xmlns:r="`+docxNSRels+`"><w:body>`+body.String()+`</w:body></w:document>`))
+	add("word/_rels/document.xml.rels", []byte(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+rels.String()+`</Relationships>`))
+	// Картинки из нулей после заголовка PNG почти не занимают места в архиве
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, imageSize)...)
+	for i := 1; i <= images; i++ {
+		add(fmt.Sprintf("word/media/image%d.png", i), png)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseDOCXImages(t *testing.T) {
+	imported, err := ParseDOCX(testDocx(t, 2, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Images) != 2 || imported.Images[1].Name != "image2.png" {
+		t.Fatalf("изображения: %+v", imported.Images)
+	}
+	if !strings.Contains(imported.Content, "image:image1.png") {
+		t.Errorf("в тексте нет ссылки на картинку: %q", imported.Content)
+	}
+}
+
+func TestParseDOCXLimits(t *testing.T) {
+	if _, err := ParseDOCX(testDocx(t, maxDocxImages+1, 16)); !errors.Is(err, ErrDocxManyImages) {
+		t.Errorf("%d картинок: ошибка %v, ожидалась %v", maxDocxImages+1, err, ErrDocxManyImages)
+	}
+
+	// Каждая часть меньше предела на одну часть, но вместе они превышают общий бюджет
+	data := testDocx(t, maxDocxUnpacked/maxDocxPartSize+1, maxDocxPartSize-1<<20)
+	if len(data) > maxDocxUpload {
+		t.Fatalf("архив %d байт больше допустимой загрузки", len(data))
+	}
+	if _, err := ParseDOCX(data); !errors.Is(err, ErrDocxTooLarge) {
+		t.Errorf("ошибка %v, ожидалась %v", err, ErrDocxTooLarge)
+	}
+}
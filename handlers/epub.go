@@ -0,0 +1,658 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Расширения файлов изображений в EPUB по MIME-типу аватара
+var epubImageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+const epubMimetype = "application/epub+zip"
+
+// Стили читалки по умолчанию дополняются только самым необходимым
+const epubStylesheet = `body { font-family: serif; line-height: 1.4; }
+h1 { font-size: 1.6em; margin-bottom: 0.3em; }
+h2 { font-size: 1.3em; }
+p { margin: 0 0 0.6em 0; text-align: justify; }
+.meta { color: #666; font-size: 0.9em; }
+.byline { margin: 0.8em 0; }
+.byline img { width: 2.5em; height: 2.5em; vertical-align: middle; margin-right: 0.5em; }
+.retracted { font-weight: bold; border: 1px solid #999; padding: 0.5em; }
+.title-page { text-align: center; margin-top: 30%; }
+figure { margin: 1em 0; text-align: center; }
+figure img { max-width: 100%; }
+figcaption { color: #666; font-size: 0.9em; }
+`
+
+// epubSection — раздел книги; у сборника автора один раздел без названия
+type epubSection struct {
+	Title    string
+	Articles []exportArticle
+}
+
+// epubBook — содержимое и метаданные книги EPUB
+type epubBook struct {
+	Identifier string
+	Title      string
+	Subtitle   string
+	Creators   []string
+	Date       time.Time
+	Modified   time.Time
+	Sections   []epubSection
+}
+
+// xmlEscape экранирует текст для XML; недопустимые в XML символы заменяются
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// epubPage оборачивает тело страницы в документ XHTML
+func epubPage(title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="ru" lang="ru">
+<head>
+<meta charset="UTF-8"/>
+<title>` + xmlEscape(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `</body>
+</html>
+`
+}
+
+// epubArticlePage верстает статью; avatars сопоставляет участнику файл его аватара,
+// pictures — имени изображения из текста его файл в пакете
+func epubArticlePage(a exportArticle, avatars map[int]string, pictures map[string]string) string {
+	var b strings.Builder
+	b.WriteString(`<section epub:type="chapter">` + "\n")
+	b.WriteString("<h1>" + xmlEscape(a.Title) + "</h1>\n")
+
+	var meta []string
+	if a.Department != "" {
+		meta = append(meta, xmlEscape(a.Department))
+	}
+	if a.PublishedAt.Valid {
+		meta = append(meta, `<time datetime="`+a.PublishedAt.Time.Format("2006-01-02")+`">`+a.PublishedAt.Time.Format("02.01.2006")+"</time>")
+	}
+	if len(meta) > 0 {
+		b.WriteString(`<p class="meta">` + strings.Join(meta, " · ") + "</p>\n")
+	}
+	if a.Withdrawn {
+		b.WriteString(`<p class="retracted">Статья снята с публикации. ` + xmlEscape(a.UnpublishReason) + "</p>\n</section>\n")
+		return epubPage(a.Title, b.String())
+	}
+
+	for _, c := range a.Byline() {
+		b.WriteString(`<p class="byline">`)
+		if file, ok := avatars[c.UserID]; ok {
+			b.WriteString(`<img src="` + file + `" alt="` + xmlEscape(c.Name) + `"/>`)
+		}
+		b.WriteString("<strong>" + xmlEscape(c.Name) + "</strong>, " + xmlEscape(ContributorRoleLabels[c.Role]) + "</p>\n")
+	}
+
+	if a.IsRetracted {
+		b.WriteString(`<p class="retracted">Статья отозвана редакцией. ` + xmlEscape(a.RetractionReason) + "</p>\n")
+	}
+	b.WriteString(markupHTML(a.Content, func(name string) (string, bool) {
+		file, ok := pictures[name]
+		return file, ok
+	}))
+	b.WriteString("</section>\n")
+	return epubPage(a.Title, b.String())
+}
+
+// epubArticleFile — имя файла статьи внутри пакета
+func epubArticleFile(a exportArticle) string {
+	return "article-" + strconv.Itoa(a.ID) + ".xhtml"
+}
+
+// WriteEPUB собирает книгу в формате EPUB 3
+func WriteEPUB(w io.Writer, book epubBook) error {
+	z := zip.NewWriter(w)
+
+	// mimetype — первый файл архива, без сжатия и без дополнительных полей
+	mimetype := []byte(epubMimetype)
+	header := &zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	}
+	f, err := z.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(mimetype); err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		"META-INF/container.xml": []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`),
+		"OEBPS/style.css": []byte(epubStylesheet),
+	}
+
+	type manifestItem struct {
+		id, href, mediaType, properties string
+	}
+	manifest := []manifestItem{
+		{"style", "style.css", "text/css", ""},
+		{"nav", "nav.xhtml", "application/xhtml+xml", "nav"},
+		{"title", "title.xhtml", "application/xhtml+xml", ""},
+	}
+	spine := []string{"title", "nav"}
+
+	// Титульная страница
+	var title strings.Builder
+	title.WriteString(`<section class="title-page" epub:type="titlepage">` + "\n")
+	title.WriteString("<h1>" + xmlEscape(book.Title) + "</h1>\n")
+	if book.Subtitle != "" {
+		title.WriteString("<p>" + xmlEscape(book.Subtitle) + "</p>\n")
+	}
+	if !book.Date.IsZero() {
+		title.WriteString(`<p class="meta">` + book.Date.Format("02.01.2006") + "</p>\n")
+	}
+	title.WriteString("</section>\n")
+	files["OEBPS/title.xhtml"] = []byte(epubPage(book.Title, title.String()))
+
+	// Аватары участников: каждый файл добавляется один раз
+	images := map[int]string{}
+	for _, s := range book.Sections {
+		for _, a := range s.Articles {
+			for _, c := range a.Byline() {
+				userID := c.UserID
+				avatar, hasAvatar := a.Avatars[userID]
+				ext, ok := epubImageExtensions[avatar.Type]
+				if _, added := images[userID]; added || !hasAvatar || !ok {
+					continue
+				}
+				href := "images/avatar-" + strconv.Itoa(userID) + "." + ext
+				images[userID] = href
+				files["OEBPS/"+href] = avatar.Data
+				manifest = append(manifest, manifestItem{"avatar-" + strconv.Itoa(userID), href, avatar.Type, ""})
+			}
+		}
+	}
+
+	// Изображения из текста статей; форматы, которых нет в EPUB, пропускаются
+	pictures := map[int]map[string]string{}
+	for _, s := range book.Sections {
+		for _, a := range s.Articles {
+			if _, added := pictures[a.ID]; added {
+				continue
+			}
+			pictures[a.ID] = map[string]string{}
+			n := 0
+			for _, block := range parseMarkup(a.Content) {
+				img, ok := a.Images[block.Image]
+				ext, supported := epubImageExtensions[img.ContentType]
+				if block.Kind != markupImage || !ok || !supported || pictures[a.ID][block.Image] != "" {
+					continue
+				}
+				n++
+				id := "image-" + strconv.Itoa(a.ID) + "-" + strconv.Itoa(n)
+				href := "images/" + id + "." + ext
+				pictures[a.ID][block.Image] = href
+				files["OEBPS/"+href] = img.Data
+				manifest = append(manifest, manifestItem{id, href, img.ContentType, ""})
+			}
+		}
+	}
+
+	// Статьи и оглавление
+	var nav strings.Builder
+	nav.WriteString(`<nav epub:type="toc" id="toc">` + "\n<h1>Содержание</h1>\n<ol>\n")
+	for _, s := range book.Sections {
+		if s.Title != "" {
+			nav.WriteString("<li><span>" + xmlEscape(s.Title) + "</span>\n<ol>\n")
+		}
+		for _, a := range s.Articles {
+			file := epubArticleFile(a)
+			files["OEBPS/"+file] = []byte(epubArticlePage(a, images, pictures[a.ID]))
+			id := "article-" + strconv.Itoa(a.ID)
+			manifest = append(manifest, manifestItem{id, file, "application/xhtml+xml", ""})
+			spine = append(spine, id)
+			nav.WriteString(`<li><a href="` + file + `">` + xmlEscape(a.Title) + "</a></li>\n")
+		}
+		if s.Title != "" {
+			nav.WriteString("</ol>\n</li>\n")
+		}
+	}
+	nav.WriteString("</ol>\n</nav>\n")
+	files["OEBPS/nav.xhtml"] = []byte(epubPage("Содержание", nav.String()))
+
+	// Пакетный документ: метаданные, список файлов и порядок чтения
+	var opf strings.Builder
+	opf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="ru">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	opf.WriteString(`<dc:identifier id="book-id">` + xmlEscape(book.Identifier) + "</dc:identifier>\n")
+	opf.WriteString("<dc:title>" + xmlEscape(book.Title) + "</dc:title>\n")
+	opf.WriteString("<dc:language>ru</dc:language>\n")
+	for i, creator := range book.Creators {
+		opf.WriteString(fmt.Sprintf(`<dc:creator id="creator-%d">%s</dc:creator>`+"\n", i+1, xmlEscape(creator)))
+	}
+	if !book.Date.IsZero() {
+		opf.WriteString("<dc:date>" + book.Date.Format("2006-01-02") + "</dc:date>\n")
+	}
+	opf.WriteString(`<meta property="dcterms:modified">` + book.Modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	opf.WriteString("</metadata>\n<manifest>\n")
+	for _, item := range manifest {
+		properties := ""
+		if item.properties != "" {
+			properties = ` properties="` + item.properties + `"`
+		}
+		opf.WriteString(fmt.Sprintf(`<item id="%s" href="%s" media-type="%s"%s/>`+"\n", item.id, item.href, item.mediaType, properties))
+	}
+	opf.WriteString("</manifest>\n<spine>\n")
+	for _, id := range spine {
+		opf.WriteString(`<itemref idref="` + id + `"/>` + "\n")
+	}
+	opf.WriteString("</spine>\n</package>\n")
+	files["OEBPS/content.opf"] = []byte(opf.String())
+
+	// Порядок файлов в архиве не важен, но пусть он будет одинаковым от раза к разу
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// Структура пакетного документа, которую проверяет ValidateEPUB
+type epubPackage struct {
+	Version          string `xml:"version,attr"`
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Identifiers []struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+		Titles    []string `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Languages []string `xml:"http://purl.org/dc/elements/1.1/ language"`
+		Meta      []struct {
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Items []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Itemrefs []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// ValidateEPUB проверяет структуру пакета по требованиям EPUB 3 (OCF и пакетный документ):
+// mimetype первым файлом без сжатия, container.xml, обязательные метаданные, наличие
+// всех файлов манифеста, единственный документ навигации, ссылки spine и корректность XHTML
+func ValidateEPUB(data []byte) error {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if len(z.File) == 0 || z.File[0].Name != "mimetype" {
+		return errors.New("первым файлом архива должен быть mimetype")
+	}
+	first := z.File[0]
+	if first.Method != zip.Store || len(first.Extra) > 0 {
+		return errors.New("mimetype должен храниться без сжатия и дополнительных полей")
+	}
+	content, err := readZipFile(first)
+	if err != nil {
+		return err
+	}
+	if string(content) != epubMimetype {
+		return fmt.Errorf("неверное содержимое mimetype: %q", content)
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	// container.xml указывает на пакетный документ
+	var container struct {
+		Rootfiles []struct {
+			FullPath  string `xml:"full-path,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := unmarshalZipXML(files, "META-INF/container.xml", &container); err != nil {
+		return err
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].MediaType != "application/oebps-package+xml" {
+		return errors.New("в container.xml нет пакетного документа")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg epubPackage
+	if err := unmarshalZipXML(files, opfPath, &pkg); err != nil {
+		return err
+	}
+	if pkg.Version != "3.0" {
+		return fmt.Errorf("неверная версия пакета: %q", pkg.Version)
+	}
+	identified := false
+	for _, id := range pkg.Metadata.Identifiers {
+		if id.ID == pkg.UniqueIdentifier && strings.TrimSpace(id.Value) != "" {
+			identified = true
+		}
+	}
+	if !identified {
+		return errors.New("нет идентификатора, указанного в unique-identifier")
+	}
+	if len(pkg.Metadata.Titles) == 0 || len(pkg.Metadata.Languages) == 0 {
+		return errors.New("в метаданных нет названия или языка")
+	}
+	modified := false
+	for _, m := range pkg.Metadata.Meta {
+		if m.Property == "dcterms:modified" {
+			if _, err := time.Parse("2006-01-02T15:04:05Z", m.Value); err != nil {
+				return fmt.Errorf("неверная дата dcterms:modified: %q", m.Value)
+			}
+			modified = true
+		}
+	}
+	if !modified {
+		return errors.New("в метаданных нет dcterms:modified")
+	}
+
+	base := path.Dir(opfPath)
+	items := map[string]bool{}
+	navs := 0
+	for _, item := range pkg.Items {
+		if items[item.ID] {
+			return fmt.Errorf("повторяющийся идентификатор в манифесте: %s", item.ID)
+		}
+		items[item.ID] = true
+		name := path.Join(base, item.Href)
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("файла %s из манифеста нет в архиве", name)
+		}
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			navs++
+		}
+		if item.MediaType == "application/xhtml+xml" {
+			if err := checkZipXML(f); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	if navs != 1 {
+		return fmt.Errorf("документов навигации в манифесте: %d, должен быть один", navs)
+	}
+	if len(pkg.Itemrefs) == 0 {
+		return errors.New("пустой spine")
+	}
+	for _, ref := range pkg.Itemrefs {
+		if !items[ref.IDRef] {
+			return fmt.Errorf("spine ссылается на отсутствующий элемент %s", ref.IDRef)
+		}
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func unmarshalZipXML(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("в архиве нет %s", name)
+	}
+	data, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// checkZipXML проверяет, что файл — правильно построенный XML
+func checkZipXML(f *zip.File) error {
+	data, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeEPUB собирает книгу, проверяет её структуру и отправляет
+func writeEPUB(w http.ResponseWriter, filename string, book epubBook) error {
+	var buf bytes.Buffer
+	if err := WriteEPUB(&buf, book); err != nil {
+		return err
+	}
+	if err := ValidateEPUB(buf.Bytes()); err != nil {
+		return fmt.Errorf("EPUB не прошёл проверку: %v", err)
+	}
+	w.Header().Set("Content-Type", epubMimetype)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// epubCreators собирает авторов статей без повторов в порядке появления
+func epubCreators(sections []epubSection) []string {
+	seen := map[string]bool{}
+	var creators []string
+	for _, s := range sections {
+		for _, a := range s.Articles {
+			for _, c := range a.Byline() {
+				if c.Role == ContributorAuthor && !seen[c.Name] {
+					seen[c.Name] = true
+					creators = append(creators, c.Name)
+				}
+			}
+		}
+	}
+	return creators
+}
+
+// epubModified возвращает время последнего изменения статей книги
+func epubModified(sections []epubSection) time.Time {
+	var modified time.Time
+	for _, s := range sections {
+		for _, a := range s.Articles {
+			if a.UpdatedAt.After(modified) {
+				modified = a.UpdatedAt
+			}
+		}
+	}
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	return modified
+}
+
+// issueEPUBBook описывает книгу выпуска по его разделам
+func issueEPUBBook(issue Issue, sections []epubSection) epubBook {
+	return epubBook{
+		Identifier: "urn:myproject:issue:" + strconv.Itoa(issue.ID),
+		Title:      fmt.Sprintf("Выпуск №%d", issue.Number),
+		Subtitle:   issue.Theme,
+		Date:       issue.Date,
+		Creators:   epubCreators(sections),
+		Modified:   epubModified(sections),
+		Sections:   sections,
+	}
+}
+
+// EPUB выпуска. Вышедший выпуск доступен всем, готовящийся — только редакторам.
+func IssueEPUBHandler(w http.ResponseWriter, r *http.Request) {
+	issueID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор выпуска", http.StatusBadRequest)
+		return
+	}
+	issue, sections, err := GetIssue(issueID)
+	if err == ErrIssueNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении выпуска", http.StatusInternalServerError)
+		return
+	}
+	if issue.Status != IssueReleased {
+		userID, ok := CurrentUserID(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		isEditor, err := CheckEditor(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Ошибка при проверке роли пользователя", http.StatusInternalServerError)
+			return
+		}
+		if !isEditor {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// Удалённые статьи пропускаются, а снятые после выхода выпуска только упоминаются — как на сайте
+	var bookSections []epubSection
+	for _, s := range sections {
+		section := epubSection{Title: s.Department}
+		for _, p := range s.Publications {
+			a, err := loadExportArticle(p.PublicationID)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				http.Error(w, "Ошибка при получении публикации", http.StatusInternalServerError)
+				return
+			}
+			if issue.Status == IssueReleased && !a.IsPublished {
+				a.withdraw()
+			}
+			section.Articles = append(section.Articles, a)
+		}
+		if len(section.Articles) > 0 {
+			bookSections = append(bookSections, section)
+		}
+	}
+	book := issueEPUBBook(issue, bookSections)
+
+	if err := writeEPUB(w, fmt.Sprintf("issue-%d.epub", issue.Number), book); err != nil {
+		Logger(r.Context()).Error("Ошибка формирования EPUB выпуска", "issue_id", issueID, "error", err)
+		http.Error(w, "Ошибка при формировании EPUB", http.StatusInternalServerError)
+	}
+}
+
+// EPUB-сборник опубликованных статей автора в порядке выхода
+func AuthorEPUBHandler(w http.ResponseWriter, r *http.Request) {
+	authorID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор автора", http.StatusBadRequest)
+		return
+	}
+	profile, err := GetProfile(authorID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении профиля автора", http.StatusInternalServerError)
+		return
+	}
+	publications, err := GetPublishedArticlesByAuthor(authorID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении публикаций автора", http.StatusInternalServerError)
+		return
+	}
+	if len(publications) == 0 {
+		http.Error(w, "У автора пока нет опубликованных статей", http.StatusNotFound)
+		return
+	}
+
+	var section epubSection
+	var date time.Time
+	for i := len(publications) - 1; i >= 0; i-- {
+		a, err := loadExportArticle(publications[i].ID)
+		if err != nil {
+			http.Error(w, "Ошибка при получении публикации", http.StatusInternalServerError)
+			return
+		}
+		if a.PublishedAt.Valid && a.PublishedAt.Time.After(date) {
+			date = a.PublishedAt.Time
+		}
+		section.Articles = append(section.Articles, a)
+	}
+
+	book := epubBook{
+		Identifier: "urn:myproject:author:" + strconv.Itoa(authorID),
+		Title:      profile.DisplayName() + ": публикации",
+		Creators:   []string{profile.DisplayName()},
+		Date:       date,
+		Sections:   []epubSection{section},
+	}
+	book.Modified = epubModified(book.Sections)
+
+	if err := writeEPUB(w, fmt.Sprintf("author-%d.epub", authorID), book); err != nil {
+		Logger(r.Context()).Error("Ошибка формирования EPUB автора", "author_id", authorID, "error", err)
+		http.Error(w, "Ошибка при формировании EPUB", http.StatusInternalServerError)
+	}
+}
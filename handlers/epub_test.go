@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"io"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssueBook собирает книгу выпуска из двух разделов без обращения к базе
+func testIssueBook() epubBook {
+	published := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	article := func(id int, title, content string) exportArticle {
+		a := exportArticle{Publication: Publication{
+			ID:         id,
+			Title:      title,
+			Content:    content,
+			AuthorID:   id * 10,
+			AuthorName: "Автор " + title,
+			Department: "Политика",
+			UpdatedAt:  published.Add(time.Duration(id) * time.Hour),
+		}}
+		a.PublishedAt = sql.NullTime{Time: published, Valid: true}
+		return a
+	}
+	withAvatar := article(2, "Интервью", "## Вопрос\n\n**Ответ**\n![Схема & план](image:scheme.png)\n![Нет файла](image:missing.png)")
+	withAvatar.Avatars = map[int]exportAvatar{20: {Data: []byte("GIF89a"), Type: "image/gif"}}
+	withAvatar.Images = map[string]PublicationImage{"scheme.png": {Name: "scheme.png", ContentType: "image/png", Data: []byte("\x89PNG")}}
+
+	issue := Issue{ID: 7, Number: 12, Date: published, Theme: "Выборы & <итоги>"}
+	return issueEPUBBook(issue, []epubSection{
+		{Title: "Политика", Articles: []exportArticle{article(1, "Первая <статья>", "Абзац 1\nАбзац & 2"), withAvatar}},
+		{Title: "Культура", Articles: []exportArticle{article(3, "Театр", "Премьера")}},
+	})
+}
+
+func TestIssueEPUBIsValid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEPUB(&buf, testIssueBook()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateEPUB(buf.Bytes()); err != nil {
+		t.Fatalf("ValidateEPUB: %v", err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mimetype — первая запись, без сжатия; проверяем по самим байтам архива
+	first := z.File[0]
+	if first.Name != "mimetype" || first.Method != zip.Store {
+		t.Fatalf("первая запись %q, метод %d", first.Name, first.Method)
+	}
+	if got := string(buf.Bytes()[30:38]); got != "mimetype" {
+		t.Errorf("в локальном заголовке первой записи имя %q", got)
+	}
+	if got := string(buf.Bytes()[38 : 38+len(epubMimetype)]); got != epubMimetype {
+		t.Errorf("mimetype не хранится открытым текстом сразу после заголовка: %q", got)
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	// container.xml указывает на пакетный документ, который есть в архиве
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := unmarshalZipXML(files, "META-INF/container.xml", &container); err != nil {
+		t.Fatal(err)
+	}
+	if len(container.Rootfiles) != 1 {
+		t.Fatalf("пакетных документов в container.xml: %d", len(container.Rootfiles))
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	var pkg epubPackage
+	if err := unmarshalZipXML(files, opfPath, &pkg); err != nil {
+		t.Fatal(err)
+	}
+	if got := pkg.Metadata.Titles; len(got) != 1 || got[0] != "Выпуск №12" {
+		t.Errorf("название книги %q", got)
+	}
+
+	// Каждый файл архива, кроме служебных, перечислен в манифесте, и наоборот
+	base := path.Dir(opfPath)
+	manifest := map[string]string{}
+	navHref := ""
+	for _, item := range pkg.Items {
+		manifest[path.Join(base, item.Href)] = item.ID
+		if item.Properties == "nav" {
+			navHref = path.Join(base, item.Href)
+		}
+	}
+	for name := range files {
+		if name == "mimetype" || name == opfPath || strings.HasPrefix(name, "META-INF/") {
+			continue
+		}
+		if _, ok := manifest[name]; !ok {
+			t.Errorf("файла %s нет в манифесте", name)
+		}
+	}
+	if _, ok := manifest[path.Join(base, "images/avatar-20.gif")]; !ok {
+		t.Error("аватар участника не попал в манифест")
+	}
+	if _, ok := manifest[path.Join(base, "images/image-2-1.png")]; !ok {
+		t.Error("изображение из текста не попало в манифест")
+	}
+	interview := string(readTestZipFile(t, files[path.Join(base, "article-2.xhtml")]))
+	for _, want := range []string{"<h3>Вопрос</h3>", "<strong>Ответ</strong>", `<img src="images/image-2-1.png" alt="Схема &amp; план"/>`} {
+		if !strings.Contains(interview, want) {
+			t.Errorf("в статье нет %s:\n%s", want, interview)
+		}
+	}
+	if strings.Contains(interview, "missing.png") || strings.Contains(interview, "**") {
+		t.Errorf("разметка осталась в тексте:\n%s", interview)
+	}
+
+	// Оглавление ссылается на статьи в порядке spine
+	nav := readTestZipFile(t, files[navHref])
+	var links []string
+	d := xml.NewDecoder(bytes.NewReader(nav))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "a" {
+			for _, attr := range el.Attr {
+				if attr.Name.Local == "href" {
+					links = append(links, path.Join(base, attr.Value))
+				}
+			}
+		}
+	}
+	var spineArticles []string
+	for _, ref := range pkg.Itemrefs {
+		if strings.HasPrefix(ref.IDRef, "article-") {
+			spineArticles = append(spineArticles, ref.IDRef)
+		}
+	}
+	if len(links) != 3 || len(spineArticles) != 3 {
+		t.Fatalf("ссылок в оглавлении %d, статей в spine %d, ожидалось по 3", len(links), len(spineArticles))
+	}
+	for i, link := range links {
+		if manifest[link] != spineArticles[i] {
+			t.Errorf("ссылка %d оглавления %s не совпадает с spine %s", i, link, spineArticles[i])
+		}
+	}
+
+	// Спецсимволы из названий и текста экранированы
+	page := string(readTestZipFile(t, files[path.Join(base, "article-1.xhtml")]))
+	if !strings.Contains(page, "Первая &lt;статья&gt;") || !strings.Contains(page, "Абзац &amp; 2") {
+		t.Errorf("спецсимволы не экранированы:\n%s", page)
+	}
+}
+
+func readTestZipFile(t *testing.T, f *zip.File) []byte {
+	t.Helper()
+	if f == nil {
+		t.Fatal("файла нет в архиве")
+	}
+	data, err := readZipFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rewriteEPUB пересобирает архив, позволяя изменить записи
+func rewriteEPUB(t *testing.T, data []byte, edit func(name string, content []byte) (string, []byte, uint16)) []byte {
+	t.Helper()
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range z.File {
+		name, content, method := edit(f.Name, readTestZipFile(t, f))
+		if name == "" {
+			continue
+		}
+		out, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidateEPUBRejectsBrokenPackages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEPUB(&buf, testIssueBook()); err != nil {
+		t.Fatal(err)
+	}
+	keep := func(name string, content []byte) (string, []byte, uint16) {
+		if name == "mimetype" {
+			return name, content, zip.Store
+		}
+		return name, content, zip.Deflate
+	}
+	if err := ValidateEPUB(rewriteEPUB(t, buf.Bytes(), keep)); err != nil {
+		t.Fatalf("пересобранный без изменений архив не прошёл проверку: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(name string, content []byte) (string, []byte, uint16)
+	}{
+		{"mimetype сжат", func(name string, content []byte) (string, []byte, uint16) {
+			return name, content, zip.Deflate
+		}},
+		{"нет статьи из манифеста", func(name string, content []byte) (string, []byte, uint16) {
+			if name == "OEBPS/article-3.xhtml" {
+				return "", nil, 0
+			}
+			return keep(name, content)
+		}},
+		{"container.xml ссылается не туда", func(name string, content []byte) (string, []byte, uint16) {
+			if name == "META-INF/container.xml" {
+				content = bytes.Replace(content, []byte("OEBPS/content.opf"), []byte("OEBPS/book.opf"), 1)
+			}
+			return keep(name, content)
+		}},
+		{"нет документа навигации", func(name string, content []byte) (string, []byte, uint16) {
+			if name == "OEBPS/content.opf" {
+				content = bytes.Replace(content, []byte(` properties="nav"`), nil, 1)
+			}
+			return keep(name, content)
+		}},
+		{"spine ссылается на неизвестный элемент", func(name string, content []byte) (string, []byte, uint16) {
+			if name == "OEBPS/content.opf" {
+				content = bytes.Replace(content, []byte(`<itemref idref="title"/>`), []byte(`<itemref idref="missing"/>`), 1)
+			}
+			return keep(name, content)
+		}},
+		{"статья не XML", func(name string, content []byte) (string, []byte, uint16) {
+			if name == "OEBPS/article-1.xhtml" {
+				content = append(content, []byte("<p>")...)
+			}
+			return keep(name, content)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateEPUB(rewriteEPUB(t, buf.Bytes(), tt.edit)); err == nil {
+				t.Error("ожидалась ошибка проверки")
+			}
+		})
+	}
+}
+
+func TestIssueEPUBWithdrawnArticle(t *testing.T) {
+	book := testIssueBook()
+	withdrawn := &book.Sections[0].Articles[1]
+	withdrawn.UnpublishReason = "Ошибка в цифрах"
+	withdrawn.withdraw()
+	book = issueEPUBBook(Issue{ID: 7, Number: 12}, book.Sections)
+
+	var buf bytes.Buffer
+	if err := WriteEPUB(&buf, book); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateEPUB(buf.Bytes()); err != nil {
+		t.Fatalf("ValidateEPUB: %v", err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range z.File {
+		if strings.HasPrefix(path.Base(f.Name), "image-2-") || strings.Contains(f.Name, "avatar-20") {
+			t.Errorf("в пакет попал файл снятой статьи: %s", f.Name)
+		}
+		if f.Name != "OEBPS/"+epubArticleFile(*withdrawn) {
+			continue
+		}
+		page := string(readTestZipFile(t, f))
+		if !strings.Contains(page, "Статья снята с публикации. Ошибка в цифрах") {
+			t.Errorf("нет пометки о снятии: %s", page)
+		}
+		if strings.Contains(page, "Ответ") || strings.Contains(page, "byline") {
+			t.Errorf("текст или подпись снятой статьи попали в пакет: %s", page)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Типы событий для живого обновления рабочих страниц
+const (
+	EventStatusChanged = "status_changed" // смена статуса публикации
+	EventSubmitted     = "submitted"      // новая публикация на проверку
+	EventComment       = "comment"        // замечания редактора или ответ автора
+	EventAssigned      = "assigned"       // назначение темы или участника публикации
+)
+
+// Сколько последних событий хранится для досылки после переподключения
+const eventBacklog = 200
+
+// Event — событие редакционного процесса
+type Event struct {
+	ID            int64     `json:"id"`
+	Type          string    `json:"type"`
+	PublicationID int       `json:"publication_id,omitempty"`
+	Title         string    `json:"title,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Message       string    `json:"message"`
+	ActorID       int       `json:"actor_id,omitempty"`
+	Time          time.Time `json:"time"`
+	UserIDs       []int     `json:"-"` // получатели помимо редакторов
+	Editors       bool      `json:"-"` // событие видят все редакторы
+}
+
+// visibleTo сообщает, должен ли пользователь получить событие
+func (e Event) visibleTo(userID int, editor bool) bool {
+	if e.Editors && editor {
+		return true
+	}
+	for _, id := range e.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription — подписка одного подключения на события пользователя
+type Subscription struct {
+	C      chan Event
+	userID int
+	editor bool
+}
+
+// PubSub раздаёт события подключённым к этому экземпляру пользователям.
+// События других экземпляров приходят сюда через шину Bus.
+type PubSub struct {
+	mu     sync.Mutex
+	nextID int64
+	subs   map[*Subscription]struct{}
+	recent []Event
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{subs: map[*Subscription]struct{}{}}
+}
+
+// Events — подписки на события рабочих страниц
+var Events = NewPubSub()
+
+// Subscribe подписывает пользователя на события. Если передан lastID, сразу
+// досылаются пропущенные события из недавней истории.
+func (ps *PubSub) Subscribe(userID int, editor bool, lastID int64) *Subscription {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	sub := &Subscription{C: make(chan Event, 64), userID: userID, editor: editor}
+	if lastID > 0 {
+		for _, e := range ps.recent {
+			if e.ID > lastID && e.visibleTo(userID, editor) {
+				select {
+				case sub.C <- e:
+				default:
+				}
+			}
+		}
+	}
+	ps.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe отменяет подписку
+func (ps *PubSub) Unsubscribe(sub *Subscription) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.subs, sub)
+}
+
+// DisconnectUser закрывает все подписки пользователя, например после принудительного выхода
+func (ps *PubSub) DisconnectUser(userID int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for sub := range ps.subs {
+		if sub.userID == userID {
+			delete(ps.subs, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Close закрывает все подписки при остановке сервера, чтобы потоки событий завершились.
+// Браузеры переподключатся сами, когда сервер снова будет доступен.
+func (ps *PubSub) Close() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for sub := range ps.subs {
+		delete(ps.subs, sub)
+		close(sub.C)
+	}
+}
+
+// Publish рассылает событие подписчикам. Медленный подписчик теряет событие,
+// а не задерживает остальных: страница всё равно может перезагрузить список.
+func (ps *PubSub) Publish(ctx context.Context, e Event) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	// Номер события задаёт шина; без него нумеруем сами
+	if e.ID > ps.nextID {
+		ps.nextID = e.ID
+	} else {
+		ps.nextID++
+		e.ID = ps.nextID
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	ps.recent = append(ps.recent, e)
+	if len(ps.recent) > eventBacklog {
+		ps.recent = append([]Event{}, ps.recent[len(ps.recent)-eventBacklog:]...)
+	}
+
+	for sub := range ps.subs {
+		if !e.visibleTo(sub.userID, sub.editor) {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			Logger(ctx).Warn("Подписчик не успевает получать события, событие пропущено", "user_id", sub.userID, "event_id", e.ID, "event_type", e.Type)
+		}
+	}
+}
+
+// publishPublicationEvent отправляет в шину событие о публикации для редакторов и её участников.
+// Ошибка чтения публикации не мешает основному действию и только записывается в журнал.
+func publishPublicationEvent(ctx context.Context, eventType string, publicationID, actorID int, message string) {
+	e := PublicationChanged{PublicationID: publicationID, Kind: eventType, ActorID: actorID, Message: message}
+
+	var userIDs pq.Int64Array
+	err := Db.QueryRow(`SELECT p.title, p.status,
+                               ARRAY(SELECT c.user_id FROM publication_contributors c WHERE c.publication_id = p.id)
+                        FROM publications p WHERE p.id = $1`, publicationID).
+		Scan(&e.Title, &e.Status, &userIDs)
+	if err != nil {
+		Logger(ctx).Error("Ошибка подготовки события", "publication_id", publicationID, "error", err)
+		return
+	}
+	for _, id := range userIDs {
+		e.UserIDs = append(e.UserIDs, int(id))
+	}
+	publishBusEvent(TopicPublication, e)
+}
+
+// Поток событий пользователя в формате Server-Sent Events
+func EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return
+	}
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении роли пользователя", http.StatusInternalServerError)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	// Браузер сам передаёт номер последнего полученного события при переподключении
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub := Events.Subscribe(userID, IsEditorRole(role), lastID)
+	defer Events.Unsubscribe(sub)
+
+	// Поток живёт дольше общего таймаута записи сервера, поэтому срок для него снимаем
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	// Комментарий раз в 25 секунд не даёт прокси закрыть простаивающее соединение
+	keepAlive := time.NewTicker(25 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-sub.C:
+			if !ok {
+				// Сессии пользователя завершены
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				Logger(r.Context()).Error("Ошибка кодирования события", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import "github.com/lib/pq"
+
+// exportAvatar — аватар участника для подписи в выгружаемых документах
+type exportAvatar struct {
+	Data []byte
+	Type string
+}
+
+// exportArticle — публикация со всем, что нужно для вёрстки в PDF и EPUB
+type exportArticle struct {
+	Publication
+	Contributors []Contributor
+	Avatars      map[int]exportAvatar
+	Images       map[string]PublicationImage // изображения из текста по именам
+	Withdrawn    bool                        // снята с публикации: в выгрузке остаётся только заголовок и пометка
+}
+
+// withdraw убирает из статьи текст, подпись и изображения, как страница снятой статьи на сайте
+func (a *exportArticle) withdraw() {
+	a.Withdrawn = true
+	a.Content = ""
+	a.AuthorName = ""
+	a.Contributors = nil
+	a.Avatars = nil
+	a.Images = nil
+}
+
+// Byline возвращает участников для подписи, а если их нет — автора публикации
+func (a exportArticle) Byline() []Contributor {
+	if len(a.Contributors) == 0 && a.AuthorName != "" {
+		return []Contributor{{UserID: a.AuthorID, Name: a.AuthorName, Role: ContributorAuthor}}
+	}
+	return a.Contributors
+}
+
+// loadExportArticle загружает публикацию, её участников с аватарами и изображения текста
+func loadExportArticle(publicationID int) (exportArticle, error) {
+	var a exportArticle
+	err := Db.QueryRow(`SELECT p.id, p.title, p.content, p.status, COALESCE(p.department, ''), p.published_at, p.updated_at,
+                               COALESCE(p.is_published, FALSE), COALESCE(p.unpublish_reason, ''), p.is_retracted, p.retraction_reason,
+                               COALESCE(p.author_id, 0), COALESCE(NULLIF(pr.full_name, ''), u.login, '')
+                        FROM publications p
+                        LEFT JOIN users u ON u.id = p.author_id
+                        LEFT JOIN user_profiles pr ON pr.user_id = p.author_id
+                        WHERE p.id = $1 AND p.deleted_at IS NULL`, publicationID).
+		Scan(&a.ID, &a.Title, &a.Content, &a.Status, &a.Department, &a.PublishedAt, &a.UpdatedAt,
+			&a.IsPublished, &a.UnpublishReason, &a.IsRetracted, &a.RetractionReason, &a.AuthorID, &a.AuthorName)
+	if err != nil {
+		return a, err
+	}
+
+	a.Contributors, err = GetContributors(publicationID)
+	if err != nil {
+		return a, err
+	}
+	a.Images, err = GetPublicationImages(publicationID)
+	if err != nil {
+		return a, err
+	}
+	var userIDs []int64
+	for _, c := range a.Byline() {
+		userIDs = append(userIDs, int64(c.UserID))
+	}
+
+	a.Avatars = map[int]exportAvatar{}
+	rows, err := Db.Query(`SELECT user_id, avatar, avatar_type FROM user_profiles
+                           WHERE user_id = ANY($1) AND avatar IS NOT NULL`, pq.Int64Array(userIDs))
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var avatar exportAvatar
+		if err := rows.Scan(&userID, &avatar.Data, &avatar.Type); err != nil {
+			return a, err
+		}
+		a.Avatars[userID] = avatar
+	}
+	return a, rows.Err()
+}
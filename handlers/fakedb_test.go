@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Поддельная база для проверки обработчиков без PostgreSQL: запросы записываются,
+// а ответы подбираются по тексту запроса
+
+// fakeCall — запрос, отправленный в поддельную базу
+type fakeCall struct {
+	query string
+	args  []driver.Value
+}
+
+// fakeStore отвечает на запросы: поиск существующих строк ничего не находит (если не задан existing),
+// INSERT ... RETURNING id выдаёт новые идентификаторы начиная с 1000, а Exec меняет одну строку (если не задан affected).
+// row, если задан, отвечает на запрос одной строкой раньше остальных правил; пустой срез означает
+// пустой результат, nil — «не знаю».
+type fakeStore struct {
+	calls    []fakeCall
+	nextID   int64
+	existing func(query string) (int64, bool)
+	affected func(query string, args []driver.Value) int64
+	row      func(query string, args []driver.Value) []driver.Value
+}
+
+func (s *fakeStore) Connect(context.Context) (driver.Conn, error) { return fakeConn{s}, nil }
+func (s *fakeStore) Driver() driver.Driver                        { return nil }
+
+// find возвращает первый запрос, содержащий фрагмент
+func (s *fakeStore) find(t *testing.T, fragment string) fakeCall {
+	t.Helper()
+	for _, c := range s.calls {
+		if strings.Contains(c.query, fragment) {
+			return c
+		}
+	}
+	t.Fatalf("запрос с %q не выполнялся", fragment)
+	return fakeCall{}
+}
+
+type fakeConn struct{ s *fakeStore }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.s, strings.Join(strings.Fields(query), " ")}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	s     *fakeStore
+	query string
+}
+
+func (st fakeStmt) Close() error  { return nil }
+func (st fakeStmt) NumInput() int { return -1 }
+
+func (st fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	st.s.calls = append(st.s.calls, fakeCall{st.query, args})
+	if st.s.affected != nil {
+		return driver.RowsAffected(st.s.affected(st.query, args)), nil
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (st fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	st.s.calls = append(st.s.calls, fakeCall{st.query, args})
+	if st.s.row != nil {
+		if row := st.s.row(st.query, args); row != nil {
+			if len(row) == 0 {
+				return &fakeRows{}, nil
+			}
+			return &fakeRows{values: row}, nil
+		}
+	}
+	switch {
+	case strings.Contains(st.query, "RETURNING (xmax = 0)"):
+		return &fakeRows{values: []driver.Value{true}}, nil
+	case strings.Contains(st.query, "RETURNING id"):
+		st.s.nextID++
+		return &fakeRows{values: []driver.Value{999 + st.s.nextID}}, nil
+	case strings.HasPrefix(st.query, "SELECT EXISTS"):
+		return &fakeRows{values: []driver.Value{false}}, nil
+	case strings.HasPrefix(st.query, "SELECT id") && st.s.existing != nil:
+		if id, ok := st.s.existing(st.query); ok {
+			return &fakeRows{values: []driver.Value{id}}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+// fakeRows — результат из одной строки или пустой
+type fakeRows struct {
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.values) == 0 {
+		return []string{"value"}
+	}
+	return make([]string, len(r.values))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.values == nil {
+		return io.EOF
+	}
+	copy(dest, r.values)
+	r.values = nil
+	return nil
+}
+
+// useFakeDb подменяет базу на время теста
+func useFakeDb(t *testing.T, s *fakeStore) {
+	previous := Db
+	Db = sql.OpenDB(s)
+	t.Cleanup(func() {
+		Db.Close()
+		Db = previous
+	})
+}
+
+func assertArgs(t *testing.T, c fakeCall, want map[int]int64) {
+	t.Helper()
+	for i, id := range want {
+		if i >= len(c.args) || c.args[i] != id {
+			t.Errorf("%s\nаргумент $%d = %v, ожидался %d", c.query, i+1, c.args, id)
+		}
+	}
+}
+
+// withSession добавляет к запросу cookie сессии пользователя. Поддельная база подтверждает
+// сессию и отвечает ролью пользователя на запросы CurrentUserID и GetUserRoleByIDFromDB.
+func withSession(t *testing.T, s *fakeStore, req *http.Request, userID int, role string) *http.Request {
+	t.Helper()
+	session, err := store.New(httptest.NewRequest(http.MethodGet, "/", nil), sessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["user_id"] = userID
+	session.Values["session_version"] = 1
+	rec := httptest.NewRecorder()
+	if err := session.Save(httptest.NewRequest(http.MethodGet, "/", nil), rec); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+
+	next := s.row
+	s.row = func(query string, args []driver.Value) []driver.Value {
+		if len(args) > 0 && args[0] == int64(userID) {
+			switch {
+			case strings.HasPrefix(query, "SELECT session_version, is_active FROM users"):
+				return []driver.Value{int64(1), true}
+			case strings.HasPrefix(query, "SELECT role FROM users"):
+				return []driver.Value{role}
+			}
+		}
+		if next != nil {
+			return next(query, args)
+		}
+		return nil
+	}
+	return req
+}
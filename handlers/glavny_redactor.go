@@ -1,43 +1,96 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
 )
 
-// Добавление новой темы
+// Добавление новой темы и её назначение авторам
 func AssignTopicHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
 
-	editorIDStr := r.FormValue("editor_id")
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Ошибка при обработке формы", http.StatusBadRequest)
+		return
+	}
+
+	editorID, _, ok := sessionRole(w, r, IsChiefEditorRole, "Назначать темы может только главный редактор")
+	if !ok {
+		return
+	}
 	topic := r.FormValue("topic")
 	department := r.FormValue("department")
-	editorID, err := strconv.Atoi(editorIDStr)
+	isOpen := r.FormValue("open") == "on"
+
+	if topic == "" {
+		http.Error(w, "Тема не может быть пустой", http.StatusBadRequest)
+		return
+	}
+
+	dueAt, err := ParseDueDate(r.FormValue("due_at"))
 	if err != nil {
-		http.Error(w, "Неверный ID главного редактора", http.StatusBadRequest)
+		http.Error(w, "Неверный формат срока сдачи", http.StatusBadRequest)
 		return
 	}
 
-	query := `INSERT INTO user_topics (editor_id, topic, department, assigned_at) VALUES ($1, $2, $3, $4)`
-	_, err = Db.Exec(query, editorID, topic, department, time.Now())
+	// Авторы, которым назначается тема, и их индивидуальные сроки (поля due_at_<id>)
+	var authorIDs []int
+	authorDueAts := map[int]sql.NullTime{}
+	for _, idStr := range r.Form["author_ids"] {
+		authorID, err := strconv.Atoi(idStr)
+		if err != nil || authorID <= 0 {
+			http.Error(w, "Неверный идентификатор автора", http.StatusBadRequest)
+			return
+		}
+		authorDueAt, err := ParseDueDate(r.FormValue("due_at_" + idStr))
+		if err != nil {
+			http.Error(w, "Неверный формат срока сдачи автора", http.StatusBadRequest)
+			return
+		}
+		authorIDs = append(authorIDs, authorID)
+		authorDueAts[authorID] = authorDueAt
+	}
+	authorsOK, err := CheckAuthors(authorIDs)
 	if err != nil {
+		http.Error(w, "Ошибка при проверке авторов", http.StatusInternalServerError)
+		return
+	}
+	if !authorsOK {
+		http.Error(w, "Тему можно назначить только действующим авторам", http.StatusBadRequest)
+		return
+	}
+
+	if len(authorIDs) == 0 && !isOpen {
+		http.Error(w, "Выберите авторов или откройте тему для свободного выбора", http.StatusBadRequest)
+		return
+	}
+
+	if err := CreateTopicWithAssignments(editorID, topic, department, isOpen, dueAt, authorIDs, authorDueAts); err != nil {
+		Logger(r.Context()).Error("Ошибка при назначении темы", "error", err)
 		http.Error(w, "Ошибка при назначении темы", http.StatusInternalServerError)
 		return
 	}
+	publishBusEvent(TopicTopic, TopicChanged{
+		Kind:      EventAssigned,
+		Title:     topic,
+		Message:   "Назначена тема «" + topic + "»",
+		ActorID:   editorID,
+		AuthorIDs: authorIDs,
+	})
 
-	http.Redirect(w, r, "/chief_editor_page?id="+editorIDStr, http.StatusSeeOther)
+	http.Redirect(w, r, "/chief_editor_page?id="+strconv.Itoa(editorID), http.StatusSeeOther)
 }
 
 // Вспомогательная функция для получения подготовленных публикаций
 func GetPreparedPublications() ([]Publication, error) {
-	query := "SELECT id, title, content, department, created_at FROM publications WHERE status = 'draft'"
+	query := "SELECT id, title, content, department, created_at FROM publications WHERE status = 'draft' AND deleted_at IS NULL"
 	rows, err := Db.Query(query)
 	if err != nil {
 		return nil, err
@@ -63,7 +116,7 @@ func CheckPublicationsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := "SELECT id, title, content, created_at FROM publications WHERE status = 'draft'"
+	query := "SELECT id, title, content, created_at FROM publications WHERE status = 'draft' AND deleted_at IS NULL"
 	rows, err := Db.Query(query)
 	if err != nil {
 		http.Error(w, "Ошибка при получении публикаций", http.StatusInternalServerError)
@@ -102,7 +155,7 @@ func CheckPublicationsHandler(w http.ResponseWriter, r *http.Request) {
 
 // Получение черновиков публикаций
 func GetDraftPublications() ([]Publication, error) {
-	query := "SELECT id, title, content, created_at FROM publications WHERE status = 'draft'"
+	query := "SELECT id, title, content, created_at FROM publications WHERE status = 'draft' AND deleted_at IS NULL"
 	rows, err := Db.Query(query)
 	if err != nil {
 		return nil, err
@@ -127,52 +180,89 @@ func EditDraftHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	articleIDStr := r.FormValue("article_id")
-	title := r.FormValue("title")
-	content := r.FormValue("content")
+	// Черновик правит главный редактор из сессии, на него же записывается версия
+	editorID, _, ok := sessionRole(w, r, IsChiefEditorRole, "Править черновики может только главный редактор")
+	if !ok {
+		return
+	}
 
+	articleIDStr := r.FormValue("article_id")
 	articleID, err := strconv.Atoi(articleIDStr)
 	if err != nil {
 		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
 		return
 	}
 
-	query := `UPDATE publications SET title = $1, content = $2, updated_at = $3 WHERE id = $4 AND status = 'draft'`
-	_, err = Db.Exec(query, title, content, time.Now(), articleID)
+	// Главный редактор правит только черновики
+	current, err := GetPublicationForEdit(articleID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Публикация не найдена", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Printf("Ошибка обновления черновика: %v", err)
+		Logger(r.Context()).Error("Ошибка получения черновика", "error", err)
 		http.Error(w, "Ошибка при обновлении черновика", http.StatusInternalServerError)
 		return
 	}
+	if current.Status != "draft" {
+		http.Error(w, "Публикация уже не является черновиком", http.StatusConflict)
+		return
+	}
+
+	backURL := "/chief_editor_page?id=" + strconv.Itoa(editorID)
+	if !savePublicationForm(w, r, articleID, editorID, "", map[string]string{"article_id": articleIDStr}, backURL) {
+		return
+	}
 
-	http.Redirect(w, r, "/chief_editor_page?id="+r.FormValue("editor_id"), http.StatusSeeOther)
+	http.Redirect(w, r, backURL, http.StatusSeeOther)
 }
 
-func GetPublications() ([]Publication, error) {
+func GetPublications(ctx context.Context) ([]Publication, error) {
 	var publications []Publication
 
-	query := `SELECT id, title, content, topic_id, author_id, status, created_at, updated_at FROM publications`
-	rows, err := Db.Query(query)
+	query := `SELECT p.id, p.title, p.content, p.topic_id, p.author_id, p.status, p.created_at, p.updated_at, p.version,
+                     p.due_at, p.status_changed_at, p.scheduled_at, p.schedule_tz, p.embargo_until, p.published_at,
+                     COALESCE(p.is_published, FALSE), p.unpublish_reason, p.is_retracted, p.retraction_reason,
+                     COALESCE((SELECT string_agg(e.kind, ',') FROM escalations e
+                               WHERE e.publication_id = p.id AND e.resolved_at IS NULL), ''),
+                     p.category_id, COALESCE(c.name, ''), COALESCE(c.slug, '')
+              FROM publications p
+              LEFT JOIN categories c ON c.id = p.category_id
+              WHERE p.deleted_at IS NULL`
+	rows, err := Db.QueryContext(ctx, query)
 	if err != nil {
-		log.Printf("Ошибка выполнения SQL-запроса: %v", err)
+		Logger(ctx).Error("Ошибка выполнения SQL-запроса", "error", err)
 		return nil, fmt.Errorf("ошибка при выполнении запроса к базе данных")
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var pub Publication
-		if err := rows.Scan(&pub.ID, &pub.Title, &pub.Content, &pub.TopicID, &pub.AuthorID, &pub.Status, &pub.CreatedAt, &pub.UpdatedAt); err != nil {
-			log.Printf("Ошибка сканирования данных публикации: %v", err)
+		if err := rows.Scan(&pub.ID, &pub.Title, &pub.Content, &pub.TopicID, &pub.AuthorID, &pub.Status, &pub.CreatedAt, &pub.UpdatedAt, &pub.Version,
+			&pub.DueAt, &pub.StatusChangedAt, &pub.ScheduledAt, &pub.ScheduleTZ, &pub.EmbargoUntil, &pub.PublishedAt,
+			&pub.IsPublished, &pub.UnpublishReason, &pub.IsRetracted, &pub.RetractionReason,
+			&pub.OverdueReason, &pub.CategoryID, &pub.CategoryName, &pub.CategorySlug); err != nil {
+			Logger(ctx).Error("Ошибка сканирования данных публикации", "error", err)
 			return nil, fmt.Errorf("ошибка при чтении данных")
 		}
+		pub.Overdue = pub.OverdueReason != ""
 		publications = append(publications, pub)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Ошибка после завершения rows.Next(): %v", err)
+		Logger(ctx).Error("Ошибка после завершения rows.Next()", "error", err)
 		return nil, fmt.Errorf("ошибка после завершения чтения данных")
 	}
 
+	if err := attachTags(publications); err != nil {
+		Logger(ctx).Error("Ошибка получения тегов публикаций", "error", err)
+		return nil, fmt.Errorf("ошибка при чтении тегов")
+	}
+	if err := attachLocks(publications); err != nil {
+		Logger(ctx).Error("Ошибка получения блокировок публикаций", "error", err)
+		return nil, fmt.Errorf("ошибка при чтении блокировок")
+	}
+
 	return publications, nil
 }
 func ViewTopicsHandler(w http.ResponseWriter, r *http.Request) {
@@ -203,7 +293,7 @@ func ViewTopicsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 func GetTopicsByEditorID(editorID int) ([]Topic, error) {
-	rows, err := Db.Query("SELECT id, topic, department FROM user_topics WHERE editor_id = $1", editorID)
+	rows, err := Db.Query("SELECT id, topic, department, is_open, due_at FROM user_topics WHERE editor_id = $1 AND deleted_at IS NULL", editorID)
 	if err != nil {
 		return nil, err
 	}
@@ -212,12 +302,30 @@ func GetTopicsByEditorID(editorID int) ([]Topic, error) {
 	topics := []Topic{}
 	for rows.Next() {
 		var topic Topic
-		if err := rows.Scan(&topic.ID, &topic.Topic, &topic.Department); err != nil {
+		if err := rows.Scan(&topic.ID, &topic.Topic, &topic.Department, &topic.IsOpen, &topic.DueAt); err != nil {
 			return nil, err
 		}
 		topic.EditorID = editorID // Добавляем EditorID вручную
 		topics = append(topics, topic)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Назначения всех тем редактора загружаются одним запросом и раскладываются по темам
+	assignments, err := GetEditorTopicAssignments(editorID)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[int]int, len(topics))
+	for i, topic := range topics {
+		index[topic.ID] = i
+	}
+	for _, a := range assignments {
+		if i, ok := index[a.TopicID]; ok {
+			topics[i].Assignments = append(topics[i].Assignments, a)
+		}
+	}
 	return topics, nil
 }
 func ChiefEditorPage(w http.ResponseWriter, r *http.Request) {
@@ -233,7 +341,7 @@ func ChiefEditorPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userName, err := GetUserNameByIDFromDB(editorID)
+	userName, err := GetUserNameByIDFromDB(r.Context(), editorID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении имени пользователя из базы данных", http.StatusInternalServerError)
 		return
@@ -245,22 +353,59 @@ func ChiefEditorPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	publications, err := GetPublications()
+	publications, err := GetPublications(r.Context())
 	if err != nil {
 		http.Error(w, "Ошибка при получении публикаций", http.StatusInternalServerError)
 		return
 	}
 
+	authors, err := GetUsersByRole("author")
+	if err != nil {
+		http.Error(w, "Ошибка при получении списка авторов", http.StatusInternalServerError)
+		return
+	}
+
+	escalations, err := GetOpenEscalations(editorID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении просрочек", http.StatusInternalServerError)
+		return
+	}
+
+	categories, err := GetCategoryTree()
+	if err != nil {
+		http.Error(w, "Ошибка при получении рубрик", http.StatusInternalServerError)
+		return
+	}
+	tags, err := GetTags()
+	if err != nil {
+		http.Error(w, "Ошибка при получении тегов", http.StatusInternalServerError)
+		return
+	}
+
+	// Фильтр публикаций по тегу
+	tag := r.URL.Query().Get("tag")
+	publications = FilterPublicationsByTag(publications, tag)
+
 	data := struct {
 		EditorID     int
 		UserName     string
 		Topics       []Topic
 		Publications []Publication
+		Authors      []User
+		Escalations  []Escalation
+		Categories   []Category
+		Tags         []Tag
+		Tag          string
 	}{
 		EditorID:     editorID,
 		UserName:     userName,
 		Topics:       topics,
 		Publications: publications,
+		Authors:      authors,
+		Escalations:  escalations,
+		Categories:   categories,
+		Tags:         tags,
+		Tag:          tag,
 	}
 
 	if err := TmplChiefEditor.Execute(w, data); err != nil {
@@ -274,27 +419,22 @@ func DeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получение данных из формы
-	topicIDStr := r.FormValue("topic_id")
-	editorIDStr := r.FormValue("editor_id")
-
-	// Проверка корректности topic_id
-	topicID, err := strconv.Atoi(topicIDStr)
-	if err != nil {
-		http.Error(w, "Неверный идентификатор темы", http.StatusBadRequest)
+	// Тему удаляет главный редактор из сессии
+	editorID, _, ok := sessionRole(w, r, IsChiefEditorRole, "Удалять темы может только главный редактор")
+	if !ok {
 		return
 	}
 
-	// Проверка корректности editor_id
-	editorID, err := strconv.Atoi(editorIDStr)
+	// Проверка корректности topic_id
+	topicID, err := strconv.Atoi(r.FormValue("topic_id"))
 	if err != nil {
-		http.Error(w, "Неверный идентификатор редактора", http.StatusBadRequest)
+		http.Error(w, "Неверный идентификатор темы", http.StatusBadRequest)
 		return
 	}
 
-	// Выполнение удаления
-	query := "DELETE FROM user_topics WHERE id = $1 AND editor_id = $2"
-	result, err := Db.Exec(query, topicID, editorID)
+	// Перемещение темы в корзину
+	query := "UPDATE user_topics SET deleted_at = $1, deleted_by = $2 WHERE id = $3 AND editor_id = $2 AND deleted_at IS NULL"
+	result, err := Db.Exec(query, time.Now(), editorID, topicID)
 	if err != nil {
 		http.Error(w, "Ошибка при удалении темы из базы данных: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -308,7 +448,7 @@ func DeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Перенаправление после успешного удаления
-	http.Redirect(w, r, "/chief_editor_page?id="+editorIDStr, http.StatusSeeOther)
+	http.Redirect(w, r, "/chief_editor_page?id="+strconv.Itoa(editorID), http.StatusSeeOther)
 }
 
 /*
@@ -367,34 +507,15 @@ func DeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
 }
 */
 func ApprovePublicationHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Получаем идентификаторы статьи и редактора
-	articleIDStr := r.FormValue("article_id")
-	editorIDStr := r.FormValue("editor_id")
-
-	articleID, err := strconv.Atoi(articleIDStr)
-	if err != nil {
-		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
-		return
-	}
-
-	// Проверка роли редактора
-	var role string
-	queryRole := `SELECT role FROM users WHERE id = $1`
-	err = Db.QueryRow(queryRole, editorIDStr).Scan(&role)
-	if err != nil {
-		http.Error(w, "Ошибка при получении роли пользователя: "+err.Error(), http.StatusInternalServerError)
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
 		return
 	}
 
 	// Проверяем статус публикации
 	var status string
 	query := `SELECT status FROM publications WHERE id = $1`
-	err = Db.QueryRow(query, articleID).Scan(&status)
+	err := Db.QueryRow(query, articleID).Scan(&status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Публикация не найдена", http.StatusNotFound)
@@ -417,51 +538,27 @@ func ApprovePublicationHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Ошибка при обновлении статуса публикации: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	editorDecisions.Inc(strconv.Itoa(editorID), decisionApprove)
+	publishPublicationEvent(r.Context(), EventStatusChanged, articleID, editorID, "Публикация одобрена")
 
-	// Перенаправление в зависимости от роли пользователя
-	if role == "chief_admin" {
-		http.Redirect(w, r, "/chief_editor_page?id="+editorIDStr, http.StatusSeeOther)
-	} else if role == "section_editor" {
-		http.Redirect(w, r, "/section_editor_page?id="+editorIDStr, http.StatusSeeOther)
-	} else {
-		http.Error(w, "Недопустимая роль пользователя", http.StatusForbidden)
-	}
+	redirectToEditorPage(w, r, role, editorID)
 }
 func RequestRevisionHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
 		return
 	}
 
-	// Получаем идентификатор статьи и замечания
-	articleIDStr := r.FormValue("article_id")
 	remarks := r.FormValue("remarks")
-	editorIDStr := r.FormValue("editor_id")
-
-	articleID, err := strconv.Atoi(articleIDStr)
-	if err != nil {
-		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
-		return
-	}
-
 	if remarks == "" {
 		http.Error(w, "Замечания не могут быть пустыми", http.StatusBadRequest)
 		return
 	}
 
-	// Проверка роли редактора
-	var role string
-	queryRole := `SELECT role FROM users WHERE id = $1`
-	err = Db.QueryRow(queryRole, editorIDStr).Scan(&role)
-	if err != nil {
-		http.Error(w, "Ошибка при получении роли пользователя: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Проверяем, существует ли статья и кому она принадлежит
-	var editorID int
+	// Проверяем, существует ли статья
+	var authorID int
 	query := `SELECT author_id FROM publications WHERE id = $1`
-	err = Db.QueryRow(query, articleID).Scan(&editorID)
+	err := Db.QueryRow(query, articleID).Scan(&authorID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Публикация не найдена", http.StatusNotFound)
@@ -471,20 +568,28 @@ func RequestRevisionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkNotInLockedIssue(w, articleID) {
+		return
+	}
+
 	// Обновляем статус статьи и добавляем замечания
-	updateQuery := `UPDATE publications SET status = 'revision', remarks = $1, updated_at = $2 WHERE id = $3`
-	_, err = Db.Exec(updateQuery, remarks, time.Now(), articleID)
+	updateQuery := `UPDATE publications SET status = 'revision', remarks = $1, updated_at = $2
+                    WHERE id = $3 AND ` + notInLockedIssue
+	result, err := Db.Exec(updateQuery, remarks, time.Now(), articleID)
 	if err != nil {
 		http.Error(w, "Ошибка при обновлении статуса публикации: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// Перенаправление в зависимости от роли пользователя
-	if role == "chief_admin" {
-		http.Redirect(w, r, "/chief_editor_page?id="+editorIDStr, http.StatusSeeOther)
-	} else if role == "section_editor" {
-		http.Redirect(w, r, "/section_editor_page?id="+editorIDStr, http.StatusSeeOther)
-	} else {
-		http.Error(w, "Недопустимая роль пользователя", http.StatusForbidden)
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		http.Error(w, "Публикация входит в зафиксированный выпуск", http.StatusConflict)
+		return
+	}
+	// Замечания перезаписываются при каждом возврате, поэтому историю храним отдельно для выгрузки в Word
+	if err := AddPublicationComment(articleID, editorID, remarks); err != nil {
+		Logger(r.Context()).Error("Ошибка сохранения замечания", "publication_id", articleID, "error", err)
 	}
+	editorDecisions.Inc(strconv.Itoa(editorID), decisionRevision)
+	publishPublicationEvent(r.Context(), EventComment, articleID, editorID, "Публикация возвращена на доработку: "+remarks)
+
+	redirectToEditorPage(w, r, role, editorID)
 }
@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
-	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
+	"example.com/myproject/templates"
 	"github.com/gorilla/sessions"
 	_ "github.com/lib/pq"
 )
@@ -18,6 +20,7 @@ type User struct {
 	Login    string
 	Password string
 	Role     string
+	IsActive bool
 }
 
 type Publication struct {
@@ -26,11 +29,43 @@ type Publication struct {
 	Content     string
 	TopicID     int
 	AuthorID    int
+	AuthorName  string // подпись автора: полное имя из профиля или логин
 	Status      string
 	Department  string
 	IsPublished bool
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	Version     int // номер версии текста, растёт при каждом сохранении
+
+	// Соавторы и участники; ContributorRole — роль текущего пользователя в публикации
+	Contributors    []Contributor
+	ContributorRole string
+
+	// Рубрика и теги
+	CategoryID   sql.NullInt64
+	CategoryName string
+	CategorySlug string
+	Tags         []Tag
+
+	// Кто сейчас редактирует публикацию
+	EditLock *EditLock
+
+	// Сроки и просрочки
+	DueAt           sql.NullTime
+	StatusChangedAt time.Time
+	Overdue         bool
+	OverdueReason   string
+
+	// Снятие с публикации и отзыв
+	UnpublishReason  string
+	IsRetracted      bool
+	RetractionReason string
+
+	// Отложенная выкладка и эмбарго
+	ScheduledAt  sql.NullTime
+	ScheduleTZ   string
+	EmbargoUntil sql.NullTime
+	PublishedAt  sql.NullTime
 }
 
 type Topic struct {
@@ -39,6 +74,27 @@ type Topic struct {
 	Topic      string
 	Department string
 	EditorID   int // Добавить это поле
+	IsOpen     bool
+	DueAt      sql.NullTime
+
+	// Заполняются для тем, полученных через назначение автору
+	AssignmentID     int
+	AssignmentStatus string
+
+	// Заполняется для страницы главного редактора
+	Assignments []TopicAssignment
+}
+
+// Назначение темы конкретному автору
+type TopicAssignment struct {
+	ID          int
+	TopicID     int
+	AuthorID    int
+	AuthorName  string
+	Status      string // assigned, accepted, declined
+	DueAt       sql.NullTime
+	AssignedAt  time.Time
+	RespondedAt sql.NullTime
 }
 
 type ChiefEditorData struct {
@@ -50,36 +106,36 @@ type ChiefEditorData struct {
 }
 
 var Db *sql.DB
-var Tmpl1 = template.Must(template.ParseFiles("templates/register.html"))
-var TmplCatalog = template.Must(template.ParseFiles("templates/upload1.html"))                   // Главная страница
-var TmplAdmin = template.Must(template.ParseFiles("templates/admin_page.html"))                  // Шаблон страницы администратора
-var TmplChiefEditor = template.Must(template.ParseFiles("templates/chief_editor_page.html"))     // Шаблон страницы главного редактора
-var TmplSectionEditor = template.Must(template.ParseFiles("templates/section_editor_page.html")) // Шаблон страницы редактора раздела
-var TmplAuthor = template.Must(template.ParseFiles("templates/author_page.html"))
-var TmplCreatePublication = template.Must(template.ParseFiles("templates/сreate_publication.html"))
-var TmplEditPublication = template.Must(template.ParseFiles("templates/edit_publication.html"))
+var Tmpl1 = template.Must(template.ParseFS(templates.FS, "register.html"))
+var TmplCatalog = template.Must(template.ParseFS(templates.FS, "upload1.html"))                   // Главная страница
+var TmplAdmin = template.Must(template.ParseFS(templates.FS, "admin_page.html"))                  // Шаблон страницы администратора
+var TmplChiefEditor = template.Must(template.ParseFS(templates.FS, "chief_editor_page.html"))     // Шаблон страницы главного редактора
+var TmplSectionEditor = template.Must(template.ParseFS(templates.FS, "section_editor_page.html")) // Шаблон страницы редактора раздела
+var TmplAuthor = template.Must(template.ParseFS(templates.FS, "author_page.html"))
+var TmplCreatePublication = template.Must(template.ParseFS(templates.FS, "сreate_publication.html"))
+var TmplEditPublication = template.Must(template.ParseFS(templates.FS, "edit_publication.html"))
 
 var store = sessions.NewCookieStore([]byte("секретный-ключ"))
 
 // Получаем имя пользователя по ID из базы данных
-func GetUserNameByIDFromDB(userID int) (string, error) {
+func GetUserNameByIDFromDB(ctx context.Context, userID int) (string, error) {
 	var userName string
 	query := "SELECT login FROM users WHERE id = $1"
-	err := Db.QueryRow(query, userID).Scan(&userName)
+	err := Db.QueryRowContext(ctx, query, userID).Scan(&userName)
 	if err != nil {
-		log.Println("Ошибка при получении имени пользователя из базы данных:", err)
+		Logger(ctx).Error("Ошибка при получении имени пользователя из базы данных", "user_id", userID, "error", err)
 		return "", err
 	}
 	return userName, nil
 }
 
 // Получаем роль пользователя по ID из базы данных
-func GetUserRoleByIDFromDB(userID int) (string, error) {
+func GetUserRoleByIDFromDB(ctx context.Context, userID int) (string, error) {
 	var role string
 	query := "SELECT role FROM users WHERE id = $1"
-	err := Db.QueryRow(query, userID).Scan(&role)
+	err := Db.QueryRowContext(ctx, query, userID).Scan(&role)
 	if err != nil {
-		log.Println("Ошибка при получении роли пользователя из базы данных:", err)
+		Logger(ctx).Error("Ошибка при получении роли пользователя из базы данных", "user_id", userID, "error", err)
 		return "", err
 	}
 	return role, nil
@@ -94,13 +150,13 @@ func Index(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userName, err := GetUserNameByIDFromDB(userID)
+	userName, err := GetUserNameByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении имени пользователя из базы данных", http.StatusInternalServerError)
 		return
 	}
 
-	role, err := GetUserRoleByIDFromDB(userID)
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении роли пользователя из базы данных", http.StatusInternalServerError)
 		return
@@ -133,16 +189,19 @@ func Home(w http.ResponseWriter, r *http.Request) {
 
 		// Проверяем результат аутентификации
 		if id == -1 {
-			log.Println("Ошибка аутентификации: неверный пароль")
+			Logger(r.Context()).Warn("Ошибка аутентификации", "login", login)
 			http.ServeFile(w, r, "templates/errorModal.html") // Ошибка при аутентификации
 			return
 		} else if id == 0 {
-			log.Println("Регистрация пользователя, так как id = 0")
+			Logger(r.Context()).Info("Регистрация пользователя", "login", login)
 			RegisterHandler(w, r) // Если id == 0, направляем на регистрацию
 			return
 		} else {
 			// Успешная аутентификация, перенаправляем на главную страницу
-			log.Printf("Успешная аутентификация для пользователя с ID: %d", id)
+			Logger(r.Context()).Info("Успешная аутентификация", "user_id", id)
+			if err := StartSession(w, r, id); err != nil {
+				Logger(r.Context()).Error("Ошибка при создании сессии", "error", err)
+			}
 			http.Redirect(w, r, "/main?id="+strconv.Itoa(id), http.StatusFound)
 			return
 		}
@@ -151,7 +210,7 @@ func Home(w http.ResponseWriter, r *http.Request) {
 
 // Получаем список всех пользователей
 func GetAllUsers() ([]User, error) {
-	query := "SELECT id, login, role FROM users"
+	query := "SELECT id, login, role, is_active FROM users WHERE deleted_at IS NULL"
 	rows, err := Db.Query(query)
 	if err != nil {
 		return nil, err
@@ -161,7 +220,7 @@ func GetAllUsers() ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var user User
-		err := rows.Scan(&user.IDuser, &user.Login, &user.Role)
+		err := rows.Scan(&user.IDuser, &user.Login, &user.Role, &user.IsActive)
 		if err != nil {
 			return nil, err
 		}
@@ -170,27 +229,75 @@ func GetAllUsers() ([]User, error) {
 	return users, nil
 }
 
-// Назначение публикаций автору
+// Получаем список пользователей с заданной ролью
+func GetUsersByRole(role string) ([]User, error) {
+	query := "SELECT id, login, role, is_active FROM users WHERE role = $1 AND deleted_at IS NULL AND is_active ORDER BY login"
+	rows, err := Db.Query(query, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.IDuser, &user.Login, &user.Role, &user.IsActive); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Назначение участника публикации: соавтора, переводчика, фотографа или фактчекера
 func AssignPublications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Участников назначает редактор из сессии
+	editorID, editorRole, ok := sessionRole(w, r, IsEditorRole, "Назначать участников может только редактор")
+	if !ok {
+		return
+	}
+
 	userIDStr := r.FormValue("user_id")
 	publicationIDStr := r.FormValue("publication_id")
 
 	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
 	publicationID, err := strconv.Atoi(publicationIDStr)
+	if err != nil {
+		http.Error(w, "Неверный идентификатор публикации", http.StatusBadRequest)
+		return
+	}
+
+	role := r.FormValue("role")
+	if role == "" {
+		role = ContributorAuthor
+	}
 
-	query := "INSERT INTO user_publications (user_id, publication_id) VALUES ($1, $2)"
-	_, err = Db.Exec(query, userID, publicationID)
+	err = AddContributor(Db, publicationID, userID, role)
+	if err == ErrLastAuthor {
+		http.Error(w, "У публикации должен остаться хотя бы один автор", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Ошибка при назначении публикации: "+err.Error(), http.StatusInternalServerError)
+		writeUserError(w, err)
 		return
 	}
+	publishPublicationEvent(r.Context(), EventAssigned, publicationID, editorID, "Назначен участник публикации: "+ContributorRoleLabels[role])
 
-	http.Redirect(w, r, "/chief_editor_page", http.StatusSeeOther)
+	redirectToEditorPage(w, r, editorRole, editorID)
 }
 
 // Отображение каталога публикаций
 func CatalogPage(w http.ResponseWriter, r *http.Request) {
-	rows, err := Db.Query("SELECT id, title, content FROM publications")
+	rows, err := Db.Query("SELECT id, title, content FROM publications WHERE deleted_at IS NULL")
 	if err != nil {
 		http.Error(w, "Ошибка при получении публикаций: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -223,39 +330,90 @@ func CatalogPage(w http.ResponseWriter, r *http.Request) {
 // Редактирование публикации
 func EditPublication(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		pubID := r.FormValue("id")
-		title := r.FormValue("title")
-		content := r.FormValue("content")
-
-		query := "UPDATE publications SET title = $1, content = $2 WHERE id = $3"
-		_, err := Db.Exec(query, title, content, pubID)
+		// Правку сохраняет редактор из сессии, на него же записывается версия
+		editorID, role, ok := sessionRole(w, r, IsEditorRole, "Редактировать публикацию может только редактор")
+		if !ok {
+			return
+		}
+		pubIDStr := r.FormValue("id")
+		pubID, err := strconv.Atoi(pubIDStr)
 		if err != nil {
-			http.Error(w, "Ошибка при редактировании публикации: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Неверный идентификатор публикации", http.StatusBadRequest)
+			return
+		}
+
+		fields := map[string]string{"id": pubIDStr}
+		if !savePublicationForm(w, r, pubID, editorID, "", fields, editorPageURL(role, editorID)) {
 			return
 		}
-		http.Redirect(w, r, "/catalog_page", http.StatusSeeOther)
+		redirectToEditorPage(w, r, role, editorID)
 	}
 }
 
-// Удаление публикации
+// Удаление публикации в корзину
 func DeletePublication(w http.ResponseWriter, r *http.Request) {
-	pubID := r.URL.Query().Get("id")
-	query := "DELETE FROM publications WHERE id = $1"
-	_, err := Db.Exec(query, pubID)
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
+		return
+	}
+
+	query := "UPDATE publications SET deleted_at = $1, deleted_by = $2 WHERE id = $3 AND deleted_at IS NULL"
+	result, err := Db.Exec(query, time.Now(), editorID, articleID)
 	if err != nil {
 		http.Error(w, "Ошибка при удалении публикации: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, "/catalog_page", http.StatusSeeOther)
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		http.Error(w, "Публикация не найдена или уже удалена", http.StatusNotFound)
+		return
+	}
+
+	redirectToEditorPage(w, r, role, editorID)
 }
 
 // Проверка роли пользователя
-func CheckUserRole(userID int) (string, error) {
-	role, err := GetUserRoleByIDFromDB(userID)
+func CheckUserRole(ctx context.Context, userID int) (string, error) {
+	role, err := GetUserRoleByIDFromDB(ctx, userID)
 	if err != nil {
 		return "", err
 	}
 	return role, nil
 }
 
+// Роли пользователей
+const (
+	RoleUser          = "user"
+	RoleAdmin         = "admin"
+	RoleChiefEditor   = "chief_editor"
+	RoleSectionEditor = "section_editor"
+	RoleAuthor        = "author"
+)
+
+// IsAdminRole сообщает, является ли роль ролью администратора
+func IsAdminRole(role string) bool {
+	return strings.EqualFold(role, RoleAdmin)
+}
+
+// IsEditorRole сообщает, относится ли роль к главному редактору или редактору отдела.
+// "chief_admin" — устаревшее название роли главного редактора.
+func IsEditorRole(role string) bool {
+	return role == RoleChiefEditor || role == "chief_admin" || role == RoleSectionEditor
+}
+
+// IsChiefEditorRole сообщает, является ли роль ролью главного редактора (с учётом устаревшего "chief_admin")
+func IsChiefEditorRole(role string) bool {
+	return role == RoleChiefEditor || role == "chief_admin"
+}
+
+// CheckEditor проверяет, что пользователь с данным ID является редактором
+func CheckEditor(ctx context.Context, userID int) (bool, error) {
+	role, err := GetUserRoleByIDFromDB(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return IsEditorRole(role), nil
+}
+
 func FixComments() {}
@@ -0,0 +1,700 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myproject/templates"
+	"github.com/lib/pq"
+)
+
+var TmplIssues = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "issues.html"))
+var TmplIssue = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "issue.html"))
+
+// Статусы выпуска
+const (
+	IssuePlanning = "planning" // состав выпуска можно менять
+	IssueLocked   = "locked"   // состав зафиксирован, выпуск готов к выходу
+	IssueReleased = "released" // выпуск вышел, все его публикации выложены
+)
+
+var IssueStatusLabels = map[string]string{
+	IssuePlanning: "Планирование",
+	IssueLocked:   "Зафиксирован",
+	IssueReleased: "Вышел",
+}
+
+// Раздел выпуска для публикаций без отдела
+const issueNoDepartment = "Без отдела"
+
+// ErrIssueNotFound возвращается, если выпуск не найден
+var ErrIssueNotFound = errors.New("выпуск не найден")
+
+// IssueError — ошибка планирования выпуска, которую нужно показать редактору
+type IssueError struct {
+	Message string
+}
+
+func (e *IssueError) Error() string {
+	return e.Message
+}
+
+// notInLockedIssue — условие для запросов к publications: публикация не входит в зафиксированный выпуск.
+// Статус таких публикаций меняет только выход выпуска (ReleaseIssue).
+const notInLockedIssue = `NOT EXISTS (SELECT 1 FROM issue_publications ip JOIN issues i ON i.id = ip.issue_id
+                                   WHERE ip.publication_id = publications.id AND i.status = 'locked')`
+
+// checkNotInLockedIssue отвечает 409, если публикация входит в зафиксированный выпуск.
+// При ошибке ответ уже отправлен.
+func checkNotInLockedIssue(w http.ResponseWriter, publicationID int) bool {
+	var number int
+	err := Db.QueryRow(`SELECT i.number FROM issue_publications ip JOIN issues i ON i.id = ip.issue_id
+                        WHERE ip.publication_id = $1 AND i.status = $2
+                        ORDER BY i.number LIMIT 1`, publicationID, IssueLocked).Scan(&number)
+	if err == sql.ErrNoRows {
+		return true
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при проверке выпусков публикации", http.StatusInternalServerError)
+		return false
+	}
+	http.Error(w, "Публикация входит в зафиксированный выпуск №"+strconv.Itoa(number)+
+		": она выйдет вместе с выпуском, а менять её статус можно после снятия фиксации", http.StatusConflict)
+	return false
+}
+
+// Issue — номер периодического издания
+type Issue struct {
+	ID         int
+	Number     int
+	Date       time.Time
+	Theme      string
+	Status     string
+	CreatedAt  time.Time
+	ReleasedAt sql.NullTime
+	Count      int // число публикаций в выпуске
+}
+
+func (i Issue) StatusLabel() string {
+	return IssueStatusLabels[i.Status]
+}
+
+// IssueSection — раздел выпуска, соответствующий отделу редакции
+type IssueSection struct {
+	Department   string
+	Publications []IssuePublication
+}
+
+// IssuePublication — публикация на своём месте в разделе выпуска
+type IssuePublication struct {
+	PublicationID int
+	Title         string
+	Status        string
+	Position      int
+}
+
+// GetIssues возвращает все выпуски, начиная с последнего номера
+func GetIssues() ([]Issue, error) {
+	rows, err := Db.Query(`SELECT i.id, i.number, i.issue_date, i.theme, i.status, i.created_at, i.released_at,
+                                  (SELECT COUNT(*) FROM issue_publications ip WHERE ip.issue_id = i.id)
+                           FROM issues i ORDER BY i.number DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []Issue
+	for rows.Next() {
+		var i Issue
+		if err := rows.Scan(&i.ID, &i.Number, &i.Date, &i.Theme, &i.Status, &i.CreatedAt, &i.ReleasedAt, &i.Count); err != nil {
+			return nil, err
+		}
+		issues = append(issues, i)
+	}
+	return issues, rows.Err()
+}
+
+// GetIssue возвращает выпуск и его разделы с публикациями в порядке вёрстки
+func GetIssue(issueID int) (Issue, []IssueSection, error) {
+	var i Issue
+	err := Db.QueryRow(`SELECT id, number, issue_date, theme, status, created_at, released_at FROM issues WHERE id = $1`, issueID).
+		Scan(&i.ID, &i.Number, &i.Date, &i.Theme, &i.Status, &i.CreatedAt, &i.ReleasedAt)
+	if err == sql.ErrNoRows {
+		return i, nil, ErrIssueNotFound
+	}
+	if err != nil {
+		return i, nil, err
+	}
+
+	rows, err := Db.Query(`SELECT s.department, p.id, p.title, p.status, ip.position
+                           FROM issue_sections s
+                           JOIN issue_publications ip ON ip.issue_id = s.issue_id AND ip.department = s.department
+                           JOIN publications p ON p.id = ip.publication_id
+                           WHERE s.issue_id = $1
+                           ORDER BY s.position, ip.position`, issueID)
+	if err != nil {
+		return i, nil, err
+	}
+	defer rows.Close()
+
+	var sections []IssueSection
+	for rows.Next() {
+		var department string
+		var p IssuePublication
+		if err := rows.Scan(&department, &p.PublicationID, &p.Title, &p.Status, &p.Position); err != nil {
+			return i, nil, err
+		}
+		if len(sections) == 0 || sections[len(sections)-1].Department != department {
+			sections = append(sections, IssueSection{Department: department})
+		}
+		last := &sections[len(sections)-1]
+		last.Publications = append(last.Publications, p)
+		i.Count++
+	}
+	return i, sections, rows.Err()
+}
+
+// GetUnslottedPublications возвращает одобренные публикации, ещё не включённые ни в один выпуск
+func GetUnslottedPublications() ([]Publication, error) {
+	rows, err := Db.Query(`SELECT p.id, p.title, COALESCE(p.department, '')
+                           FROM publications p
+                           WHERE p.status = 'approved' AND p.deleted_at IS NULL
+                             AND NOT EXISTS (SELECT 1 FROM issue_publications ip WHERE ip.publication_id = p.id)
+                           ORDER BY p.department, p.title`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publications []Publication
+	for rows.Next() {
+		var p Publication
+		if err := rows.Scan(&p.ID, &p.Title, &p.Department); err != nil {
+			return nil, err
+		}
+		publications = append(publications, p)
+	}
+	return publications, rows.Err()
+}
+
+// CreateIssue создаёт выпуск в статусе планирования
+func CreateIssue(number int, date time.Time, theme string, editorID int) (int, error) {
+	var id int
+	err := Db.QueryRow(`INSERT INTO issues (number, issue_date, theme, created_by) VALUES ($1, $2, $3, $4) RETURNING id`,
+		number, date, theme, editorID).Scan(&id)
+	if pqErr, isPq := err.(*pq.Error); isPq && pqErr.Code == "23505" {
+		return 0, &IssueError{Message: "Выпуск №" + strconv.Itoa(number) + " уже существует"}
+	}
+	return id, err
+}
+
+// lockIssue блокирует строку выпуска до конца транзакции и проверяет его статус
+func lockIssue(tx *sql.Tx, issueID int, status string) error {
+	var current string
+	err := tx.QueryRow(`SELECT status FROM issues WHERE id = $1 FOR UPDATE`, issueID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return ErrIssueNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if current != status {
+		if status == IssuePlanning {
+			return &IssueError{Message: "Состав выпуска можно менять только во время планирования"}
+		}
+		return &IssueError{Message: "Выпуск находится в статусе «" + IssueStatusLabels[current] + "»"}
+	}
+	return nil
+}
+
+// AddPublicationToIssue ставит одобренную публикацию в конец раздела её отдела.
+// Раздел создаётся, если в выпуске его ещё нет.
+func AddPublicationToIssue(issueID, publicationID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockIssue(tx, issueID, IssuePlanning); err != nil {
+		return err
+	}
+
+	var department string
+	err = tx.QueryRow(`SELECT COALESCE(department, '') FROM publications
+                       WHERE id = $1 AND status = 'approved' AND deleted_at IS NULL FOR UPDATE`, publicationID).Scan(&department)
+	if err == sql.ErrNoRows {
+		return &IssueError{Message: "В выпуск можно включить только одобренную публикацию"}
+	}
+	if err != nil {
+		return err
+	}
+	if department == "" {
+		department = issueNoDepartment
+	}
+
+	_, err = tx.Exec(`INSERT INTO issue_sections (issue_id, department, position)
+                      SELECT $1, $2, COALESCE(MAX(position), 0) + 1 FROM issue_sections WHERE issue_id = $1
+                      ON CONFLICT (issue_id, department) DO NOTHING`, issueID, department)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO issue_publications (issue_id, department, publication_id, position)
+                      SELECT $1, $2, $3, COALESCE(MAX(position), 0) + 1
+                      FROM issue_publications WHERE issue_id = $1 AND department = $2`, issueID, department, publicationID)
+	if pqErr, isPq := err.(*pq.Error); isPq && pqErr.Code == "23505" {
+		return &IssueError{Message: "Публикация уже включена в выпуск"}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemovePublicationFromIssue убирает публикацию из выпуска; опустевший раздел удаляется
+func RemovePublicationFromIssue(issueID, publicationID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockIssue(tx, issueID, IssuePlanning); err != nil {
+		return err
+	}
+	var department string
+	err = tx.QueryRow(`DELETE FROM issue_publications WHERE issue_id = $1 AND publication_id = $2 RETURNING department`,
+		issueID, publicationID).Scan(&department)
+	if err == sql.ErrNoRows {
+		return &IssueError{Message: "Публикации нет в этом выпуске"}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM issue_sections s WHERE s.issue_id = $1 AND s.department = $2
+                      AND NOT EXISTS (SELECT 1 FROM issue_publications ip WHERE ip.issue_id = $1 AND ip.department = $2)`,
+		issueID, department)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveIssuePublication меняет публикацию местами с соседней в её разделе
+func MoveIssuePublication(issueID, publicationID int, up bool) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockIssue(tx, issueID, IssuePlanning); err != nil {
+		return err
+	}
+	var department string
+	var position int
+	err = tx.QueryRow(`SELECT department, position FROM issue_publications WHERE issue_id = $1 AND publication_id = $2`,
+		issueID, publicationID).Scan(&department, &position)
+	if err == sql.ErrNoRows {
+		return &IssueError{Message: "Публикации нет в этом выпуске"}
+	}
+	if err != nil {
+		return err
+	}
+
+	neighbourQuery := `SELECT publication_id, position FROM issue_publications
+                       WHERE issue_id = $1 AND department = $2 AND position > $3 ORDER BY position LIMIT 1`
+	if up {
+		neighbourQuery = `SELECT publication_id, position FROM issue_publications
+                          WHERE issue_id = $1 AND department = $2 AND position < $3 ORDER BY position DESC LIMIT 1`
+	}
+	var neighbourID, neighbourPosition int
+	err = tx.QueryRow(neighbourQuery, issueID, department, position).Scan(&neighbourID, &neighbourPosition)
+	if err == sql.ErrNoRows {
+		// Публикация уже первая или последняя в разделе
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE issue_publications SET position = $1 WHERE publication_id = $2`, neighbourPosition, publicationID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE issue_publications SET position = $1 WHERE publication_id = $2`, position, neighbourID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveIssueSection меняет раздел местами с соседним
+func MoveIssueSection(issueID int, department string, up bool) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockIssue(tx, issueID, IssuePlanning); err != nil {
+		return err
+	}
+	var position int
+	err = tx.QueryRow(`SELECT position FROM issue_sections WHERE issue_id = $1 AND department = $2`, issueID, department).Scan(&position)
+	if err == sql.ErrNoRows {
+		return &IssueError{Message: "Раздела нет в этом выпуске"}
+	}
+	if err != nil {
+		return err
+	}
+
+	neighbourQuery := `SELECT department, position FROM issue_sections
+                       WHERE issue_id = $1 AND position > $2 ORDER BY position LIMIT 1`
+	if up {
+		neighbourQuery = `SELECT department, position FROM issue_sections
+                          WHERE issue_id = $1 AND position < $2 ORDER BY position DESC LIMIT 1`
+	}
+	var neighbour string
+	var neighbourPosition int
+	err = tx.QueryRow(neighbourQuery, issueID, position).Scan(&neighbour, &neighbourPosition)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE issue_sections SET position = $1 WHERE issue_id = $2 AND department = $3`, neighbourPosition, issueID, department); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE issue_sections SET position = $1 WHERE issue_id = $2 AND department = $3`, position, issueID, neighbour); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetIssueLocked фиксирует состав выпуска или возвращает его к планированию
+func SetIssueLocked(issueID int, locked bool) error {
+	from, to := IssueLocked, IssuePlanning
+	if locked {
+		from, to = IssuePlanning, IssueLocked
+	}
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockIssue(tx, issueID, from); err != nil {
+		return err
+	}
+	if locked {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM issue_publications WHERE issue_id = $1`, issueID).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return &IssueError{Message: "Нельзя зафиксировать пустой выпуск"}
+		}
+	}
+	if _, err := tx.Exec(`UPDATE issues SET status = $1 WHERE id = $2`, to, issueID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReleaseIssue выпускает зафиксированный выпуск: все его публикации выкладываются в одной
+// транзакции. Если хотя бы одну публикацию выложить нельзя, не выкладывается ни одна.
+func ReleaseIssue(issueID int) ([]int, error) {
+	tx, err := Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockIssue(tx, issueID, IssueLocked); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT p.id, p.title, p.status, p.deleted_at IS NOT NULL,
+                                  COALESCE(p.embargo_until > NOW(), FALSE)
+                           FROM issue_publications ip JOIN publications p ON p.id = ip.publication_id
+                           WHERE ip.issue_id = $1
+                           FOR UPDATE OF p`, issueID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	var problems []string
+	for rows.Next() {
+		var id int
+		var title, status string
+		var deleted, embargoed bool
+		if err := rows.Scan(&id, &title, &status, &deleted, &embargoed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		switch {
+		case deleted:
+			problems = append(problems, "«"+title+"» удалена")
+		case status != "approved":
+			problems = append(problems, "«"+title+"» в статусе "+status)
+		case embargoed:
+			problems = append(problems, "«"+title+"» под эмбарго")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, &IssueError{Message: "В выпуске нет публикаций"}
+	}
+	if len(problems) > 0 {
+		return nil, &IssueError{Message: "Выпуск не может выйти: " + strings.Join(problems, "; ")}
+	}
+
+	_, err = tx.Exec(`UPDATE publications SET status = 'published', is_published = TRUE, published_at = NOW(), updated_at = NOW()
+                      WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE issues SET status = $1, released_at = NOW() WHERE id = $2`, IssueReleased, issueID); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+// writeIssueError отвечает 409 на ошибки планирования, 404 на отсутствующий выпуск и 500 на остальные
+func writeIssueError(w http.ResponseWriter, err error) {
+	var issueErr *IssueError
+	if errors.As(err, &issueErr) {
+		http.Error(w, issueErr.Message, http.StatusConflict)
+		return
+	}
+	if err == ErrIssueNotFound {
+		http.Error(w, "Выпуск не найден", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Ошибка при изменении выпуска: "+err.Error(), http.StatusInternalServerError)
+}
+
+// checkChiefEditor проверяет, что пользователь сессии — главный редактор. При ошибке ответ уже отправлен.
+func checkChiefEditor(w http.ResponseWriter, r *http.Request) (int, bool) {
+	editorID, _, ok := sessionRole(w, r, IsChiefEditorRole, "Выпусками управляет только главный редактор")
+	return editorID, ok
+}
+
+// issueAction проверяет POST-запрос главного редактора к выпуску. При ошибке ответ уже отправлен.
+func issueAction(w http.ResponseWriter, r *http.Request) (editorID, issueID int, ok bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return 0, 0, false
+	}
+	editorID, ok = checkChiefEditor(w, r)
+	if !ok {
+		return 0, 0, false
+	}
+	issueID, err := strconv.Atoi(r.FormValue("issue_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор выпуска", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return editorID, issueID, true
+}
+
+// redirectToIssue возвращает главного редактора на страницу выпуска
+func redirectToIssue(w http.ResponseWriter, r *http.Request, editorID, issueID int) {
+	http.Redirect(w, r, "/issue?id="+strconv.Itoa(editorID)+"&issue_id="+strconv.Itoa(issueID), http.StatusSeeOther)
+}
+
+// Список выпусков и создание нового
+func IssuesPage(w http.ResponseWriter, r *http.Request) {
+	editorID, ok := checkChiefEditor(w, r)
+	if !ok {
+		return
+	}
+
+	issues, err := GetIssues()
+	if err != nil {
+		http.Error(w, "Ошибка при получении выпусков: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nextNumber := 1
+	for _, i := range issues {
+		if i.Number >= nextNumber {
+			nextNumber = i.Number + 1
+		}
+	}
+
+	data := struct {
+		EditorID   int
+		Issues     []Issue
+		NextNumber int
+		Today      string
+	}{
+		EditorID:   editorID,
+		Issues:     issues,
+		NextNumber: nextNumber,
+		Today:      time.Now().Format("2006-01-02"),
+	}
+	if err := TmplIssues.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона", "error", err)
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// Создание выпуска
+func CreateIssueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	editorID, ok := checkChiefEditor(w, r)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(r.FormValue("number"))
+	if err != nil || number <= 0 {
+		http.Error(w, "Неверный номер выпуска", http.StatusBadRequest)
+		return
+	}
+	date, err := time.Parse("2006-01-02", r.FormValue("date"))
+	if err != nil {
+		http.Error(w, "Неверная дата выпуска", http.StatusBadRequest)
+		return
+	}
+
+	issueID, err := CreateIssue(number, date, strings.TrimSpace(r.FormValue("theme")), editorID)
+	if err != nil {
+		writeIssueError(w, err)
+		return
+	}
+	redirectToIssue(w, r, editorID, issueID)
+}
+
+// Страница выпуска: разделы, порядок публикаций и смена статуса
+func IssuePage(w http.ResponseWriter, r *http.Request) {
+	editorID, ok := checkChiefEditor(w, r)
+	if !ok {
+		return
+	}
+	issueID, err := strconv.Atoi(r.URL.Query().Get("issue_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор выпуска", http.StatusBadRequest)
+		return
+	}
+
+	issue, sections, err := GetIssue(issueID)
+	if err != nil {
+		writeIssueError(w, err)
+		return
+	}
+	var available []Publication
+	if issue.Status == IssuePlanning {
+		available, err = GetUnslottedPublications()
+		if err != nil {
+			http.Error(w, "Ошибка при получении публикаций: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := struct {
+		EditorID  int
+		Issue     Issue
+		Sections  []IssueSection
+		Available []Publication
+	}{
+		EditorID:  editorID,
+		Issue:     issue,
+		Sections:  sections,
+		Available: available,
+	}
+	if err := TmplIssue.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона", "error", err)
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// Включение публикации в выпуск и исключение из него
+func IssuePublicationHandler(w http.ResponseWriter, r *http.Request) {
+	editorID, issueID, ok := issueAction(w, r)
+	if !ok {
+		return
+	}
+	publicationID, err := strconv.Atoi(r.FormValue("article_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "add":
+		err = AddPublicationToIssue(issueID, publicationID)
+	case "remove":
+		err = RemovePublicationFromIssue(issueID, publicationID)
+	case "up", "down":
+		err = MoveIssuePublication(issueID, publicationID, r.FormValue("action") == "up")
+	default:
+		http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeIssueError(w, err)
+		return
+	}
+	redirectToIssue(w, r, editorID, issueID)
+}
+
+// Перемещение раздела выпуска
+func IssueSectionHandler(w http.ResponseWriter, r *http.Request) {
+	editorID, issueID, ok := issueAction(w, r)
+	if !ok {
+		return
+	}
+	action := r.FormValue("action")
+	if action != "up" && action != "down" {
+		http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+		return
+	}
+	if err := MoveIssueSection(issueID, r.FormValue("department"), action == "up"); err != nil {
+		writeIssueError(w, err)
+		return
+	}
+	redirectToIssue(w, r, editorID, issueID)
+}
+
+// Смена статуса выпуска: фиксация, возврат к планированию и выход
+func IssueStatusHandler(w http.ResponseWriter, r *http.Request) {
+	editorID, issueID, ok := issueAction(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "lock", "unlock":
+		if err := SetIssueLocked(issueID, r.FormValue("action") == "lock"); err != nil {
+			writeIssueError(w, err)
+			return
+		}
+	case "release":
+		published, err := ReleaseIssue(issueID)
+		if err != nil {
+			writeIssueError(w, err)
+			return
+		}
+		Logger(r.Context()).Info("Выпуск вышел", "issue_id", issueID, "published", len(published))
+		for _, id := range published {
+			publishPublicationEvent(r.Context(), EventStatusChanged, id, editorID, "Публикация выложена в составе выпуска")
+		}
+	default:
+		http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+		return
+	}
+	redirectToIssue(w, r, editorID, issueID)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// lockedIssueStore — база, в которой публикация 7 одобрена и входит в зафиксированный выпуск №12
+func lockedIssueStore() *fakeStore {
+	return &fakeStore{row: func(query string, _ []driver.Value) []driver.Value {
+		switch {
+		case strings.HasPrefix(query, "SELECT status FROM publications"):
+			return []driver.Value{"approved"}
+		case strings.HasPrefix(query, "SELECT author_id FROM publications"):
+			return []driver.Value{int64(3)}
+		case strings.HasPrefix(query, "SELECT i.number FROM issue_publications"):
+			return []driver.Value{int64(12)}
+		}
+		return nil
+	}}
+}
+
+func postForm(target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestLockedIssueFreezesArticles(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"выкладка":     PublishPublicationHandler,
+		"разрешение":   AllowPublicationHandler,
+		"планирование": SchedulePublicationHandler,
+		"доработка":    RequestRevisionHandler,
+	}
+	form := url.Values{
+		"article_id": {"7"},
+		"publish_at": {"2999-01-01T10:00"},
+		"remarks":    {"Переписать вывод"},
+	}
+	for name, handler := range handlers {
+		s := lockedIssueStore()
+		useFakeDb(t, s)
+		req := withSession(t, s, postForm("/", form), 5, RoleChiefEditor)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "выпуск №12") {
+			t.Errorf("%s: статус %d, ответ %q; ожидался 409 с номером выпуска", name, rec.Code, rec.Body.String())
+		}
+		for _, c := range s.calls {
+			if strings.HasPrefix(c.query, "UPDATE publications") {
+				t.Errorf("%s: статус публикации изменён: %s", name, c.query)
+			}
+		}
+	}
+}
+
+func TestSchedulerSkipsLockedIssues(t *testing.T) {
+	s := &fakeStore{row: func(string, []driver.Value) []driver.Value { return []driver.Value{} }}
+	useFakeDb(t, s)
+	if _, err := PublishDuePublications(); err != nil {
+		t.Fatal(err)
+	}
+	if update := s.find(t, "UPDATE publications"); !strings.Contains(update.query, "i.status = 'locked'") {
+		t.Errorf("планировщик не пропускает публикации зафиксированных выпусков: %s", update.query)
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Время жизни блокировки без продления. Страница редактора продлевает её каждые LockHeartbeat.
+const (
+	LockTTL       = 2 * time.Minute
+	LockHeartbeat = 30 * time.Second
+)
+
+// ErrLockLost — блокировка истекла или её забрал главный редактор
+var ErrLockLost = errors.New("блокировка редактирования потеряна")
+
+// Блокировка редактирования публикации
+type EditLock struct {
+	PublicationID int
+	UserID        int
+	UserName      string
+	AcquiredAt    time.Time
+	ExpiresAt     time.Time
+}
+
+// lockSelect выбирает действующие блокировки с именем редактора
+const lockSelect = `SELECT l.publication_id, l.user_id, COALESCE(NULLIF(p.full_name, ''), u.login), l.acquired_at, l.expires_at
+                    FROM publication_locks l
+                    JOIN users u ON u.id = l.user_id
+                    LEFT JOIN user_profiles p ON p.user_id = l.user_id
+                    WHERE l.expires_at > NOW()`
+
+// AcquireEditLock берёт блокировку публикации или продлевает собственную.
+// Если публикацию уже редактирует другой пользователь, возвращается его блокировка и ok = false.
+func AcquireEditLock(publicationID, userID int) (lock EditLock, ok bool, err error) {
+	result, err := Db.Exec(`INSERT INTO publication_locks (publication_id, user_id, acquired_at, expires_at)
+                            VALUES ($1, $2, NOW(), NOW() + make_interval(secs => $3))
+                            ON CONFLICT (publication_id) DO UPDATE
+                            SET user_id = EXCLUDED.user_id,
+                                acquired_at = CASE WHEN publication_locks.user_id = EXCLUDED.user_id
+                                                   THEN publication_locks.acquired_at ELSE EXCLUDED.acquired_at END,
+                                expires_at = EXCLUDED.expires_at
+                            WHERE publication_locks.user_id = EXCLUDED.user_id OR publication_locks.expires_at <= NOW()`,
+		publicationID, userID, LockTTL.Seconds())
+	if err != nil {
+		return lock, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return lock, false, err
+	}
+
+	err = Db.QueryRow(lockSelect+` AND l.publication_id = $1`, publicationID).
+		Scan(&lock.PublicationID, &lock.UserID, &lock.UserName, &lock.AcquiredAt, &lock.ExpiresAt)
+	if err != nil {
+		return lock, false, err
+	}
+	return lock, n > 0, nil
+}
+
+// HeartbeatEditLock продлевает блокировку, пока её держит пользователь
+func HeartbeatEditLock(publicationID, userID int) error {
+	result, err := Db.Exec(`UPDATE publication_locks SET expires_at = NOW() + make_interval(secs => $3)
+                            WHERE publication_id = $1 AND user_id = $2 AND expires_at > NOW()`,
+		publicationID, userID, LockTTL.Seconds())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// ReleaseEditLock снимает блокировку пользователя с публикации
+func ReleaseEditLock(publicationID, userID int) error {
+	_, err := Db.Exec(`DELETE FROM publication_locks WHERE publication_id = $1 AND user_id = $2`, publicationID, userID)
+	return err
+}
+
+// ReleaseUserLocks снимает все блокировки пользователя, например при выходе из системы
+func ReleaseUserLocks(userID int) error {
+	_, err := Db.Exec(`DELETE FROM publication_locks WHERE user_id = $1`, userID)
+	return err
+}
+
+// StealEditLock передаёт блокировку пользователю независимо от того, кто её держит
+func StealEditLock(publicationID, userID int) error {
+	_, err := Db.Exec(`INSERT INTO publication_locks (publication_id, user_id, acquired_at, expires_at)
+                       VALUES ($1, $2, NOW(), NOW() + make_interval(secs => $3))
+                       ON CONFLICT (publication_id) DO UPDATE
+                       SET user_id = EXCLUDED.user_id, acquired_at = EXCLUDED.acquired_at, expires_at = EXCLUDED.expires_at`,
+		publicationID, userID, LockTTL.Seconds())
+	return err
+}
+
+// attachLocks отмечает в списке публикаций, кто их сейчас редактирует
+func attachLocks(publications []Publication) error {
+	if len(publications) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(publications))
+	index := map[int]int{}
+	for i, pub := range publications {
+		ids[i] = int64(pub.ID)
+		index[pub.ID] = i
+	}
+
+	rows, err := Db.Query(lockSelect+` AND l.publication_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lock EditLock
+		if err := rows.Scan(&lock.PublicationID, &lock.UserID, &lock.UserName, &lock.AcquiredAt, &lock.ExpiresAt); err != nil {
+			return err
+		}
+		publications[index[lock.PublicationID]].EditLock = &lock
+	}
+	return rows.Err()
+}
+
+// lockForm разбирает идентификатор публикации из формы блокировки.
+// Владелец блокировки — пользователь сессии.
+func lockForm(w http.ResponseWriter, r *http.Request) (publicationID, userID int, ok bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return 0, 0, false
+	}
+	userID, ok = CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	publicationID, err := strconv.Atoi(r.FormValue("publication_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор публикации", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return publicationID, userID, true
+}
+
+// Продление блокировки со страницы редактирования. 409 означает, что блокировка потеряна.
+func LockHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	publicationID, userID, ok := lockForm(w, r)
+	if !ok {
+		return
+	}
+	err := HeartbeatEditLock(publicationID, userID)
+	if err == ErrLockLost {
+		http.Error(w, "Блокировка потеряна: публикацию редактирует другой пользователь", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка продления блокировки", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Снятие блокировки при уходе со страницы редактирования
+func LockReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	publicationID, userID, ok := lockForm(w, r)
+	if !ok {
+		return
+	}
+	if err := ReleaseEditLock(publicationID, userID); err != nil {
+		http.Error(w, "Ошибка снятия блокировки", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Главный редактор забирает блокировку публикации себе
+func StealLockHandler(w http.ResponseWriter, r *http.Request) {
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
+		return
+	}
+	if !IsChiefEditorRole(role) {
+		http.Error(w, "Забрать блокировку может только главный редактор", http.StatusForbidden)
+		return
+	}
+
+	err := StealEditLock(articleID, editorID)
+	if pqErr, isPq := err.(*pq.Error); isPq && pqErr.Code == "23503" {
+		http.Error(w, "Публикация не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при передаче блокировки: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	Logger(r.Context()).Info("Главный редактор забрал блокировку", "editor_id", editorID, "publication_id", articleID)
+
+	redirectToEditorPage(w, r, role, editorID)
+}
+
+// releaseLockAfterSave снимает блокировку после сохранения; ошибка не мешает сохранению
+func releaseLockAfterSave(ctx context.Context, publicationID, userID int) {
+	if err := ReleaseEditLock(publicationID, userID); err != nil {
+		Logger(ctx).Error("Ошибка снятия блокировки публикации", "publication_id", publicationID, "error", err)
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditPublicationLockNeedsSession(t *testing.T) {
+	s := &fakeStore{}
+	useFakeDb(t, s)
+
+	// Чужой user_id в адресе не даёт ни открыть редактор, ни взять блокировку
+	req := httptest.NewRequest(http.MethodGet, "/author/edit_publication?publication_id=7&user_id=3", nil)
+	rec := httptest.NewRecorder()
+	EditPublicationHandler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("без сессии: статус %d, ожидался 401", rec.Code)
+	}
+	for _, c := range s.calls {
+		if strings.Contains(c.query, "publication_locks") {
+			t.Errorf("блокировка взята без сессии: %s", c.query)
+		}
+	}
+}
+
+// lockTable — блокировка публикации 7 в поддельной базе: кто её держит и истекла ли она
+type lockTable struct {
+	holder  int64
+	expired bool
+}
+
+// store отвечает на запросы блокировок так же, как их условия в PostgreSQL
+func (l *lockTable) store() *fakeStore {
+	return &fakeStore{
+		affected: func(query string, args []driver.Value) int64 {
+			switch {
+			case strings.HasPrefix(query, "INSERT INTO publication_locks"):
+				// Взять блокировку можно, если она свободна, своя или истекла; забрать — всегда
+				free := l.holder == 0 || l.holder == args[1] || l.expired
+				if !free && strings.Contains(query, "WHERE publication_locks.user_id = EXCLUDED.user_id") {
+					return 0
+				}
+				l.holder, l.expired = args[1].(int64), false
+			case strings.HasPrefix(query, "UPDATE publication_locks"):
+				if l.holder != args[1] || l.expired {
+					return 0
+				}
+			case strings.HasPrefix(query, "DELETE FROM publication_locks"):
+				if l.holder != args[1] {
+					return 0
+				}
+				l.holder = 0
+			}
+			return 1
+		},
+		row: func(query string, _ []driver.Value) []driver.Value {
+			if !strings.HasPrefix(query, "SELECT l.publication_id, l.user_id") {
+				return nil
+			}
+			if l.holder == 0 || l.expired {
+				return []driver.Value{}
+			}
+			now := time.Now()
+			return []driver.Value{int64(7), l.holder, "Редактор " + strconv.FormatInt(l.holder, 10), now, now.Add(LockTTL)}
+		},
+	}
+}
+
+func TestAcquireEditLock(t *testing.T) {
+	locks := &lockTable{}
+	useFakeDb(t, locks.store())
+
+	if lock, ok, err := AcquireEditLock(7, 2); err != nil || !ok || lock.UserID != 2 {
+		t.Fatalf("свободная публикация: блокировка %+v, взята %v, ошибка %v", lock, ok, err)
+	}
+	if _, ok, err := AcquireEditLock(7, 2); err != nil || !ok {
+		t.Errorf("своя блокировка не продлевается: взята %v, ошибка %v", ok, err)
+	}
+
+	// Второй автор видит, кто правит публикацию, и блокировку не получает
+	lock, ok, err := AcquireEditLock(7, 4)
+	if err != nil || ok || lock.UserID != 2 || lock.UserName != "Редактор 2" {
+		t.Errorf("занятая публикация: блокировка %+v, взята %v, ошибка %v", lock, ok, err)
+	}
+	if err := HeartbeatEditLock(7, 4); err != ErrLockLost {
+		t.Errorf("чужая блокировка продлена: %v", err)
+	}
+
+	// Истёкшая блокировка достаётся следующему
+	locks.expired = true
+	if lock, ok, err := AcquireEditLock(7, 4); err != nil || !ok || lock.UserID != 4 {
+		t.Errorf("истёкшая блокировка: %+v, взята %v, ошибка %v", lock, ok, err)
+	}
+}
+
+func TestStealLockHandler(t *testing.T) {
+	locks := &lockTable{holder: 2}
+	form := url.Values{"article_id": {"7"}}
+
+	// Редактор раздела забрать блокировку не может
+	s := locks.store()
+	useFakeDb(t, s)
+	rec := httptest.NewRecorder()
+	StealLockHandler(rec, withSession(t, s, postForm("/steal_lock", form), 3, RoleSectionEditor))
+	if rec.Code != http.StatusForbidden || locks.holder != 2 {
+		t.Fatalf("редактор раздела: статус %d, блокировку держит %d", rec.Code, locks.holder)
+	}
+
+	s = locks.store()
+	useFakeDb(t, s)
+	rec = httptest.NewRecorder()
+	StealLockHandler(rec, withSession(t, s, postForm("/steal_lock", form), 5, RoleChiefEditor))
+	if rec.Code != http.StatusSeeOther || locks.holder != 5 {
+		t.Fatalf("главный редактор: статус %d, блокировку держит %d", rec.Code, locks.holder)
+	}
+
+	// Автор узнаёт о потере блокировки при следующем продлении
+	s = locks.store()
+	useFakeDb(t, s)
+	rec = httptest.NewRecorder()
+	LockHeartbeatHandler(rec, withSession(t, s, postForm("/lock/heartbeat", url.Values{"publication_id": {"7"}}), 2, RoleAuthor))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("продление отобранной блокировки: статус %d, ожидался 409", rec.Code)
+	}
+	if _, ok, _ := AcquireEditLock(7, 2); ok {
+		t.Error("автор снова взял блокировку главного редактора")
+	}
+}
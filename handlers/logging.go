@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+var TmplServerError = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "server_error.html"))
+
+// Номер запроса, пришедший от балансировщика, принимается только в таком виде
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type logContextKey struct{}
+
+// requestInfo — сведения о запросе, которые дополняются по ходу обработки
+type requestInfo struct {
+	id     string
+	logger *slog.Logger
+	userID int
+}
+
+// SetupLogging направляет журнал сервера, включая вызовы пакета log, в JSON на stderr.
+// Уровень задаётся переменной LOG_LEVEL: debug, info, warn или error.
+func SetupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
+
+// Logger возвращает журнал запроса с его номером, а вне запроса — общий журнал
+func Logger(ctx context.Context) *slog.Logger {
+	if info, ok := ctx.Value(logContextKey{}).(*requestInfo); ok {
+		return info.logger
+	}
+	return slog.Default()
+}
+
+// RequestID возвращает номер текущего запроса или пустую строку вне запроса
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(logContextKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// setRequestUser запоминает пользователя запроса для журнала
+func setRequestUser(ctx context.Context, userID int) {
+	if info, ok := ctx.Value(logContextKey{}).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestMiddleware присваивает запросу номер, перехватывает панику и записывает итог запроса в журнал.
+// Номер передаётся клиенту в заголовке X-Request-ID и попадает во все записи журнала этого запроса.
+func RequestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRe.MatchString(id) {
+			id = newRequestID()
+		}
+		info := &requestInfo{id: id, logger: slog.Default().With("request_id", id)}
+		r = r.WithContext(context.WithValue(r.Context(), logContextKey{}, info))
+		w.Header().Set("X-Request-ID", id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int64("bytes", rec.bytes),
+				slog.String("remote", r.RemoteAddr),
+			}
+			if info.userID != 0 {
+				attrs = append(attrs, slog.Int("user_id", info.userID))
+			}
+			info.logger.LogAttrs(r.Context(), level, "Запрос обработан", attrs...)
+			// Шаблон маршрута ServeMux записывает в запрос при выборе обработчика
+			observeHTTPRequest(r.Pattern, r.Method, status, time.Since(start))
+		}()
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Обработчик сам прервал ответ, соединение закрывает сервер
+				rec.status = 499
+				panic(v)
+			}
+			info.logger.Error("Паника при обработке запроса", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if rec.status != 0 {
+				// Ответ уже начат, заменить его страницей ошибки нельзя
+				rec.status = http.StatusInternalServerError
+				return
+			}
+			rec.Header().Set("Content-Type", "text/html; charset=utf-8")
+			rec.Header().Del("Content-Disposition")
+			rec.WriteHeader(http.StatusInternalServerError)
+			TmplServerError.Execute(rec, struct{ RequestID string }{id})
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder запоминает код и размер ответа. Потоковая передача и переход на WebSocket
+// продолжают работать через него так же, как с исходным ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("соединение не поддерживает перехват")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap нужен http.ResponseController, чтобы добраться до исходного ResponseWriter
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
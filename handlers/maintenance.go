@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Статусы публикаций, которые встречаются в редакционном процессе
+var PublicationStatuses = []string{
+	"draft", "pending", "under_review", "revision", "approved",
+	"scheduled", "ready_for_publication", "published", "unpublished",
+}
+
+var ErrPublicationNotFound = errors.New("публикация не найдена")
+
+// ListPublications возвращает неудалённые публикации, начиная с недавно изменённых; пустой статус — все
+func ListPublications(status string, limit int) ([]Publication, error) {
+	rows, err := Db.Query(`SELECT p.id, p.title, p.status, COALESCE(p.department, ''), COALESCE(p.is_published, FALSE),
+                                  COALESCE(p.author_id, 0), COALESCE(NULLIF(pr.full_name, ''), u.login, ''), p.updated_at, p.scheduled_at
+                           FROM publications p
+                           LEFT JOIN users u ON u.id = p.author_id
+                           LEFT JOIN user_profiles pr ON pr.user_id = p.author_id
+                           WHERE p.deleted_at IS NULL AND ($1 = '' OR p.status = $1)
+                           ORDER BY p.updated_at DESC NULLS LAST, p.id DESC
+                           LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publications []Publication
+	for rows.Next() {
+		var p Publication
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Title, &p.Status, &p.Department, &p.IsPublished,
+			&p.AuthorID, &p.AuthorName, &updatedAt, &p.ScheduledAt); err != nil {
+			return nil, err
+		}
+		p.UpdatedAt = updatedAt.Time
+		publications = append(publications, p)
+	}
+	return publications, rows.Err()
+}
+
+// TransitionPublication переводит публикацию в другой статус в обход обычного процесса,
+// сохраняя версию с пояснением. Признак выкладки приводится в соответствие со статусом.
+func TransitionPublication(publicationID int, status, note string) error {
+	known := false
+	for _, s := range PublicationStatuses {
+		known = known || s == status
+	}
+	if !known {
+		return &UserValidationError{Message: "Неизвестный статус публикации: " + status}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	var scheduledAt sql.NullTime
+	err = tx.QueryRow(`SELECT status, scheduled_at FROM publications WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, publicationID).
+		Scan(&current, &scheduledAt)
+	if err == sql.ErrNoRows {
+		return ErrPublicationNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if current == status {
+		return &UserValidationError{Message: "Публикация уже в статусе " + status}
+	}
+	if status == "scheduled" && !scheduledAt.Valid {
+		return &UserValidationError{Message: "У публикации не задано время выкладки"}
+	}
+
+	published := status == "published" || status == "ready_for_publication"
+	_, err = tx.Exec(`UPDATE publications
+                      SET status = $1, is_published = $2, updated_at = NOW(),
+                          published_at = CASE WHEN $2 THEN COALESCE(published_at, NOW()) ELSE published_at END,
+                          unpublished_at = CASE WHEN $1 = 'unpublished' THEN NOW() ELSE unpublished_at END
+                      WHERE id = $3`, status, published, publicationID)
+	if err != nil {
+		return err
+	}
+	if note == "" {
+		note = "Смена статуса администратором"
+	}
+	if _, err := SaveRevision(tx, publicationID, 0, note); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publishPublicationEvent(context.Background(), EventStatusChanged, publicationID, 0, fmt.Sprintf("Статус изменён: %s → %s", current, status))
+	return nil
+}
+
+// ConsistencyProblem — нарушение целостности данных и идентификаторы затронутых объектов
+type ConsistencyProblem struct {
+	Check string
+	IDs   []int64
+}
+
+// Проверки целостности: каждый запрос возвращает идентификаторы объектов с нарушением
+var consistencyChecks = []struct {
+	Name  string
+	Query string
+}{
+	{"Публикации, автор которых удалён или не существует",
+		`SELECT p.id FROM publications p LEFT JOIN users u ON u.id = p.author_id
+         WHERE p.deleted_at IS NULL AND p.author_id IS NOT NULL AND (u.id IS NULL OR u.deleted_at IS NOT NULL)`},
+	{"Публикации без участников в подписи",
+		`SELECT p.id FROM publications p
+         WHERE p.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM publication_contributors c WHERE c.publication_id = p.id)`},
+	{"Публикации без сохранённых версий",
+		`SELECT p.id FROM publications p
+         WHERE p.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM publication_revisions r WHERE r.publication_id = p.id)`},
+	{"Признак выкладки не соответствует статусу",
+		`SELECT id FROM publications
+         WHERE deleted_at IS NULL
+           AND COALESCE(is_published, FALSE) <> (status IN ('published', 'ready_for_publication'))`},
+	{"Запланированные публикации без времени выкладки",
+		`SELECT id FROM publications WHERE deleted_at IS NULL AND status = 'scheduled' AND scheduled_at IS NULL`},
+	{"Запланированные публикации, время которых давно прошло",
+		`SELECT id FROM publications
+         WHERE deleted_at IS NULL AND status = 'scheduled'
+           AND scheduled_at < NOW() - INTERVAL '1 hour' AND (embargo_until IS NULL OR embargo_until < NOW())`},
+	{"Номер версии публикации меньше номера сохранённой версии",
+		`SELECT p.id FROM publications p
+         WHERE p.version < (SELECT COALESCE(MAX(r.version), 0) FROM publication_revisions r WHERE r.publication_id = p.id)`},
+	{"Публикации в выпусках, удалённые в корзину",
+		`SELECT ip.publication_id FROM issue_publications ip JOIN publications p ON p.id = ip.publication_id
+         WHERE p.deleted_at IS NOT NULL`},
+	{"Публикации в вышедших выпусках, которые не выложены",
+		`SELECT ip.publication_id FROM issue_publications ip
+         JOIN issues i ON i.id = ip.issue_id JOIN publications p ON p.id = ip.publication_id
+         WHERE i.status = 'released' AND p.deleted_at IS NULL AND NOT COALESCE(p.is_published, FALSE)`},
+	{"Действующие назначения тем, удалённых в корзину",
+		`SELECT a.id FROM topic_assignments a JOIN user_topics t ON t.id = a.topic_id
+         WHERE t.deleted_at IS NOT NULL AND a.status IN ('assigned', 'accepted')`},
+	{"Блокировки редактирования, удерживаемые удалёнными или деактивированными пользователями",
+		`SELECT l.publication_id FROM publication_locks l JOIN users u ON u.id = l.user_id
+         WHERE u.deleted_at IS NOT NULL OR NOT u.is_active`},
+}
+
+// CheckConsistency выполняет все проверки целостности и возвращает найденные нарушения
+func CheckConsistency() ([]ConsistencyProblem, error) {
+	var problems []ConsistencyProblem
+	for _, check := range consistencyChecks {
+		var ids pq.Int64Array
+		if err := Db.QueryRow(`SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM (` + check.Query + `) AS found (id)`).Scan(&ids); err != nil {
+			return nil, fmt.Errorf("%s: %w", check.Name, err)
+		}
+		if len(ids) > 0 {
+			problems = append(problems, ConsistencyProblem{Check: check.Name, IDs: ids})
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Текст публикации хранится как обычный текст: строка — абзац, пустая строка — отбивка.
+// Оформление, пришедшее из Word, передаётся лёгкой разметкой:
+//
+//	# Заголовок, ## Подзаголовок, ### Подраздел
+//	- пункт маркированного списка
+//	1. пункт нумерованного списка
+//	**полужирный** и *курсив* внутри строки
+//	![подпись](image:имя) — изображение публикации отдельной строкой
+const (
+	markupParagraph = "paragraph"
+	markupHeading   = "heading"
+	markupBullet    = "bullet"
+	markupNumber    = "number"
+	markupImage     = "image"
+)
+
+var (
+	markupImageRe  = regexp.MustCompile(`^!\[([^\]]*)\]\(image:([^)\s]+)\)$`)
+	markupNumberRe = regexp.MustCompile(`^\d+[.)] `)
+)
+
+// markupRun — фрагмент строки с одинаковым начертанием
+type markupRun struct {
+	Text   string
+	Bold   bool
+	Italic bool
+}
+
+// markupBlock — абзац, заголовок, пункт списка или изображение
+type markupBlock struct {
+	Kind  string
+	Level int
+	Runs  []markupRun
+	Image string
+	Alt   string
+}
+
+// parseMarkup разбирает текст публикации на блоки, пропуская пустые строки
+func parseMarkup(content string) []markupBlock {
+	var blocks []markupBlock
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			continue
+		}
+		if m := markupImageRe.FindStringSubmatch(line); m != nil {
+			blocks = append(blocks, markupBlock{Kind: markupImage, Image: m[2], Alt: m[1]})
+			continue
+		}
+
+		block := markupBlock{Kind: markupParagraph}
+		if level := len(line) - len(strings.TrimLeft(line, "#")); level >= 1 && level <= 3 && strings.HasPrefix(line[level:], " ") {
+			block.Kind, block.Level = markupHeading, level
+			line = line[level+1:]
+		} else if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") {
+			block.Kind = markupBullet
+			line = line[2:]
+		} else if loc := markupNumberRe.FindStringIndex(line); loc != nil {
+			block.Kind = markupNumber
+			line = line[loc[1]:]
+		}
+		block.Runs = parseInlineMarkup(strings.TrimSpace(line))
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// parseInlineMarkup разбивает строку на фрагменты по ** и *; непарные звёздочки остаются текстом
+func parseInlineMarkup(s string) []markupRun {
+	var runs []markupRun
+	var bold, italic bool
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			runs = append(runs, markupRun{Text: cur.String(), Bold: bold, Italic: italic})
+			cur.Reset()
+		}
+	}
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "**") && (bold || strings.Contains(s[i+2:], "**")) {
+			flush()
+			bold = !bold
+			i += 2
+			continue
+		}
+		if s[i] == '*' && (italic || strings.Contains(strings.ReplaceAll(s[i+1:], "**", ""), "*")) {
+			flush()
+			italic = !italic
+			i++
+			continue
+		}
+		cur.WriteByte(s[i])
+		i++
+	}
+	flush()
+	return runs
+}
+
+// formatInlineMarkup собирает строку из фрагментов, объединяя соседние с одинаковым начертанием.
+// Пробелы по краям фрагмента выносятся за маркеры, иначе разметка не распознается.
+func formatInlineMarkup(runs []markupRun) string {
+	var merged []markupRun
+	for _, run := range runs {
+		if run.Text == "" {
+			continue
+		}
+		if n := len(merged); n > 0 && merged[n-1].Bold == run.Bold && merged[n-1].Italic == run.Italic {
+			merged[n-1].Text += run.Text
+			continue
+		}
+		merged = append(merged, run)
+	}
+
+	var b strings.Builder
+	for _, run := range merged {
+		text := strings.TrimSpace(run.Text)
+		if text == "" || (!run.Bold && !run.Italic) {
+			b.WriteString(run.Text)
+			continue
+		}
+		marker := ""
+		if run.Bold {
+			marker += "**"
+		}
+		if run.Italic {
+			marker += "*"
+		}
+		start := strings.Index(run.Text, text)
+		b.WriteString(run.Text[:start])
+		b.WriteString(marker + text + marker)
+		b.WriteString(run.Text[start+len(text):])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// formatMarkupBlock превращает блок обратно в строку текста публикации
+func formatMarkupBlock(block markupBlock, number int) string {
+	switch block.Kind {
+	case markupImage:
+		alt := strings.NewReplacer("[", "", "]", "", "\n", " ").Replace(block.Alt)
+		return "![" + alt + "](image:" + block.Image + ")"
+	case markupHeading:
+		return strings.Repeat("#", block.Level) + " " + formatInlineMarkup(block.Runs)
+	case markupBullet:
+		return "- " + formatInlineMarkup(block.Runs)
+	case markupNumber:
+		return strconv.Itoa(number) + ". " + formatInlineMarkup(block.Runs)
+	}
+	return formatInlineMarkup(block.Runs)
+}
+
+// markupHTML переводит текст публикации в HTML, который годится и для XHTML страниц EPUB.
+// Заголовки разметки становятся h2–h4: h1 на странице занимает название публикации.
+// imageSrc возвращает адрес изображения по имени; изображения без адреса пропускаются.
+func markupHTML(content string, imageSrc func(name string) (string, bool)) string {
+	var b strings.Builder
+	list := ""
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	for _, block := range parseMarkup(content) {
+		tag := ""
+		switch block.Kind {
+		case markupBullet:
+			tag = "ul"
+		case markupNumber:
+			tag = "ol"
+		}
+		if tag != list {
+			closeList()
+			if tag != "" {
+				b.WriteString("<" + tag + ">\n")
+				list = tag
+			}
+		}
+
+		switch block.Kind {
+		case markupImage:
+			src, ok := imageSrc(block.Image)
+			if !ok {
+				continue
+			}
+			b.WriteString(`<figure><img src="` + xmlEscape(src) + `" alt="` + xmlEscape(block.Alt) + `"/>`)
+			if block.Alt != "" {
+				b.WriteString("<figcaption>" + xmlEscape(block.Alt) + "</figcaption>")
+			}
+			b.WriteString("</figure>\n")
+		case markupHeading:
+			h := "h" + strconv.Itoa(block.Level+1)
+			b.WriteString("<" + h + ">" + inlineMarkupHTML(block.Runs) + "</" + h + ">\n")
+		case markupBullet, markupNumber:
+			b.WriteString("<li>" + inlineMarkupHTML(block.Runs) + "</li>\n")
+		default:
+			b.WriteString("<p>" + inlineMarkupHTML(block.Runs) + "</p>\n")
+		}
+	}
+	closeList()
+	return b.String()
+}
+
+// inlineMarkupHTML выводит фрагменты строки с полужирным и курсивом
+func inlineMarkupHTML(runs []markupRun) string {
+	var b strings.Builder
+	for _, run := range runs {
+		text := xmlEscape(run.Text)
+		if run.Italic {
+			text = "<em>" + text + "</em>"
+		}
+		if run.Bold {
+			text = "<strong>" + text + "</strong>"
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
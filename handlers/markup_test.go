@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkupHTML(t *testing.T) {
+	src := func(name string) (string, bool) {
+		return "/img?name=" + name + "&x=1", name != "missing"
+	}
+	tests := []struct {
+		name, content, want string
+	}{
+		{"абзацы", "Первый\n\nВторой", "<p>Первый</p>\n<p>Второй</p>\n"},
+		{"заголовки", "# Раз\n### Три", "<h2>Раз</h2>\n<h4>Три</h4>\n"},
+		{"выделение", "**полу** и *курсив*", "<p><strong>полу</strong> и <em>курсив</em></p>\n"},
+		{"непарная звёздочка", "2 * 3", "<p>2 * 3</p>\n"},
+		{"списки подряд", "- а\n- б\n1. в\nтекст", "<ul>\n<li>а</li>\n<li>б</li>\n</ul>\n<ol>\n<li>в</li>\n</ol>\n<p>текст</p>\n"},
+		{"изображение", "![Подпись](image:a.png)", `<figure><img src="/img?name=a.png&amp;x=1" alt="Подпись"/><figcaption>Подпись</figcaption></figure>` + "\n"},
+		{"изображение без подписи", "![](image:a.png)", `<figure><img src="/img?name=a.png&amp;x=1" alt=""/></figure>` + "\n"},
+		{"изображения нет", "![Подпись](image:missing)", ""},
+		{"экранирование", `<script>alert("x")</script> & **<b>**`, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; <strong>&lt;b&gt;</strong></p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markupHTML(tt.content, src); got != tt.want {
+				t.Errorf("получено\n%s\nожидалось\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublicationContentHTML(t *testing.T) {
+	p := Publication{ID: 3, Content: "![Фото](image:a&b.png)\n# <Заголовок>"}
+	got := string(p.ContentHTML())
+	if !strings.Contains(got, `src="/publication/image?publication_id=3&amp;name=a%26b.png"`) {
+		t.Errorf("неверный адрес изображения: %s", got)
+	}
+	if !strings.Contains(got, "<h2>&lt;Заголовок&gt;</h2>") {
+		t.Errorf("заголовок не экранирован: %s", got)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import "strings"
+
+// Строка сравнения двух текстов
+type DiffLine struct {
+	Kind string // same, added, removed
+	Text string
+}
+
+// Маркеры конфликтующих фрагментов при слиянии
+const (
+	mergeMarkerOurs   = "<<<<<<< ваша версия"
+	mergeMarkerSep    = "======="
+	mergeMarkerTheirs = ">>>>>>> сохранённая версия"
+)
+
+// splitLines делит текст на строки, не различая переводы строк \r\n и \n
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// lcsMatch находит наибольшую общую подпоследовательность строк
+// и возвращает для каждой строки a индекс совпавшей строки b или -1
+func lcsMatch(a, b []string) []int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	match := make([]int, len(a))
+	for i := range match {
+		match[i] = -1
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			match[i] = j
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+// DiffLines построчно сравнивает старый и новый текст
+func DiffLines(oldText, newText string) []DiffLine {
+	a, b := splitLines(oldText), splitLines(newText)
+	match := lcsMatch(a, b)
+
+	var diff []DiffLine
+	j := 0
+	for i, line := range a {
+		if match[i] < 0 {
+			diff = append(diff, DiffLine{Kind: "removed", Text: line})
+			continue
+		}
+		for ; j < match[i]; j++ {
+			diff = append(diff, DiffLine{Kind: "added", Text: b[j]})
+		}
+		diff = append(diff, DiffLine{Kind: "same", Text: line})
+		j++
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Kind: "added", Text: b[j]})
+	}
+	return diff
+}
+
+// equalLines сравнивает два набора строк
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeTexts выполняет трёхстороннее построчное слияние: base — общая исходная версия,
+// ours — версия пользователя, theirs — уже сохранённая. Непересекающиеся правки объединяются,
+// пересекающиеся помечаются маркерами конфликта. Возвращает текст и число конфликтов.
+func MergeTexts(base, ours, theirs string) (string, int) {
+	b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+	matchO, matchT := lcsMatch(b, o), lcsMatch(b, t)
+
+	var merged []string
+	conflicts := 0
+	mergeChunk := func(bc, oc, tc []string) {
+		switch {
+		case equalLines(oc, bc):
+			merged = append(merged, tc...)
+		case equalLines(tc, bc), equalLines(oc, tc):
+			merged = append(merged, oc...)
+		default:
+			conflicts++
+			merged = append(merged, mergeMarkerOurs)
+			merged = append(merged, oc...)
+			merged = append(merged, mergeMarkerSep)
+			merged = append(merged, tc...)
+			merged = append(merged, mergeMarkerTheirs)
+		}
+	}
+
+	i, j, k := 0, 0, 0
+	for m := range b {
+		// Строка исходной версии, сохранённая в обеих правках, служит опорной точкой
+		if matchO[m] < 0 || matchT[m] < 0 {
+			continue
+		}
+		mergeChunk(b[i:m], o[j:matchO[m]], t[k:matchT[m]])
+		merged = append(merged, b[m])
+		i, j, k = m+1, matchO[m]+1, matchT[m]+1
+	}
+	mergeChunk(b[i:], o[j:], t[k:])
+
+	return strings.Join(merged, "\n"), conflicts
+}
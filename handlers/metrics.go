@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Метрики отдаются на /metrics в текстовом формате Prometheus. Счётчики запросов, входов и решений
+// редакторов копятся в памяти экземпляра; остальное считается из базы в момент опроса.
+
+// Границы корзин гистограмм в секундах
+var (
+	httpDurationBuckets  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	stateDurationBuckets = []float64{
+		60, 5 * 60, 15 * 60, 60 * 60, 4 * 3600, 12 * 3600,
+		24 * 3600, 2 * 24 * 3600, 7 * 24 * 3600, 30 * 24 * 3600,
+	}
+)
+
+var (
+	httpRequestDuration = newHistogramVec("http_request_duration_seconds",
+		"Время обработки HTTP-запросов по маршрутам", httpDurationBuckets, "route", "method", "status")
+	loginAttempts = newCounterVec("editorial_login_attempts_total",
+		"Попытки входа по результату", "result")
+	editorDecisions = newCounterVec("editorial_editor_decisions_total",
+		"Одобрения и возвраты на доработку по редакторам", "editor_id", "decision")
+)
+
+// Результаты попыток входа
+const (
+	loginSuccess       = "success"
+	loginWrongPassword = "wrong_password"
+	loginInactive      = "inactive"
+	loginUnknownUser   = "unknown_user"
+	loginError         = "error"
+)
+
+// Решения редактора по публикации
+const (
+	decisionApprove  = "approve"
+	decisionRevision = "revision"
+)
+
+// Методы, которые попадают в метки как есть; остальные считаются вместе, чтобы не плодить ряды
+var metricMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// observeHTTPRequest учитывает запрос; маршрут — шаблон ServeMux, а не путь, иначе число рядов не ограничено
+func observeHTTPRequest(route, method string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	if !metricMethods[method] {
+		method = "OTHER"
+	}
+	httpRequestDuration.Observe(d.Seconds(), route, method, strconv.Itoa(status))
+}
+
+// counterVec — счётчики с набором меток
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, series: map[string]*counterSeries{}}
+}
+
+func (c *counterVec) Inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.Join(values, "\xff")
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: values}
+		c.series[key] = s
+	}
+	s.value++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeMetricHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.values), formatMetricValue(s.value))
+	}
+}
+
+// histogramVec — гистограммы с набором меток
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // по корзинам, не накопительно
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, labels: labels, series: map[string]*histogramSeries{}}
+}
+
+func (h *histogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(values, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		writeHistogram(w, h.name, h.labels, s.values, h.buckets, s.counts, s.count, s.sum)
+	}
+}
+
+// writeHistogram выводит ряды одной гистограммы; counts — число наблюдений в каждой корзине
+func writeHistogram(w io.Writer, name string, labels, values []string, buckets []float64, counts []uint64, count uint64, sum float64) {
+	bucketLabels := append(append([]string{}, labels...), "le")
+	var cumulative uint64
+	for i, bound := range buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(bucketLabels, append(append([]string{}, values...), formatMetricValue(bound))), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(bucketLabels, append(append([]string{}, values...), "+Inf")), count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labels, values), formatMetricValue(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labels, values), count)
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, kind)
+}
+
+func writeGauge(w io.Writer, name string, value float64) {
+	fmt.Fprintf(w, "%s %s\n", name, formatMetricValue(value))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + labelValueEscaper.Replace(values[i]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeDBPoolMetrics выводит состояние пула соединений с базой
+func writeDBPoolMetrics(w io.Writer) {
+	stats := Db.Stats()
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"db_pool_max_open_connections", "Предел открытых соединений с базой", float64(stats.MaxOpenConnections)},
+		{"db_pool_open_connections", "Открытые соединения с базой", float64(stats.OpenConnections)},
+		{"db_pool_in_use_connections", "Занятые соединения с базой", float64(stats.InUse)},
+		{"db_pool_idle_connections", "Свободные соединения с базой", float64(stats.Idle)},
+	}
+	for _, g := range gauges {
+		writeMetricHeader(w, g.name, g.help, "gauge")
+		writeGauge(w, g.name, g.value)
+	}
+	counters := []struct {
+		name, help string
+		value      float64
+	}{
+		{"db_pool_wait_count_total", "Сколько раз пришлось ждать свободного соединения", float64(stats.WaitCount)},
+		{"db_pool_wait_duration_seconds_total", "Общее время ожидания свободного соединения", stats.WaitDuration.Seconds()},
+		{"db_pool_max_idle_closed_total", "Соединения, закрытые из-за предела свободных", float64(stats.MaxIdleClosed)},
+		{"db_pool_max_lifetime_closed_total", "Соединения, закрытые по сроку жизни", float64(stats.MaxLifetimeClosed)},
+	}
+	for _, c := range counters {
+		writeMetricHeader(w, c.name, c.help, "counter")
+		writeGauge(w, c.name, c.value)
+	}
+}
+
+// writePublicationMetrics выводит число публикаций и возраст самой старой в каждом статусе
+func writePublicationMetrics(w io.Writer) error {
+	rows, err := Db.Query(`SELECT status, COUNT(*), EXTRACT(EPOCH FROM NOW() - MIN(status_changed_at))::float8
+                           FROM publications WHERE deleted_at IS NULL AND status IS NOT NULL
+                           GROUP BY status`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	counts := map[string]float64{}
+	oldest := map[string]float64{}
+	for _, status := range PublicationStatuses {
+		counts[status], oldest[status] = 0, 0
+	}
+	for rows.Next() {
+		var status string
+		var count, age float64
+		if err := rows.Scan(&status, &count, &age); err != nil {
+			return err
+		}
+		counts[status], oldest[status] = count, math.Max(age, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	writeMetricHeader(w, "editorial_publications", "Публикации по статусам", "gauge")
+	for _, status := range sortedKeys(counts) {
+		fmt.Fprintf(w, "editorial_publications%s %s\n", formatLabels([]string{"status"}, []string{status}), formatMetricValue(counts[status]))
+	}
+	writeMetricHeader(w, "editorial_publication_oldest_in_status_seconds", "Сколько находится в статусе самая давняя публикация", "gauge")
+	for _, status := range sortedKeys(oldest) {
+		fmt.Fprintf(w, "editorial_publication_oldest_in_status_seconds%s %s\n", formatLabels([]string{"status"}, []string{status}), formatMetricValue(oldest[status]))
+	}
+	return nil
+}
+
+// writeStateDurationMetrics выводит гистограмму времени, проведённого публикациями в каждом статусе,
+// по журналу смен статуса. Корзины считаются в базе, чтобы не читать весь журнал.
+func writeStateDurationMetrics(w io.Writer) error {
+	var columns []string
+	for _, bound := range stateDurationBuckets {
+		columns = append(columns, fmt.Sprintf("COUNT(*) FILTER (WHERE seconds <= %s)", formatMetricValue(bound)))
+	}
+	rows, err := Db.Query(`SELECT status, COUNT(*), COALESCE(SUM(seconds), 0), ` + strings.Join(columns, ", ") + `
+                           FROM (SELECT status, GREATEST(EXTRACT(EPOCH FROM left_at - entered_at), 0)::float8 AS seconds
+                                 FROM publication_status_history) AS h
+                           GROUP BY status ORDER BY status`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	const name = "editorial_status_duration_seconds"
+	writeMetricHeader(w, name, "Время, проведённое публикациями в статусе до его смены", "histogram")
+	for rows.Next() {
+		var status string
+		var count uint64
+		var sum float64
+		cumulative := make([]uint64, len(stateDurationBuckets))
+		dest := []interface{}{&status, &count, &sum}
+		for i := range cumulative {
+			dest = append(dest, &cumulative[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		// writeHistogram ждёт число наблюдений по корзинам, а база вернула накопленные
+		counts := make([]uint64, len(cumulative))
+		for i := range cumulative {
+			counts[i] = cumulative[i]
+			if i > 0 {
+				counts[i] -= cumulative[i-1]
+			}
+		}
+		writeHistogram(w, name, []string{"status"}, []string{status}, stateDurationBuckets, counts, count, sum)
+	}
+	return rows.Err()
+}
+
+// WriteMetrics выводит все метрики в текстовом формате Prometheus. Ошибки чтения из базы
+// не прерывают вывод: метрики памяти и пула соединений отдаются в любом случае.
+func WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	httpRequestDuration.write(bw)
+	loginAttempts.write(bw)
+	editorDecisions.write(bw)
+	writeDBPoolMetrics(bw)
+
+	var errs []string
+	if err := writePublicationMetrics(bw); err != nil {
+		errs = append(errs, "публикации по статусам: "+err.Error())
+	}
+	if err := writeStateDurationMetrics(bw); err != nil {
+		errs = append(errs, "время в статусах: "+err.Error())
+	}
+	writeMetricHeader(bw, "editorial_metrics_collect_errors", "Сколько групп метрик из базы не удалось собрать при этом опросе", "gauge")
+	writeGauge(bw, "editorial_metrics_collect_errors", float64(len(errs)))
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("ошибка сбора метрик: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Обработчик /metrics. Если задана переменная METRICS_TOKEN, требуется заголовок Authorization: Bearer <токен>.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "Нет доступа", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := WriteMetrics(w); err != nil {
+		Logger(r.Context()).Error("Ошибка сбора метрик", "error", err)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Строка с рядом в текстовом формате Prometheus: имя, необязательные метки и значение
+var metricSampleRe = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*")*\})? (\S+)$`)
+
+// Суффиксы рядов гистограммы, которые объявляются одним TYPE
+var histogramSuffixRe = regexp.MustCompile(`_(bucket|sum|count)$`)
+
+func scrapeMetrics(t *testing.T, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	MetricsHandler(rec, req)
+	return rec
+}
+
+func TestMetricsHandlerExposition(t *testing.T) {
+	useFakeDb(t, &fakeStore{})
+	t.Setenv("METRICS_TOKEN", "secret")
+
+	observeHTTPRequest("GET /metrics-test/{id}", http.MethodGet, http.StatusOK, 30*time.Millisecond)
+	observeHTTPRequest("GET /metrics-test/{id}", "PROPFIND", http.StatusNotFound, time.Second)
+	loginAttempts.Inc(loginWrongPassword)
+	editorDecisions.Inc("5", decisionApprove)
+
+	if rec := scrapeMetrics(t, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("без токена: статус %d, ожидался 401", rec.Code)
+	}
+	if rec := scrapeMetrics(t, "Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("с неверным токеном: статус %d, ожидался 401", rec.Code)
+	}
+
+	rec := scrapeMetrics(t, "Bearer secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("статус %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	body := rec.Body.String()
+	types := map[string]string{}
+	samples := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				t.Errorf("некорректная строка TYPE: %q", line)
+				continue
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+		m := metricSampleRe.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("строка не в формате Prometheus: %q", line)
+			continue
+		}
+		family := m[1]
+		if _, ok := types[family]; !ok {
+			family = histogramSuffixRe.ReplaceAllString(family, "")
+		}
+		if _, ok := types[family]; !ok {
+			t.Errorf("ряд %s выведен без объявления TYPE", m[1])
+		}
+		samples[m[1]+m[2]] = m[3]
+	}
+
+	want := map[string]string{
+		`http_request_duration_seconds_bucket{route="GET /metrics-test/{id}",method="GET",status="200",le="0.025"}`: "0",
+		`http_request_duration_seconds_bucket{route="GET /metrics-test/{id}",method="GET",status="200",le="0.05"}`:  "1",
+		`http_request_duration_seconds_bucket{route="GET /metrics-test/{id}",method="GET",status="200",le="+Inf"}`:  "1",
+		`http_request_duration_seconds_count{route="GET /metrics-test/{id}",method="OTHER",status="404"}`:           "1",
+		`editorial_login_attempts_total{result="wrong_password"}`:                                                   "1",
+		`editorial_editor_decisions_total{editor_id="5",decision="approve"}`:                                        "1",
+		`editorial_publications{status="draft"}`:                                                                    "0",
+		`db_pool_open_connections`:                                                                                  "",
+		`editorial_metrics_collect_errors`:                                                                          "0",
+	}
+	for series, value := range want {
+		got, ok := samples[series]
+		if !ok {
+			t.Errorf("нет ряда %s", series)
+		} else if value != "" && got != value {
+			t.Errorf("%s = %s, ожидалось %s", series, got, value)
+		}
+	}
+	if types["editorial_editor_decisions_total"] != "counter" || types["http_request_duration_seconds"] != "histogram" {
+		t.Errorf("неверные типы метрик: %v", types)
+	}
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// Миграции схемы базы данных. Каждая миграция применяется один раз,
+// номер применённой миграции сохраняется в таблице schema_migrations.
+// Новые миграции добавляются только в конец списка.
+var migrations = []string{
+	// 1: назначение тем конкретным авторам и открытые темы
+	`ALTER TABLE user_topics ADD COLUMN IF NOT EXISTS is_open BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE user_topics ADD COLUMN IF NOT EXISTS due_at TIMESTAMP;
+	CREATE TABLE IF NOT EXISTS topic_assignments (
+		id SERIAL PRIMARY KEY,
+		topic_id INTEGER NOT NULL REFERENCES user_topics(id) ON DELETE CASCADE,
+		author_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status TEXT NOT NULL DEFAULT 'assigned',
+		due_at TIMESTAMP,
+		assigned_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		responded_at TIMESTAMP,
+		UNIQUE (topic_id, author_id)
+	);`,
+
+	// 2: сроки сдачи черновиков, SLA по статусам и эскалации просрочек
+	`ALTER TABLE publications ADD COLUMN IF NOT EXISTS due_at TIMESTAMP;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP NOT NULL DEFAULT NOW();
+	CREATE TABLE IF NOT EXISTS status_slas (
+		status TEXT PRIMARY KEY,
+		max_hours INTEGER NOT NULL CHECK (max_hours > 0)
+	);
+	INSERT INTO status_slas (status, max_hours) VALUES
+		('pending', 48), ('under_review', 48), ('approved', 24)
+		ON CONFLICT (status) DO NOTHING;
+	CREATE OR REPLACE FUNCTION publications_status_changed() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.status IS DISTINCT FROM OLD.status THEN
+			NEW.status_changed_at := NOW();
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS publications_status_changed ON publications;
+	CREATE TRIGGER publications_status_changed BEFORE UPDATE ON publications
+		FOR EACH ROW EXECUTE FUNCTION publications_status_changed();
+	CREATE TABLE IF NOT EXISTS escalations (
+		id SERIAL PRIMARY KEY,
+		publication_id INTEGER NOT NULL REFERENCES publications(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		escalated_to INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		detected_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		status_changed_at TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS escalations_open_idx ON escalations (publication_id, kind) WHERE resolved_at IS NULL;`,
+
+	// 3: отложенная выкладка и эмбарго
+	`ALTER TABLE publications ADD COLUMN IF NOT EXISTS scheduled_at TIMESTAMPTZ;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS schedule_tz TEXT NOT NULL DEFAULT '';
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS embargo_until TIMESTAMPTZ;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ;
+	CREATE INDEX IF NOT EXISTS publications_scheduled_idx ON publications (scheduled_at) WHERE status = 'scheduled';`,
+
+	// 4: снятие с публикации, отзыв, исправления и история версий
+	`ALTER TABLE publications ADD COLUMN IF NOT EXISTS unpublish_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS unpublished_at TIMESTAMPTZ;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS is_retracted BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS retraction_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS retracted_at TIMESTAMPTZ;
+	CREATE TABLE IF NOT EXISTS publication_revisions (
+		id SERIAL PRIMARY KEY,
+		publication_id INTEGER NOT NULL REFERENCES publications(id) ON DELETE CASCADE,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		status TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS publication_revisions_pub_idx ON publication_revisions (publication_id, id);
+	CREATE TABLE IF NOT EXISTS publication_corrections (
+		id SERIAL PRIMARY KEY,
+		publication_id INTEGER NOT NULL REFERENCES publications(id) ON DELETE CASCADE,
+		notice TEXT NOT NULL,
+		revision_id INTEGER REFERENCES publication_revisions(id) ON DELETE SET NULL,
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+
+	// 5: мягкое удаление пользователей, тем и публикаций
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_by INTEGER;
+	ALTER TABLE user_topics ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+	ALTER TABLE user_topics ADD COLUMN IF NOT EXISTS deleted_by INTEGER;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS deleted_by INTEGER;
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS deleted_with_user INTEGER;`,
+
+	// 6: деактивация пользователей и принудительный выход
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT TRUE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version INTEGER NOT NULL DEFAULT 0;`,
+
+	// 7: профили пользователей
+	`CREATE TABLE IF NOT EXISTS user_profiles (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		full_name TEXT NOT NULL DEFAULT '',
+		email TEXT NOT NULL DEFAULT '',
+		bio TEXT NOT NULL DEFAULT '',
+		avatar BYTEA,
+		avatar_type TEXT NOT NULL DEFAULT '',
+		social_links JSONB NOT NULL DEFAULT '{}',
+		preferred_language TEXT NOT NULL DEFAULT 'ru',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+
+	// 8: соавторы и роли участников публикации
+	`CREATE TABLE IF NOT EXISTS publication_contributors (
+		publication_id INTEGER NOT NULL REFERENCES publications(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL DEFAULT 'author',
+		position INTEGER NOT NULL DEFAULT 0,
+		added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (publication_id, user_id)
+	);
+	INSERT INTO publication_contributors (publication_id, user_id, role, position)
+		SELECT id, author_id, 'author', 0 FROM publications WHERE author_id IS NOT NULL
+		ON CONFLICT DO NOTHING;
+	INSERT INTO publication_contributors (publication_id, user_id, role, position)
+		SELECT up.publication_id, up.user_id, 'author',
+		       1 + ROW_NUMBER() OVER (PARTITION BY up.publication_id ORDER BY up.user_id)
+		FROM user_publications up
+		ON CONFLICT DO NOTHING;`,
+
+	// 9: рубрики и теги
+	`CREATE TABLE IF NOT EXISTS categories (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		parent_id INTEGER REFERENCES categories(id),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE TABLE IF NOT EXISTS tags (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		canonical_id INTEGER REFERENCES tags(id),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS tags_name_lower_idx ON tags (LOWER(name));
+	CREATE TABLE IF NOT EXISTS publication_tags (
+		publication_id INTEGER NOT NULL REFERENCES publications(id) ON DELETE CASCADE,
+		tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		PRIMARY KEY (publication_id, tag_id)
+	);
+	CREATE INDEX IF NOT EXISTS publication_tags_tag_idx ON publication_tags (tag_id);
+	ALTER TABLE publications ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL;`,
+
+	// 10: номер версии публикации для оптимистичной блокировки
+	`ALTER TABLE publications ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE publication_revisions ADD COLUMN IF NOT EXISTS version INTEGER;
+	CREATE INDEX IF NOT EXISTS publication_revisions_version_idx ON publication_revisions (publication_id, version);`,
+
+	// 11: блокировки редактирования
+	`CREATE TABLE IF NOT EXISTS publication_locks (
+		publication_id INTEGER PRIMARY KEY REFERENCES publications(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS publication_locks_user_idx ON publication_locks (user_id);`,
+
+	// 12: журнал шины событий для доставки между экземплярами сервера
+	`CREATE TABLE IF NOT EXISTS bus_events (
+		id BIGSERIAL PRIMARY KEY,
+		topic TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		origin TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+	);
+	CREATE INDEX IF NOT EXISTS bus_events_created_idx ON bus_events (created_at);`,
+
+	// 13: уведомления внутри приложения и настройки их получения
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		publication_id INTEGER REFERENCES publications(id) ON DELETE CASCADE,
+		title TEXT NOT NULL DEFAULT '',
+		message TEXT NOT NULL,
+		link TEXT NOT NULL DEFAULT '',
+		event_key TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		read_at TIMESTAMPTZ,
+		UNIQUE (user_id, event_key)
+	);
+	CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL;
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		PRIMARY KEY (user_id, type)
+	);`,
+
+	// 14: секретный ключ для подписки на календарь из сторонних приложений
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS calendar_token TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS users_calendar_token_idx ON users (calendar_token) WHERE calendar_token IS NOT NULL;`,
+
+	// 15: номера выпусков, разделы по отделам и порядок публикаций в выпуске
+	`CREATE TABLE IF NOT EXISTS issues (
+		id SERIAL PRIMARY KEY,
+		number INTEGER NOT NULL UNIQUE CHECK (number > 0),
+		issue_date DATE NOT NULL,
+		theme TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'planning' CHECK (status IN ('planning', 'locked', 'released')),
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		released_at TIMESTAMPTZ
+	);
+	CREATE TABLE IF NOT EXISTS issue_sections (
+		issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		department TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (issue_id, department)
+	);
+	CREATE TABLE IF NOT EXISTS issue_publications (
+		issue_id INTEGER NOT NULL,
+		department TEXT NOT NULL,
+		publication_id INTEGER NOT NULL UNIQUE REFERENCES publications(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		FOREIGN KEY (issue_id, department) REFERENCES issue_sections (issue_id, department) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS issue_publications_issue_idx ON issue_publications (issue_id, department, position);`,
+	// 16: изображения из импортированных документов Word и история редакционных замечаний
+	`CREATE TABLE IF NOT EXISTS publication_images (
+		id SERIAL PRIMARY KEY,
+		publication_id INTEGER NOT NULL REFERENCES publications (id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		data BYTEA NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (publication_id, name)
+	);
+	CREATE TABLE IF NOT EXISTS publication_comments (
+		id SERIAL PRIMARY KEY,
+		publication_id INTEGER NOT NULL REFERENCES publications (id) ON DELETE CASCADE,
+		revision_id INTEGER REFERENCES publication_revisions (id) ON DELETE SET NULL,
+		author_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+		body TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS publication_comments_publication_idx ON publication_comments (publication_id, id);
+	INSERT INTO publication_comments (publication_id, revision_id, body, created_at)
+	SELECT p.id, (SELECT MAX(r.id) FROM publication_revisions r WHERE r.publication_id = p.id), p.remarks, COALESCE(p.updated_at, NOW())
+	FROM publications p WHERE COALESCE(p.remarks, '') <> '';`,
+	// 17: журнал смен статуса для метрик времени прохождения редакционного процесса
+	`CREATE TABLE IF NOT EXISTS publication_status_history (
+		id SERIAL PRIMARY KEY,
+		publication_id INTEGER NOT NULL REFERENCES publications (id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		entered_at TIMESTAMP NOT NULL,
+		left_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS publication_status_history_status_idx ON publication_status_history (status);
+	CREATE OR REPLACE FUNCTION publications_status_changed() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.status IS DISTINCT FROM OLD.status THEN
+			IF OLD.status IS NOT NULL THEN
+				INSERT INTO publication_status_history (publication_id, status, entered_at, left_at)
+				VALUES (OLD.id, OLD.status, OLD.status_changed_at, NOW());
+			END IF;
+			NEW.status_changed_at := NOW();
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`,
+	// 18: назначения для тем, созданных до миграции 1. Авторы публикаций по теме получают
+	// принятое назначение, а темы без назначений открываются для свободного выбора.
+	`INSERT INTO topic_assignments (topic_id, author_id, status, due_at, assigned_at, responded_at)
+	SELECT DISTINCT ON (p.topic_id, p.author_id) p.topic_id, p.author_id, 'accepted', t.due_at, COALESCE(t.assigned_at, NOW()), NOW()
+	FROM publications p
+	JOIN user_topics t ON t.id = p.topic_id
+	JOIN users u ON u.id = p.author_id
+	ON CONFLICT (topic_id, author_id) DO NOTHING;
+	UPDATE user_topics t SET is_open = TRUE
+	WHERE t.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM topic_assignments a WHERE a.topic_id = t.id);`,
+}
+
+// SchemaVersion возвращает номер последней применённой миграции и номер последней известной
+func SchemaVersion() (current, latest int, err error) {
+	err = Db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "42P01" {
+			// Таблицы миграций ещё нет: ни одна миграция не применялась
+			return 0, len(migrations), nil
+		}
+	}
+	return current, len(migrations), err
+}
+
+// Ключ рекомендательной блокировки PostgreSQL, под которой применяются миграции
+const migrationLockKey = 7310021
+
+// MigrateDatabase применяет к базе данных все ещё не применённые миграции.
+// Несколько одновременно запущенных экземпляров сервера применяют миграции по очереди:
+// на время работы берётся рекомендательная блокировка, а версия схемы читается уже под ней.
+func MigrateDatabase() error {
+	ctx := context.Background()
+	// Блокировка уровня сессии принадлежит соединению, поэтому вся работа идёт через одно соединение
+	conn, err := Db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка подключения к базе для миграций: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("ошибка блокировки миграций: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы миграций: %w", err)
+	}
+
+	var current int
+	err = conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return fmt.Errorf("ошибка получения версии схемы: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("ошибка применения миграции %d: %w", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("ошибка сохранения версии миграции %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Применена миграция %d", version)
+	}
+	return nil
+}
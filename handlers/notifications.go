@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"example.com/myproject/templates"
+	"github.com/lib/pq"
+)
+
+var TmplNotifications = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "notifications.html"))
+
+// Значок уведомлений подключается ко всем рабочим страницам ролей
+func init() {
+	for _, t := range []*template.Template{TmplAdmin, TmplChiefEditor, TmplSectionEditor, TmplAuthor} {
+		template.Must(t.ParseFS(templates.FS, "notification_bell.html"))
+	}
+}
+
+// Типы уведомлений
+const (
+	NotifyRevisionRequested = "revision_requested"
+	NotifyApproved          = "approved"
+	NotifyPublished         = "published"
+	NotifyTopicAssigned     = "topic_assigned"
+	NotifyCommentReply      = "comment_reply"
+)
+
+// NotificationTypes — типы уведомлений в порядке показа в настройках
+var NotificationTypes = []string{NotifyRevisionRequested, NotifyApproved, NotifyPublished, NotifyTopicAssigned, NotifyCommentReply}
+
+var NotificationTypeLabels = map[string]string{
+	NotifyRevisionRequested: "Публикация возвращена на доработку",
+	NotifyApproved:          "Публикация одобрена",
+	NotifyPublished:         "Публикация выложена",
+	NotifyTopicAssigned:     "Назначение темы или участия в публикации",
+	NotifyCommentReply:      "Ответ автора на замечания",
+}
+
+// Тип события рабочих страниц, по которому обновляется счётчик непрочитанных
+const EventNotification = "notification"
+
+const notificationsPageSize = 20
+
+// Уведомление пользователя
+type Notification struct {
+	ID            int64
+	Type          string
+	PublicationID sql.NullInt64
+	Title         string
+	Message       string
+	Link          string
+	CreatedAt     time.Time
+	ReadAt        sql.NullTime
+}
+
+func (n Notification) TypeLabel() string {
+	return NotificationTypeLabels[n.Type]
+}
+
+// Страница истории уведомлений
+type NotificationList struct {
+	Notifications []Notification
+	Unread        int
+	Page          int
+	Pages         int
+	Total         int
+}
+
+// PrevPage и NextPage нужны шаблону для ссылок постраничной навигации
+func (l NotificationList) PrevPage() int { return l.Page - 1 }
+func (l NotificationList) NextPage() int { return l.Page + 1 }
+
+// rolePageURL возвращает рабочую страницу пользователя с данной ролью
+func rolePageURL(role string, userID int) string {
+	id := strconv.Itoa(userID)
+	switch {
+	case IsAdminRole(role):
+		return "/admin_page?id=" + id
+	case IsChiefEditorRole(role):
+		return "/chief_editor_page?id=" + id
+	case role == RoleSectionEditor:
+		return "/section_editor_page?id=" + id
+	case role == RoleAuthor:
+		return "/author_page?id=" + id
+	}
+	return "/main?id=" + id
+}
+
+// notificationRecipient — получатель уведомления
+type notificationRecipient struct {
+	ID   int
+	Role string
+}
+
+// notificationRecipients возвращает активных пользователей из списка, которые не отключили уведомления данного типа.
+// При editors = true к списку добавляются все редакторы.
+func notificationRecipients(notifType string, userIDs []int, editors bool) ([]notificationRecipient, error) {
+	ids := make([]int64, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = int64(id)
+	}
+	rows, err := Db.Query(`SELECT u.id, u.role FROM users u
+                           WHERE (u.id = ANY($1) OR ($2 AND u.role IN ($3, 'chief_admin', $4)))
+                             AND u.is_active AND u.deleted_at IS NULL
+                             AND NOT EXISTS (SELECT 1 FROM notification_preferences p
+                                             WHERE p.user_id = u.id AND p.type = $5 AND NOT p.enabled)`,
+		pq.Array(ids), editors, RoleChiefEditor, RoleSectionEditor, notifType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []notificationRecipient
+	for rows.Next() {
+		var r notificationRecipient
+		if err := rows.Scan(&r.ID, &r.Role); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// deliverNotifications сохраняет уведомление для получателей и обновляет их счётчики.
+// Ключ события защищает от дублей: шина может доставить сообщение повторно,
+// а при нескольких экземплярах сервера его обрабатывает каждый из них.
+func deliverNotifications(m BusMessage, notifType string, userIDs []int, editors bool, actorID, publicationID int, title, message string) error {
+	recipients, err := notificationRecipients(notifType, userIDs, editors)
+	if err != nil {
+		return err
+	}
+	eventKey := m.Origin + ":" + strconv.FormatInt(m.ID, 10)
+
+	for _, recipient := range recipients {
+		if recipient.ID == actorID {
+			continue
+		}
+		_, err := Db.Exec(`INSERT INTO notifications (user_id, type, publication_id, title, message, link, event_key)
+                           VALUES ($1, $2, $3, $4, $5, $6, $7)
+                           ON CONFLICT (user_id, event_key) DO NOTHING`,
+			recipient.ID, notifType, nullableID(publicationID), title, message, rolePageURL(recipient.Role, recipient.ID), eventKey)
+		if err != nil {
+			return err
+		}
+		// Подключения пользователя могут быть на любом экземпляре, поэтому счётчик обновляет каждый
+		Events.Publish(context.Background(), Event{Type: EventNotification, PublicationID: publicationID, Title: title, Message: message, UserIDs: []int{recipient.ID}})
+	}
+	return nil
+}
+
+// publicationNotificationType определяет, о каком событии публикации уведомлять и кого:
+// участников публикации или (editors = true) редакторов
+func publicationNotificationType(e PublicationChanged) (notifType string, editors bool) {
+	switch {
+	case e.Kind == EventComment && e.Status == "revision":
+		return NotifyRevisionRequested, false
+	case e.Kind == EventComment && e.Status == "under_review":
+		return NotifyCommentReply, true
+	case e.Kind == EventStatusChanged && e.Status == "approved":
+		return NotifyApproved, false
+	case e.Kind == EventStatusChanged && e.Status == "published":
+		return NotifyPublished, false
+	case e.Kind == EventAssigned:
+		return NotifyTopicAssigned, false
+	}
+	return "", false
+}
+
+// subscribeNotifications создаёт уведомления из событий шины
+func subscribeNotifications(bus EventBus) {
+	bus.Subscribe(TopicPublication, func(m BusMessage) error {
+		var e PublicationChanged
+		if err := m.Decode(&e); err != nil {
+			return nil
+		}
+		notifType, editors := publicationNotificationType(e)
+		if notifType == "" {
+			return nil
+		}
+		var userIDs []int
+		if !editors {
+			userIDs = e.UserIDs
+		}
+		return deliverNotifications(m, notifType, userIDs, editors, e.ActorID, e.PublicationID, e.Title, e.Message)
+	})
+	bus.Subscribe(TopicTopic, func(m BusMessage) error {
+		var e TopicChanged
+		if err := m.Decode(&e); err != nil || e.Kind != EventAssigned {
+			return nil
+		}
+		return deliverNotifications(m, NotifyTopicAssigned, e.AuthorIDs, false, e.ActorID, 0, e.Title, e.Message)
+	})
+}
+
+// CountUnreadNotifications возвращает число непрочитанных уведомлений
+func CountUnreadNotifications(userID int) (int, error) {
+	var count int
+	err := Db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// GetNotifications возвращает страницу истории уведомлений, новые сверху
+func GetNotifications(userID, page, pageSize int) (NotificationList, error) {
+	list := NotificationList{Page: page}
+	err := Db.QueryRow(`SELECT COUNT(*), COUNT(*) FILTER (WHERE read_at IS NULL) FROM notifications WHERE user_id = $1`, userID).
+		Scan(&list.Total, &list.Unread)
+	if err != nil {
+		return list, err
+	}
+	list.Pages = (list.Total + pageSize - 1) / pageSize
+
+	rows, err := Db.Query(`SELECT id, type, publication_id, title, message, link, created_at, read_at
+                           FROM notifications WHERE user_id = $1
+                           ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`, userID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Type, &n.PublicationID, &n.Title, &n.Message, &n.Link, &n.CreatedAt, &n.ReadAt); err != nil {
+			return list, err
+		}
+		list.Notifications = append(list.Notifications, n)
+	}
+	return list, rows.Err()
+}
+
+// MarkNotificationsRead отмечает прочитанным одно уведомление или, при id = 0, все уведомления пользователя
+func MarkNotificationsRead(userID int, id int64) error {
+	_, err := Db.Exec(`UPDATE notifications SET read_at = NOW()
+                       WHERE user_id = $1 AND read_at IS NULL AND ($2 = 0 OR id = $2)`, userID, id)
+	return err
+}
+
+// GetNotificationPreferences возвращает включённость каждого типа уведомлений; по умолчанию все включены
+func GetNotificationPreferences(userID int) (map[string]bool, error) {
+	prefs := map[string]bool{}
+	for _, t := range NotificationTypes {
+		prefs[t] = true
+	}
+	rows, err := Db.Query(`SELECT type, enabled FROM notification_preferences WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t string
+		var enabled bool
+		if err := rows.Scan(&t, &enabled); err != nil {
+			return nil, err
+		}
+		prefs[t] = enabled
+	}
+	return prefs, rows.Err()
+}
+
+// SetNotificationPreferences сохраняет настройки всех типов уведомлений
+func SetNotificationPreferences(userID int, prefs map[string]bool) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range NotificationTypes {
+		_, err := tx.Exec(`INSERT INTO notification_preferences (user_id, type, enabled) VALUES ($1, $2, $3)
+                           ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled`, userID, t, prefs[t])
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// notificationUser возвращает пользователя текущей сессии. При ошибке ответ уже отправлен.
+func notificationUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return 0, false
+	}
+	return userID, true
+}
+
+// Центр уведомлений: история с постраничной навигацией и настройки
+func NotificationsPage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := notificationUser(w, r)
+	if !ok {
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	list, err := GetNotifications(userID, page, notificationsPageSize)
+	if err != nil {
+		http.Error(w, "Ошибка при получении уведомлений", http.StatusInternalServerError)
+		return
+	}
+	prefs, err := GetNotificationPreferences(userID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении настроек уведомлений", http.StatusInternalServerError)
+		return
+	}
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении роли пользователя", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		List        NotificationList
+		Types       []string
+		TypeLabels  map[string]string
+		Preferences map[string]bool
+		BackURL     string
+	}{
+		List:        list,
+		Types:       NotificationTypes,
+		TypeLabels:  NotificationTypeLabels,
+		Preferences: prefs,
+		BackURL:     rolePageURL(role, userID),
+	}
+	if err := TmplNotifications.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона", "error", err)
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// Число непрочитанных уведомлений для значка на рабочих страницах
+func UnreadNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		writeJSONError(w, r, http.StatusUnauthorized, "Необходимо войти в систему")
+		return
+	}
+	count, err := CountUnreadNotifications(userID)
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, "Ошибка при получении уведомлений")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]int{"unread": count})
+}
+
+// Отметка уведомления (или всех, если id не передан) прочитанным
+func MarkNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := notificationUser(w, r)
+	if !ok {
+		return
+	}
+	var id int64
+	if idStr := r.FormValue("id"); idStr != "" {
+		var err error
+		if id, err = strconv.ParseInt(idStr, 10, 64); err != nil || id <= 0 {
+			http.Error(w, "Неверный идентификатор уведомления", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := MarkNotificationsRead(userID, id); err != nil {
+		http.Error(w, "Ошибка при обновлении уведомлений", http.StatusInternalServerError)
+		return
+	}
+	Events.Publish(r.Context(), Event{Type: EventNotification, UserIDs: []int{userID}})
+
+	// Переход по ссылке уведомления сразу отмечает его прочитанным
+	if link := r.FormValue("link"); isLocalLink(link) {
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/notifications?page="+r.FormValue("page"), http.StatusSeeOther)
+}
+
+// isLocalLink пропускает только пути внутри сайта. Браузеры читают "/\host" как "//host",
+// поэтому обратная косая черта запрещена везде.
+func isLocalLink(link string) bool {
+	if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//") || strings.Contains(link, "\\") {
+		return false
+	}
+	u, err := url.Parse(link)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// Сохранение настроек уведомлений
+func NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := notificationUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Ошибка при обработке формы", http.StatusBadRequest)
+		return
+	}
+
+	prefs := map[string]bool{}
+	for _, t := range r.Form["types"] {
+		prefs[t] = true
+	}
+	if err := SetNotificationPreferences(userID, prefs); err != nil {
+		http.Error(w, "Ошибка при сохранении настроек", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestIsLocalLink(t *testing.T) {
+	cases := map[string]bool{
+		"/":                       true,
+		"/article/7":              true,
+		"/notifications?page=2#x": true,
+		"":                        false,
+		"article/7":               false,
+		"//evil.example":          false,
+		`/\evil.example`:          false,
+		`/article\..\x`:           false,
+		"https://evil.example/":   false,
+		"javascript:alert(1)":     false,
+		"/%0a/evil":               true,
+		"/article/7\n//evil":      false,
+	}
+	for link, want := range cases {
+		if got := isLocalLink(link); got != want {
+			t.Errorf("isLocalLink(%q) = %v, ожидалось %v", link, got, want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Операционное преобразование текста в формате ot.js. Операция — последовательность компонентов:
+// положительное число пропускает символы, строка вставляет текст, отрицательное число удаляет символы.
+// Позиции считаются в единицах UTF-16, как в textarea браузера.
+
+var ErrOperationMismatch = errors.New("операция не соответствует длине документа")
+
+// Компонент операции: ровно одно из полей отлично от нуля
+type opComponent struct {
+	Retain int
+	Insert []uint16
+	Delete int
+}
+
+// TextOperation — правка текста целиком
+type TextOperation struct {
+	ops       []opComponent
+	BaseLen   int // длина документа до применения
+	TargetLen int // длина документа после применения
+}
+
+func (o *TextOperation) retain(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.BaseLen += n
+	o.TargetLen += n
+	if last := len(o.ops) - 1; last >= 0 && o.ops[last].Retain > 0 {
+		o.ops[last].Retain += n
+	} else {
+		o.ops = append(o.ops, opComponent{Retain: n})
+	}
+	return o
+}
+
+func (o *TextOperation) insert(s []uint16) *TextOperation {
+	if len(s) == 0 {
+		return o
+	}
+	o.TargetLen += len(s)
+	last := len(o.ops) - 1
+	switch {
+	case last >= 0 && o.ops[last].Insert != nil:
+		o.ops[last].Insert = append(append([]uint16{}, o.ops[last].Insert...), s...)
+	case last >= 0 && o.ops[last].Delete > 0:
+		// Вставка всегда идёт перед удалением, так операции проще сравнивать
+		if last > 0 && o.ops[last-1].Insert != nil {
+			o.ops[last-1].Insert = append(append([]uint16{}, o.ops[last-1].Insert...), s...)
+		} else {
+			del := o.ops[last]
+			o.ops[last] = opComponent{Insert: s}
+			o.ops = append(o.ops, del)
+		}
+	default:
+		o.ops = append(o.ops, opComponent{Insert: s})
+	}
+	return o
+}
+
+func (o *TextOperation) delete(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.BaseLen += n
+	if last := len(o.ops) - 1; last >= 0 && o.ops[last].Delete > 0 {
+		o.ops[last].Delete += n
+	} else {
+		o.ops = append(o.ops, opComponent{Delete: n})
+	}
+	return o
+}
+
+// IsNoop сообщает, что операция ничего не меняет
+func (o *TextOperation) IsNoop() bool {
+	return len(o.ops) == 0 || (len(o.ops) == 1 && o.ops[0].Retain > 0)
+}
+
+// MarshalJSON кодирует операцию в формат ot.js
+func (o TextOperation) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, 0, len(o.ops))
+	for _, c := range o.ops {
+		switch {
+		case c.Retain > 0:
+			items = append(items, c.Retain)
+		case c.Insert != nil:
+			items = append(items, string(utf16.Decode(c.Insert)))
+		default:
+			items = append(items, -c.Delete)
+		}
+	}
+	return json.Marshal(items)
+}
+
+// UnmarshalJSON разбирает операцию из формата ot.js
+func (o *TextOperation) UnmarshalJSON(data []byte) error {
+	var items []interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*o = TextOperation{}
+	for _, item := range items {
+		switch v := item.(type) {
+		case float64:
+			n := int(v)
+			if float64(n) != v || n == 0 {
+				return fmt.Errorf("неверный компонент операции: %v", v)
+			}
+			if n > 0 {
+				o.retain(n)
+			} else {
+				o.delete(-n)
+			}
+		case string:
+			o.insert(utf16.Encode([]rune(v)))
+		default:
+			return fmt.Errorf("неверный компонент операции: %v", v)
+		}
+	}
+	return nil
+}
+
+// Apply применяет операцию к документу
+func (o *TextOperation) Apply(doc []uint16) ([]uint16, error) {
+	if len(doc) != o.BaseLen {
+		return nil, ErrOperationMismatch
+	}
+	result := make([]uint16, 0, o.TargetLen)
+	pos := 0
+	for _, c := range o.ops {
+		switch {
+		case c.Retain > 0:
+			result = append(result, doc[pos:pos+c.Retain]...)
+			pos += c.Retain
+		case c.Insert != nil:
+			result = append(result, c.Insert...)
+		default:
+			pos += c.Delete
+		}
+	}
+	return result, nil
+}
+
+// TransformIndex сдвигает позицию курсора с учётом операции
+func (o *TextOperation) TransformIndex(index int) int {
+	newIndex, pos := index, 0
+	for _, c := range o.ops {
+		if pos > index {
+			break
+		}
+		switch {
+		case c.Retain > 0:
+			pos += c.Retain
+		case c.Insert != nil:
+			newIndex += len(c.Insert)
+		default:
+			newIndex -= min(c.Delete, index-pos)
+			pos += c.Delete
+		}
+	}
+	return newIndex
+}
+
+// TransformOperations преобразует две параллельные операции над одним документом так,
+// что apply(apply(doc, a), b') == apply(apply(doc, b), a'). При вставке в одно место текст a идёт первым.
+func TransformOperations(a, b *TextOperation) (*TextOperation, *TextOperation, error) {
+	if a.BaseLen != b.BaseLen {
+		return nil, nil, ErrOperationMismatch
+	}
+	aPrime, bPrime := &TextOperation{}, &TextOperation{}
+	opsA, opsB := append([]opComponent{}, a.ops...), append([]opComponent{}, b.ops...)
+	i, j := 0, 0
+	next := func(ops []opComponent, k int) *opComponent {
+		if k < len(ops) {
+			return &ops[k]
+		}
+		return nil
+	}
+
+	for {
+		ca, cb := next(opsA, i), next(opsB, j)
+		if ca == nil && cb == nil {
+			break
+		}
+		if ca != nil && ca.Insert != nil {
+			aPrime.insert(ca.Insert)
+			bPrime.retain(len(ca.Insert))
+			i++
+			continue
+		}
+		if cb != nil && cb.Insert != nil {
+			aPrime.retain(len(cb.Insert))
+			bPrime.insert(cb.Insert)
+			j++
+			continue
+		}
+		if ca == nil || cb == nil {
+			return nil, nil, ErrOperationMismatch
+		}
+
+		switch {
+		case ca.Retain > 0 && cb.Retain > 0:
+			n := min(ca.Retain, cb.Retain)
+			aPrime.retain(n)
+			bPrime.retain(n)
+			ca.Retain -= n
+			cb.Retain -= n
+		case ca.Delete > 0 && cb.Delete > 0:
+			// Оба удалили один и тот же фрагмент
+			n := min(ca.Delete, cb.Delete)
+			ca.Delete -= n
+			cb.Delete -= n
+		case ca.Delete > 0 && cb.Retain > 0:
+			n := min(ca.Delete, cb.Retain)
+			aPrime.delete(n)
+			ca.Delete -= n
+			cb.Retain -= n
+		case ca.Retain > 0 && cb.Delete > 0:
+			n := min(ca.Retain, cb.Delete)
+			bPrime.delete(n)
+			ca.Retain -= n
+			cb.Delete -= n
+		}
+		if ca.Retain == 0 && ca.Delete == 0 {
+			i++
+		}
+		if cb.Retain == 0 && cb.Delete == 0 {
+			j++
+		}
+	}
+	return aPrime, bPrime, nil
+}
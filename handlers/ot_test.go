@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"unicode/utf16"
+)
+
+// op собирает операцию из компонентов ot.js: число > 0 — пропуск, < 0 — удаление, строка — вставка
+func op(t *testing.T, items ...interface{}) *TextOperation {
+	t.Helper()
+	data, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var o TextOperation
+	if err := json.Unmarshal(data, &o); err != nil {
+		t.Fatal(err)
+	}
+	return &o
+}
+
+func apply(t *testing.T, o *TextOperation, doc string) string {
+	t.Helper()
+	result, err := o.Apply(utf16.Encode([]rune(doc)))
+	if err != nil {
+		t.Fatalf("Apply(%q): %v", doc, err)
+	}
+	return string(utf16.Decode(result))
+}
+
+func TestTransformOperationsConverge(t *testing.T) {
+	const doc = "Привет, мир"
+	tests := []struct {
+		name string
+		a, b []interface{}
+		want string
+	}{
+		{"вставки в разных местах", []interface{}{"А", 11}, []interface{}{11, "Б"}, "АПривет, мирБ"},
+		{"вставки в одно место", []interface{}{6, "1", 5}, []interface{}{6, "2", 5}, "Привет12, мир"},
+		{"одинаковое удаление", []interface{}{6, -2, 3}, []interface{}{6, -2, 3}, "Приветмир"},
+		{"пересекающиеся удаления", []interface{}{4, -4, 3}, []interface{}{6, -5}, "Прив"},
+		{"вставка внутри удалённого", []interface{}{2, -6, 3}, []interface{}{4, "X", 7}, "ПрXмир"},
+		{"суррогатная пара", []interface{}{"😀", 11}, []interface{}{-1, 10}, "😀ривет, мир"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := op(t, tt.a...), op(t, tt.b...)
+			aPrime, bPrime, err := TransformOperations(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			left := apply(t, bPrime, apply(t, a, doc))
+			right := apply(t, aPrime, apply(t, b, doc))
+			if left != right {
+				t.Fatalf("документы разошлись: %q и %q", left, right)
+			}
+			if left != tt.want {
+				t.Errorf("получено %q, ожидалось %q", left, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformOperationsMismatch(t *testing.T) {
+	if _, _, err := TransformOperations(op(t, 3), op(t, 4)); err != ErrOperationMismatch {
+		t.Errorf("ожидалась ErrOperationMismatch, получено %v", err)
+	}
+	if _, err := op(t, 3).Apply(utf16.Encode([]rune("ab"))); err != ErrOperationMismatch {
+		t.Errorf("ожидалась ErrOperationMismatch, получено %v", err)
+	}
+}
+
+// randomOperation строит случайную правку документа длины n
+func randomOperation(rnd *rand.Rand, n int) *TextOperation {
+	o := &TextOperation{}
+	for pos := 0; pos < n; {
+		k := 1 + rnd.Intn(n-pos)
+		switch rnd.Intn(3) {
+		case 0:
+			o.retain(k)
+			pos += k
+		case 1:
+			o.delete(k)
+			pos += k
+		default:
+			o.insert(utf16.Encode([]rune(string(rune('a' + rnd.Intn(26))))))
+		}
+	}
+	if rnd.Intn(2) == 0 {
+		o.insert([]uint16{'z'})
+	}
+	return o
+}
+
+// Сервер преобразует запоздавшую правку относительно всех уже применённых (см. receiveOperation),
+// а клиент — применённые операции относительно своей правки. Документы должны совпасть.
+func TestTransformAgainstHistoryConverges(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 500; iter++ {
+		base := make([]uint16, 1+rnd.Intn(20))
+		for i := range base {
+			base[i] = uint16('A' + rnd.Intn(26))
+		}
+
+		// История сервера: несколько последовательных операций от base
+		serverDoc := base
+		var history []*TextOperation
+		for k := rnd.Intn(4); k >= 0; k-- {
+			o := randomOperation(rnd, len(serverDoc))
+			next, err := o.Apply(serverDoc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			history = append(history, o)
+			serverDoc = next
+		}
+
+		// Правка клиента сделана относительно base
+		clientOp := randomOperation(rnd, len(base))
+		clientDoc, err := clientOp.Apply(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pending := clientOp
+		for _, applied := range history {
+			var appliedPrime *TextOperation
+			if pending, appliedPrime, err = TransformOperations(pending, applied); err != nil {
+				t.Fatal(err)
+			}
+			if clientDoc, err = appliedPrime.Apply(clientDoc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if serverDoc, err = pending.Apply(serverDoc); err != nil {
+			t.Fatal(err)
+		}
+		if string(utf16.Decode(serverDoc)) != string(utf16.Decode(clientDoc)) {
+			t.Fatalf("итерация %d: сервер %q, клиент %q", iter, string(utf16.Decode(serverDoc)), string(utf16.Decode(clientDoc)))
+		}
+	}
+}
+
+func TestTextOperationJSONRoundTrip(t *testing.T) {
+	o := op(t, 2, "вставка", -3, 4)
+	data, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), `[2,"вставка",-3,4]`; got != want {
+		t.Errorf("получено %s, ожидалось %s", got, want)
+	}
+	if o.BaseLen != 9 || o.TargetLen != 13 {
+		t.Errorf("длины %d → %d, ожидалось 9 → 13", o.BaseLen, o.TargetLen)
+	}
+
+	var bad TextOperation
+	for _, input := range []string{`[0]`, `[1.5]`, `[true]`, `{}`} {
+		if err := json.Unmarshal([]byte(input), &bad); err == nil {
+			t.Errorf("%s: ожидалась ошибка разбора", input)
+		}
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	o := op(t, 2, "xyz", -2, 3)
+	for _, tt := range []struct{ index, want int }{{0, 0}, {2, 5}, {3, 5}, {4, 5}, {6, 7}} {
+		if got := o.TransformIndex(tt.index); got != tt.want {
+			t.Errorf("TransformIndex(%d) = %d, ожидалось %d", tt.index, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Шрифты DejaVu Serif с кириллицей встраиваются в каждый PDF (лицензия в fonts/LICENSE)
+//
+//go:embed fonts/DejaVuSerif.ttf fonts/DejaVuSerif-Bold.ttf
+var pdfFonts embed.FS
+
+const pdfFont = "dejavu"
+
+// Размеры вёрстки в миллиметрах
+const (
+	pdfMargin     = 20.0
+	pdfLineHeight = 6.0
+	pdfAvatarSize = 10.0
+)
+
+// Типы изображений fpdf по MIME-типу аватара
+var pdfImageTypes = map[string]string{
+	"image/jpeg": "JPG",
+	"image/png":  "PNG",
+	"image/gif":  "GIF",
+}
+
+// newPDF создаёт документ A4 со встроенными шрифтами и номерами страниц в нижнем колонтитуле.
+// Первые skipFooter страниц (титул) выводятся без номера.
+func newPDF(title string, skipFooter int) (*fpdf.Fpdf, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	for style, file := range map[string]string{"": "fonts/DejaVuSerif.ttf", "B": "fonts/DejaVuSerif-Bold.ttf"} {
+		data, err := pdfFonts.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pdf.AddUTF8FontFromBytes(pdfFont, style, data)
+	}
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("myproject", true)
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() <= skipFooter {
+			return
+		}
+		pdf.SetY(-15)
+		pdf.SetFont(pdfFont, "", 9)
+		pdf.CellFormat(0, 10, strconv.Itoa(pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	return pdf, pdf.Error()
+}
+
+// writePDFArticle выводит статью с новой страницы: заголовок, отдел, подпись с аватарами и текст.
+// link — ссылка из оглавления, которая должна вести на начало статьи (0 — без ссылки).
+// Возвращает номер первой страницы статьи.
+func writePDFArticle(ctx context.Context, pdf *fpdf.Fpdf, a exportArticle, link int) int {
+	pdf.AddPage()
+	start := pdf.PageNo()
+	if link > 0 {
+		pdf.SetLink(link, -1, -1)
+	}
+
+	pdf.SetFont(pdfFont, "B", 18)
+	pdf.MultiCell(0, 9, a.Title, "", "L", false)
+
+	pdf.SetFont(pdfFont, "", 10)
+	var meta []string
+	if a.Department != "" {
+		meta = append(meta, a.Department)
+	}
+	if a.PublishedAt.Valid {
+		meta = append(meta, a.PublishedAt.Time.Format("02.01.2006"))
+	}
+	if len(meta) > 0 {
+		pdf.CellFormat(0, pdfLineHeight, strings.Join(meta, " · "), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	for _, c := range a.Byline() {
+		x, y := pdf.GetX(), pdf.GetY()
+		if avatar, ok := a.Avatars[c.UserID]; ok && pdfImageTypes[avatar.Type] != "" {
+			name := "avatar-" + strconv.Itoa(c.UserID)
+			options := fpdf.ImageOptions{ImageType: pdfImageTypes[avatar.Type]}
+			if info := pdf.GetImageInfo(name); info == nil {
+				pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(avatar.Data))
+			}
+			if pdf.Ok() {
+				pdf.ImageOptions(name, x, y, pdfAvatarSize, pdfAvatarSize, false, options, 0, "")
+			} else {
+				// Повреждённый аватар не должен ломать весь документ
+				Logger(ctx).Warn("Не удалось добавить аватар в PDF", "user_id", c.UserID, "publication_id", a.ID, "error", pdf.Error())
+				pdf.ClearError()
+			}
+		}
+		pdf.SetXY(x+pdfAvatarSize+3, y+2)
+		pdf.SetFont(pdfFont, "B", 10)
+		pdf.Write(pdfLineHeight, c.Name)
+		pdf.SetFont(pdfFont, "", 10)
+		pdf.Write(pdfLineHeight, ", "+ContributorRoleLabels[c.Role])
+		pdf.SetXY(x, y+pdfAvatarSize+2)
+	}
+	pdf.Ln(4)
+
+	if a.IsRetracted {
+		pdf.SetFont(pdfFont, "B", 11)
+		pdf.MultiCell(0, pdfLineHeight, "Статья отозвана редакцией. "+a.RetractionReason, "", "L", false)
+		pdf.Ln(2)
+	}
+
+	writePDFMarkup(ctx, pdf, a)
+	return start
+}
+
+// writePDFMarkup выводит текст статьи с заголовками, списками, полужирным и изображениями.
+// Курсивного начертания среди встроенных шрифтов нет, поэтому курсив выводится прямым.
+func writePDFMarkup(ctx context.Context, pdf *fpdf.Fpdf, a exportArticle) {
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	number := 0
+	for _, block := range parseMarkup(a.Content) {
+		if block.Kind == markupNumber {
+			number++
+		} else {
+			number = 0
+		}
+
+		switch block.Kind {
+		case markupImage:
+			writePDFImage(ctx, pdf, a, block, pageWidth-left-right)
+		case markupHeading:
+			pdf.Ln(2)
+			pdf.SetFont(pdfFont, "B", []float64{15, 13, 12}[block.Level-1])
+			pdf.MultiCell(0, 7, formatPlainRuns(block.Runs), "", "L", false)
+			pdf.Ln(1)
+		case markupBullet, markupNumber:
+			marker := "•"
+			if block.Kind == markupNumber {
+				marker = strconv.Itoa(number) + "."
+			}
+			pdf.SetFont(pdfFont, "", 11)
+			pdf.SetX(left)
+			pdf.CellFormat(7, pdfLineHeight, marker, "", 0, "R", false, 0, "")
+			// Перенесённые строки пункта выравниваются по его тексту, а не по маркеру
+			pdf.SetLeftMargin(left + 9)
+			pdf.SetX(left + 9)
+			writePDFRuns(pdf, block.Runs, "L")
+			pdf.SetLeftMargin(left)
+		default:
+			writePDFRuns(pdf, block.Runs, "J")
+			pdf.Ln(pdfLineHeight / 3)
+		}
+	}
+}
+
+// writePDFRuns выводит строку. Без выделений абзац выравнивается по ширине через MultiCell,
+// с выделениями — фрагментами через Write, который умеет менять шрифт посреди строки.
+func writePDFRuns(pdf *fpdf.Fpdf, runs []markupRun, align string) {
+	plain := true
+	for _, run := range runs {
+		plain = plain && !run.Bold
+	}
+	if plain {
+		pdf.SetFont(pdfFont, "", 11)
+		pdf.MultiCell(0, pdfLineHeight, formatPlainRuns(runs), "", align, false)
+		return
+	}
+	for _, run := range runs {
+		style := ""
+		if run.Bold {
+			style = "B"
+		}
+		pdf.SetFont(pdfFont, style, 11)
+		pdf.Write(pdfLineHeight, run.Text)
+	}
+	pdf.SetFont(pdfFont, "", 11)
+	pdf.Ln(pdfLineHeight)
+}
+
+// formatPlainRuns склеивает фрагменты строки без оформления
+func formatPlainRuns(runs []markupRun) string {
+	var b strings.Builder
+	for _, run := range runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+// writePDFImage выводит изображение из текста по ширине колонки, но не крупнее исходного размера,
+// и подпись под ним. Изображение, которое не удалось прочитать, пропускается.
+func writePDFImage(ctx context.Context, pdf *fpdf.Fpdf, a exportArticle, block markupBlock, maxWidth float64) {
+	img, ok := a.Images[block.Image]
+	if !ok || pdfImageTypes[img.ContentType] == "" {
+		return
+	}
+	name := "image-" + strconv.Itoa(a.ID) + "-" + block.Image
+	options := fpdf.ImageOptions{ImageType: pdfImageTypes[img.ContentType]}
+	info := pdf.GetImageInfo(name)
+	if info == nil {
+		info = pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(img.Data))
+	}
+	if !pdf.Ok() || info == nil {
+		Logger(ctx).Warn("Не удалось добавить изображение в PDF", "publication_id", a.ID, "image", block.Image, "error", pdf.Error())
+		pdf.ClearError()
+		return
+	}
+
+	// Высокое изображение уменьшается так, чтобы поместилось на одной странице
+	left, top, _, bottom := pdf.GetMargins()
+	_, pageHeight := pdf.GetPageSize()
+	width := min(maxWidth, info.Width())
+	if maxHeight := pageHeight - top - bottom - 15; width*info.Height()/info.Width() > maxHeight {
+		width = maxHeight * info.Width() / info.Height()
+	}
+	pdf.Ln(2)
+	pdf.ImageOptions(name, left+(maxWidth-width)/2, -1, width, 0, true, options, 0, "")
+	if block.Alt != "" {
+		pdf.SetFont(pdfFont, "", 9)
+		pdf.MultiCell(0, 5, block.Alt, "", "C", false)
+	}
+	pdf.Ln(2)
+}
+
+// RenderPublicationPDF верстает одну публикацию
+func RenderPublicationPDF(ctx context.Context, w io.Writer, publicationID int) error {
+	a, err := loadExportArticle(publicationID)
+	if err != nil {
+		return err
+	}
+	pdf, err := newPDF(a.Title, 0)
+	if err != nil {
+		return err
+	}
+	writePDFArticle(ctx, pdf, a, 0)
+	return pdf.Output(w)
+}
+
+// renderIssuePDF верстает выпуск: титульный лист, оглавление и статьи по разделам.
+// pages — номера первых страниц статей для оглавления; возвращаются фактические номера.
+func renderIssuePDF(ctx context.Context, issue Issue, sections []IssueSection, articles map[int]exportArticle, pages []int) (*fpdf.Fpdf, []int, error) {
+	title := fmt.Sprintf("Выпуск №%d", issue.Number)
+	pdf, err := newPDF(title, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Титульный лист
+	pdf.AddPage()
+	pdf.SetY(90)
+	pdf.SetFont(pdfFont, "B", 32)
+	pdf.CellFormat(0, 16, title, "", 1, "C", false, 0, "")
+	pdf.SetFont(pdfFont, "", 16)
+	pdf.CellFormat(0, 10, issue.Date.Format("02.01.2006"), "", 1, "C", false, 0, "")
+	if issue.Theme != "" {
+		pdf.Ln(10)
+		pdf.SetFont(pdfFont, "", 14)
+		pdf.MultiCell(0, 8, issue.Theme, "", "C", false)
+	}
+
+	// Оглавление: номера страниц на первом проходе ещё неизвестны, но место под них
+	// одинаковое, поэтому вёрстка второго прохода не сдвигается
+	pdf.AddPage()
+	pdf.SetFont(pdfFont, "B", 18)
+	pdf.CellFormat(0, 12, "Содержание", "", 1, "L", false, 0, "")
+	var links []int
+	n := 0
+	for _, s := range sections {
+		pdf.Ln(2)
+		pdf.SetFont(pdfFont, "B", 12)
+		pdf.CellFormat(0, 8, s.Department, "", 1, "L", false, 0, "")
+		pdf.SetFont(pdfFont, "", 11)
+		for _, p := range s.Publications {
+			link := pdf.AddLink()
+			links = append(links, link)
+			page := ""
+			if n < len(pages) {
+				page = strconv.Itoa(pages[n])
+			}
+			pageWidth, _ := pdf.GetPageSize()
+			titleWidth := pageWidth - 2*pdfMargin - 25
+			pdf.SetX(pdfMargin + 5)
+			pdf.CellFormat(titleWidth, pdfLineHeight, pdfFit(pdf, p.Title, titleWidth), "", 0, "L", false, link, "")
+			pdf.CellFormat(20, pdfLineHeight, page, "", 1, "R", false, link, "")
+			n++
+		}
+	}
+
+	var starts []int
+	n = 0
+	for _, s := range sections {
+		for _, p := range s.Publications {
+			starts = append(starts, writePDFArticle(ctx, pdf, articles[p.PublicationID], links[n]))
+			n++
+		}
+	}
+	return pdf, starts, pdf.Error()
+}
+
+// pdfFit укорачивает строку с многоточием, чтобы она поместилась в ширину width текущим шрифтом
+func pdfFit(pdf *fpdf.Fpdf, s string, width float64) string {
+	if pdf.GetStringWidth(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"…") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// RenderIssuePDF верстает весь выпуск в порядке разделов и публикаций
+func RenderIssuePDF(ctx context.Context, w io.Writer, issueID int) error {
+	issue, sections, err := GetIssue(issueID)
+	if err != nil {
+		return err
+	}
+	articles := map[int]exportArticle{}
+	for _, s := range sections {
+		for _, p := range s.Publications {
+			a, err := loadExportArticle(p.PublicationID)
+			if err != nil {
+				return err
+			}
+			articles[p.PublicationID] = a
+		}
+	}
+
+	// Первый проход узнаёт страницы статей, второй выводит их в оглавление
+	_, pages, err := renderIssuePDF(ctx, issue, sections, articles, nil)
+	if err != nil {
+		return err
+	}
+	pdf, _, err := renderIssuePDF(ctx, issue, sections, articles, pages)
+	if err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+// writePDF отправляет готовый PDF; документ сначала собирается в памяти,
+// чтобы ошибка вёрстки не оборвала уже начатый ответ
+func writePDF(w http.ResponseWriter, filename string, render func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// PDF публикации для печати и партнёров
+func PublicationPDFHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		writeJSONError(w, r, http.StatusUnauthorized, "Необходимо войти в систему")
+		return
+	}
+	publicationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "Неверный идентификатор публикации")
+		return
+	}
+	allowed, err := canManageContributors(r.Context(), publicationID, userID)
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, "Ошибка проверки прав")
+		return
+	}
+	if !allowed {
+		writeJSONError(w, r, http.StatusForbidden, "Недостаточно прав для доступа к публикации")
+		return
+	}
+
+	err = writePDF(w, fmt.Sprintf("publication-%d.pdf", publicationID), func(out io.Writer) error {
+		return RenderPublicationPDF(r.Context(), out, publicationID)
+	})
+	if err == sql.ErrNoRows {
+		writeJSONError(w, r, http.StatusNotFound, "Публикация не найдена")
+		return
+	}
+	if err != nil {
+		Logger(r.Context()).Error("Ошибка формирования PDF публикации", "publication_id", publicationID, "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "Ошибка при формировании PDF")
+	}
+}
+
+// PDF всего выпуска: титульный лист, оглавление и статьи
+func IssuePDFHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := CurrentUserID(r)
+	if !ok {
+		writeJSONError(w, r, http.StatusUnauthorized, "Необходимо войти в систему")
+		return
+	}
+	isEditor, err := CheckEditor(r.Context(), userID)
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, "Ошибка проверки прав")
+		return
+	}
+	if !isEditor {
+		writeJSONError(w, r, http.StatusForbidden, "PDF выпуска доступен только редакторам")
+		return
+	}
+	issueID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "Неверный идентификатор выпуска")
+		return
+	}
+
+	err = writePDF(w, fmt.Sprintf("issue-%d.pdf", issueID), func(out io.Writer) error {
+		return RenderIssuePDF(r.Context(), out, issueID)
+	})
+	if err == ErrIssueNotFound {
+		writeJSONError(w, r, http.StatusNotFound, "Выпуск не найден")
+		return
+	}
+	if err != nil {
+		Logger(r.Context()).Error("Ошибка формирования PDF выпуска", "issue_id", issueID, "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "Ошибка при формировании PDF")
+	}
+}
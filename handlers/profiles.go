@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	htmltemplate "html/template"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+var TmplProfile = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "profile.html"))
+var TmplAuthorPublic = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "author_public.html"))
+
+// Максимальный размер аватара
+const maxAvatarSize = 2 << 20
+
+// Поддерживаемые социальные сети в профиле
+var SocialNetworks = []string{"website", "telegram", "vk", "twitter"}
+
+// Поддерживаемые языки интерфейса
+var ProfileLanguages = []string{"ru", "en"}
+
+// Профиль пользователя
+type Profile struct {
+	UserID            int
+	Login             string
+	FullName          string
+	Email             string
+	Bio               string
+	HasAvatar         bool
+	SocialLinks       map[string]string
+	PreferredLanguage string
+	UpdatedAt         sql.NullTime
+}
+
+// DisplayName возвращает имя для подписи: полное имя или логин
+func (p Profile) DisplayName() string {
+	if p.FullName != "" {
+		return p.FullName
+	}
+	return p.Login
+}
+
+// GetProfile возвращает профиль пользователя. Если профиль ещё не заполнен, возвращаются значения по умолчанию.
+func GetProfile(userID int) (Profile, error) {
+	profile := Profile{UserID: userID, PreferredLanguage: "ru"}
+	var links []byte
+	query := `SELECT u.login, COALESCE(p.full_name, ''), COALESCE(p.email, ''), COALESCE(p.bio, ''),
+                     p.avatar IS NOT NULL, COALESCE(p.social_links, '{}'), COALESCE(p.preferred_language, 'ru'), p.updated_at
+              FROM users u LEFT JOIN user_profiles p ON p.user_id = u.id
+              WHERE u.id = $1 AND u.deleted_at IS NULL`
+	err := Db.QueryRow(query, userID).Scan(&profile.Login, &profile.FullName, &profile.Email, &profile.Bio,
+		&profile.HasAvatar, &links, &profile.PreferredLanguage, &profile.UpdatedAt)
+	if err != nil {
+		return profile, err
+	}
+	if err := json.Unmarshal(links, &profile.SocialLinks); err != nil {
+		return profile, err
+	}
+	return profile, nil
+}
+
+// validateProfile проверяет email, язык и ссылки профиля
+func validateProfile(profile *Profile) error {
+	if profile.Email != "" {
+		addr, err := mail.ParseAddress(profile.Email)
+		if err != nil {
+			return &UserValidationError{Message: "Неверный адрес электронной почты"}
+		}
+		profile.Email = addr.Address
+	}
+
+	languageKnown := false
+	for _, lang := range ProfileLanguages {
+		if profile.PreferredLanguage == lang {
+			languageKnown = true
+		}
+	}
+	if !languageKnown {
+		return &UserValidationError{Message: "Неизвестный язык: " + profile.PreferredLanguage}
+	}
+
+	for network, link := range profile.SocialLinks {
+		if link == "" {
+			delete(profile.SocialLinks, network)
+			continue
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &UserValidationError{Message: "Неверная ссылка для " + network}
+		}
+	}
+	return nil
+}
+
+// SaveProfile проверяет и сохраняет текстовые поля профиля
+func SaveProfile(profile Profile) error {
+	if err := validateProfile(&profile); err != nil {
+		return err
+	}
+	links, err := json.Marshal(profile.SocialLinks)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO user_profiles (user_id, full_name, email, bio, social_links, preferred_language, updated_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7)
+              ON CONFLICT (user_id) DO UPDATE SET full_name = EXCLUDED.full_name, email = EXCLUDED.email,
+                  bio = EXCLUDED.bio, social_links = EXCLUDED.social_links,
+                  preferred_language = EXCLUDED.preferred_language, updated_at = EXCLUDED.updated_at`
+	_, err = Db.Exec(query, profile.UserID, profile.FullName, profile.Email, profile.Bio, links, profile.PreferredLanguage, time.Now())
+	return err
+}
+
+// SaveAvatar проверяет, что файл является изображением, и сохраняет его как аватар
+func SaveAvatar(userID int, src io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(src, maxAvatarSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxAvatarSize {
+		return &UserValidationError{Message: "Аватар слишком большой (не более 2 МБ)"}
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return &UserValidationError{Message: "Аватар должен быть изображением PNG, JPEG или GIF"}
+	}
+
+	query := `INSERT INTO user_profiles (user_id, avatar, avatar_type, updated_at) VALUES ($1, $2, $3, $4)
+              ON CONFLICT (user_id) DO UPDATE SET avatar = EXCLUDED.avatar, avatar_type = EXCLUDED.avatar_type,
+                  updated_at = EXCLUDED.updated_at`
+	_, err = Db.Exec(query, userID, data, "image/"+format, time.Now())
+	return err
+}
+
+// profileOwner определяет, чей профиль редактируется: свой или, для администратора, любой.
+// Пользователь берётся из сессии. При ошибке ответ уже отправлен.
+func profileOwner(w http.ResponseWriter, r *http.Request, userIDStr string) (int, bool) {
+	sessionUserID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	userID := sessionUserID
+	if userIDStr != "" {
+		var err error
+		userID, err = strconv.Atoi(userIDStr)
+		if err != nil {
+			http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+			return 0, false
+		}
+	}
+	if userID != sessionUserID {
+		role, err := GetUserRoleByIDFromDB(r.Context(), sessionUserID)
+		if err != nil || !IsAdminRole(role) {
+			http.Error(w, "Можно редактировать только собственный профиль", http.StatusForbidden)
+			return 0, false
+		}
+	}
+	return userID, true
+}
+
+// Страница редактирования профиля
+func ProfilePage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := profileOwner(w, r, r.URL.Query().Get("id"))
+	if !ok {
+		return
+	}
+
+	profile, err := GetProfile(userID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении профиля", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Profile   Profile
+		Networks  []string
+		Languages []string
+	}{
+		Profile:   profile,
+		Networks:  SocialNetworks,
+		Languages: ProfileLanguages,
+	}
+	if err := TmplProfile.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона профиля", "error", err)
+	}
+}
+
+// Сохранение профиля
+func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(maxAvatarSize + 1<<20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, "Ошибка при обработке формы", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := profileOwner(w, r, r.FormValue("user_id"))
+	if !ok {
+		return
+	}
+
+	profile := Profile{
+		UserID:            userID,
+		FullName:          strings.TrimSpace(r.FormValue("full_name")),
+		Email:             strings.TrimSpace(r.FormValue("email")),
+		Bio:               strings.TrimSpace(r.FormValue("bio")),
+		PreferredLanguage: r.FormValue("preferred_language"),
+		SocialLinks:       map[string]string{},
+	}
+	for _, network := range SocialNetworks {
+		profile.SocialLinks[network] = strings.TrimSpace(r.FormValue("social_" + network))
+	}
+
+	if err := SaveProfile(profile); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if file, _, err := r.FormFile("avatar"); err == nil {
+		defer file.Close()
+		if err := SaveAvatar(userID, file); err != nil {
+			writeUserError(w, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/profile?id="+strconv.Itoa(userID), http.StatusSeeOther)
+}
+
+// Выдача аватара пользователя
+func AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+
+	var avatar []byte
+	var avatarType string
+	err = Db.QueryRow(`SELECT avatar, avatar_type FROM user_profiles WHERE user_id = $1 AND avatar IS NOT NULL`, userID).Scan(&avatar, &avatarType)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении аватара", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", avatarType)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(avatar)
+}
+
+// GetPublishedArticlesByAuthor возвращает опубликованные статьи, в которых участвовал автор, начиная с самых новых
+func GetPublishedArticlesByAuthor(authorID int) ([]Publication, error) {
+	query := `SELECT p.id, p.title, p.published_at, p.is_retracted, p.author_id, c.role
+              FROM publication_contributors c JOIN publications p ON p.id = c.publication_id
+              WHERE c.user_id = $1 AND p.is_published = TRUE AND p.deleted_at IS NULL
+              ORDER BY COALESCE(p.published_at, p.updated_at) DESC`
+	rows, err := Db.Query(query, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publications []Publication
+	for rows.Next() {
+		var pub Publication
+		if err := rows.Scan(&pub.ID, &pub.Title, &pub.PublishedAt, &pub.IsRetracted, &pub.AuthorID, &pub.ContributorRole); err != nil {
+			return nil, err
+		}
+		pub.IsPublished = true
+		publications = append(publications, pub)
+	}
+	return publications, rows.Err()
+}
+
+// Публичная страница автора со списком его опубликованных статей
+func AuthorPublicPage(w http.ResponseWriter, r *http.Request) {
+	authorID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор автора", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := GetProfile(authorID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении профиля автора", http.StatusInternalServerError)
+		return
+	}
+
+	publications, err := GetPublishedArticlesByAuthor(authorID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении публикаций автора", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Profile      Profile
+		Publications []Publication
+	}{
+		Profile:      profile,
+		Publications: publications,
+	}
+	if err := TmplAuthorPublic.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона страницы автора", "error", err)
+	}
+}
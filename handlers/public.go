@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/xml"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+// Публичные страницы выводят текст, введённый авторами, поэтому используют html/template с экранированием
+var TmplArticle = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "article.html"))
+
+// Количество статей в ленте
+const feedSize = 50
+
+// publicationImageURL — адрес изображения публикации; base пуст для ссылок внутри сайта
+func publicationImageURL(base string, publicationID int, name string) string {
+	return base + "/publication/image?publication_id=" + strconv.Itoa(publicationID) + "&name=" + url.QueryEscape(name)
+}
+
+// ContentHTML выводит текст публикации с оформлением и изображениями.
+// Разметка экранируется в markupHTML, поэтому результат безопасно вставлять в страницу.
+func (p Publication) ContentHTML() htmltemplate.HTML {
+	return htmltemplate.HTML(markupHTML(p.Content, func(name string) (string, bool) {
+		return publicationImageURL("", p.ID, name), true
+	}))
+}
+
+// GetPublicArticle возвращает статью для публичной страницы: опубликованную или снятую с публикации
+func GetPublicArticle(publicationID int) (Publication, error) {
+	var pub Publication
+	query := `SELECT p.id, p.title, p.content, p.status, COALESCE(p.department, ''), COALESCE(p.is_published, FALSE),
+                     p.published_at, p.unpublish_reason, p.is_retracted, p.retraction_reason, p.updated_at,
+                     p.author_id, COALESCE(NULLIF(pr.full_name, ''), u.login, ''),
+                     COALESCE(c.name, ''), COALESCE(c.slug, '')
+              FROM publications p
+              LEFT JOIN users u ON u.id = p.author_id
+              LEFT JOIN user_profiles pr ON pr.user_id = p.author_id
+              LEFT JOIN categories c ON c.id = p.category_id
+              WHERE p.id = $1 AND p.deleted_at IS NULL AND (p.is_published = TRUE OR p.status = 'unpublished')`
+	err := Db.QueryRow(query, publicationID).Scan(&pub.ID, &pub.Title, &pub.Content, &pub.Status, &pub.Department, &pub.IsPublished,
+		&pub.PublishedAt, &pub.UnpublishReason, &pub.IsRetracted, &pub.RetractionReason, &pub.UpdatedAt,
+		&pub.AuthorID, &pub.AuthorName, &pub.CategoryName, &pub.CategorySlug)
+	if err != nil {
+		return pub, err
+	}
+	pub.Tags, err = GetPublicationTags(publicationID)
+	return pub, err
+}
+
+// GetPublishedArticles возвращает последние опубликованные статьи, начиная с самых новых
+func GetPublishedArticles(limit int) ([]Publication, error) {
+	return queryPublishedArticles("", limit)
+}
+
+// queryPublishedArticles выбирает опубликованные статьи с дополнительным условием filter.
+// Параметры условия передаются в args, лимит добавляется последним параметром.
+func queryPublishedArticles(filter string, limit int, args ...interface{}) ([]Publication, error) {
+	query := `SELECT id, title, content, COALESCE(department, ''), published_at, is_retracted, retraction_reason, updated_at
+              FROM publications WHERE is_published = TRUE AND deleted_at IS NULL` + filter + `
+              ORDER BY COALESCE(published_at, updated_at) DESC LIMIT $` + strconv.Itoa(len(args)+1)
+	rows, err := Db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publications []Publication
+	for rows.Next() {
+		var pub Publication
+		if err := rows.Scan(&pub.ID, &pub.Title, &pub.Content, &pub.Department, &pub.PublishedAt, &pub.IsRetracted, &pub.RetractionReason, &pub.UpdatedAt); err != nil {
+			return nil, err
+		}
+		pub.IsPublished = true
+		publications = append(publications, pub)
+	}
+	return publications, rows.Err()
+}
+
+// Публичная страница статьи
+func ArticlePage(w http.ResponseWriter, r *http.Request) {
+	publicationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+		return
+	}
+
+	pub, err := GetPublicArticle(publicationID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении статьи", http.StatusInternalServerError)
+		return
+	}
+
+	corrections, err := GetCorrections(publicationID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении исправлений", http.StatusInternalServerError)
+		return
+	}
+
+	contributors, err := GetContributors(publicationID)
+	if err != nil {
+		http.Error(w, "Ошибка при получении авторов статьи", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Publication  Publication
+		Corrections  []Correction
+		Contributors []Contributor
+	}{
+		Publication:  pub,
+		Corrections:  corrections,
+		Contributors: contributors,
+	}
+
+	// Снятая статья остаётся по адресу, но сообщает, что её больше нет
+	if !pub.IsPublished {
+		w.WriteHeader(http.StatusGone)
+	}
+	if err := TmplArticle.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона статьи", "error", err)
+	}
+}
+
+// Элементы RSS 2.0
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Language    string    `xml:"language"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate,omitempty"`
+}
+
+// baseURL восстанавливает адрес сайта из запроса
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writeFeed формирует RSS-ленту из списка статей с баннерами отзыва и исправлениями
+func writeFeed(w http.ResponseWriter, r *http.Request, title string, publications []Publication) {
+	base := baseURL(r)
+	channel := rssChannel{
+		Title:       title,
+		Link:        base + "/",
+		Description: title,
+		Language:    "ru",
+	}
+
+	for _, pub := range publications {
+		// Описание — HTML статьи; программы чтения лент показывают его с оформлением и картинками
+		pubID := pub.ID
+		content := markupHTML(pub.Content, func(name string) (string, bool) {
+			return publicationImageURL(base, pubID, name), true
+		})
+		item := rssItem{
+			Title:       pub.Title,
+			Link:        base + "/article?id=" + strconv.Itoa(pub.ID),
+			GUID:        base + "/article?id=" + strconv.Itoa(pub.ID),
+			Description: content,
+		}
+		if pub.PublishedAt.Valid {
+			item.PubDate = pub.PublishedAt.Time.Format(time.RFC1123Z)
+		}
+		if pub.IsRetracted {
+			item.Title = "[Отозвана] " + pub.Title
+			item.Description = "<p><strong>Статья отозвана:</strong> " + xmlEscape(pub.RetractionReason) + "</p>\n" + content
+		}
+
+		corrections, err := GetCorrections(pub.ID)
+		if err != nil {
+			http.Error(w, "Ошибка при получении исправлений", http.StatusInternalServerError)
+			return
+		}
+		for _, c := range corrections {
+			item.Description += "<p>Исправление от " + c.CreatedAt.Format("02.01.2006") + ": " + xmlEscape(c.Notice) + "</p>\n"
+		}
+
+		channel.Items = append(channel.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(rssFeed{Version: "2.0", Channel: channel}); err != nil {
+		Logger(r.Context()).Error("Ошибка формирования RSS-ленты", "error", err)
+	}
+}
+
+// RSS-лента последних публикаций
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	publications, err := GetPublishedArticles(feedSize)
+	if err != nil {
+		http.Error(w, "Ошибка при получении публикаций", http.StatusInternalServerError)
+		return
+	}
+	writeFeed(w, r, "Последние публикации", publications)
+}
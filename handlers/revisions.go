@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Сохранённая версия публикации
+type Revision struct {
+	ID            int
+	PublicationID int
+	Title         string
+	Content       string
+	Status        string
+	Version       sql.NullInt64
+	Note          string
+	CreatedBy     sql.NullInt64
+	CreatedAt     time.Time
+}
+
+// nullableUserID превращает нулевой идентификатор пользователя в NULL
+func nullableUserID(userID int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(userID), Valid: userID > 0}
+}
+
+// SaveRevision сохраняет текущее состояние публикации как новую версию и возвращает её ID
+func SaveRevision(tx *sql.Tx, publicationID, userID int, note string) (int, error) {
+	var revisionID int
+	query := `INSERT INTO publication_revisions (publication_id, title, content, status, version, note, created_by)
+              SELECT id, title, content, status, version, $2, $3 FROM publications WHERE id = $1
+              RETURNING id`
+	err := tx.QueryRow(query, publicationID, note, nullableUserID(userID)).Scan(&revisionID)
+	return revisionID, err
+}
+
+// GetRevisions возвращает историю версий публикации, начиная с самой новой
+func GetRevisions(publicationID int) ([]Revision, error) {
+	query := `SELECT id, publication_id, title, content, status, version, note, created_by, created_at
+              FROM publication_revisions WHERE publication_id = $1 ORDER BY id DESC`
+	rows, err := Db.Query(query, publicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.ID, &rev.PublicationID, &rev.Title, &rev.Content, &rev.Status, &rev.Version, &rev.Note, &rev.CreatedBy, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// Редакционное замечание к публикации, привязанное к версии, к которой оно относится
+type PublicationComment struct {
+	ID         int
+	RevisionID sql.NullInt64
+	AuthorName string
+	Body       string
+	CreatedAt  time.Time
+}
+
+// AddPublicationComment сохраняет замечание в истории и привязывает его к последней версии публикации
+func AddPublicationComment(publicationID, userID int, body string) error {
+	query := `INSERT INTO publication_comments (publication_id, revision_id, author_id, body)
+              VALUES ($1, (SELECT MAX(id) FROM publication_revisions WHERE publication_id = $1), $2, $3)`
+	_, err := Db.Exec(query, publicationID, nullableUserID(userID), body)
+	return err
+}
+
+// GetPublicationComments возвращает замечания по порядку; если задана версия, то только сделанные к ней и более ранним
+func GetPublicationComments(publicationID, upToRevisionID int) ([]PublicationComment, error) {
+	query := `SELECT c.id, c.revision_id, COALESCE(NULLIF(pr.full_name, ''), u.login, 'Редакция'), c.body, c.created_at
+              FROM publication_comments c
+              LEFT JOIN users u ON u.id = c.author_id
+              LEFT JOIN user_profiles pr ON pr.user_id = c.author_id
+              WHERE c.publication_id = $1 AND ($2 = 0 OR c.revision_id <= $2)
+              ORDER BY c.id`
+	rows, err := Db.Query(query, publicationID, upToRevisionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []PublicationComment
+	for rows.Next() {
+		var c PublicationComment
+		if err := rows.Scan(&c.ID, &c.RevisionID, &c.AuthorName, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Часовой пояс по умолчанию для формы планирования выкладки
+const DefaultScheduleTZ = "Europe/Moscow"
+
+// ScheduledAtLocal возвращает время выкладки в часовом поясе, выбранном редактором
+func (p Publication) ScheduledAtLocal() string {
+	return formatInZone(p.ScheduledAt, p.ScheduleTZ)
+}
+
+// EmbargoUntilLocal возвращает время снятия эмбарго в часовом поясе, выбранном редактором
+func (p Publication) EmbargoUntilLocal() string {
+	return formatInZone(p.EmbargoUntil, p.ScheduleTZ)
+}
+
+func formatInZone(t sql.NullTime, zone string) string {
+	if !t.Valid {
+		return ""
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil || zone == "" {
+		loc = time.Local
+	}
+	return t.Time.In(loc).Format("02.01.2006 15:04 MST")
+}
+
+// parseScheduleTime разбирает время из поля datetime-local в указанном часовом поясе
+func parseScheduleTime(value string, loc *time.Location) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.ParseInLocation(dueDateLayout, value, loc)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
+
+// Планирование (или перенос) выкладки одобренной публикации
+func SchedulePublicationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	editorID, role, ok := sessionRole(w, r, IsEditorRole, "Планировать выкладку может только редактор")
+	if !ok {
+		return
+	}
+
+	articleID, err := strconv.Atoi(r.FormValue("article_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+		return
+	}
+
+	zone := r.FormValue("timezone")
+	if zone == "" {
+		zone = DefaultScheduleTZ
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		http.Error(w, "Неизвестный часовой пояс", http.StatusBadRequest)
+		return
+	}
+
+	publishAt, err := parseScheduleTime(r.FormValue("publish_at"), loc)
+	if err != nil || !publishAt.Valid {
+		http.Error(w, "Неверное время выкладки", http.StatusBadRequest)
+		return
+	}
+	if !publishAt.Time.After(time.Now()) {
+		http.Error(w, "Время выкладки должно быть в будущем", http.StatusBadRequest)
+		return
+	}
+
+	embargoUntil, err := parseScheduleTime(r.FormValue("embargo_until"), loc)
+	if err != nil {
+		http.Error(w, "Неверное время снятия эмбарго", http.StatusBadRequest)
+		return
+	}
+
+	// Публикация из зафиксированного выпуска выйдет вместе с ним
+	if !checkNotInLockedIssue(w, articleID) {
+		return
+	}
+
+	// Планировать можно одобренную публикацию, переносить — уже запланированную
+	query := `UPDATE publications
+              SET status = 'scheduled', scheduled_at = $1, schedule_tz = $2, embargo_until = $3, updated_at = $4
+              WHERE id = $5 AND status IN ('approved', 'scheduled') AND ` + notInLockedIssue
+	result, err := Db.Exec(query, publishAt, zone, embargoUntil, time.Now(), articleID)
+	if err != nil {
+		http.Error(w, "Ошибка при планировании выкладки: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		http.Error(w, "Публикация должна быть одобрена перед выкладкой", http.StatusBadRequest)
+		return
+	}
+	// Эмбарго позже времени выкладки откладывает её: планировщик ждёт и того, и другого
+	message := "Выкладка запланирована на " + publishAt.Time.Format("02.01.2006 15:04")
+	if embargoUntil.Valid && embargoUntil.Time.After(publishAt.Time) {
+		message += ", но не раньше снятия эмбарго " + embargoUntil.Time.Format("02.01.2006 15:04")
+	}
+	publishPublicationEvent(r.Context(), EventStatusChanged, articleID, editorID, message)
+
+	redirectToEditorPage(w, r, role, editorID)
+}
+
+// Отмена запланированной выкладки: публикация возвращается в статус "approved".
+// Эмбарго сохраняется и продолжает запрещать немедленную выкладку.
+func CancelScheduledPublicationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	editorID, role, ok := sessionRole(w, r, IsEditorRole, "Отменять выкладку может только редактор")
+	if !ok {
+		return
+	}
+
+	articleID, err := strconv.Atoi(r.FormValue("article_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+		return
+	}
+
+	query := `UPDATE publications
+              SET status = 'approved', scheduled_at = NULL, updated_at = $1
+              WHERE id = $2 AND status = 'scheduled'`
+	result, err := Db.Exec(query, time.Now(), articleID)
+	if err != nil {
+		http.Error(w, "Ошибка при отмене выкладки: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		http.Error(w, "Публикация не запланирована или уже выложена", http.StatusConflict)
+		return
+	}
+	publishPublicationEvent(r.Context(), EventStatusChanged, articleID, editorID, "Запланированная выкладка отменена")
+
+	redirectToEditorPage(w, r, role, editorID)
+}
+
+// StartPublishScheduler периодически выкладывает публикации, время которых наступило
+func StartPublishScheduler(ctx context.Context, interval time.Duration) {
+	startPeriodicWorker(ctx, interval, "отложенная выкладка", func() error {
+		_, err := PublishDuePublications()
+		return err
+	})
+}
+
+// PublishDuePublications выкладывает запланированные публикации, время выкладки и эмбарго
+// которых наступили. Смена статуса выполняется одним условным UPDATE, поэтому при нескольких
+// запущенных экземплярах сервера каждая публикация выкладывается ровно один раз: строки,
+// заблокированные другим экземпляром, пропускаются, а повторная выкладка отсекается условием на статус.
+// Публикации из зафиксированного выпуска ждут выхода выпуска.
+func PublishDuePublications() ([]int, error) {
+	query := `UPDATE publications
+              SET status = 'published', is_published = TRUE, published_at = NOW(), updated_at = NOW()
+              WHERE id IN (
+                  SELECT id FROM publications
+                  WHERE status = 'scheduled' AND scheduled_at <= NOW() AND deleted_at IS NULL
+                    AND (embargo_until IS NULL OR embargo_until <= NOW())
+                    AND ` + notInLockedIssue + `
+                  FOR UPDATE SKIP LOCKED
+              ) AND status = 'scheduled'
+              RETURNING id`
+	rows, err := Db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var published []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		published = append(published, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range published {
+		log.Printf("Публикация %d выложена по расписанию", id)
+		publishPublicationEvent(context.Background(), EventStatusChanged, id, 0, "Публикация выложена по расписанию")
+	}
+	return published, nil
+}
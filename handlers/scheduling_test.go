@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPublishDuePublicationsOnce(t *testing.T) {
+	// Публикация 7 запланирована на прошедшее время; UPDATE выкладывает её, только пока она в статусе scheduled
+	status := "scheduled"
+	s := &fakeStore{row: func(query string, _ []driver.Value) []driver.Value {
+		if !strings.HasPrefix(query, "UPDATE publications SET status = 'published'") {
+			return nil
+		}
+		if status != "scheduled" {
+			return []driver.Value{}
+		}
+		status = "published"
+		return []driver.Value{int64(7)}
+	}}
+	useFakeDb(t, s)
+
+	for run, want := range []int{1, 0} {
+		published, err := PublishDuePublications()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(published) != want {
+			t.Errorf("запуск %d: выложены %v, ожидалось публикаций: %d", run+1, published, want)
+		}
+	}
+
+	update := s.find(t, "UPDATE publications SET status = 'published'")
+	if !strings.Contains(update.query, "FOR UPDATE SKIP LOCKED") || !strings.Contains(update.query, ") AND status = 'scheduled'") {
+		t.Errorf("параллельные запуски могут выложить публикацию дважды: %s", update.query)
+	}
+	events := 0
+	for _, c := range s.calls {
+		if strings.HasPrefix(c.query, "SELECT p.title, p.status") {
+			events++
+		}
+	}
+	if events != 1 {
+		t.Errorf("событий о выкладке: %d, ожидалось одно", events)
+	}
+}
+
+func TestPublishHandlersNeedSession(t *testing.T) {
+	form := url.Values{"article_id": {"7"}, "user_id": {"5"}}
+	for name, handler := range map[string]http.HandlerFunc{"выкладка": PublishPublicationHandler, "разрешение": AllowPublicationHandler} {
+		s := &fakeStore{}
+		useFakeDb(t, s)
+		rec := httptest.NewRecorder()
+		handler(rec, postForm("/", form))
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s без сессии: статус %d, ожидался 401", name, rec.Code)
+		}
+		for _, c := range s.calls {
+			if strings.HasPrefix(c.query, "UPDATE publications") {
+				t.Errorf("%s без сессии изменила публикацию: %s", name, c.query)
+			}
+		}
+	}
+}
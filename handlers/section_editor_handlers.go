@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"database/sql"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -22,41 +21,62 @@ func SectionEditorPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userName, err := GetUserNameByIDFromDB(userID)
+	userName, err := GetUserNameByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении имени пользователя из базы данных", http.StatusInternalServerError)
 		return
 	}
 
-	role, err := GetUserRoleByIDFromDB(userID)
+	role, err := GetUserRoleByIDFromDB(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Ошибка при получении роли пользователя из базы данных", http.StatusInternalServerError)
 		return
 	}
 
 	// Получаем список публикаций
-	publications, err := GetPublications()
+	publications, err := GetPublications(r.Context())
 	if err != nil {
 		http.Error(w, "Ошибка при получении публикаций", http.StatusInternalServerError)
 		return
 	}
 
+	categories, err := GetCategoryTree()
+	if err != nil {
+		http.Error(w, "Ошибка при получении рубрик", http.StatusInternalServerError)
+		return
+	}
+	tags, err := GetTags()
+	if err != nil {
+		http.Error(w, "Ошибка при получении тегов", http.StatusInternalServerError)
+		return
+	}
+
+	// Фильтр публикаций по тегу
+	tag := r.URL.Query().Get("tag")
+	publications = FilterPublicationsByTag(publications, tag)
+
 	// Создаем данные для шаблона
 	data := struct {
 		UserID       int
 		UserName     string
 		Role         string
 		Publications []Publication
+		Categories   []Category
+		Tags         []Tag
+		Tag          string
 	}{
 		UserID:       userID,
 		UserName:     userName,
 		Role:         role,
 		Publications: publications,
+		Categories:   categories,
+		Tags:         tags,
+		Tag:          tag,
 	}
 
 	// Выполняем шаблон
 	if err = TmplSectionEditor.Execute(w, data); err != nil {
-		log.Printf("Ошибка выполнения шаблона: %v", err)
+		Logger(r.Context()).Error("Ошибка выполнения шаблона", "error", err)
 		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
 		return
 	}
@@ -64,23 +84,16 @@ func SectionEditorPage(w http.ResponseWriter, r *http.Request) {
 
 // Обработчик разрешения выкладки публикации
 func AllowPublicationHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
-		return
-	}
-
-	articleIDStr := r.FormValue("article_id")
-	editorIDStr := r.FormValue("editor_id")
-	articleID, err := strconv.Atoi(articleIDStr)
-	if err != nil {
-		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+	// Разрешает выкладку редактор из сессии
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
 		return
 	}
 
 	// Проверка статуса публикации
 	var status string
 	query := `SELECT status FROM publications WHERE id = $1`
-	err = Db.QueryRow(query, articleID).Scan(&status)
+	err := Db.QueryRow(query, articleID).Scan(&status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Публикация не найдена", http.StatusNotFound)
@@ -95,39 +108,39 @@ func AllowPublicationHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Публикация должна быть одобрена перед выкладкой", http.StatusBadRequest)
 		return
 	}
+	if !checkNotInLockedIssue(w, articleID) {
+		return
+	}
 
 	// Обновляем статус на "ready_for_publication" и устанавливаем is_published = TRUE
-	updateQuery := `UPDATE publications SET status = 'ready_for_publication', is_published = TRUE, updated_at = $1 WHERE id = $2`
-	_, err = Db.Exec(updateQuery, time.Now(), articleID)
+	updateQuery := `UPDATE publications SET status = 'ready_for_publication', is_published = TRUE, updated_at = $1
+                    WHERE id = $2 AND ` + notInLockedIssue
+	result, err := Db.Exec(updateQuery, time.Now(), articleID)
 	if err != nil {
 		http.Error(w, "Ошибка при обновлении публикации: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		http.Error(w, "Публикация входит в зафиксированный выпуск", http.StatusConflict)
+		return
+	}
+	publishPublicationEvent(r.Context(), EventStatusChanged, articleID, editorID, "Выкладка публикации разрешена")
 
-	// Перенаправление обратно на страницу редактора отдела
-	http.Redirect(w, r, "/section_editor_page?id="+editorIDStr, http.StatusSeeOther)
+	redirectToEditorPage(w, r, role, editorID)
 }
 
 // Обработчик для выкладки публикации
 func PublishPublicationHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Получаем идентификатор статьи и редактора
-	articleIDStr := r.FormValue("article_id")
-	editorIDStr := r.FormValue("editor_id")
-	articleID, err := strconv.Atoi(articleIDStr)
-	if err != nil {
-		http.Error(w, "Неверный идентификатор статьи", http.StatusBadRequest)
+	// Выкладывает редактор из сессии
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
 		return
 	}
 
 	// Проверка статуса публикации
 	var status string
 	query := `SELECT status FROM publications WHERE id = $1`
-	err = Db.QueryRow(query, articleID).Scan(&status)
+	err := Db.QueryRow(query, articleID).Scan(&status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Публикация не найдена", http.StatusNotFound)
@@ -142,17 +155,29 @@ func PublishPublicationHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Публикация должна быть одобрена перед выкладкой", http.StatusBadRequest)
 		return
 	}
+	if !checkNotInLockedIssue(w, articleID) {
+		return
+	}
 
-	// Обновляем статус публикации на "published" и устанавливаем флаг is_published = TRUE
-	updateQuery := `UPDATE publications SET status = 'published', is_published = TRUE, updated_at = $1 WHERE id = $2`
-	_, err = Db.Exec(updateQuery, time.Now(), articleID)
+	// Обновляем статус публикации на "published" и устанавливаем флаг is_published = TRUE.
+	// Публикацию под эмбарго выложить немедленно нельзя — её нужно запланировать.
+	updateQuery := `UPDATE publications SET status = 'published', is_published = TRUE, published_at = $1, updated_at = $1
+                    WHERE id = $2 AND status = 'approved' AND (embargo_until IS NULL OR embargo_until <= $1)
+                      AND ` + notInLockedIssue
+	result, err := Db.Exec(updateQuery, time.Now(), articleID)
 	if err != nil {
 		http.Error(w, "Ошибка при выкладке публикации: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Перенаправление обратно на страницу редактора отдела
-	http.Redirect(w, r, "/section_editor_page?id="+editorIDStr, http.StatusSeeOther)
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		http.Error(w, "Публикация находится под эмбарго или уже выложена", http.StatusConflict)
+		return
+	}
+	publishPublicationEvent(r.Context(), EventStatusChanged, articleID, editorID, "Публикация выложена")
+
+	redirectToEditorPage(w, r, role, editorID)
 }
 
 // Обработчик для страницы редактора отдела
@@ -170,7 +195,7 @@ func SectionEditorPageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Получаем публикации
-	publications, err := GetPublications()
+	publications, err := GetPublications(r.Context())
 	if err != nil {
 		http.Error(w, "Ошибка при получении публикаций", http.StatusInternalServerError)
 		return
@@ -187,7 +212,7 @@ func SectionEditorPageHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Выполняем шаблон
 	if err := TmplSectionEditor.Execute(w, data); err != nil {
-		log.Printf("Ошибка выполнения шаблона: %v", err)
+		Logger(r.Context()).Error("Ошибка выполнения шаблона", "error", err)
 		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
 		return
 	}
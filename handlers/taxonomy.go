@@ -0,0 +1,653 @@
+package handlers
+
+import (
+	"database/sql"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"example.com/myproject/templates"
+	"github.com/lib/pq"
+)
+
+var TmplTaxonomy = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "taxonomy.html"))
+var TmplArchive = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "archive.html"))
+
+// Рубрика. Рубрики образуют дерево через ParentID.
+type Category struct {
+	ID       int
+	Name     string
+	Slug     string
+	ParentID sql.NullInt64
+	Depth    int // уровень вложенности, 0 — корневая рубрика
+	Count    int // число публикаций непосредственно в рубрике
+}
+
+// Indent возвращает отступ для вывода рубрики в списке с учётом вложенности
+func (c Category) Indent() string {
+	return strings.Repeat("— ", c.Depth)
+}
+
+// Тег. Синоним ссылается на основной тег через CanonicalID.
+type Tag struct {
+	ID          int
+	Name        string
+	Slug        string
+	CanonicalID sql.NullInt64
+	Count       int
+	Synonyms    []string
+}
+
+// Транслитерация кириллицы для адресов рубрик и тегов
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// Slugify превращает название в адрес из латинских букв, цифр и дефисов
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case translit[r] != "":
+			b.WriteString(translit[r])
+			dash = false
+		case r == 'ъ' || r == 'ь':
+		default:
+			if !dash && b.Len() > 0 {
+				b.WriteByte('-')
+				dash = true
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// GetCategoryTree возвращает все рубрики в порядке обхода дерева
+func GetCategoryTree() ([]Category, error) {
+	query := `WITH RECURSIVE tree AS (
+                  SELECT id, name, slug, parent_id, 0 AS depth, ARRAY[LOWER(name)] AS path
+                  FROM categories WHERE parent_id IS NULL
+                  UNION ALL
+                  SELECT c.id, c.name, c.slug, c.parent_id, t.depth + 1, t.path || LOWER(c.name)
+                  FROM categories c JOIN tree t ON c.parent_id = t.id
+              )
+              SELECT t.id, t.name, t.slug, t.parent_id, t.depth,
+                     (SELECT COUNT(*) FROM publications p WHERE p.category_id = t.id AND p.deleted_at IS NULL)
+              FROM tree t ORDER BY t.path`
+	rows, err := Db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID, &c.Depth, &c.Count); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// GetCategoryBySlug возвращает рубрику по адресу
+func GetCategoryBySlug(slug string) (Category, error) {
+	var c Category
+	err := Db.QueryRow(`SELECT id, name, slug, parent_id FROM categories WHERE slug = $1`, slug).
+		Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID)
+	return c, err
+}
+
+// nullableID превращает нулевой идентификатор в NULL
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
+
+// CreateCategory добавляет рубрику; parentID = 0 создаёт корневую рубрику
+func CreateCategory(name string, parentID int) error {
+	name = strings.TrimSpace(name)
+	slug := Slugify(name)
+	if name == "" || slug == "" {
+		return &UserValidationError{Message: "Название рубрики не может быть пустым"}
+	}
+
+	var exists bool
+	if err := Db.QueryRow(`SELECT EXISTS(SELECT 1 FROM categories WHERE slug = $1)`, slug).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return &UserValidationError{Message: "Рубрика с адресом " + slug + " уже существует"}
+	}
+
+	_, err := Db.Exec(`INSERT INTO categories (name, slug, parent_id) VALUES ($1, $2, $3)`, name, slug, nullableID(parentID))
+	return err
+}
+
+// DeleteCategory удаляет рубрику. Подрубрики и публикации переходят к родительской рубрике.
+func DeleteCategory(categoryID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	err = tx.QueryRow(`SELECT parent_id FROM categories WHERE id = $1 FOR UPDATE`, categoryID).Scan(&parentID)
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		`UPDATE categories SET parent_id = $2 WHERE parent_id = $1`,
+		`UPDATE publications SET category_id = $2 WHERE category_id = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, categoryID, parentID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM categories WHERE id = $1`, categoryID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ParseTagNames разбирает список тегов через запятую, убирая пустые и повторяющиеся
+func ParseTagNames(input string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(input, ",") {
+		name = strings.Join(strings.Fields(name), " ")
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// resolveTag находит тег по названию или адресу, создавая его при необходимости.
+// Для синонима возвращается основной тег.
+func resolveTag(exec dbExecutor, name string) (int, error) {
+	slug := Slugify(name)
+	if slug == "" {
+		return 0, &UserValidationError{Message: "Недопустимое название тега: " + name}
+	}
+
+	var id int
+	var canonicalID sql.NullInt64
+	query := `SELECT id, canonical_id FROM tags WHERE LOWER(name) = LOWER($1) OR slug = $2 LIMIT 1`
+	err := exec.QueryRow(query, name, slug).Scan(&id, &canonicalID)
+	if err == sql.ErrNoRows {
+		err = exec.QueryRow(`INSERT INTO tags (name, slug) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING id`, name, slug).Scan(&id)
+		if err == sql.ErrNoRows {
+			// Тег успели создать параллельно
+			err = exec.QueryRow(query, name, slug).Scan(&id, &canonicalID)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if canonicalID.Valid {
+		return int(canonicalID.Int64), nil
+	}
+	return id, nil
+}
+
+// SetPublicationTaxonomy задаёт рубрику и полный набор тегов публикации
+func SetPublicationTaxonomy(exec dbExecutor, publicationID, categoryID int, tagNames []string) error {
+	_, err := exec.Exec(`UPDATE publications SET category_id = $1 WHERE id = $2`, nullableID(categoryID), publicationID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := exec.Exec(`DELETE FROM publication_tags WHERE publication_id = $1`, publicationID); err != nil {
+		return err
+	}
+	for _, name := range tagNames {
+		tagID, err := resolveTag(exec, name)
+		if err != nil {
+			return err
+		}
+		_, err = exec.Exec(`INSERT INTO publication_tags (publication_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, publicationID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePublicationTaxonomy применяет рубрику и теги из формы в одной транзакции
+func updatePublicationTaxonomy(publicationID int, r *http.Request) error {
+	categoryID, _ := strconv.Atoi(r.FormValue("category_id"))
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := SetPublicationTaxonomy(tx, publicationID, categoryID, ParseTagNames(r.FormValue("tags"))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPublicationTags возвращает теги публикации
+func GetPublicationTags(publicationID int) ([]Tag, error) {
+	rows, err := Db.Query(`SELECT t.id, t.name, t.slug FROM publication_tags pt JOIN tags t ON t.id = pt.tag_id
+                           WHERE pt.publication_id = $1 ORDER BY LOWER(t.name)`, publicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Slug); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// attachTags подгружает теги для списка публикаций одним запросом
+func attachTags(publications []Publication) error {
+	if len(publications) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(publications))
+	index := map[int]int{}
+	for i, pub := range publications {
+		ids[i] = int64(pub.ID)
+		index[pub.ID] = i
+	}
+
+	rows, err := Db.Query(`SELECT pt.publication_id, t.id, t.name, t.slug FROM publication_tags pt JOIN tags t ON t.id = pt.tag_id
+                           WHERE pt.publication_id = ANY($1) ORDER BY LOWER(t.name)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var publicationID int
+		var t Tag
+		if err := rows.Scan(&publicationID, &t.ID, &t.Name, &t.Slug); err != nil {
+			return err
+		}
+		i := index[publicationID]
+		publications[i].Tags = append(publications[i].Tags, t)
+	}
+	return rows.Err()
+}
+
+// FilterPublicationsByTag оставляет публикации с указанным тегом; пустой адрес тега ничего не фильтрует
+func FilterPublicationsByTag(publications []Publication, slug string) []Publication {
+	if slug == "" {
+		return publications
+	}
+	var filtered []Publication
+	for _, pub := range publications {
+		for _, t := range pub.Tags {
+			if t.Slug == slug {
+				filtered = append(filtered, pub)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// TagList возвращает теги публикации через запятую для подстановки в форму
+func (p Publication) TagList() string {
+	names := make([]string, len(p.Tags))
+	for i, t := range p.Tags {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// GetTags возвращает основные теги с числом публикаций и синонимами
+func GetTags() ([]Tag, error) {
+	query := `SELECT t.id, t.name, t.slug,
+                     (SELECT COUNT(*) FROM publication_tags pt JOIN publications p ON p.id = pt.publication_id
+                      WHERE pt.tag_id = t.id AND p.deleted_at IS NULL),
+                     COALESCE((SELECT string_agg(s.name, ',' ORDER BY LOWER(s.name)) FROM tags s WHERE s.canonical_id = t.id), '')
+              FROM tags t WHERE t.canonical_id IS NULL
+              ORDER BY LOWER(t.name)`
+	rows, err := Db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		var synonyms string
+		if err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.Count, &synonyms); err != nil {
+			return nil, err
+		}
+		if synonyms != "" {
+			t.Synonyms = strings.Split(synonyms, ",")
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// GetTagBySlug возвращает тег по адресу; адрес синонима ведёт на основной тег
+func GetTagBySlug(slug string) (Tag, error) {
+	var t Tag
+	query := `SELECT c.id, c.name, c.slug FROM tags t JOIN tags c ON c.id = COALESCE(t.canonical_id, t.id)
+              WHERE t.slug = $1`
+	err := Db.QueryRow(query, slug).Scan(&t.ID, &t.Name, &t.Slug)
+	return t, err
+}
+
+// MergeTags переносит публикации и синонимы тега sourceID на targetID, а сам sourceID становится синонимом
+func MergeTags(exec dbExecutor, sourceID, targetID int) error {
+	if sourceID == targetID {
+		return &UserValidationError{Message: "Нельзя объединить тег с самим собой"}
+	}
+	var targetIsCanonical bool
+	err := exec.QueryRow(`SELECT canonical_id IS NULL FROM tags WHERE id = $1`, targetID).Scan(&targetIsCanonical)
+	if err != nil {
+		return err
+	}
+	if !targetIsCanonical {
+		return &UserValidationError{Message: "Объединять можно только с основным тегом"}
+	}
+
+	statements := []string{
+		`INSERT INTO publication_tags (publication_id, tag_id)
+		 SELECT publication_id, $2 FROM publication_tags WHERE tag_id = $1 ON CONFLICT DO NOTHING`,
+		`DELETE FROM publication_tags WHERE tag_id = $1`,
+		`UPDATE tags SET canonical_id = $2 WHERE canonical_id = $1`,
+		`UPDATE tags SET canonical_id = $2 WHERE id = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err := exec.Exec(stmt, sourceID, targetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTagSynonym добавляет тегу синоним. Если тег с таким названием уже есть, он объединяется с основным.
+func AddTagSynonym(tagID int, name string) error {
+	name = strings.Join(strings.Fields(name), " ")
+	slug := Slugify(name)
+	if slug == "" {
+		return &UserValidationError{Message: "Недопустимое название синонима"}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existingID int
+	err = tx.QueryRow(`SELECT id FROM tags WHERE LOWER(name) = LOWER($1) OR slug = $2 LIMIT 1`, name, slug).Scan(&existingID)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(`INSERT INTO tags (name, slug, canonical_id) VALUES ($1, $2, $3)`, name, slug, tagID)
+	case err == nil:
+		err = MergeTags(tx, existingID, tagID)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// taxonomyAdmin проверяет метод и администратора из сессии. При ошибке ответ уже отправлен.
+func taxonomyAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+	return sessionAdmin(w, r)
+}
+
+// redirectToTaxonomy возвращает администратора на страницу рубрик и тегов
+func redirectToTaxonomy(w http.ResponseWriter, r *http.Request, adminID int) {
+	http.Redirect(w, r, "/admin/taxonomy?id="+strconv.Itoa(adminID), http.StatusSeeOther)
+}
+
+// Страница управления рубриками и тегами
+func TaxonomyPage(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := sessionAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	categories, err := GetCategoryTree()
+	if err != nil {
+		http.Error(w, "Ошибка при получении рубрик: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tags, err := GetTags()
+	if err != nil {
+		http.Error(w, "Ошибка при получении тегов: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		AdminID    int
+		Categories []Category
+		Tags       []Tag
+	}{
+		AdminID:    adminID,
+		Categories: categories,
+		Tags:       tags,
+	}
+	if err := TmplTaxonomy.Execute(w, data); err != nil {
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// Создание рубрики
+func CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := taxonomyAdmin(w, r)
+	if !ok {
+		return
+	}
+	parentID, _ := strconv.Atoi(r.FormValue("parent_id"))
+	if err := CreateCategory(r.FormValue("name"), parentID); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	redirectToTaxonomy(w, r, adminID)
+}
+
+// Удаление рубрики
+func DeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := taxonomyAdmin(w, r)
+	if !ok {
+		return
+	}
+	categoryID, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор рубрики", http.StatusBadRequest)
+		return
+	}
+	err = DeleteCategory(categoryID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Рубрика не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при удалении рубрики: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectToTaxonomy(w, r, adminID)
+}
+
+// Объединение тегов
+func MergeTagsHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := taxonomyAdmin(w, r)
+	if !ok {
+		return
+	}
+	sourceID, err := strconv.Atoi(r.FormValue("source_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор тега", http.StatusBadRequest)
+		return
+	}
+	targetID, err := strconv.Atoi(r.FormValue("target_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор тега", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка при объединении тегов: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := MergeTags(tx, sourceID, targetID); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка при объединении тегов: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectToTaxonomy(w, r, adminID)
+}
+
+// Добавление синонима тега
+func AddTagSynonymHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := taxonomyAdmin(w, r)
+	if !ok {
+		return
+	}
+	tagID, err := strconv.Atoi(r.FormValue("tag_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор тега", http.StatusBadRequest)
+		return
+	}
+	if err := AddTagSynonym(tagID, r.FormValue("name")); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	redirectToTaxonomy(w, r, adminID)
+}
+
+// Изменение рубрики и тегов публикации редактором
+func TagPublicationHandler(w http.ResponseWriter, r *http.Request) {
+	articleID, editorID, role, ok := editorAction(w, r)
+	if !ok {
+		return
+	}
+	if err := updatePublicationTaxonomy(articleID, r); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	redirectToEditorPage(w, r, role, editorID)
+}
+
+// GetPublishedArticlesByTag возвращает опубликованные статьи с тегом
+func GetPublishedArticlesByTag(tagID, limit int) ([]Publication, error) {
+	return queryPublishedArticles(` AND id IN (SELECT publication_id FROM publication_tags WHERE tag_id = $1)`, limit, tagID)
+}
+
+// GetPublishedArticlesByCategory возвращает опубликованные статьи рубрики и всех её подрубрик
+func GetPublishedArticlesByCategory(categoryID, limit int) ([]Publication, error) {
+	filter := ` AND category_id IN (
+                    WITH RECURSIVE sub AS (
+                        SELECT id FROM categories WHERE id = $1
+                        UNION ALL
+                        SELECT c.id FROM categories c JOIN sub ON c.parent_id = sub.id
+                    )
+                    SELECT id FROM sub)`
+	return queryPublishedArticles(filter, limit, categoryID)
+}
+
+// archiveArticles определяет статьи архива тега или рубрики по адресу из запроса
+func archiveArticles(w http.ResponseWriter, r *http.Request, kind string) (title string, publications []Publication, ok bool) {
+	slug := r.URL.Query().Get("slug")
+	var err error
+	if kind == "tag" {
+		var tag Tag
+		if tag, err = GetTagBySlug(slug); err == nil {
+			title = "Тег: " + tag.Name
+			publications, err = GetPublishedArticlesByTag(tag.ID, feedSize)
+		}
+	} else {
+		var category Category
+		if category, err = GetCategoryBySlug(slug); err == nil {
+			title = "Рубрика: " + category.Name
+			publications, err = GetPublishedArticlesByCategory(category.ID, feedSize)
+		}
+	}
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return "", nil, false
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении публикаций", http.StatusInternalServerError)
+		return "", nil, false
+	}
+	return title, publications, true
+}
+
+// renderArchive выводит страницу архива тега или рубрики
+func renderArchive(w http.ResponseWriter, r *http.Request, kind string) {
+	title, publications, ok := archiveArticles(w, r, kind)
+	if !ok {
+		return
+	}
+	data := struct {
+		Title        string
+		FeedURL      string
+		Publications []Publication
+	}{
+		Title:        title,
+		FeedURL:      "/" + kind + "/feed?slug=" + r.URL.Query().Get("slug"),
+		Publications: publications,
+	}
+	if err := TmplArchive.Execute(w, data); err != nil {
+		Logger(r.Context()).Error("Ошибка выполнения шаблона архива", "error", err)
+	}
+}
+
+// Архив статей с тегом
+func TagArchivePage(w http.ResponseWriter, r *http.Request) {
+	renderArchive(w, r, "tag")
+}
+
+// Архив статей рубрики
+func CategoryArchivePage(w http.ResponseWriter, r *http.Request) {
+	renderArchive(w, r, "category")
+}
+
+// RSS-лента статей с тегом
+func TagFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if title, publications, ok := archiveArticles(w, r, "tag"); ok {
+		writeFeed(w, r, title, publications)
+	}
+}
+
+// RSS-лента статей рубрики
+func CategoryFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if title, publications, ok := archiveArticles(w, r, "category"); ok {
+		writeFeed(w, r, title, publications)
+	}
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Формат поля datetime-local в HTML-формах
+const dueDateLayout = "2006-01-02T15:04"
+
+// ParseDueDate разбирает срок сдачи из формы. Пустая строка означает отсутствие срока.
+func ParseDueDate(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.ParseInLocation(dueDateLayout, value, time.Local)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
+
+// CreateTopicWithAssignments создаёт тему и назначает её перечисленным авторам в одной транзакции.
+// Срок назначения берётся из authorDueAts, а если для автора он не задан — общий срок темы.
+func CreateTopicWithAssignments(editorID int, topic, department string, isOpen bool, dueAt sql.NullTime, authorIDs []int, authorDueAts map[int]sql.NullTime) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var topicID int
+	query := `INSERT INTO user_topics (editor_id, topic, department, assigned_at, is_open, due_at)
+              VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	if err := tx.QueryRow(query, editorID, topic, department, now, isOpen, dueAt).Scan(&topicID); err != nil {
+		return err
+	}
+
+	for _, authorID := range authorIDs {
+		authorDueAt := dueAt
+		if d, ok := authorDueAts[authorID]; ok && d.Valid {
+			authorDueAt = d
+		}
+		_, err := tx.Exec(`INSERT INTO topic_assignments (topic_id, author_id, status, due_at, assigned_at)
+                           VALUES ($1, $2, 'assigned', $3, $4) ON CONFLICT (topic_id, author_id) DO NOTHING`,
+			topicID, authorID, authorDueAt, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CheckAuthors проверяет, что все пользователи существуют, активны и имеют роль автора
+func CheckAuthors(userIDs []int) (bool, error) {
+	if len(userIDs) == 0 {
+		return true, nil
+	}
+	ids := make(pq.Int64Array, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = int64(id)
+	}
+	var count int
+	err := Db.QueryRow(`SELECT COUNT(*) FROM users
+                        WHERE id = ANY($1) AND role = $2 AND is_active AND deleted_at IS NULL`, ids, RoleAuthor).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count == len(userIDs), nil
+}
+
+// GetTopicAssignments возвращает все назначения темы с логинами авторов
+func GetTopicAssignments(topicID int) ([]TopicAssignment, error) {
+	return queryTopicAssignments(`WHERE a.topic_id = $1`, topicID)
+}
+
+// GetEditorTopicAssignments возвращает назначения всех неудалённых тем редактора одним запросом
+func GetEditorTopicAssignments(editorID int) ([]TopicAssignment, error) {
+	return queryTopicAssignments(`JOIN user_topics t ON t.id = a.topic_id
+              WHERE t.editor_id = $1 AND t.deleted_at IS NULL`, editorID)
+}
+
+func queryTopicAssignments(where string, arg int) ([]TopicAssignment, error) {
+	query := `SELECT a.id, a.topic_id, a.author_id, u.login, a.status, a.due_at, a.assigned_at, a.responded_at
+              FROM topic_assignments a JOIN users u ON u.id = a.author_id ` + where + `
+              ORDER BY a.assigned_at, u.login`
+	rows, err := Db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []TopicAssignment
+	for rows.Next() {
+		var a TopicAssignment
+		if err := rows.Scan(&a.ID, &a.TopicID, &a.AuthorID, &a.AuthorName, &a.Status, &a.DueAt, &a.AssignedAt, &a.RespondedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+// GetOpenTopics возвращает открытые темы, которые автор ещё не брал и не отклонял
+func GetOpenTopics(authorID int) ([]Topic, error) {
+	query := `SELECT t.id, t.topic, t.department, t.due_at FROM user_topics t
+              WHERE t.is_open = TRUE AND t.deleted_at IS NULL
+                AND NOT EXISTS (SELECT 1 FROM topic_assignments a WHERE a.topic_id = t.id AND a.author_id = $1)
+              ORDER BY t.assigned_at`
+	rows, err := Db.Query(query, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []Topic
+	for rows.Next() {
+		var topic Topic
+		if err := rows.Scan(&topic.ID, &topic.Topic, &topic.Department, &topic.DueAt); err != nil {
+			return nil, err
+		}
+		topic.IsOpen = true
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
+}
+
+// CheckTopicAssignedToAuthor проверяет, что автор принял тему (или взял её из открытых)
+func CheckTopicAssignedToAuthor(topicID, authorID int) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM topic_assignments a JOIN user_topics t ON t.id = a.topic_id
+              WHERE a.topic_id = $1 AND a.author_id = $2 AND a.status = 'accepted' AND t.deleted_at IS NULL)`
+	err := Db.QueryRow(query, topicID, authorID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// Принятие назначенной темы автором
+func AcceptTopicHandler(w http.ResponseWriter, r *http.Request) {
+	respondToTopicAssignment(w, r, "accepted")
+}
+
+// Отказ автора от назначенной темы
+func DeclineTopicHandler(w http.ResponseWriter, r *http.Request) {
+	respondToTopicAssignment(w, r, "declined")
+}
+
+func respondToTopicAssignment(w http.ResponseWriter, r *http.Request, status string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	assignmentID, err := strconv.Atoi(r.FormValue("assignment_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор назначения", http.StatusBadRequest)
+		return
+	}
+
+	authorID, ok := CurrentUserID(r)
+	if !ok {
+		http.Error(w, "Необходимо войти в систему", http.StatusUnauthorized)
+		return
+	}
+
+	// Ответить можно только на собственное назначение, которое ещё ожидает ответа
+	query := `UPDATE topic_assignments SET status = $1, responded_at = $2
+              WHERE id = $3 AND author_id = $4 AND status = 'assigned'`
+	result, err := Db.Exec(query, status, time.Now(), assignmentID, authorID)
+	if err != nil {
+		http.Error(w, "Ошибка при обновлении назначения: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		http.Error(w, "Назначение не найдено или уже обработано", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/author_page?id="+strconv.Itoa(authorID), http.StatusSeeOther)
+}
+
+// Взятие открытой темы автором. Открытую тему может взять только один автор.
+func ClaimTopicHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topicID, err := strconv.Atoi(r.FormValue("topic_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор темы", http.StatusBadRequest)
+		return
+	}
+
+	// Открытые темы берут только авторы
+	authorID, _, ok := sessionRole(w, r, func(role string) bool { return role == RoleAuthor }, "Брать темы могут только авторы")
+	if !ok {
+		return
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка при взятии темы: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Закрываем тему; если её уже закрыли, значит тему взял другой автор
+	var dueAt sql.NullTime
+	err = tx.QueryRow(`UPDATE user_topics SET is_open = FALSE WHERE id = $1 AND is_open = TRUE AND deleted_at IS NULL RETURNING due_at`, topicID).Scan(&dueAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Тема уже взята другим автором или не существует", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при взятии темы: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`INSERT INTO topic_assignments (topic_id, author_id, status, due_at, assigned_at, responded_at)
+                      VALUES ($1, $2, 'accepted', $3, $4, $4)`, topicID, authorID, dueAt, now)
+	if err != nil {
+		http.Error(w, "Ошибка при взятии темы: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка при взятии темы: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/author_page?id="+strconv.Itoa(authorID), http.StatusSeeOther)
+}
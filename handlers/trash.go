@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/myproject/templates"
+)
+
+var TmplTrash = htmltemplate.Must(htmltemplate.ParseFS(templates.FS, "trash.html"))
+
+// Срок хранения объектов в корзине, после которого они удаляются окончательно
+const TrashRetention = 30 * 24 * time.Hour
+
+var (
+	ErrUserNotFound      = errors.New("пользователь не найден")
+	ErrSuccessorNotFound = errors.New("преемник не найден")
+)
+
+// Объект в корзине
+type TrashItem struct {
+	ID        int
+	Title     string
+	Details   string
+	DeletedAt time.Time
+	PurgeAt   time.Time
+}
+
+// SoftDeleteUser перемещает пользователя в корзину и передаёт его незавершённую работу.
+//
+// Правила передачи:
+//   - опубликованные статьи всегда остаются за автором;
+//   - если указан преемник, ему передаются неопубликованные статьи, активные назначения тем,
+//     темы, которые вёл пользователь как главный редактор, и открытые эскалации;
+//   - без преемника активные назначения отменяются, а темы без других исполнителей снова
+//     открываются для свободного выбора; неопубликованные статьи попадают в корзину вместе
+//     с пользователем и восстанавливаются вместе с ним; темы главного редактора переходят
+//     к другому активному главному редактору, а эскалации становятся видны всем главным редакторам.
+func SoftDeleteUser(userID, successorID, actorID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`UPDATE users SET deleted_at = $1, deleted_by = $2 WHERE id = $3 AND deleted_at IS NULL`, now, actorID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := reassignUserWork(tx, userID, successorID, actorID, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// reassignUserWork применяет правила передачи работы, описанные у SoftDeleteUser
+func reassignUserWork(tx *sql.Tx, userID, successorID, actorID int, now time.Time) error {
+	if successorID > 0 {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`, successorID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrSuccessorNotFound
+		}
+
+		// Назначения, которые уже есть у преемника, отменяются, остальные переходят к нему
+		_, err = tx.Exec(`UPDATE topic_assignments a SET status = 'cancelled', responded_at = $3
+		                  WHERE a.author_id = $1 AND a.status IN ('assigned', 'accepted')
+		                    AND EXISTS (SELECT 1 FROM topic_assignments b WHERE b.topic_id = a.topic_id AND b.author_id = $2)`,
+			userID, successorID, now)
+		if err != nil {
+			return err
+		}
+		// Неопубликованные материалы переходят к преемнику, он же становится их соавтором
+		_, err = tx.Exec(`WITH moved AS (
+		                      UPDATE publications SET author_id = $2, updated_at = $3
+		                      WHERE author_id = $1 AND is_published IS NOT TRUE AND deleted_at IS NULL
+		                      RETURNING id
+		                  )
+		                  INSERT INTO publication_contributors (publication_id, user_id, role, position)
+		                  SELECT m.id, $2, 'author',
+		                         (SELECT COALESCE(MAX(c.position) + 1, 0) FROM publication_contributors c WHERE c.publication_id = m.id)
+		                  FROM moved m
+		                  ON CONFLICT (publication_id, user_id) DO UPDATE SET role = 'author'`,
+			userID, successorID, now)
+		if err != nil {
+			return err
+		}
+
+		statements := []string{
+			`UPDATE topic_assignments SET author_id = $2 WHERE author_id = $1 AND status IN ('assigned', 'accepted')`,
+			`UPDATE user_topics SET editor_id = $2 WHERE editor_id = $1 AND deleted_at IS NULL`,
+			`UPDATE escalations SET escalated_to = $2 WHERE escalated_to = $1 AND resolved_at IS NULL`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt, userID, successorID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Отменяем активные назначения и снова открываем темы, у которых не осталось исполнителей
+	_, err := tx.Exec(`WITH cancelled AS (
+	                       UPDATE topic_assignments SET status = 'cancelled', responded_at = $2
+	                       WHERE author_id = $1 AND status IN ('assigned', 'accepted')
+	                       RETURNING topic_id
+	                   )
+	                   UPDATE user_topics t SET is_open = TRUE
+	                   WHERE t.id IN (SELECT topic_id FROM cancelled) AND t.deleted_at IS NULL
+	                     AND NOT EXISTS (SELECT 1 FROM topic_assignments a
+	                                     WHERE a.topic_id = t.id AND a.author_id <> $1 AND a.status IN ('assigned', 'accepted'))`,
+		userID, now)
+	if err != nil {
+		return err
+	}
+
+	// Неопубликованные статьи уходят в корзину вместе с автором
+	_, err = tx.Exec(`UPDATE publications SET deleted_at = $2, deleted_by = $3, deleted_with_user = $1
+	                  WHERE author_id = $1 AND is_published IS NOT TRUE AND deleted_at IS NULL`,
+		userID, now, actorID)
+	if err != nil {
+		return err
+	}
+
+	// Темы главного редактора переходят к другому активному главному редактору, если он есть
+	_, err = tx.Exec(`UPDATE user_topics SET editor_id = c.id
+	                  FROM (SELECT id FROM users
+	                        WHERE role IN ($2, 'chief_admin') AND deleted_at IS NULL AND id <> $1
+	                        ORDER BY id LIMIT 1) c
+	                  WHERE editor_id = $1 AND deleted_at IS NULL`,
+		userID, RoleChiefEditor)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE escalations SET escalated_to = NULL WHERE escalated_to = $1 AND resolved_at IS NULL`, userID)
+	return err
+}
+
+// RestoreUser возвращает пользователя из корзины вместе со статьями, удалёнными вместе с ним.
+// Переданные преемнику темы и статьи не возвращаются.
+func RestoreUser(userID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET deleted_at = NULL, deleted_by = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	_, err = tx.Exec(`UPDATE publications SET deleted_at = NULL, deleted_by = NULL, deleted_with_user = NULL
+	                  WHERE deleted_with_user = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Корзина: запросы для каждого вида объектов
+var trashQueries = map[string]string{
+	"user": `SELECT id, login, role, deleted_at FROM users
+	         WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`,
+	"topic": `SELECT id, topic, COALESCE(department, ''), deleted_at FROM user_topics
+	          WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`,
+	"publication": `SELECT id, title, status, deleted_at FROM publications
+	                WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`,
+}
+
+// GetTrash возвращает содержимое корзины для указанного вида объектов
+func GetTrash(entity string) ([]TrashItem, error) {
+	query, ok := trashQueries[entity]
+	if !ok {
+		return nil, errors.New("неизвестный вид объектов: " + entity)
+	}
+
+	rows, err := Db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		var item TrashItem
+		if err := rows.Scan(&item.ID, &item.Title, &item.Details, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		item.PurgeAt = item.DeletedAt.Add(TrashRetention)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// sessionAdmin возвращает администратора из сессии. При ошибке ответ уже отправлен.
+func sessionAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	adminID, _, ok := sessionRole(w, r, IsAdminRole, "Доступ только для администратора")
+	return adminID, ok
+}
+
+// Страница корзины администратора
+func TrashPage(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := sessionAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	data := struct {
+		AdminID      int
+		Retention    int
+		Users        []TrashItem
+		Topics       []TrashItem
+		Publications []TrashItem
+	}{
+		AdminID:   adminID,
+		Retention: int(TrashRetention.Hours() / 24),
+	}
+	if data.Users, err = GetTrash("user"); err == nil {
+		if data.Topics, err = GetTrash("topic"); err == nil {
+			data.Publications, err = GetTrash("publication")
+		}
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при получении корзины: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := TmplTrash.Execute(w, data); err != nil {
+		http.Error(w, "Ошибка выполнения шаблона", http.StatusInternalServerError)
+	}
+}
+
+// Восстановление объекта из корзины
+func RestoreFromTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adminID, ok := sessionAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("item_id"))
+	if err != nil {
+		http.Error(w, "Неверный идентификатор объекта", http.StatusBadRequest)
+		return
+	}
+
+	switch r.FormValue("entity") {
+	case "user":
+		err = RestoreUser(id)
+	case "topic":
+		err = restoreRow(`UPDATE user_topics SET deleted_at = NULL, deleted_by = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	case "publication":
+		err = restoreRow(`UPDATE publications SET deleted_at = NULL, deleted_by = NULL, deleted_with_user = NULL
+		                  WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	default:
+		http.Error(w, "Неизвестный вид объекта", http.StatusBadRequest)
+		return
+	}
+	if err == ErrUserNotFound || err == sql.ErrNoRows {
+		http.Error(w, "Объект не найден в корзине", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при восстановлении: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/trash?id="+strconv.Itoa(adminID), http.StatusSeeOther)
+}
+
+func restoreRow(query string, id int) error {
+	result, err := Db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// StartTrashPurger периодически окончательно удаляет объекты с истёкшим сроком хранения
+func StartTrashPurger(ctx context.Context, interval time.Duration) {
+	startPeriodicWorker(ctx, interval, "очистка корзины", func() error {
+		return PurgeTrash(time.Now().Add(-TrashRetention))
+	})
+}
+
+// PurgeTrash окончательно удаляет объекты, помещённые в корзину раньше cutoff.
+// Темы и пользователи, на которые ещё ссылаются публикации, не удаляются.
+func PurgeTrash(cutoff time.Time) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM publications WHERE deleted_at < $1`,
+		`DELETE FROM user_topics t WHERE t.deleted_at < $1
+		   AND NOT EXISTS (SELECT 1 FROM publications p WHERE p.topic_id = t.id)`,
+		`DELETE FROM user_publications up USING users u
+		 WHERE up.user_id = u.id AND u.deleted_at < $1
+		   AND NOT EXISTS (SELECT 1 FROM publications p WHERE p.author_id = u.id)
+		   AND NOT EXISTS (SELECT 1 FROM user_topics t WHERE t.editor_id = u.id)`,
+		`DELETE FROM users u WHERE u.deleted_at < $1
+		   AND NOT EXISTS (SELECT 1 FROM publications p WHERE p.author_id = u.id)
+		   AND NOT EXISTS (SELECT 1 FROM user_topics t WHERE t.editor_id = u.id)`,
+	}
+	var purged int64
+	for _, stmt := range statements {
+		result, err := tx.Exec(stmt, cutoff)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		purged += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Из корзины окончательно удалено записей: %d", purged)
+	}
+	return nil
+}
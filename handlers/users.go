@@ -0,0 +1,565 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Допустимые роли пользователей
+var KnownRoles = []string{RoleUser, RoleAdmin, RoleChiefEditor, RoleSectionEditor, RoleAuthor}
+
+// Количество пользователей на странице списка сотрудников
+const usersPageSize = 20
+
+// dbExecutor — общее для *sql.DB и *sql.Tx подмножество методов
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// UserValidationError — ошибка в данных пользователя, которую нужно показать администратору
+type UserValidationError struct {
+	Message string
+}
+
+func (e *UserValidationError) Error() string {
+	return e.Message
+}
+
+// writeUserError отвечает 400 на ошибки проверки данных и 500 на остальные
+func writeUserError(w http.ResponseWriter, err error) {
+	var validationErr *UserValidationError
+	if errors.As(err, &validationErr) {
+		http.Error(w, validationErr.Message, http.StatusBadRequest)
+		return
+	}
+	if err == ErrUserNotFound {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Ошибка при изменении пользователя: "+err.Error(), http.StatusInternalServerError)
+}
+
+// ValidateRole проверяет, что роль входит в список известных ролей
+func ValidateRole(role string) error {
+	for _, known := range KnownRoles {
+		if role == known {
+			return nil
+		}
+	}
+	return &UserValidationError{Message: "Неизвестная роль: " + role}
+}
+
+// validateLogin проверяет логин и его уникальность среди остальных пользователей (включая удалённых)
+func validateLogin(exec dbExecutor, login string, exceptUserID int) error {
+	if login == "" {
+		return &UserValidationError{Message: "Логин не может быть пустым"}
+	}
+	if strings.ContainsAny(login, " \t\r\n") {
+		return &UserValidationError{Message: "Логин не может содержать пробелы"}
+	}
+
+	var taken bool
+	err := exec.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE login = $1 AND id <> $2)`, login, exceptUserID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return &UserValidationError{Message: "Логин уже занят: " + login}
+	}
+	return nil
+}
+
+// CreateUser проверяет данные и добавляет пользователя. Используется формой администратора и импортом.
+func CreateUser(exec dbExecutor, login, password, role string) (int, error) {
+	// Проверяем, что логин, пароль и роль не пустые
+	if login == "" || password == "" || role == "" {
+		return 0, &UserValidationError{Message: "Поля логин, пароль и роль обязательны"}
+	}
+	if err := ValidateRole(role); err != nil {
+		return 0, err
+	}
+	if err := validateLogin(exec, login, 0); err != nil {
+		return 0, err
+	}
+
+	// Хешируем пароль
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при хешировании пароля: %w", err)
+	}
+
+	var userID int
+	query := "INSERT INTO users (login, password, role) VALUES ($1, $2, $3) RETURNING id"
+	if err := exec.QueryRow(query, login, hashedPassword, role).Scan(&userID); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Страница списка пользователей с поиском
+type UserList struct {
+	Users []User
+	Query string
+	Page  int
+	Pages int
+	Total int
+}
+
+// PrevPage и NextPage нужны шаблону для ссылок постраничной навигации
+func (l UserList) PrevPage() int { return l.Page - 1 }
+func (l UserList) NextPage() int { return l.Page + 1 }
+
+// LoadUserList читает параметры q и page из запроса и возвращает страницу списка пользователей
+func LoadUserList(r *http.Request) (UserList, error) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	return SearchUsers(strings.TrimSpace(r.URL.Query().Get("q")), page, usersPageSize)
+}
+
+// SearchUsers ищет активных и деактивированных (но не удалённых) пользователей по логину или роли
+func SearchUsers(search string, page, pageSize int) (UserList, error) {
+	list := UserList{Query: search, Page: page}
+	pattern := "%" + search + "%"
+
+	err := Db.QueryRow(`SELECT COUNT(*) FROM users
+                        WHERE deleted_at IS NULL AND (login ILIKE $1 OR role ILIKE $1)`, pattern).Scan(&list.Total)
+	if err != nil {
+		return list, err
+	}
+	list.Pages = (list.Total + pageSize - 1) / pageSize
+
+	rows, err := Db.Query(`SELECT id, login, role, is_active FROM users
+                           WHERE deleted_at IS NULL AND (login ILIKE $1 OR role ILIKE $1)
+                           ORDER BY login LIMIT $2 OFFSET $3`, pattern, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.IDuser, &user.Login, &user.Role, &user.IsActive); err != nil {
+			return list, err
+		}
+		list.Users = append(list.Users, user)
+	}
+	return list, rows.Err()
+}
+
+// adminForm проверяет метод и то, что в сессии администратор. При ошибке ответ уже отправлен.
+func adminForm(w http.ResponseWriter, r *http.Request) (adminID int, ok bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Неправильный метод запроса", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+	return sessionAdmin(w, r)
+}
+
+// formUserID читает идентификатор изменяемого пользователя из формы
+func formUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+func redirectToAdminPage(w http.ResponseWriter, r *http.Request, adminID int) {
+	http.Redirect(w, r, "/admin_page?id="+strconv.Itoa(adminID), http.StatusSeeOther)
+}
+
+// UpdateUser меняет логин и роль пользователя с той же проверкой, что и при добавлении.
+// При смене роли сессии пользователя завершаются, чтобы прежние права не действовали.
+func UpdateUser(exec dbExecutor, userID int, login, role string) error {
+	if err := ValidateRole(role); err != nil {
+		return err
+	}
+	if err := validateLogin(exec, login, userID); err != nil {
+		return err
+	}
+	result, err := exec.Exec(`UPDATE users SET login = $1, role = $2,
+                                     session_version = session_version + CASE WHEN role <> $2 THEN 1 ELSE 0 END
+                              WHERE id = $3 AND deleted_at IS NULL`, login, role, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Изменение логина и роли пользователя
+func EditUserHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := formUserID(w, r)
+	if !ok {
+		return
+	}
+
+	role := r.FormValue("role")
+	if userID == adminID && !IsAdminRole(role) {
+		http.Error(w, "Нельзя снять с себя роль администратора", http.StatusBadRequest)
+		return
+	}
+
+	login := strings.TrimSpace(r.FormValue("login"))
+	if err := UpdateUser(Db, userID, login, role); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	redirectToAdminPage(w, r, adminID)
+}
+
+// DeactivateUser блокирует вход пользователя, завершает его сессии и передаёт его работу
+// по тем же правилам, что и при удалении (см. SoftDeleteUser)
+func DeactivateUser(userID, successorID, actorID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET is_active = FALSE, session_version = session_version + 1
+                            WHERE id = $1 AND is_active = TRUE AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := reassignUserWork(tx, userID, successorID, actorID, time.Now()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publishBusEvent(TopicUser, UserChanged{UserID: userID, Action: UserDeactivated})
+	return nil
+}
+
+// ReactivateUser снова разрешает вход и возвращает статьи, убранные в корзину при деактивации
+func ReactivateUser(userID int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET is_active = TRUE WHERE id = $1 AND is_active = FALSE AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	_, err = tx.Exec(`UPDATE publications SET deleted_at = NULL, deleted_by = NULL, deleted_with_user = NULL
+                      WHERE deleted_with_user = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Деактивация пользователя без удаления
+func DeactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := formUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID == adminID {
+		http.Error(w, "Нельзя деактивировать собственную учётную запись", http.StatusBadRequest)
+		return
+	}
+
+	successorID := 0
+	if successorIDStr := r.FormValue("successor_id"); successorIDStr != "" {
+		var err error
+		successorID, err = strconv.Atoi(successorIDStr)
+		if err != nil || successorID <= 0 || successorID == userID {
+			http.Error(w, "Неверный идентификатор преемника", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := DeactivateUser(userID, successorID, adminID)
+	if err == ErrSuccessorNotFound {
+		http.Error(w, "Преемник не найден или удалён", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	redirectToAdminPage(w, r, adminID)
+}
+
+// Повторная активация пользователя
+func ReactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := formUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := ReactivateUser(userID); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	redirectToAdminPage(w, r, adminID)
+}
+
+// ForceLogout завершает все сессии пользователя
+func ForceLogout(userID int) error {
+	result, err := Db.Exec("UPDATE users SET session_version = session_version + 1 WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	publishBusEvent(TopicUser, UserChanged{UserID: userID, Action: UserLoggedOut})
+	return ReleaseUserLocks(userID)
+}
+
+// Принудительный выход пользователя из всех сессий
+func ForceLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := formUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := ForceLogout(userID); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	redirectToAdminPage(w, r, adminID)
+}
+
+// SetPassword устанавливает новый пароль и завершает все сессии пользователя
+func SetPassword(exec dbExecutor, userID int, password string) error {
+	if password == "" {
+		return &UserValidationError{Message: "Пароль не может быть пустым"}
+	}
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("ошибка при хешировании пароля: %w", err)
+	}
+	result, err := exec.Exec(`UPDATE users SET password = $1, session_version = session_version + 1
+                              WHERE id = $2 AND deleted_at IS NULL`, hashedPassword, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Сброс пароля пользователя администратором
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := formUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := SetPassword(Db, userID, r.FormValue("password")); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	redirectToAdminPage(w, r, adminID)
+}
+
+// GetUserIDByLogin находит неудалённого пользователя по логину
+func GetUserIDByLogin(login string) (int, error) {
+	var userID int
+	err := Db.QueryRow(`SELECT id FROM users WHERE login = $1 AND deleted_at IS NULL`, login).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	return userID, err
+}
+
+// SetUserRole меняет роль пользователя и при её смене завершает его сессии
+func SetUserRole(exec dbExecutor, userID int, role string) error {
+	if err := ValidateRole(role); err != nil {
+		return err
+	}
+	result, err := exec.Exec(`UPDATE users SET role = $1,
+                                     session_version = session_version + CASE WHEN role <> $1 THEN 1 ELSE 0 END
+                              WHERE id = $2 AND deleted_at IS NULL`, role, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Массовая смена роли выбранных пользователей
+func BulkRoleChangeHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+
+	role := r.FormValue("role")
+	if err := ValidateRole(role); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	var userIDs []int
+	for _, idStr := range r.Form["user_ids"] {
+		userID, err := strconv.Atoi(idStr)
+		if err != nil || userID <= 0 {
+			http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+			return
+		}
+		if userID == adminID && !IsAdminRole(role) {
+			http.Error(w, "Нельзя снять с себя роль администратора", http.StatusBadRequest)
+			return
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if len(userIDs) == 0 {
+		http.Error(w, "Не выбраны пользователи", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, userID := range userIDs {
+		if err := SetUserRole(tx, userID, role); err != nil && err != ErrUserNotFound {
+			writeUserError(w, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	redirectToAdminPage(w, r, adminID)
+}
+
+// ImportUsersCSV добавляет пользователей из CSV с заголовком login,password,role.
+// Импорт выполняется целиком или не выполняется вовсе; ошибка указывает номер строки.
+func ImportUsersCSV(src io.Reader) (int, error) {
+	reader := csv.NewReader(src)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, &UserValidationError{Message: "Не удалось прочитать заголовок CSV"}
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"login", "password", "role"} {
+		if _, ok := columns[name]; !ok {
+			return 0, &UserValidationError{Message: "В CSV нет столбца " + name}
+		}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, &UserValidationError{Message: fmt.Sprintf("Строка %d: %v", line, err)}
+		}
+
+		login := strings.TrimSpace(record[columns["login"]])
+		password := record[columns["password"]]
+		role := strings.TrimSpace(record[columns["role"]])
+		if _, err := CreateUser(tx, login, password, role); err != nil {
+			var validationErr *UserValidationError
+			if errors.As(err, &validationErr) {
+				return 0, &UserValidationError{Message: fmt.Sprintf("Строка %d: %s", line, validationErr.Message)}
+			}
+			return 0, err
+		}
+		imported++
+	}
+
+	return imported, tx.Commit()
+}
+
+// Импорт новых сотрудников из CSV-файла
+func ImportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Ошибка при загрузке файла", http.StatusBadRequest)
+		return
+	}
+	adminID, ok := adminForm(w, r)
+	if !ok {
+		return
+	}
+
+	file, _, err := r.FormFile("csv")
+	if err != nil {
+		http.Error(w, "Файл CSV не передан", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if _, err := ImportUsersCSV(file); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	redirectToAdminPage(w, r, adminID)
+}
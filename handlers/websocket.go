@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Минимальная серверная реализация протокола WebSocket (RFC 6455):
+// рукопожатие, текстовые сообщения с фрагментацией, ping/pong и закрытие соединения.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Коды кадров WebSocket
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Максимальный размер входящего сообщения
+const wsMaxMessageSize = 1 << 20
+
+var (
+	ErrWebSocketClosed   = errors.New("соединение WebSocket закрыто")
+	errWebSocketProtocol = errors.New("нарушение протокола WebSocket")
+)
+
+// Соединение WebSocket
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	wmu  sync.Mutex // запись кадров из разных горутин
+}
+
+// headerContainsToken проверяет, что заголовок содержит токен из списка через запятую
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkSameOrigin пропускает только запросы со страниц этого же сайта
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket выполняет рукопожатие и забирает соединение у HTTP-сервера.
+// При ошибке ответ уже отправлен.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Ожидается соединение WebSocket", http.StatusBadRequest)
+		return nil, errWebSocketProtocol
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Неподдерживаемая версия WebSocket", http.StatusUpgradeRequired)
+		return nil, errWebSocketProtocol
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Не передан ключ WebSocket", http.StatusBadRequest)
+		return nil, errWebSocketProtocol
+	}
+	if !checkSameOrigin(r) {
+		http.Error(w, "Запрещённый источник запроса", http.StatusForbidden)
+		return nil, errWebSocketProtocol
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Сервер не поддерживает WebSocket", http.StatusInternalServerError)
+		return nil, errWebSocketProtocol
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Сроки, выставленные сервером для обычного запроса, к долгому соединению не относятся
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// writeFrame отправляет один кадр; сервер кадры не маскирует
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// WriteText отправляет текстовое сообщение
+func (c *wsConn) WriteText(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+// readFrame читает один кадр и снимает маску клиента
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		// Расширения не согласовывались, а кадры клиента обязаны быть замаскированы
+		return false, 0, nil, errWebSocketProtocol
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageSize || (opcode >= wsOpClose && (length > 125 || !fin)) {
+		return false, 0, nil, errWebSocketProtocol
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage возвращает очередное текстовое или двоичное сообщение, собирая фрагменты.
+// На ping отвечает pong, на закрытие — ответным кадром закрытия и ошибкой ErrWebSocketClosed.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := payload
+			if len(code) > 2 {
+				code = code[:2]
+			}
+			c.writeFrame(wsOpClose, code)
+			return nil, ErrWebSocketClosed
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, errWebSocketProtocol
+			}
+			started = true
+			message = payload
+		case wsOpContinuation:
+			if !started {
+				return nil, errWebSocketProtocol
+			}
+			if len(message)+len(payload) > wsMaxMessageSize {
+				return nil, errWebSocketProtocol
+			}
+			message = append(message, payload...)
+		default:
+			return nil, errWebSocketProtocol
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// SetReadDeadline ограничивает время ожидания следующего кадра
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Ping отправляет кадр ping, чтобы проверить, что клиент на связи
+func (c *wsConn) Ping() error {
+	return c.writeFrame(wsOpPing, nil)
+}
+
+// Close отправляет кадр закрытия и закрывает соединение
+func (c *wsConn) Close() error {
+	c.writeFrame(wsOpClose, []byte{0x03, 0xE8}) // 1000: нормальное закрытие
+	return c.conn.Close()
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// writeClientFrame отправляет кадр клиента: кадры клиента всегда замаскированы
+func writeClientFrame(t *testing.T, w io.Writer, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := w.Write(frame); err != nil {
+		t.Error(err)
+	}
+}
+
+// readServerFrame читает незамаскированный кадр сервера. Вызывается и из горутин, поэтому не прерывает тест.
+func readServerFrame(t *testing.T, r io.Reader) (fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Error(err)
+		return false, 0, nil
+	}
+	if head[1]&0x80 != 0 {
+		t.Error("кадр сервера не должен быть замаскирован")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Error(err)
+	}
+	return head[0]&0x80 != 0, head[0] & 0x0F, payload
+}
+
+// wsPipe соединяет серверную сторону wsConn с клиентом в памяти
+func wsPipe(t *testing.T) (*wsConn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return &wsConn{conn: server, br: bufio.NewReader(server)}, client
+}
+
+func TestWebSocketReadFragmentedMessage(t *testing.T) {
+	ws, client := wsPipe(t)
+	long := bytes.Repeat([]byte("ж"), 200) // 400 байт, расширенная длина
+
+	go func() {
+		writeClientFrame(t, client, false, wsOpText, []byte("Привет, "))
+		writeClientFrame(t, client, true, wsOpPing, []byte("ping"))
+		writeClientFrame(t, client, true, wsOpContinuation, long)
+	}()
+	pong := make(chan []byte, 1)
+	go func() {
+		_, opcode, payload := readServerFrame(t, client)
+		if opcode != wsOpPong {
+			t.Errorf("ожидался pong, получен кадр %#x", opcode)
+		}
+		pong <- payload
+	}()
+
+	message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte("Привет, "), long...); !bytes.Equal(message, want) {
+		t.Errorf("сообщение собрано неверно: %d байт вместо %d", len(message), len(want))
+	}
+	if got := <-pong; string(got) != "ping" {
+		t.Errorf("pong должен повторять данные ping, получено %q", got)
+	}
+}
+
+func TestWebSocketWriteRoundTrip(t *testing.T) {
+	ws, client := wsPipe(t)
+	for _, size := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		payload := bytes.Repeat([]byte{'a'}, size)
+		go ws.WriteText(payload)
+		fin, opcode, got := readServerFrame(t, client)
+		if !fin || opcode != wsOpText || !bytes.Equal(got, payload) {
+			t.Errorf("размер %d: fin=%v opcode=%#x длина %d", size, fin, opcode, len(got))
+		}
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	ws, client := wsPipe(t)
+	go writeClientFrame(t, client, true, wsOpClose, []byte{0x03, 0xE8, 'b', 'y', 'e'})
+	reply := make(chan []byte, 1)
+	go func() {
+		_, opcode, payload := readServerFrame(t, client)
+		if opcode != wsOpClose {
+			t.Errorf("ожидался кадр закрытия, получен %#x", opcode)
+		}
+		reply <- payload
+	}()
+
+	if _, err := ws.ReadMessage(); err != ErrWebSocketClosed {
+		t.Fatalf("ожидалась ErrWebSocketClosed, получено %v", err)
+	}
+	if got := <-reply; !bytes.Equal(got, []byte{0x03, 0xE8}) {
+		t.Errorf("ответ на закрытие должен содержать код, получено %v", got)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"кадр без маски", []byte{0x81, 0x01, 'a'}},
+		{"зарезервированные биты", []byte{0xC1, 0x80, 0, 0, 0, 0}},
+		{"фрагментированный ping", []byte{0x09, 0x80, 0, 0, 0, 0}},
+		{"продолжение без начала", []byte{0x80, 0x80, 0, 0, 0, 0}},
+		{"слишком большое сообщение", []byte{0x81, 0x80 | 127, 0, 0, 0, 0, 0x10, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, client := wsPipe(t)
+			go client.Write(tt.frame)
+			if _, err := ws.ReadMessage(); err != errWebSocketProtocol {
+				t.Errorf("ожидалась errWebSocketProtocol, получено %v", err)
+			}
+		})
+	}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		message, err := ws.ReadMessage()
+		if err != nil {
+			received <- "ошибка: " + err.Error()
+			return
+		}
+		received <- string(message)
+		ws.WriteText(message)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Ключ и ответ из примера RFC 6455
+	request := "GET /ws HTTP/1.1\r\nHost: " + srv.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("код ответа %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+
+	writeClientFrame(t, conn, true, wsOpText, []byte("эхо"))
+	if got := <-received; got != "эхо" {
+		t.Fatalf("сервер получил %q", got)
+	}
+	if _, opcode, payload := readServerFrame(t, br); opcode != wsOpText || string(payload) != "эхо" {
+		t.Errorf("ответ сервера: кадр %#x, %q", opcode, payload)
+	}
+}
+
+func TestUpgradeWebSocketRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"не WebSocket", map[string]string{}, http.StatusBadRequest},
+		{"старая версия", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"чужой источник", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+			if len(tt.header) > 0 {
+				r.Header.Set("Connection", "Upgrade")
+				r.Header.Set("Upgrade", "websocket")
+				r.Header.Set("Sec-WebSocket-Version", "13")
+				r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if _, err := upgradeWebSocket(w, r); err == nil {
+				t.Fatal("ожидалась ошибка")
+			}
+			if w.Code != tt.status {
+				t.Errorf("код %d, ожидался %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Группа запущенных фоновых задач, чтобы при остановке сервера дождаться их завершения
+var workers sync.WaitGroup
+
+// WaitWorkers ждёт, пока фоновые задачи завершат текущий проход после отмены их контекста.
+// Возвращает ошибку контекста, если задачи не успели завершиться.
+func WaitWorkers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startPeriodicWorker запускает fn сразу и затем с заданным интервалом до отмены контекста
+func startPeriodicWorker(ctx context.Context, interval time.Duration, name string, fn func() error) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := fn(); err != nil {
+				slog.Error("Ошибка фоновой задачи", "worker", name, "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
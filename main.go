@@ -1,18 +1,44 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"example.com/myproject/handlers"
 )
 
 func main() {
+	// Журнал в формате JSON; уровень задаётся переменной LOG_LEVEL
+	handlers.SetupLogging()
+
 	handlers.OpenDatabase()   // Открываем подключение к базе данных
 	defer handlers.Db.Close() // Закрываем соединение с базой данных при завершении работы
 
+	// Фоновые задачи работают до остановки сервера
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	// Шина событий: EVENT_BUS=postgres включает доставку между несколькими экземплярами сервера
+	if err := handlers.StartEventBus(workersCtx, os.Getenv("EVENT_BUS") == "postgres"); err != nil {
+		log.Fatal("Не удалось запустить шину событий:", err)
+	}
+	defer handlers.Bus.Close()
+
+	// Фоновая проверка просроченных публикаций
+	handlers.StartOverdueScheduler(workersCtx, 5*time.Minute)
+	// Фоновая выкладка запланированных публикаций
+	handlers.StartPublishScheduler(workersCtx, 30*time.Second)
+	// Окончательное удаление объектов с истёкшим сроком хранения в корзине
+	handlers.StartTrashPurger(workersCtx, time.Hour)
+	// Сохранение документов совместного редактирования
+	handlers.StartCollabPersister(workersCtx, 15*time.Second)
+
 	http.HandleFunc("/", handlers.Home)
 	http.HandleFunc("/main", handlers.Index)
+	http.HandleFunc("/logout", handlers.LogoutHandler)
 
 	// Страницы для ролей
 	http.HandleFunc("/admin_page", handlers.AdminPage)
@@ -29,6 +55,8 @@ func main() {
 	http.HandleFunc("/chief_editor/edit_draft", handlers.EditDraftHandler)
 	http.HandleFunc("/approve_publication", handlers.ApprovePublicationHandler)
 	http.HandleFunc("/request_revision", handlers.RequestRevisionHandler)
+	http.HandleFunc("/chief_editor/set_due_date", handlers.SetPublicationDueDateHandler)
+	http.HandleFunc("/chief_editor/overdue_report", handlers.OverdueReportHandler)
 
 	http.HandleFunc("/section_editor/assign_publications", handlers.AssignPublications)
 	http.HandleFunc("/section_editor/edit_publication", handlers.EditPublication)
@@ -36,16 +64,134 @@ func main() {
 	// дейстаивя админа
 	http.HandleFunc("/add_user", handlers.AddUserHandler)
 	http.HandleFunc("/delete_user", handlers.DeleteUserHandler)
+	http.HandleFunc("/admin/users/edit", handlers.EditUserHandler)
+	http.HandleFunc("/admin/users/deactivate", handlers.DeactivateUserHandler)
+	http.HandleFunc("/admin/users/reactivate", handlers.ReactivateUserHandler)
+	http.HandleFunc("/admin/users/force_logout", handlers.ForceLogoutHandler)
+	http.HandleFunc("/admin/users/reset_password", handlers.ResetPasswordHandler)
+	http.HandleFunc("/admin/users/bulk_role", handlers.BulkRoleChangeHandler)
+	http.HandleFunc("/admin/users/import", handlers.ImportUsersHandler)
+	http.HandleFunc("/admin/taxonomy", handlers.TaxonomyPage)
+	http.HandleFunc("/admin/categories/create", handlers.CreateCategoryHandler)
+	http.HandleFunc("/admin/categories/delete", handlers.DeleteCategoryHandler)
+	http.HandleFunc("/admin/tags/merge", handlers.MergeTagsHandler)
+	http.HandleFunc("/admin/tags/synonym", handlers.AddTagSynonymHandler)
+	http.HandleFunc("/admin/trash", handlers.TrashPage)
+	http.HandleFunc("/admin/trash/restore", handlers.RestoreFromTrashHandler)
+	http.HandleFunc("/admin/data", handlers.DataTransferPage)
+	http.HandleFunc("/admin/data/export", handlers.ExportBundleHandler)
+	http.HandleFunc("/admin/data/import", handlers.ImportBundleHandler)
 
 	// автор
 	http.HandleFunc("/author/create_publication", handlers.CreatePublicationHandler)
 	http.HandleFunc("/author/fix_comments", handlers.FixCommentsHandler)
 	http.HandleFunc("/author/create_publication_form", handlers.AuthorCreatePublicationFormHandler)
+	http.HandleFunc("/author/import_docx", handlers.ImportDocxHandler)
 	http.HandleFunc("/author/edit_publication", handlers.EditPublicationHandler)
 	http.HandleFunc("/author/update_publication", handlers.UpdatePublicationHandler)
+	http.HandleFunc("/author/contributors", handlers.ContributorsHandler)
+	http.HandleFunc("/author/topics/accept", handlers.AcceptTopicHandler)
+	http.HandleFunc("/author/topics/decline", handlers.DeclineTopicHandler)
+	http.HandleFunc("/author/topics/claim", handlers.ClaimTopicHandler)
 
 	http.HandleFunc("/section_editor/publish_publication", handlers.PublishPublicationHandler)
+	http.HandleFunc("/section_editor/schedule_publication", handlers.SchedulePublicationHandler)
+	http.HandleFunc("/section_editor/cancel_schedule", handlers.CancelScheduledPublicationHandler)
+
+	// Снятие, отзыв и исправления опубликованных статей
+	http.HandleFunc("/editor/unpublish", handlers.UnpublishPublicationHandler)
+	http.HandleFunc("/editor/retract", handlers.RetractPublicationHandler)
+	http.HandleFunc("/editor/correct", handlers.CorrectPublicationHandler)
+	http.HandleFunc("/editor/delete_publication", handlers.DeletePublication)
+	http.HandleFunc("/editor/tag_publication", handlers.TagPublicationHandler)
+
+	// Блокировки редактирования
+	http.HandleFunc("/publication/lock/heartbeat", handlers.LockHeartbeatHandler)
+	http.HandleFunc("/publication/lock/release", handlers.LockReleaseHandler)
+
+	// Обмен документами Word
+	http.HandleFunc("/publication/docx", handlers.PublicationDocxHandler)
+	http.HandleFunc("/publication/image", handlers.PublicationImageHandler)
+	http.HandleFunc("/chief_editor/steal_lock", handlers.StealLockHandler)
+
+	// Живые обновления рабочих страниц
+	http.HandleFunc("/events", handlers.EventStreamHandler)
+
+	// Центр уведомлений
+	http.HandleFunc("/notifications", handlers.NotificationsPage)
+	http.HandleFunc("/notifications/unread", handlers.UnreadNotificationsHandler)
+	http.HandleFunc("/notifications/read", handlers.MarkNotificationsReadHandler)
+	http.HandleFunc("/notifications/preferences", handlers.NotificationPreferencesHandler)
+
+	// Совместное редактирование
+	http.HandleFunc("/collab", handlers.CollabPage)
+	http.HandleFunc("/collab/ws", handlers.CollabSocketHandler)
+
+	// Редакционный календарь
+	http.HandleFunc("/calendar", handlers.CalendarPage)
+	http.HandleFunc("/calendar/move", handlers.MoveCalendarEntryHandler)
+	http.HandleFunc("/calendar/subscribe", handlers.CalendarSubscribePage)
+	http.HandleFunc("/calendar.ics", handlers.CalendarFeedHandler)
+
+	// Планирование выпусков
+	http.HandleFunc("/issues", handlers.IssuesPage)
+	http.HandleFunc("/issues/create", handlers.CreateIssueHandler)
+	http.HandleFunc("/issue", handlers.IssuePage)
+	http.HandleFunc("/issue/publication", handlers.IssuePublicationHandler)
+	http.HandleFunc("/issue/section", handlers.IssueSectionHandler)
+	http.HandleFunc("/issue/status", handlers.IssueStatusHandler)
+
+	// Публичный сайт
+	http.HandleFunc("/article", handlers.ArticlePage)
+	http.HandleFunc("/feed", handlers.FeedHandler)
+	http.HandleFunc("/authors", handlers.AuthorPublicPage)
+	http.HandleFunc("/authors/epub", handlers.AuthorEPUBHandler)
+	http.HandleFunc("/issue/epub", handlers.IssueEPUBHandler)
+	http.HandleFunc("/avatar", handlers.AvatarHandler)
+	http.HandleFunc("/tag", handlers.TagArchivePage)
+	http.HandleFunc("/tag/feed", handlers.TagFeedHandler)
+	http.HandleFunc("/category", handlers.CategoryArchivePage)
+	http.HandleFunc("/category/feed", handlers.CategoryFeedHandler)
+
+	// Профиль пользователя
+	http.HandleFunc("/profile", handlers.ProfilePage)
+	http.HandleFunc("/profile/update", handlers.UpdateProfileHandler)
+
+	// JSON API
+	http.HandleFunc("/api/publication", handlers.PublicationAPIHandler)
+	http.HandleFunc("/api/publication/pdf", handlers.PublicationPDFHandler)
+	http.HandleFunc("/api/issue/pdf", handlers.IssuePDFHandler)
+
+	// Метрики Prometheus
+	http.HandleFunc("/metrics", handlers.MetricsHandler)
+
+	// ADDR задаёт адрес сервера; TLS_CERT_FILE и TLS_KEY_FILE включают HTTPS
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	// Номер запроса, журнал запросов и перехват паники для всех маршрутов
+	handler := handlers.RequestMiddleware(http.DefaultServeMux)
+	srv, err := newServer(addr, handler, os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"))
+	if err != nil {
+		log.Fatal("Не удалось настроить сервер:", err)
+	}
+	// Потоки событий иначе держали бы остановку сервера до истечения таймаута
+	srv.RegisterOnShutdown(handlers.Events.Close)
+
+	log.Printf("Сервер запущен на %s", addr)
+	if err := serve(srv); err != nil {
+		log.Printf("Не все запросы завершились до остановки: %v", err)
+	}
+
+	// Соединения совместного редактирования сервер не отслеживает: сохраняем документы и закрываем их сами
+	handlers.CloseCollabSessions()
 
-	log.Println("Сервер запущен на порту :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	stopWorkers()
+	waitCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := handlers.WaitWorkers(waitCtx); err != nil {
+		log.Printf("Фоновые задачи не завершились вовремя: %v", err)
+	}
+	log.Println("Сервер остановлен")
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Таймауты HTTP-сервера. Чтение и запись рассчитаны на загрузку выгрузок базы и сборку PDF выпуска;
+// поток событий и WebSocket снимают с себя срок записи сами.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 2 * time.Minute
+	writeTimeout      = 2 * time.Minute
+	idleTimeout       = 2 * time.Minute
+	maxHeaderBytes    = 1 << 20
+
+	// Сколько ждать завершения запросов и фоновых задач при остановке
+	shutdownTimeout = 30 * time.Second
+
+	// Как часто проверять, не обновились ли файлы сертификата
+	certCheckInterval = time.Minute
+)
+
+// newServer настраивает HTTP-сервер. Если заданы файлы сертификата и ключа, сервер работает по TLS
+// и подхватывает обновлённый сертификат без перезапуска.
+func newServer(addr string, handler http.Handler, certFile, keyFile string) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	if certFile == "" && keyFile == "" {
+		return srv, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("для TLS нужно задать и TLS_CERT_FILE, и TLS_KEY_FILE")
+	}
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.GetCertificate}
+	return srv, nil
+}
+
+// serve запускает сервер и ждёт SIGINT или SIGTERM, после чего перестаёт принимать соединения
+// и дожидается завершения начатых запросов. Повторный сигнал завершает процесс сразу.
+func serve(srv *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ListenAndServeTLS("", "")
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		// Сервер ещё не принял ни одного запроса, останавливать нечего
+		log.Fatal("Не удалось запустить сервер: ", err)
+	case <-ctx.Done():
+	}
+	stop()
+	log.Println("Получен сигнал остановки, завершаем начатые запросы")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// certReloader отдаёт TLS-сертификат и перечитывает его с диска, когда файлы меняются,
+// например после продления. Если новый сертификат не читается, остаётся прежний.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload загружает сертификат, если файлы изменились с прошлой загрузки. Вызывается под r.mu
+// или до начала работы сервера.
+func (r *certReloader) reload() error {
+	var modTime time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		log.Printf("TLS-сертификат %s перечитан", r.certFile)
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// GetCertificate используется в tls.Config; файлы проверяются не чаще раза в certCheckInterval
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if err := r.reload(); err != nil {
+			log.Printf("Не удалось перечитать TLS-сертификат, используется прежний: %v", err)
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,8 @@
+// Package templates встраивает шаблоны страниц в исполняемые файлы, чтобы сервер
+// и утилита администратора не зависели от рабочего каталога.
+package templates
+
+import "embed"
+
+//go:embed *.html
+var FS embed.FS